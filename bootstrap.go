@@ -0,0 +1,92 @@
+// Hap - the simple and effective provisioner
+// Copyright (c) 2015 Garrett Woodworth (https://github.com/gwoo)
+// The BSD License http://opensource.org/licenses/bsd-license.php.
+
+package hap
+
+import (
+	"fmt"
+	"strings"
+)
+
+// Prints the programs of the list that are not found on the PATH.
+const findMissing string = "for P in %s; do command -v $P >/dev/null 2>&1 || echo $P; done"
+
+// Refreshes the package index, which freshly imaged machines lack.
+var refreshCmds = map[string]string{
+	"apt-get": "$SUDO apt-get update -q",
+	"apk":     "$SUDO apk update -q",
+}
+
+// prerequisites returns the programs hap uses on the host
+// git is always needed, rsync when the git transfer has sync paths,
+// which fall back to tar without it, and curl when smoke tests send
+// requests from the host.
+func (r *Remote) prerequisites() []string {
+	programs := []string{"git"}
+	if len(r.Host.Sync) > 0 && (r.Host.Transfer == "" || r.Host.Transfer == "git") {
+		programs = append(programs, "rsync")
+	}
+	for _, test := range r.Host.smoke {
+		if test.From == "host" || test.From == "both" {
+			programs = append(programs, "curl")
+			break
+		}
+	}
+	return programs
+}
+
+// bootstrapCmds returns the commands installing the packages with the
+// package manager of the distro, or nil when it is unknown
+// apt-get installs without prompts, which would hang the session.
+func bootstrapCmds(distro string, packages []string) []string {
+	install := strings.Fields(installCmd(distro))
+	if len(install) < 2 {
+		return nil
+	}
+	cmds := []string{sudo}
+	if refresh, ok := refreshCmds[install[1]]; ok {
+		cmds = append(cmds, refresh)
+	}
+	install[0] = "$SUDO"
+	if install[1] == "apt-get" {
+		install = append([]string{"$SUDO", "env", "DEBIAN_FRONTEND=noninteractive"}, install[1:]...)
+	}
+	return append(cmds, strings.Join(append(install, packages...), " "))
+}
+
+// Bootstrap installs the prerequisites missing on the host with the
+// package manager of its distro, so freshly imaged machines can be
+// initialized right away. Local hosts are left alone.
+func (r *Remote) Bootstrap() error {
+	if r.Host.IsLocal() {
+		return nil
+	}
+	programs := r.prerequisites()
+	result, err := r.read([]string{fmt.Sprintf(findMissing, strings.Join(programs, " "))})
+	if err != nil {
+		return err
+	}
+	missing := []string{}
+	for _, program := range strings.Fields(string(result.Stdout)) {
+		for _, p := range programs {
+			if program == p {
+				missing = append(missing, program)
+			}
+		}
+	}
+	if len(missing) < 1 {
+		return nil
+	}
+	facts, err := r.Facts()
+	if err != nil {
+		return err
+	}
+	cmds := bootstrapCmds(facts["DISTRO"], missing)
+	if cmds == nil {
+		return fmt.Errorf("[%s] missing %s and no package manager known for %s", r.Host.Name, strings.Join(missing, ", "), facts["DISTRO"])
+	}
+	stdout, _ := r.writers()
+	fmt.Fprintf(stdout, "installing %s\n", strings.Join(missing, ", "))
+	return r.Execute(cmds)
+}
@@ -0,0 +1,42 @@
+// Hap - the simple and effective provisioner
+// Copyright (c) 2015 Garrett Woodworth (https://github.com/gwoo)
+// The BSD License http://opensource.org/licenses/bsd-license.php.
+
+package hap
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestPrerequisites(t *testing.T) {
+	host := &Host{Name: "web", Smoketest: []string{"home", "api"}, Sync: []string{"dist"}}
+	host.Smoketests(map[string]*Smoketest{"home": {}, "api": {From: "both"}})
+	r := &Remote{Host: host}
+	if programs := r.prerequisites(); !reflect.DeepEqual(programs, []string{"git", "rsync", "curl"}) {
+		t.Errorf("unexpected prerequisites %v", programs)
+	}
+	r.Host = &Host{Name: "web"}
+	if programs := r.prerequisites(); !reflect.DeepEqual(programs, []string{"git"}) {
+		t.Errorf("expected no rsync without sync paths, got %v", programs)
+	}
+	r.Host = &Host{Name: "web", Transfer: "bundle", Sync: []string{"dist"}}
+	if programs := r.prerequisites(); !reflect.DeepEqual(programs, []string{"git"}) {
+		t.Errorf("unexpected prerequisites %v", programs)
+	}
+}
+
+func TestBootstrapCmds(t *testing.T) {
+	cmds := bootstrapCmds("ubuntu", []string{"git", "curl"})
+	expected := []string{sudo, "$SUDO apt-get update -q", "$SUDO env DEBIAN_FRONTEND=noninteractive apt-get install -y git curl"}
+	if !reflect.DeepEqual(cmds, expected) {
+		t.Errorf("unexpected cmds %q", cmds)
+	}
+	cmds = bootstrapCmds("rocky", []string{"rsync"})
+	if !reflect.DeepEqual(cmds, []string{sudo, "$SUDO dnf install -y rsync"}) {
+		t.Errorf("unexpected cmds %q", cmds)
+	}
+	if cmds := bootstrapCmds("unknown", []string{"git"}); cmds != nil {
+		t.Errorf("expected no cmds for an unknown distro, got %q", cmds)
+	}
+}
@@ -0,0 +1,89 @@
+// Hap - the simple and effective provisioner
+// Copyright (c) 2015 Garrett Woodworth (https://github.com/gwoo)
+// The BSD License http://opensource.org/licenses/bsd-license.php.
+
+package hap
+
+import (
+	"fmt"
+	"time"
+)
+
+// TimeoutError is returned for a host whose run exceeded its time budget
+type TimeoutError struct {
+	Host   string
+	Budget time.Duration
+}
+
+// Error returns the host and the budget it exceeded
+func (e *TimeoutError) Error() string {
+	return fmt.Sprintf("[%s] timed out after the time budget of %s", e.Host, e.Budget)
+}
+
+// TimeBudget returns the wall-clock time a run may take on the host,
+// 0 when time-budget is not set
+func (h *Host) TimeBudget() (time.Duration, error) {
+	if h.Budget == "" {
+		return 0, nil
+	}
+	budget, err := time.ParseDuration(h.Budget)
+	if err != nil || budget <= 0 {
+		return 0, fmt.Errorf("[%s] invalid time-budget %q", h.Name, h.Budget)
+	}
+	return budget, nil
+}
+
+// WithinBudget calls fn and returns its result, or a TimeoutError once
+// the time budget of the host is spent. The remote is cancelled then,
+// so fn starts no further commands, and the commands running on the
+// remote machine are interrupted. fn is left to return in the
+// background, so one slow host does not hold up the rest of a rollout.
+// done is called once fn returned, before WithinBudget returns unless
+// the budget ran out, in the background then.
+func (r *Remote) WithinBudget(fn func() (string, error), done func()) (string, error) {
+	budget, err := r.Host.TimeBudget()
+	if err != nil {
+		done()
+		return "", err
+	}
+	if budget == 0 {
+		defer done()
+		return fn()
+	}
+	type outcome struct {
+		result string
+		err    error
+	}
+	returned := make(chan outcome, 1)
+	go func() {
+		result, err := fn()
+		done()
+		returned <- outcome{result, err}
+	}()
+	select {
+	case o := <-returned:
+		return o.result, o.err
+	case <-r.clock().After(budget):
+	}
+	r.Cancel()
+	r.Interrupt()
+	return fmt.Sprintf("[%s] timed out.", r.Host.Name), &TimeoutError{Host: r.Host.Name, Budget: budget}
+}
+
+// Cancel keeps the remote from running commands or connecting again
+// Commands already running are left alone, Interrupt ends them.
+func (r *Remote) Cancel() {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.cancelled = true
+}
+
+// Cancelled returns whether the remote or its parent was cancelled
+func (r *Remote) Cancelled() bool {
+	if r.parent != nil && r.parent.Cancelled() {
+		return true
+	}
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return r.cancelled
+}
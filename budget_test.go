@@ -0,0 +1,68 @@
+// Hap - the simple and effective provisioner
+// Copyright (c) 2015 Garrett Woodworth (https://github.com/gwoo)
+// The BSD License http://opensource.org/licenses/bsd-license.php.
+
+package hap
+
+import (
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestWithinBudget(t *testing.T) {
+	r := &Remote{Host: &Host{Name: "web"}}
+	calls := 0
+	done := func() { calls++ }
+	result, err := r.WithinBudget(func() (string, error) { return "done", nil }, done)
+	if result != "done" || err != nil {
+		t.Errorf("expected the result without a budget, got %q %v", result, err)
+	}
+	r.Host.Budget = "50ms"
+	result, err = r.WithinBudget(func() (string, error) { return "done", nil }, done)
+	if result != "done" || err != nil {
+		t.Errorf("expected the result within the budget, got %q %v", result, err)
+	}
+	if calls != 2 {
+		t.Errorf("expected done after each call, got %d", calls)
+	}
+	release, returned := make(chan struct{}), make(chan struct{})
+	start := time.Now()
+	result, err = r.WithinBudget(func() (string, error) {
+		<-release
+		_, err := r.run([]string{"true"}, nil, nil)
+		return "done", err
+	}, func() { close(returned) })
+	if _, ok := err.(*TimeoutError); !ok || result != "[web] timed out." {
+		t.Errorf("expected the host to time out, got %q %v", result, err)
+	}
+	if elapsed := time.Since(start); elapsed > time.Second {
+		t.Errorf("expected to return once the budget was spent, took %s", elapsed)
+	}
+	if !r.Cancelled() {
+		t.Error("expected the remote to be cancelled")
+	}
+	select {
+	case <-returned:
+		t.Error("expected done to wait for fn to return")
+	default:
+	}
+	close(release)
+	<-returned
+	r.Host.Budget = "soon"
+	if _, err := r.WithinBudget(nil, func() {}); err == nil || !strings.Contains(err.Error(), "invalid time-budget") {
+		t.Errorf("expected an invalid time-budget, got %v", err)
+	}
+}
+
+func TestCancelled(t *testing.T) {
+	parent := &Remote{Host: &Host{Name: "web", Type: "local"}}
+	sr := &Remote{Host: parent.Host, parent: parent}
+	parent.Cancel()
+	if _, err := sr.run([]string{"true"}, nil, nil); err == nil || !strings.Contains(err.Error(), "[web] cancelled") {
+		t.Errorf("expected no commands after the parent was cancelled, got %v", err)
+	}
+	if _, err := parent.dial(); err == nil || !strings.Contains(err.Error(), "[web] cancelled") {
+		t.Errorf("expected no connections after the remote was cancelled, got %v", err)
+	}
+}
@@ -0,0 +1,111 @@
+// Hap - the simple and effective provisioner
+// Copyright (c) 2015 Garrett Woodworth (https://github.com/gwoo)
+// The BSD License http://opensource.org/licenses/bsd-license.php.
+
+package hap
+
+import (
+	"fmt"
+	"strings"
+)
+
+// Where the uploaded bundle is kept in the temp dir until it is fetched.
+const bundleFile string = "hap.bundle"
+
+// Upload writes data to the file at path on the remote machine
+// over the ssh session
+func (r *Remote) Upload(path string, data []byte) error {
+	return r.pipe(data, []string{fmt.Sprintf("cat > %s", path)})
+}
+
+// pipe executes the commands with data on stdin and counts it as Pushed
+func (r *Remote) pipe(data []byte, commands []string) error {
+	r.mu.Lock()
+	r.stdin = data
+	r.mu.Unlock()
+	defer func() {
+		r.mu.Lock()
+		r.stdin = nil
+		r.mu.Unlock()
+	}()
+	if err := r.Execute(commands); err != nil {
+		return err
+	}
+	r.pushed(int64(len(data)))
+	return nil
+}
+
+// pushed counts bytes sent to the host as Pushed
+func (r *Remote) pushed(size int64) {
+	r.mu.Lock()
+	r.Pushed += size
+	r.mu.Unlock()
+}
+
+// PushBundle updates the repo on the remote machine by uploading a
+// git bundle of the new commits over the ssh session. Unlike Push it
+// needs no ssh-agent and no git connection of its own, so it works
+// wherever hap itself can connect.
+func (r *Remote) PushBundle() error {
+	rev, target, err := r.target()
+	if err != nil {
+		return err
+	}
+	head, err := r.Git.Head()
+	if err != nil {
+		return err
+	}
+	result, err := r.read([]string{
+		"cd " + r.Dir,
+		fmt.Sprintf("git rev-parse -q --verify refs/heads/%s || git rev-parse -q --verify HEAD || true", target),
+	})
+	if err != nil {
+		return err
+	}
+	basis := strings.TrimSpace(string(result.Stdout))
+	if basis == head {
+		return nil
+	}
+	bundle, err := r.Git.Bundle(basis, rev)
+	if err != nil {
+		return err
+	}
+	tmp, err := r.Temp()
+	if err != nil {
+		return err
+	}
+	file := fmt.Sprintf("%s/%s", tmp, bundleFile)
+	if err := r.Upload(file, bundle); err != nil {
+		return err
+	}
+	return r.fetch(file, rev, target, "rm -f "+file)
+}
+
+// target returns the local rev to push and the branch it is pushed to
+// Detached heads are pushed to the happened branch.
+func (r *Remote) target() (string, string, error) {
+	branch, err := r.Git.Branch()
+	if err != nil {
+		return "", "", err
+	}
+	if branch == "HEAD" {
+		return "HEAD", "happened", nil
+	}
+	return "refs/heads/" + branch, branch, nil
+}
+
+// fetch updates the target branch on the remote machine from the rev
+// of the source and checks it out, like the post-receive hook does
+// after a push.
+func (r *Remote) fetch(source, rev, target string, after ...string) error {
+	cmds := []string{
+		"cd " + r.Dir,
+		fmt.Sprintf("git fetch -q --update-head-ok %s +%s:refs/heads/%s", source, rev, target),
+	}
+	cmds = append(cmds, after...)
+	cmds = append(cmds,
+		"git reset -q --hard",
+		"git checkout -q "+target,
+	)
+	return r.Execute(cmds)
+}
@@ -0,0 +1,86 @@
+// Hap - the simple and effective provisioner
+// Copyright (c) 2015 Garrett Woodworth (https://github.com/gwoo)
+// The BSD License http://opensource.org/licenses/bsd-license.php.
+
+package hap
+
+import (
+	"bufio"
+	"bytes"
+	"fmt"
+	"regexp"
+	"strings"
+)
+
+// RescueBranch is the prefix of the branches holding captured edits
+const RescueBranch = "hap-rescue"
+
+// Where the bundle of the captured edits is kept in the temp dir until it is downloaded.
+const rescueFile string = "rescue.bundle"
+
+// The files of the deploy dir that are not written by hap itself
+const rescuePaths string = ". \":(exclude).hap*\""
+
+// Commits the manual edits in the deploy dir on top of HEAD to the branch
+// with a separate index, leaving the checkout and HEAD as they are, then
+// bundles the commit and prints the changed files. It prints nothing
+// when there are no edits.
+const captureEdits string = "if [ -z \"$(git status --porcelain -- %[1]s)\" ]; then exit 0; fi && " +
+	"export GIT_INDEX_FILE=.git/hap-rescue.index && cp .git/index $GIT_INDEX_FILE && " +
+	"git add -A -- %[1]s && TREE=$(git write-tree) && rm -f $GIT_INDEX_FILE && unset GIT_INDEX_FILE && " +
+	"COMMIT=$(GIT_AUTHOR_NAME=\"%[2]s\" GIT_AUTHOR_EMAIL=hap@localhost GIT_COMMITTER_NAME=\"%[2]s\" GIT_COMMITTER_EMAIL=hap@localhost " +
+	"git commit-tree $TREE -p HEAD -m \"%[3]s\") && git update-ref refs/heads/%[4]s $COMMIT && " +
+	"git bundle create %[5]s refs/heads/%[4]s ^HEAD && git diff --name-status HEAD $COMMIT"
+
+// Matches characters that are not safe in branch names.
+var unsafeBranch = regexp.MustCompile(`[^A-Za-z0-9._-]`)
+
+// Edits are the manual edits captured from a remote machine
+type Edits struct {
+	Branch string
+	Files  []string
+}
+
+// CaptureEdits commits the manual edits in the deploy dir of the remote
+// machine, like emergency hotfixes, to a rescue branch in the remote
+// repo and fetches the branch into the local repo, so they can be
+// reviewed and merged before the next deploy resets them. It returns
+// no Edits when there is nothing to capture.
+func (r *Remote) CaptureEdits() (*Edits, error) {
+	tmp, err := r.Temp()
+	if err != nil {
+		return nil, err
+	}
+	now := r.clock().Now().UTC()
+	branch := fmt.Sprintf("%s/%s-%s", RescueBranch, unsafeBranch.ReplaceAllString(r.Host.Name, "_"), now.Format("20060102-150405"))
+	message := fmt.Sprintf("Capture manual edits on %s", unsafeChars.ReplaceAllString(r.Host.Name, "_"))
+	file := fmt.Sprintf("%s/%s", tmp, rescueFile)
+	result, err := r.Capture([]string{
+		"cd " + r.Dir,
+		fmt.Sprintf(captureEdits, rescuePaths, Operator(), message, branch, file),
+	})
+	if err != nil {
+		if result != nil {
+			err = fmt.Errorf("%s%s", result.Stderr, err)
+		}
+		return nil, err
+	}
+	edits := &Edits{Branch: branch}
+	scanner := bufio.NewScanner(bytes.NewReader(result.Stdout))
+	for scanner.Scan() {
+		if line := strings.TrimSpace(scanner.Text()); line != "" {
+			edits.Files = append(edits.Files, strings.Join(strings.Fields(line), " "))
+		}
+	}
+	if len(edits.Files) < 1 {
+		return nil, nil
+	}
+	bundle, err := r.Capture([]string{"cat " + file, "rm -f " + file})
+	if err != nil {
+		return nil, err
+	}
+	if err := r.Git.Unbundle(bundle.Stdout, "refs/heads/"+branch); err != nil {
+		return nil, fmt.Errorf("[%s] %s is kept on the remote: %s", r.Host.Name, branch, err)
+	}
+	return edits, nil
+}
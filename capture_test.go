@@ -0,0 +1,70 @@
+// Hap - the simple and effective provisioner
+// Copyright (c) 2015 Garrett Woodworth (https://github.com/gwoo)
+// The BSD License http://opensource.org/licenses/bsd-license.php.
+
+package hap
+
+import (
+	"bytes"
+	"io/ioutil"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestCaptureEdits(t *testing.T) {
+	home := t.TempDir()
+	t.Setenv("HOME", home)
+	work := filepath.Join(home, "work", "app")
+	os.MkdirAll(work, 0755)
+	wd, _ := os.Getwd()
+	os.Chdir(work)
+	defer os.Chdir(wd)
+	ioutil.WriteFile("app.conf", []byte("workers = 2\n"), 0644)
+	for _, args := range [][]string{{"init", "-q"}, {"add", "."}, {"-c", "user.name=me", "-c", "user.email=me@localhost", "commit", "-q", "-m", "one"}} {
+		if out, err := exec.Command("git", args...).CombinedOutput(); err != nil {
+			t.Fatalf("%s %s", out, err)
+		}
+	}
+	r, err := NewRemote(&Host{Name: "web 1", Type: "local", Transfer: "bundle"}, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer r.Close()
+	var out bytes.Buffer
+	r.Stdout, r.Stderr = &out, &out
+	if err := r.Initialize(); err != nil {
+		t.Fatal(err)
+	}
+	if err := r.PushBundle(); err != nil {
+		t.Fatal(err)
+	}
+	dir := filepath.Join(home, r.Dir)
+	if edits, err := r.CaptureEdits(); err != nil || edits != nil {
+		t.Fatalf("expected no edits, got %v %v", edits, err)
+	}
+	ioutil.WriteFile(filepath.Join(dir, "app.conf"), []byte("workers = 8\n"), 0644)
+	ioutil.WriteFile(filepath.Join(dir, "hotfix.sh"), []byte("echo fixed\n"), 0644)
+	ioutil.WriteFile(filepath.Join(dir, ".happended"), []byte("sha\n"), 0644)
+	edits, err := r.CaptureEdits()
+	if err != nil || edits == nil {
+		t.Fatalf("expected edits, got %v", err)
+	}
+	if !strings.HasPrefix(edits.Branch, "hap-rescue/web_1-") {
+		t.Errorf("unexpected branch %s", edits.Branch)
+	}
+	if !reflect.DeepEqual(edits.Files, []string{"M app.conf", "A hotfix.sh"}) {
+		t.Errorf("unexpected files %v", edits.Files)
+	}
+	b, err := exec.Command("git", "show", edits.Branch+":app.conf").CombinedOutput()
+	if err != nil || string(b) != "workers = 8\n" {
+		t.Errorf("expected the edit in the local branch, got %q %v", b, err)
+	}
+	b, err = exec.Command("git", "-C", dir, "status", "--porcelain", "--", "app.conf").CombinedOutput()
+	if err != nil || string(b) != " M app.conf\n" {
+		t.Errorf("expected the remote checkout to be left as is, got %q %v", b, err)
+	}
+}
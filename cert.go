@@ -0,0 +1,131 @@
+// Hap - the simple and effective provisioner
+// Copyright (c) 2015 Garrett Woodworth (https://github.com/gwoo)
+// The BSD License http://opensource.org/licenses/bsd-license.php.
+
+package hap
+
+import (
+	"bytes"
+	"crypto/ed25519"
+	"crypto/rand"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"os"
+	"os/exec"
+	"strings"
+	"sync"
+	"time"
+
+	"golang.org/x/crypto/ssh"
+)
+
+// Certificates issued during this run, keyed by signer and principal
+var certs = make(map[string]*ssh.Certificate)
+var certsMu sync.Mutex
+
+// Certify requests a short-lived user certificate for the host from
+// vault-ssh or cert-command and adds it to the ssh-agent, so both ssh
+// and git push authenticate with it. Certificates are reused while valid
+// by the clock, the SystemClock when it is nil.
+func (h *Host) Certify(clock Clock) error {
+	signer := h.VaultSSH
+	if signer == "" {
+		signer = h.CertCommand
+	}
+	if signer == "" {
+		return nil
+	}
+	certsMu.Lock()
+	defer certsMu.Unlock()
+	cacheKey := signer + "\x00" + h.Username
+	if cert, ok := certs[cacheKey]; ok && orSystemClock(clock).Now().Add(time.Minute).Before(time.Unix(int64(cert.ValidBefore), 0)) {
+		return nil
+	}
+	public, private, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		return err
+	}
+	pub, err := ssh.NewPublicKey(public)
+	if err != nil {
+		return err
+	}
+	authorized := strings.TrimSpace(string(ssh.MarshalAuthorizedKey(pub)))
+	var signed string
+	if h.VaultSSH != "" {
+		signed, err = signVault(h.VaultSSH, authorized, h.Username)
+	} else {
+		signed, err = signCommand(h.CertCommand, authorized, h.Username)
+	}
+	if err != nil {
+		return fmt.Errorf("[%s] certificate %s", h.Name, err)
+	}
+	key, _, _, _, err := ssh.ParseAuthorizedKey([]byte(signed))
+	if err != nil {
+		return fmt.Errorf("[%s] certificate %s", h.Name, err)
+	}
+	cert, ok := key.(*ssh.Certificate)
+	if !ok {
+		return fmt.Errorf("[%s] certificate signer did not return a certificate", h.Name)
+	}
+	if err := AddCertificate(private, cert); err != nil {
+		return err
+	}
+	certs[cacheKey] = cert
+	return nil
+}
+
+// signVault signs the public key with the vault ssh secrets engine
+// The path is the sign endpoint like ssh-client-signer/sign/my-role.
+func signVault(path, public, principal string) (string, error) {
+	addr := strings.TrimSuffix(os.Getenv("VAULT_ADDR"), "/")
+	if addr == "" {
+		return "", fmt.Errorf("requires VAULT_ADDR")
+	}
+	body, err := json.Marshal(map[string]string{
+		"public_key":       public,
+		"valid_principals": principal,
+		"cert_type":        "user",
+	})
+	if err != nil {
+		return "", err
+	}
+	req, err := http.NewRequest("POST", addr+"/v1/"+strings.TrimPrefix(path, "/"), bytes.NewReader(body))
+	if err != nil {
+		return "", err
+	}
+	req.Header.Set("X-Vault-Token", os.Getenv("VAULT_TOKEN"))
+	client := &http.Client{Timeout: 30 * time.Second}
+	resp, err := client.Do(req)
+	if err != nil {
+		return "", err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return "", fmt.Errorf("vault %s %s", path, resp.Status)
+	}
+	var result struct {
+		Data struct {
+			SignedKey string `json:"signed_key"`
+		}
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&result); err != nil {
+		return "", err
+	}
+	return result.Data.SignedKey, nil
+}
+
+// signCommand runs a local command like step ssh certificate to sign
+// the public key. The public key is passed on stdin and as HAP_PUBLIC_KEY,
+// the principal as HAP_PRINCIPAL, and the certificate is read from stdout.
+func signCommand(command, public, principal string) (string, error) {
+	cmd := exec.Command("sh", "-c", command)
+	cmd.Stdin = strings.NewReader(public + "\n")
+	cmd.Stderr = os.Stderr
+	cmd.Env = append(os.Environ(), "HAP_PUBLIC_KEY="+public, "HAP_PRINCIPAL="+principal)
+	b, err := cmd.Output()
+	if err != nil {
+		return "", err
+	}
+	return string(b), nil
+}
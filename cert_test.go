@@ -0,0 +1,71 @@
+// Hap - the simple and effective provisioner
+// Copyright (c) 2015 Garrett Woodworth (https://github.com/gwoo)
+// The BSD License http://opensource.org/licenses/bsd-license.php.
+
+package hap
+
+import (
+	"crypto/ed25519"
+	"crypto/rand"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"testing"
+	"time"
+
+	"golang.org/x/crypto/ssh"
+)
+
+func TestSignVault(t *testing.T) {
+	_, caKey, _ := ed25519.GenerateKey(rand.Reader)
+	ca, err := ssh.NewSignerFromKey(caKey)
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/v1/ssh/sign/deploy" || r.Header.Get("X-Vault-Token") != "token" {
+			w.WriteHeader(http.StatusForbidden)
+			return
+		}
+		var body map[string]string
+		json.NewDecoder(r.Body).Decode(&body)
+		key, _, _, _, err := ssh.ParseAuthorizedKey([]byte(body["public_key"]))
+		if err != nil {
+			w.WriteHeader(http.StatusBadRequest)
+			return
+		}
+		cert := &ssh.Certificate{
+			Key:             key,
+			CertType:        ssh.UserCert,
+			ValidPrincipals: []string{body["valid_principals"]},
+			ValidBefore:     uint64(time.Now().Add(time.Hour).Unix()),
+		}
+		cert.SignCert(rand.Reader, ca)
+		json.NewEncoder(w).Encode(map[string]interface{}{
+			"data": map[string]string{"signed_key": string(ssh.MarshalAuthorizedKey(cert))},
+		})
+	}))
+	defer ts.Close()
+	defer os.Setenv("VAULT_ADDR", os.Getenv("VAULT_ADDR"))
+	defer os.Setenv("VAULT_TOKEN", os.Getenv("VAULT_TOKEN"))
+	os.Setenv("VAULT_ADDR", ts.URL)
+	os.Setenv("VAULT_TOKEN", "token")
+	public, _, _ := ed25519.GenerateKey(rand.Reader)
+	pub, _ := ssh.NewPublicKey(public)
+	signed, err := signVault("ssh/sign/deploy", string(ssh.MarshalAuthorizedKey(pub)), "deploy")
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	key, _, _, _, err := ssh.ParseAuthorizedKey([]byte(signed))
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	cert, ok := key.(*ssh.Certificate)
+	if !ok || cert.ValidPrincipals[0] != "deploy" {
+		t.Errorf("unexpected certificate %v", key)
+	}
+}
@@ -0,0 +1,109 @@
+// Hap - the simple and effective provisioner
+// Copyright (c) 2015 Garrett Woodworth (https://github.com/gwoo)
+// The BSD License http://opensource.org/licenses/bsd-license.php.
+
+package hap
+
+import (
+	"sync"
+	"time"
+)
+
+// Clock tells the time and waits for the orchestration, like retry
+// backoffs, lb drains, service checks, leases, and reconnects
+// Remotes, rollouts, retries, and leases take one, a SimulatedClock
+// runs their waits without taking time. They use the SystemClock when
+// it is nil.
+type Clock interface {
+	Now() time.Time
+	Sleep(d time.Duration)
+	After(d time.Duration) <-chan time.Time
+}
+
+// orSystemClock returns the clock, or the SystemClock when it is nil
+func orSystemClock(clock Clock) Clock {
+	if clock == nil {
+		return SystemClock{}
+	}
+	return clock
+}
+
+// SystemClock is the Clock of the machine
+type SystemClock struct{}
+
+// Now returns the current time
+func (SystemClock) Now() time.Time {
+	return time.Now()
+}
+
+// Sleep pauses for the duration
+func (SystemClock) Sleep(d time.Duration) {
+	time.Sleep(d)
+}
+
+// After returns a channel receiving the time once the duration passed
+func (SystemClock) After(d time.Duration) <-chan time.Time {
+	return time.After(d)
+}
+
+// SimulatedClock is a Clock whose time only moves when it is slept on
+// or advanced, which makes waits instant and deterministic in tests
+// Sleeps of concurrent goroutines add up, as they all move the time.
+type SimulatedClock struct {
+	mu     sync.Mutex
+	now    time.Time
+	timers []simulatedTimer
+}
+
+// simulatedTimer is a channel of After waiting for its time
+type simulatedTimer struct {
+	at time.Time
+	c  chan time.Time
+}
+
+// NewSimulatedClock returns a SimulatedClock starting at the time
+func NewSimulatedClock(start time.Time) *SimulatedClock {
+	return &SimulatedClock{now: start}
+}
+
+// Now returns the simulated time
+func (c *SimulatedClock) Now() time.Time {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.now
+}
+
+// Sleep advances the simulated time by the duration and returns at once
+func (c *SimulatedClock) Sleep(d time.Duration) {
+	c.Advance(d)
+}
+
+// After returns a channel receiving the time once the simulated time
+// has advanced by the duration
+func (c *SimulatedClock) After(d time.Duration) <-chan time.Time {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	t := simulatedTimer{at: c.now.Add(d), c: make(chan time.Time, 1)}
+	if d <= 0 {
+		t.c <- c.now
+		return t.c
+	}
+	c.timers = append(c.timers, t)
+	return t.c
+}
+
+// Advance moves the simulated time forward and fires the due channels
+func (c *SimulatedClock) Advance(d time.Duration) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.now = c.now.Add(d)
+	pending := c.timers[:0]
+	for _, t := range c.timers {
+		if t.at.After(c.now) {
+			pending = append(pending, t)
+			continue
+		}
+		t.c <- c.now
+	}
+	c.timers = pending
+}
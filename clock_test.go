@@ -0,0 +1,69 @@
+// Hap - the simple and effective provisioner
+// Copyright (c) 2015 Garrett Woodworth (https://github.com/gwoo)
+// The BSD License http://opensource.org/licenses/bsd-license.php.
+
+package hap
+
+import (
+	"io"
+	"testing"
+	"time"
+)
+
+func TestSimulatedClock(t *testing.T) {
+	start := time.Date(2015, 6, 1, 12, 0, 0, 0, time.UTC)
+	clock := NewSimulatedClock(start)
+	soon, later := clock.After(time.Second), clock.After(time.Minute)
+	clock.Sleep(2 * time.Second)
+	select {
+	case now := <-soon:
+		if !now.Equal(start.Add(2 * time.Second)) {
+			t.Errorf("unexpected time %s", now)
+		}
+	default:
+		t.Error("expected the due channel to fire")
+	}
+	select {
+	case <-later:
+		t.Error("expected the channel to wait")
+	default:
+	}
+	clock.Advance(time.Minute)
+	<-later
+	if !clock.Now().Equal(start.Add(62 * time.Second)) {
+		t.Errorf("unexpected time %s", clock.Now())
+	}
+}
+
+func TestRetryDoSimulated(t *testing.T) {
+	clock := NewSimulatedClock(time.Unix(0, 0))
+	rt := Retry{Attempts: 4, Delay: time.Minute, Clock: clock}
+	attempts := 0
+	err := rt.Do(func() error {
+		attempts++
+		return io.EOF
+	}, nil)
+	if err != io.EOF || attempts != 4 {
+		t.Errorf("expected 4 attempts, got %d %v", attempts, err)
+	}
+	if waited := clock.Now().Sub(time.Unix(0, 0)); waited != 7*time.Minute {
+		t.Errorf("expected backoffs of 7m, got %s", waited)
+	}
+}
+
+func TestWaitLeaseSimulated(t *testing.T) {
+	clock := NewSimulatedClock(time.Now())
+	store := newMemoryStore()
+	a, err := AcquireLease(clock, store, "build", []string{"one"}, time.Hour, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer a.Release()
+	start := clock.Now()
+	if _, err := WaitLease(clock, store, "build", []string{"one"}, time.Hour, 10*time.Minute); err == nil {
+		t.Error("expected lease error after waiting")
+	}
+	if waited := clock.Now().Sub(start); waited < 9*time.Minute || waited > 10*time.Minute {
+		t.Errorf("expected to wait about 10m, waited %s", waited)
+	}
+}
@@ -6,6 +6,8 @@ package cli
 
 import (
 	"fmt"
+	"os"
+	"strings"
 
 	"github.com/gwoo/hap"
 )
@@ -28,8 +30,29 @@ func (cmd *BuildCmd) Help() string {
 	return "hap build\tRun the builds and commands from the Hapfile."
 }
 
-// Run the build command on the remote host
+// Run the build command on the remote host and notify about the result
 func (cmd *BuildCmd) Run(remote *hap.Remote) (string, error) {
+	result, err := cmd.build(remote)
+	if nerr := remote.Notify(err); nerr != nil {
+		fmt.Fprintf(os.Stderr, "[%s] notify failed: %s\n", remote.Host.Name, nerr)
+	}
+	return result, err
+}
+
+// build runs the confirm hook, takes the remote host out of its load
+// balancers, pushes, builds, checks its health and services, runs the
+// smoke tests, and puts it back. Failed hosts stay out of the load
+// balancers, and failed checks are build errors like failed builds.
+func (cmd *BuildCmd) build(remote *hap.Remote) (string, error) {
+	if err := remote.Confirm(); err != nil {
+		result := fmt.Sprintf("[%s] deploy not confirmed.", remote.Host.Name)
+		return result, err
+	}
+	deregistered, err := remote.Deregister()
+	if err != nil {
+		result := fmt.Sprintf("[%s] lb deregister failed.", remote.Host.Name)
+		return result, err
+	}
 	if result, err := Commands.Get("push").Run(remote); err != nil {
 		return result, err
 	}
@@ -37,6 +60,46 @@ func (cmd *BuildCmd) Run(remote *hap.Remote) (string, error) {
 		result := fmt.Sprintf("[%s] build failed.", remote.Host.Name)
 		return result, err
 	}
+	if err := remote.Health(); err != nil {
+		result := fmt.Sprintf("[%s] health check failed.", remote.Host.Name)
+		return result, &hap.BuildError{Host: remote.Host.Name, Err: err}
+	}
+	if err := remote.Expect(); err != nil {
+		result := fmt.Sprintf("[%s] service check failed.", remote.Host.Name)
+		return result, &hap.BuildError{Host: remote.Host.Name, Err: err}
+	}
+	smoke, err := remote.Smoketest()
+	if err != nil {
+		result := fmt.Sprintf("[%s] smoke test failed.", remote.Host.Name)
+		return result, &hap.BuildError{Host: remote.Host.Name, Err: err}
+	}
+	if deregistered {
+		if err := remote.Register(); err != nil {
+			result := fmt.Sprintf("[%s] lb register failed.", remote.Host.Name)
+			return result, err
+		}
+	}
 	result := fmt.Sprintf("[%s] build completed.", remote.Host.Name)
+	if len(smoke) > 0 {
+		lines := []string{}
+		for _, line := range smoke {
+			lines = append(lines, fmt.Sprintf("[%s] smoketest %s", remote.Host.Name, line))
+		}
+		result = strings.Join(append(lines, result), "\n")
+	}
+	if len(remote.Outputs) > 0 {
+		lines := []string{}
+		for _, line := range hap.OutputLines(remote.Outputs) {
+			lines = append(lines, fmt.Sprintf("[%s] output %s", remote.Host.Name, line))
+		}
+		result = strings.Join(append(lines, result), "\n")
+	}
+	if len(remote.Changelog) > 0 {
+		lines := []string{fmt.Sprintf("[%s] changelog:", remote.Host.Name)}
+		for _, line := range remote.Changelog {
+			lines = append(lines, fmt.Sprintf("[%s]   %s", remote.Host.Name, line))
+		}
+		result = strings.Join(append(lines, result), "\n")
+	}
 	return result, nil
 }
@@ -40,6 +40,11 @@ func (cmd *ArbitraryCmd) Run(remote *hap.Remote) (string, error) {
 		return "", fmt.Errorf("error: expects <command>")
 	}
 	arbitrary := strings.Join(args[1:], " ")
+	stop, err := remote.Record(arbitrary)
+	if err != nil {
+		return fmt.Sprintf("[%s] recording failed.", remote.Host.Name), err
+	}
+	defer stop()
 	if err := remote.Execute([]string{arbitrary}); err != nil {
 		result := fmt.Sprintf("[%s] `%s` failed.", remote.Host.Name, arbitrary)
 		return result, err
@@ -0,0 +1,49 @@
+// Hap - the simple and effective provisioner
+// Copyright (c) 2015 Garrett Woodworth (https://github.com/gwoo)
+// The BSD License http://opensource.org/licenses/bsd-license.php.
+
+package cli
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/gwoo/hap"
+)
+
+// Add the capture command
+func init() {
+	Commands.Add("capture", &CaptureCmd{})
+}
+
+// CaptureCmd is the capture command
+type CaptureCmd struct{}
+
+// IsRemote returns whether the command expects a remote
+func (cmd *CaptureCmd) IsRemote() bool {
+	return true
+}
+
+// Help returns help on the hap capture command
+func (cmd *CaptureCmd) Help() string {
+	return "hap capture\tCommit manual edits on the remote host to a rescue branch and fetch it."
+}
+
+// Run takes a remote and captures its manual edits
+func (cmd *CaptureCmd) Run(remote *hap.Remote) (string, error) {
+	edits, err := remote.CaptureEdits()
+	if err != nil {
+		result := fmt.Sprintf("[%s] capture failed.", remote.Host.Name)
+		return result, err
+	}
+	if edits == nil {
+		result := fmt.Sprintf("[%s] capture completed, no manual edits.", remote.Host.Name)
+		return result, nil
+	}
+	lines := []string{}
+	for _, file := range edits.Files {
+		lines = append(lines, fmt.Sprintf("[%s] edited: %s", remote.Host.Name, file))
+	}
+	lines = append(lines, fmt.Sprintf("[%s] capture completed, fetched %s.", remote.Host.Name, edits.Branch))
+	return strings.Join(lines, "\n"), nil
+}
@@ -0,0 +1,248 @@
+// Hap - the simple and effective provisioner
+// Copyright (c) 2015 Garrett Woodworth (https://github.com/gwoo)
+// The BSD License http://opensource.org/licenses/bsd-license.php.
+
+package cli
+
+import (
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"os/exec"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/gwoo/hap"
+)
+
+// Add the ci command
+func init() {
+	Commands.Add("ci", &CiCmd{})
+}
+
+// CiCmd deploys from non-interactive environments like GitHub Actions
+// The hosts are read from HAP_HOSTS (comma separated or "all") and
+// the ref to deploy from HAP_REF.
+type CiCmd struct{}
+
+// CiReport is the result of deploying a host from ci
+type CiReport struct {
+	Host      string
+	Result    string
+	Error     string            `json:",omitempty"`
+	Changelog []string          `json:",omitempty"`
+	Outputs   map[string]string `json:",omitempty"`
+}
+
+// IsRemote returns whether the command expects a remote
+func (cmd *CiCmd) IsRemote() bool {
+	return false
+}
+
+// Help returns help on the hap ci command
+func (cmd *CiCmd) Help() string {
+	return "hap ci deploy\tBuild the HAP_HOSTS at HAP_REF from ci."
+}
+
+// Run builds the hosts and writes annotations, outputs, and reports
+func (cmd *CiCmd) Run(remote *hap.Remote) (string, error) {
+	if flag.Arg(1) != "deploy" {
+		return "", fmt.Errorf("error: expects deploy")
+	}
+	if ref := os.Getenv("HAP_REF"); ref != "" {
+		c := exec.Command("git", "checkout", "-q", ref)
+		if result, err := c.CombinedOutput(); err != nil {
+			return string(result), err
+		}
+	}
+	hf, err := hap.NewHapfile()
+	if err != nil {
+		return "", err
+	}
+	state, err := hf.State.Store(hap.Git{})
+	if err != nil {
+		return "", err
+	}
+	var clock hap.Clock = hap.SystemClock{}
+	hosts := ciHosts(hf, os.Getenv("HAP_HOSTS"))
+	if len(hosts) < 1 {
+		return "", fmt.Errorf("error: no hosts found for HAP_HOSTS=%q", os.Getenv("HAP_HOSTS"))
+	}
+	if state != nil {
+		lease, err := ciLease(clock, hf.State, state, hosts)
+		if err != nil {
+			return "", err
+		}
+		defer func() {
+			if err := lease.Release(); err != nil {
+				fmt.Fprintln(os.Stderr, err)
+			}
+		}()
+	}
+	var mu sync.Mutex
+	reports := []CiReport{}
+	results := make(map[string]error)
+	rerr := hf.Rollout.Run(hosts, func(h *hap.Host, turns *hap.Turns) error {
+		var result string
+		var changelog []string
+		var outputs map[string]string
+		remote, err := hap.NewRemote(h, clock)
+		if err == nil {
+			remote.AllowUnverified = os.Getenv("HAP_ALLOW_UNVERIFIED") == "true"
+			remote.State = state
+			remote.Turns = turns
+			result, err = Commands.Get("build").Run(remote)
+			changelog, outputs = remote.Changelog, remote.Outputs
+			remote.Close()
+		}
+		report := CiReport{Host: h.Name, Result: result, Changelog: changelog, Outputs: outputs}
+		if err != nil {
+			report.Error = err.Error()
+			annotate("error", h.Name, fmt.Sprintf("%s\n%s", result, err))
+		} else {
+			annotate("notice", h.Name, result)
+		}
+		mu.Lock()
+		reports = append(reports, report)
+		results[h.Name] = err
+		mu.Unlock()
+		return err
+	})
+	sort.Slice(reports, func(i, j int) bool { return reports[i].Host < reports[j].Host })
+	succeeded, failed := []string{}, []string{}
+	outputs := make(map[string]map[string]string)
+	for _, report := range reports {
+		if report.Outputs != nil {
+			outputs[report.Host] = report.Outputs
+		}
+		if report.Error != "" {
+			failed = append(failed, report.Host)
+			continue
+		}
+		succeeded = append(succeeded, report.Host)
+	}
+	updated, derr := hf.UpdateDNS(clock, results)
+	for _, line := range updated {
+		fmt.Println(line)
+	}
+	if derr != nil {
+		annotate("error", "dns", derr.Error())
+	}
+	if err := ciOutputs(succeeded, failed, outputs); err != nil {
+		return "", err
+	}
+	if err := ciSummary(reports); err != nil {
+		return "", err
+	}
+	if path := os.Getenv("HAP_REPORT"); path != "" {
+		b, err := json.MarshalIndent(reports, "", "  ")
+		if err != nil {
+			return "", err
+		}
+		if err := ioutil.WriteFile(path, b, 0644); err != nil {
+			return "", err
+		}
+	}
+	result := fmt.Sprintf("ci deploy: %d succeeded, %d failed.", len(succeeded), len(failed))
+	if rerr != nil {
+		return result, rerr
+	}
+	if len(failed) > 0 {
+		return result, fmt.Errorf("ci deploy failed on %s", strings.Join(failed, ", "))
+	}
+	return result, derr
+}
+
+// ciHosts returns the hosts named in a comma separated list
+// An empty list or "all" returns all the hosts.
+func ciHosts(hf hap.Hapfile, names string) map[string]*hap.Host {
+	if names == "" || names == "all" {
+		return hf.GetHosts("", true)
+	}
+	hosts := make(map[string]*hap.Host)
+	for _, name := range strings.Split(names, ",") {
+		name = strings.TrimSpace(name)
+		for key, host := range hf.GetHosts(name, false) {
+			hosts[key] = host
+		}
+	}
+	return hosts
+}
+
+// ciLease takes the fleet lease of the hosts, waiting for the leases of
+// other operators for up to HAP_WAIT_LEASE
+func ciLease(clock hap.Clock, settings hap.State, store hap.Store, hosts map[string]*hap.Host) (*hap.Lease, error) {
+	ttl, err := settings.LeaseTTL()
+	if err != nil {
+		return nil, err
+	}
+	wait := time.Duration(0)
+	if env := os.Getenv("HAP_WAIT_LEASE"); env != "" {
+		if wait, err = time.ParseDuration(env); err != nil {
+			return nil, fmt.Errorf("error: invalid HAP_WAIT_LEASE=%q", env)
+		}
+	}
+	names := []string{}
+	for name := range hosts {
+		names = append(names, name)
+	}
+	return hap.WaitLease(clock, store, "ci deploy", names, ttl, wait)
+}
+
+// annotate prints a GitHub Actions workflow command for the host
+func annotate(level, host, message string) {
+	if os.Getenv("GITHUB_ACTIONS") != "true" {
+		return
+	}
+	escape := strings.NewReplacer("%", "%25", "\r", "%0D", "\n", "%0A")
+	fmt.Printf("::%s title=hap %s::%s\n", level, escape.Replace(host), escape.Replace(message))
+}
+
+// ciOutputs appends the succeeded and failed hosts and the outputs of
+// the builds by host as json to GITHUB_OUTPUT
+func ciOutputs(succeeded, failed []string, outputs map[string]map[string]string) error {
+	path := os.Getenv("GITHUB_OUTPUT")
+	if path == "" {
+		return nil
+	}
+	b, err := json.Marshal(outputs)
+	if err != nil {
+		return err
+	}
+	file, err := os.OpenFile(path, os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0644)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+	_, err = fmt.Fprintf(file, "succeeded=%s\nfailed=%s\noutputs=%s\n",
+		strings.Join(succeeded, ","), strings.Join(failed, ","), b)
+	return err
+}
+
+// ciSummary appends a markdown table of the reports to GITHUB_STEP_SUMMARY
+func ciSummary(reports []CiReport) error {
+	path := os.Getenv("GITHUB_STEP_SUMMARY")
+	if path == "" {
+		return nil
+	}
+	file, err := os.OpenFile(path, os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0644)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+	lines := []string{"| Host | Result |", "| --- | --- |"}
+	for _, report := range reports {
+		result := report.Result
+		if report.Error != "" {
+			result = fmt.Sprintf("%s %s", result, report.Error)
+		}
+		result = strings.Replace(strings.Replace(result, "|", "\\|", -1), "\n", " ", -1)
+		lines = append(lines, fmt.Sprintf("| %s | %s |", report.Host, result))
+	}
+	_, err = fmt.Fprintln(file, strings.Join(lines, "\n"))
+	return err
+}
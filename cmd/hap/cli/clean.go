@@ -0,0 +1,49 @@
+// Hap - the simple and effective provisioner
+// Copyright (c) 2015 Garrett Woodworth (https://github.com/gwoo)
+// The BSD License http://opensource.org/licenses/bsd-license.php.
+
+package cli
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/gwoo/hap"
+)
+
+// Add the clean command
+func init() {
+	Commands.Add("clean", &CleanCmd{})
+}
+
+// CleanCmd is the clean command
+type CleanCmd struct{}
+
+// IsRemote returns whether the command expects a remote
+func (cmd *CleanCmd) IsRemote() bool {
+	return true
+}
+
+// Help returns help on the hap clean command
+func (cmd *CleanCmd) Help() string {
+	return "hap clean\tRemove temp files left by crashed runs on the remote host."
+}
+
+// Run takes a remote and removes the leftovers of crashed runs
+func (cmd *CleanCmd) Run(remote *hap.Remote) (string, error) {
+	report, err := remote.Clean()
+	if err != nil {
+		result := fmt.Sprintf("[%s] clean failed.", remote.Host.Name)
+		return result, err
+	}
+	if len(report) < 1 {
+		result := fmt.Sprintf("[%s] clean completed, nothing to remove.", remote.Host.Name)
+		return result, nil
+	}
+	lines := []string{}
+	for _, line := range report {
+		lines = append(lines, fmt.Sprintf("[%s] %s", remote.Host.Name, line))
+	}
+	lines = append(lines, fmt.Sprintf("[%s] clean completed.", remote.Host.Name))
+	return strings.Join(lines, "\n"), nil
+}
@@ -0,0 +1,66 @@
+// Hap - the simple and effective provisioner
+// Copyright (c) 2015 Garrett Woodworth (https://github.com/gwoo)
+// The BSD License http://opensource.org/licenses/bsd-license.php.
+
+package cli
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/gwoo/hap"
+)
+
+// Add the diff command
+func init() {
+	Commands.Add("diff", &DiffCmd{})
+}
+
+// DiffCmd is the diff command
+type DiffCmd struct{}
+
+// IsRemote returns whether the command expects a remote
+func (cmd *DiffCmd) IsRemote() bool {
+	return true
+}
+
+// Help returns help on the hap diff command
+func (cmd *DiffCmd) Help() string {
+	return "hap diff\tShow what changed since the last build on the remote host."
+}
+
+// Run takes a remote and reports the commits, cmds, and scripts
+// that changed since its last successful build
+func (cmd *DiffCmd) Run(remote *hap.Remote) (string, error) {
+	name := remote.Host.Name
+	d, err := remote.Diff()
+	if err != nil {
+		result := fmt.Sprintf("[%s] diff failed.", name)
+		return result, err
+	}
+	lines := []string{}
+	switch {
+	case d.Deployed == "":
+		lines = append(lines, fmt.Sprintf("[%s] never built, build will run.", name))
+	case d.Pending():
+		lines = append(lines, fmt.Sprintf("[%s] %d new commits since %.7s, build will run.", name, len(d.Commits), d.Deployed))
+	default:
+		lines = append(lines, fmt.Sprintf("[%s] no new commits since %.7s, build will not run.", name, d.Deployed))
+	}
+	for _, commit := range d.Commits {
+		lines = append(lines, fmt.Sprintf("[%s]   %s", name, commit))
+	}
+	if d.Deployed != "" && !d.Recorded {
+		lines = append(lines, fmt.Sprintf("[%s] cmds of the last build were not recorded.", name))
+	}
+	for _, c := range d.Added {
+		lines = append(lines, fmt.Sprintf("[%s] + %s", name, c))
+	}
+	for _, c := range d.Removed {
+		lines = append(lines, fmt.Sprintf("[%s] - %s", name, c))
+	}
+	for _, script := range d.Scripts {
+		lines = append(lines, fmt.Sprintf("[%s] ~ %s", name, script))
+	}
+	return strings.Join(lines, "\n"), nil
+}
@@ -0,0 +1,44 @@
+// Hap - the simple and effective provisioner
+// Copyright (c) 2015 Garrett Woodworth (https://github.com/gwoo)
+// The BSD License http://opensource.org/licenses/bsd-license.php.
+
+package cli
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/gwoo/hap"
+)
+
+// Add the du command
+func init() {
+	Commands.Add("du", &DuCmd{})
+}
+
+// DuCmd is the du command
+type DuCmd struct{}
+
+// IsRemote returns whether the command expects a remote
+func (cmd *DuCmd) IsRemote() bool {
+	return true
+}
+
+// Help returns help on the hap du command
+func (cmd *DuCmd) Help() string {
+	return "hap du\tReport the disk usage of the remote host."
+}
+
+// Run takes a remote and reports the disk usage of the deployment
+func (cmd *DuCmd) Run(remote *hap.Remote) (string, error) {
+	usage, err := remote.Usage()
+	if err != nil {
+		result := fmt.Sprintf("[%s] du failed.", remote.Host.Name)
+		return result, err
+	}
+	lines := []string{fmt.Sprintf("[%s] %s", remote.Host.Name, usage)}
+	for _, warning := range usage.Warnings(remote.Host.DiskWarn) {
+		lines = append(lines, fmt.Sprintf("[%s] warning: %s", remote.Host.Name, warning))
+	}
+	return strings.Join(lines, "\n"), nil
+}
@@ -0,0 +1,108 @@
+// Hap - the simple and effective provisioner
+// Copyright (c) 2015 Garrett Woodworth (https://github.com/gwoo)
+// The BSD License http://opensource.org/licenses/bsd-license.php.
+
+package cli
+
+import (
+	"bufio"
+	"bytes"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+
+	"github.com/gwoo/hap"
+)
+
+// Add the edit command
+func init() {
+	Commands.Add("edit", &EditCmd{})
+}
+
+// EditCmd edits an encrypted Hapfile
+type EditCmd struct{}
+
+// IsRemote returns whether the command expects a remote
+func (cmd *EditCmd) IsRemote() bool {
+	return false
+}
+
+// Help returns help on the hap edit command
+func (cmd *EditCmd) Help() string {
+	return "hap edit\tEdit the encrypted Hapfile in $EDITOR."
+}
+
+// Run decrypts the Hapfile to a private temp file, opens it in the
+// editor, and encrypts it again. Invalid changes are only saved after
+// fixing them in the editor, which reopens until the Hapfile parses.
+func (cmd *EditCmd) Run(remote *hap.Remote) (string, error) {
+	sealed, err := ioutil.ReadFile(hap.HapfileName)
+	if err != nil {
+		return "edit failed.", err
+	}
+	if !hap.IsEncryptedHapfile(sealed) {
+		return "edit failed.", fmt.Errorf("%s is not encrypted, edit it directly or run hap encrypt", hap.HapfileName)
+	}
+	key, err := hap.NewSecretKey(hap.HapfileKey())
+	if err != nil {
+		return "edit failed.", err
+	}
+	plain, err := hap.DecryptHapfile(key, sealed)
+	if err != nil {
+		return "edit failed.", err
+	}
+	dir, err := ioutil.TempDir("", "hap-edit")
+	if err != nil {
+		return "edit failed.", err
+	}
+	defer os.RemoveAll(dir)
+	file := filepath.Join(dir, hap.HapfileName)
+	if err := ioutil.WriteFile(file, plain, 0600); err != nil {
+		return "edit failed.", err
+	}
+	in := bufio.NewReader(os.Stdin)
+	for {
+		if err := editFile(file); err != nil {
+			return "edit failed.", err
+		}
+		edited, err := ioutil.ReadFile(file)
+		if err != nil {
+			return "edit failed.", err
+		}
+		if bytes.Equal(edited, plain) {
+			return fmt.Sprintf("%s unchanged.", hap.HapfileName), nil
+		}
+		if _, err := hap.ParseHapfile(edited); err != nil {
+			fmt.Fprintf(os.Stderr, "invalid %s: %s\nedit again? [Y/n] ", hap.HapfileName, err)
+			answer, rerr := in.ReadString('\n')
+			if rerr != nil || strings.HasPrefix(strings.ToLower(strings.TrimSpace(answer)), "n") {
+				return "edit failed.", fmt.Errorf("%s is invalid, changes not saved: %s", hap.HapfileName, err)
+			}
+			continue
+		}
+		if sealed, err = hap.EncryptHapfile(key, edited); err != nil {
+			return "edit failed.", err
+		}
+		if err := hap.WriteHapfile(hap.HapfileName, sealed); err != nil {
+			return "edit failed.", err
+		}
+		return fmt.Sprintf("edit %s completed.", hap.HapfileName), nil
+	}
+}
+
+// editFile opens the file in $VISUAL, $EDITOR, or vi
+func editFile(file string) error {
+	editor := os.Getenv("VISUAL")
+	if editor == "" {
+		editor = os.Getenv("EDITOR")
+	}
+	if editor == "" {
+		editor = "vi"
+	}
+	c := exec.Command("sh", "-c", editor+" \"$1\"", "sh", file)
+	c.Stdin, c.Stdout, c.Stderr = os.Stdin, os.Stdout, os.Stderr
+	return c.Run()
+}
@@ -0,0 +1,53 @@
+// Hap - the simple and effective provisioner
+// Copyright (c) 2015 Garrett Woodworth (https://github.com/gwoo)
+// The BSD License http://opensource.org/licenses/bsd-license.php.
+
+package cli
+
+import (
+	"fmt"
+	"io/ioutil"
+
+	"github.com/gwoo/hap"
+)
+
+// Add the encrypt command
+func init() {
+	Commands.Add("encrypt", &EncryptCmd{})
+}
+
+// EncryptCmd encrypts the whole Hapfile with the secret key
+type EncryptCmd struct{}
+
+// IsRemote returns whether the command expects a remote
+func (cmd *EncryptCmd) IsRemote() bool {
+	return false
+}
+
+// Help returns help on the hap encrypt command
+func (cmd *EncryptCmd) Help() string {
+	return "hap encrypt\tEncrypt the Hapfile with the secret key."
+}
+
+// Run checks that the Hapfile is valid and replaces it encrypted
+func (cmd *EncryptCmd) Run(remote *hap.Remote) (string, error) {
+	plain, err := ioutil.ReadFile(hap.HapfileName)
+	if err != nil {
+		return "encrypt failed.", err
+	}
+	if _, err := hap.ParseHapfile(plain); err != nil {
+		return "encrypt failed.", err
+	}
+	key, err := hap.NewSecretKey(hap.HapfileKey())
+	if err != nil {
+		return "encrypt failed.", err
+	}
+	sealed, err := hap.EncryptHapfile(key, plain)
+	if err != nil {
+		return "encrypt failed.", err
+	}
+	if err := hap.WriteHapfile(hap.HapfileName, sealed); err != nil {
+		return "encrypt failed.", err
+	}
+	return fmt.Sprintf("encrypt %s with %s completed.", hap.HapfileName, hap.HapfileKey()), nil
+}
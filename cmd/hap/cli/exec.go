@@ -40,6 +40,11 @@ func (cmd *ExecCmd) Run(remote *hap.Remote) (string, error) {
 		return result, err
 	}
 	ex := strings.Join(args[1:], " ")
+	stop, err := remote.Record("./" + ex)
+	if err != nil {
+		return fmt.Sprintf("[%s] recording failed.", remote.Host.Name), err
+	}
+	defer stop()
 	if err := remote.Execute([]string{"cd " + remote.Dir, "./" + ex}); err != nil {
 		result := fmt.Sprintf("[%s] `%s` failed.", remote.Host.Name, args[1])
 		return result, err
@@ -0,0 +1,48 @@
+// Hap - the simple and effective provisioner
+// Copyright (c) 2015 Garrett Woodworth (https://github.com/gwoo)
+// The BSD License http://opensource.org/licenses/bsd-license.php.
+
+package cli
+
+import (
+	"flag"
+	"fmt"
+	"os"
+
+	"github.com/gwoo/hap"
+)
+
+// Add the explain command
+func init() {
+	Commands.Add("explain", &ExplainCmd{})
+}
+
+// ExplainCmd is the explain command
+type ExplainCmd struct{}
+
+// IsRemote returns whether the command expects a remote
+func (cmd *ExplainCmd) IsRemote() bool {
+	return false
+}
+
+// Help returns help on the hap explain command
+func (cmd *ExplainCmd) Help() string {
+	return "hap explain <host>\tPrint the resolved config of the host."
+}
+
+// Run prints the config of the host after applying the defaults
+func (cmd *ExplainCmd) Run(remote *hap.Remote) (string, error) {
+	name := flag.Arg(1)
+	if name == "" {
+		return "", fmt.Errorf("error: expects a host")
+	}
+	hf, err := hap.NewHapfile()
+	if err != nil {
+		return "", err
+	}
+	cwd, err := os.Getwd()
+	if err != nil {
+		return "", err
+	}
+	return hf.Explain(name, cwd)
+}
@@ -0,0 +1,41 @@
+// Hap - the simple and effective provisioner
+// Copyright (c) 2015 Garrett Woodworth (https://github.com/gwoo)
+// The BSD License http://opensource.org/licenses/bsd-license.php.
+
+package cli
+
+import (
+	"flag"
+	"fmt"
+	"strings"
+
+	"github.com/gwoo/hap"
+)
+
+// Add the freeze command
+func init() {
+	Commands.Add("freeze", &FreezeCmd{})
+}
+
+// FreezeCmd is the freeze command
+type FreezeCmd struct{}
+
+// IsRemote returns whether the command expects a remote
+func (cmd *FreezeCmd) IsRemote() bool {
+	return true
+}
+
+// Help returns help on the hap freeze command
+func (cmd *FreezeCmd) Help() string {
+	return "hap freeze [reason]\tRefuse builds of the host for every operator."
+}
+
+// Run takes a remote and freezes it in the shared state
+func (cmd *FreezeCmd) Run(remote *hap.Remote) (string, error) {
+	reason := strings.Join(flag.Args()[1:], " ")
+	if err := remote.Freeze(reason); err != nil {
+		result := fmt.Sprintf("[%s] freeze failed.", remote.Host.Name)
+		return result, err
+	}
+	return fmt.Sprintf("[%s] frozen.", remote.Host.Name), nil
+}
@@ -0,0 +1,45 @@
+// Hap - the simple and effective provisioner
+// Copyright (c) 2015 Garrett Woodworth (https://github.com/gwoo)
+// The BSD License http://opensource.org/licenses/bsd-license.php.
+
+package cli
+
+import (
+	"flag"
+	"io/ioutil"
+
+	"github.com/gwoo/hap"
+)
+
+// Add the graph command
+func init() {
+	Commands.Add("graph", &GraphCmd{})
+}
+
+// GraphCmd is the graph command
+type GraphCmd struct{}
+
+// IsRemote returns whether the command expects a remote
+func (cmd *GraphCmd) IsRemote() bool {
+	return false
+}
+
+// Help returns help on the hap graph command
+func (cmd *GraphCmd) Help() string {
+	return "hap graph [--format dot|mermaid]\tRender the hosts, groups, and builds of the Hapfile."
+}
+
+// Run renders the Hapfile as a graph in the format
+func (cmd *GraphCmd) Run(remote *hap.Remote) (string, error) {
+	flags := flag.NewFlagSet("graph", flag.ContinueOnError)
+	flags.SetOutput(ioutil.Discard)
+	format := flags.String("format", "dot", "Format of the graph, dot or mermaid.")
+	if err := flags.Parse(flag.Args()[1:]); err != nil {
+		return "", err
+	}
+	hf, err := hap.NewHapfile()
+	if err != nil {
+		return "", err
+	}
+	return hf.Graph(*format)
+}
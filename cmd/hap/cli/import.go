@@ -0,0 +1,54 @@
+// Hap - the simple and effective provisioner
+// Copyright (c) 2015 Garrett Woodworth (https://github.com/gwoo)
+// The BSD License http://opensource.org/licenses/bsd-license.php.
+
+package cli
+
+import (
+	"flag"
+	"fmt"
+
+	"github.com/gwoo/hap"
+)
+
+// Add the import command
+func init() {
+	Commands.Add("import", &ImportCmd{})
+}
+
+// ImportCmd is the import command
+type ImportCmd struct{}
+
+// IsRemote returns whether the command expects a remote
+func (cmd *ImportCmd) IsRemote() bool {
+	return false
+}
+
+// Help returns help on the hap import command
+func (cmd *ImportCmd) Help() string {
+	return "hap import <[user@]addr>\tPrint a Hapfile skeleton for an existing server."
+}
+
+// Run connects to the addr and prints a Hapfile skeleton of what runs there
+// The default section of an existing Hapfile applies to the connection.
+func (cmd *ImportCmd) Run(remote *hap.Remote) (string, error) {
+	target := flag.Arg(1)
+	if target == "" {
+		return "", fmt.Errorf("error: expects <[user@]addr>")
+	}
+	host := hap.ImportHost(target)
+	if hf, err := hap.NewHapfile(); err == nil {
+		host.SetDefaults(hf.Default)
+	}
+	remote, err := hap.NewRemote(host, nil)
+	if err != nil {
+		return "", err
+	}
+	defer remote.Close()
+	inv, err := remote.Inventory()
+	if err != nil {
+		result := fmt.Sprintf("[%s] import failed.", host.Name)
+		return result, err
+	}
+	return inv.Skeleton(host), nil
+}
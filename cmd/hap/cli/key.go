@@ -0,0 +1,81 @@
+// Hap - the simple and effective provisioner
+// Copyright (c) 2015 Garrett Woodworth (https://github.com/gwoo)
+// The BSD License http://opensource.org/licenses/bsd-license.php.
+
+package cli
+
+import (
+	"flag"
+	"fmt"
+	"sort"
+	"strings"
+
+	"github.com/gwoo/hap"
+)
+
+// Add the key command
+func init() {
+	Commands.Add("key", &KeyCmd{})
+}
+
+// KeyCmd issues deploy keys for groups
+type KeyCmd struct{}
+
+// IsRemote returns whether the command expects a remote
+func (cmd *KeyCmd) IsRemote() bool {
+	return false
+}
+
+// Help returns help on the hap key command
+func (cmd *KeyCmd) Help() string {
+	return "hap key issue <group>\tIssue a deploy key and install it on the group hosts."
+}
+
+// Run issues a deploy key for the group and authorizes it on each host
+func (cmd *KeyCmd) Run(remote *hap.Remote) (string, error) {
+	if flag.Arg(0) != "key" || flag.Arg(1) != "issue" || flag.Arg(2) == "" {
+		return "", fmt.Errorf("error: expects issue <group>")
+	}
+	group := flag.Arg(2)
+	hf, err := hap.NewHapfile()
+	if err != nil {
+		return "", err
+	}
+	hosts := hf.GetGroup(group)
+	if len(hosts) < 1 {
+		return "", fmt.Errorf("error: group %s has no hosts", group)
+	}
+	secret, err := (&hap.Host{SecretKey: hf.Default.SecretKey}).Secret()
+	if err != nil {
+		return "key issue failed.", err
+	}
+	public, err := hap.IssueKey(group, secret)
+	if err != nil {
+		return "key issue failed.", err
+	}
+	keys := []string{}
+	for key := range hosts {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	lines := []string{}
+	failed := 0
+	for _, key := range keys {
+		remote, err := hap.NewRemote(hosts[key], nil)
+		if err == nil {
+			err = remote.Authorize(public)
+			remote.Close()
+		}
+		if err != nil {
+			failed++
+			lines = append(lines, fmt.Sprintf("[%s] key install failed: %s", key, err))
+			continue
+		}
+		lines = append(lines, fmt.Sprintf("[%s] key installed.", key))
+	}
+	lines = append(lines, fmt.Sprintf("key issue %s completed, stored in %s.", group, hap.GroupKeyFile(group)))
+	if failed > 0 {
+		return strings.Join(lines, "\n"), fmt.Errorf("key install failed on %d hosts", failed)
+	}
+	return strings.Join(lines, "\n"), nil
+}
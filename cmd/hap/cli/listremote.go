@@ -0,0 +1,48 @@
+// Hap - the simple and effective provisioner
+// Copyright (c) 2015 Garrett Woodworth (https://github.com/gwoo)
+// The BSD License http://opensource.org/licenses/bsd-license.php.
+
+package cli
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/gwoo/hap"
+)
+
+// Add the list-remote command
+func init() {
+	Commands.Add("list-remote", &ListRemoteCmd{})
+}
+
+// ListRemoteCmd is the list-remote command
+type ListRemoteCmd struct{}
+
+// IsRemote returns whether the command expects a remote
+func (cmd *ListRemoteCmd) IsRemote() bool {
+	return true
+}
+
+// Help returns help on the hap list-remote command
+func (cmd *ListRemoteCmd) Help() string {
+	return "hap list-remote\tList the deploy dirs hap manages on the remote host."
+}
+
+// Run takes a remote and lists the deployments of every project on it
+func (cmd *ListRemoteCmd) Run(remote *hap.Remote) (string, error) {
+	deployments, err := remote.Deployments()
+	if err != nil {
+		result := fmt.Sprintf("[%s] list-remote failed.", remote.Host.Name)
+		return result, err
+	}
+	if len(deployments) < 1 {
+		result := fmt.Sprintf("[%s] no deployments.", remote.Host.Name)
+		return result, nil
+	}
+	lines := []string{}
+	for _, d := range deployments {
+		lines = append(lines, fmt.Sprintf("[%s] %s", remote.Host.Name, d))
+	}
+	return strings.Join(lines, "\n"), nil
+}
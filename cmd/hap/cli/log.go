@@ -0,0 +1,62 @@
+// Hap - the simple and effective provisioner
+// Copyright (c) 2015 Garrett Woodworth (https://github.com/gwoo)
+// The BSD License http://opensource.org/licenses/bsd-license.php.
+
+package cli
+
+import (
+	"flag"
+	"fmt"
+	"strconv"
+	"strings"
+
+	"github.com/gwoo/hap"
+)
+
+// Add the log command
+func init() {
+	Commands.Add("log", &LogCmd{})
+}
+
+// LogCmd is the log command
+type LogCmd struct{}
+
+// IsRemote returns whether the command expects a remote
+func (cmd *LogCmd) IsRemote() bool {
+	return true
+}
+
+// Help returns help on the hap log command
+func (cmd *LogCmd) Help() string {
+	return "hap log [n]\tList the last n deploys on the remote host."
+}
+
+// Run takes a remote and lists its deployment history
+func (cmd *LogCmd) Run(remote *hap.Remote) (string, error) {
+	n := 10
+	if arg := flag.Arg(1); arg != "" {
+		var err error
+		if n, err = strconv.Atoi(arg); err != nil || n < 1 {
+			return "", fmt.Errorf("error: expects [n] to be a positive number")
+		}
+	}
+	deploys, err := remote.History(n)
+	if err != nil {
+		result := fmt.Sprintf("[%s] log failed.", remote.Host.Name)
+		return result, err
+	}
+	if len(deploys) < 1 {
+		result := fmt.Sprintf("[%s] no deploys.", remote.Host.Name)
+		return result, nil
+	}
+	lines := []string{}
+	for _, d := range deploys {
+		lines = append(lines, fmt.Sprintf("[%s] %s %s %.7s %.7s %s %s",
+			remote.Host.Name, d.Time.Local().Format("2006-01-02 15:04:05"),
+			d.Operator, d.Local, d.Remote, d.Result, d.Duration))
+		for _, line := range d.Changelog {
+			lines = append(lines, fmt.Sprintf("[%s]   %s", remote.Host.Name, line))
+		}
+	}
+	return strings.Join(lines, "\n"), nil
+}
@@ -0,0 +1,44 @@
+// Hap - the simple and effective provisioner
+// Copyright (c) 2015 Garrett Woodworth (https://github.com/gwoo)
+// The BSD License http://opensource.org/licenses/bsd-license.php.
+
+package cli
+
+import (
+	"fmt"
+
+	"github.com/gwoo/hap"
+)
+
+// Add the migrate command
+func init() {
+	Commands.Add("migrate", &MigrateCmd{})
+}
+
+// MigrateCmd is the migrate command
+type MigrateCmd struct{}
+
+// IsRemote returns whether the command expects a remote
+func (cmd *MigrateCmd) IsRemote() bool {
+	return true
+}
+
+// Help returns help on the hap migrate command
+func (cmd *MigrateCmd) Help() string {
+	return "hap migrate\tUpgrade the remote host to the current layout."
+}
+
+// Run takes a remote and migrates it
+func (cmd *MigrateCmd) Run(remote *hap.Remote) (string, error) {
+	schema, err := remote.Migrate()
+	if err != nil {
+		result := fmt.Sprintf("[%s] migrate failed.", remote.Host.Name)
+		return result, err
+	}
+	if schema == hap.Schema {
+		result := fmt.Sprintf("[%s] migrate completed, already at schema %d.", remote.Host.Name, schema)
+		return result, nil
+	}
+	result := fmt.Sprintf("[%s] migrate from schema %d to %d completed.", remote.Host.Name, schema, hap.Schema)
+	return result, nil
+}
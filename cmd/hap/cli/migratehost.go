@@ -0,0 +1,96 @@
+// Hap - the simple and effective provisioner
+// Copyright (c) 2015 Garrett Woodworth (https://github.com/gwoo)
+// The BSD License http://opensource.org/licenses/bsd-license.php.
+
+package cli
+
+import (
+	"flag"
+	"fmt"
+	"io/ioutil"
+	"strings"
+
+	"github.com/gwoo/hap"
+)
+
+// Add the migrate-host command
+func init() {
+	Commands.Add("migrate-host", &MigrateHostCmd{})
+}
+
+// MigrateHostCmd is the migrate-host command
+type MigrateHostCmd struct{}
+
+// IsRemote returns whether the command expects a remote
+func (cmd *MigrateHostCmd) IsRemote() bool {
+	return false
+}
+
+// Help returns help on the hap migrate-host command
+func (cmd *MigrateHostCmd) Help() string {
+	return "hap migrate-host <old> <new> [--dir <old dir>]\tMove the state of a renamed or moved host."
+}
+
+// Run moves the local and shared state of the old host to the new one,
+// optionally moves the deploy dir on the remote, and verifies the
+// deployment
+func (cmd *MigrateHostCmd) Run(remote *hap.Remote) (string, error) {
+	flags := flag.NewFlagSet("migrate-host", flag.ContinueOnError)
+	flags.SetOutput(ioutil.Discard)
+	dir := flags.String("dir", "", "Deploy dir of the old host to move.")
+	names := []string{}
+	for args := flag.Args()[1:]; len(args) > 0; {
+		if err := flags.Parse(args); err != nil {
+			return "", err
+		}
+		if args = flags.Args(); len(args) > 0 {
+			names, args = append(names, args[0]), args[1:]
+		}
+	}
+	if len(names) != 2 {
+		return "", fmt.Errorf("error: expects <old> <new>")
+	}
+	hf, err := hap.NewHapfile()
+	if err != nil {
+		return "", err
+	}
+	host := hf.Host(names[1])
+	if host == nil || host.Name != names[1] {
+		return "", fmt.Errorf("error: host %q not found in the Hapfile", names[1])
+	}
+	state, err := hf.State.Store(hap.Git{})
+	if err != nil {
+		return "", err
+	}
+	lines, err := hap.RenameHost(names[0], host)
+	if err == nil && state != nil {
+		var shared []string
+		shared, err = hap.RenameShared(state, names[0], host)
+		lines = append(lines, shared...)
+	}
+	for i, line := range lines {
+		lines[i] = fmt.Sprintf("[%s] %s.", host.Name, line)
+	}
+	if err != nil {
+		return strings.Join(lines, "\n"), err
+	}
+	remote, err = hap.NewRemote(host, nil)
+	if err != nil {
+		return strings.Join(lines, "\n"), err
+	}
+	defer remote.Close()
+	if *dir != "" {
+		if err := remote.MoveDir(*dir); err != nil {
+			lines = append(lines, fmt.Sprintf("[%s] move of %s failed.", host.Name, *dir))
+			return strings.Join(lines, "\n"), err
+		}
+		lines = append(lines, fmt.Sprintf("[%s] moved %s to %s.", host.Name, *dir, remote.Dir))
+	}
+	schema, err := remote.VerifyDir()
+	if err != nil {
+		lines = append(lines, fmt.Sprintf("[%s] verify failed, use --dir to move the old deploy dir.", host.Name))
+		return strings.Join(lines, "\n"), err
+	}
+	lines = append(lines, fmt.Sprintf("[%s] migrate-host completed, %s at schema %d.", host.Name, remote.Dir, schema))
+	return strings.Join(lines, "\n"), nil
+}
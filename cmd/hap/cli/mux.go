@@ -0,0 +1,100 @@
+// Hap - the simple and effective provisioner
+// Copyright (c) 2015 Garrett Woodworth (https://github.com/gwoo)
+// The BSD License http://opensource.org/licenses/bsd-license.php.
+
+package cli
+
+import (
+	"flag"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"os/signal"
+	"sort"
+	"strings"
+	"sync"
+	"syscall"
+
+	"github.com/gwoo/hap"
+)
+
+// Add the mux command
+func init() {
+	Commands.Add("mux", &MuxCmd{})
+}
+
+// MuxCmd is the mux command
+type MuxCmd struct{}
+
+// IsRemote returns whether the command expects a remote
+func (cmd *MuxCmd) IsRemote() bool {
+	return false
+}
+
+// Help returns help on the hap mux command
+func (cmd *MuxCmd) Help() string {
+	return "hap mux [--idle 30m] [host...]\tKeep the connections to the hosts up for later runs of hap."
+}
+
+// Run shares the connections to the hosts, or all hosts, until they
+// are idle or hap is interrupted
+func (cmd *MuxCmd) Run(remote *hap.Remote) (string, error) {
+	flags := flag.NewFlagSet("mux", flag.ContinueOnError)
+	flags.SetOutput(ioutil.Discard)
+	idle := flags.Duration("idle", hap.DefaultMuxIdle, "Stop sharing a connection after it was idle this long.")
+	if err := flags.Parse(flag.Args()[1:]); err != nil {
+		return "", err
+	}
+	hf, err := hap.NewHapfile()
+	if err != nil {
+		return "", err
+	}
+	hosts := hf.GetHosts("", true)
+	if names := flags.Args(); len(names) > 0 {
+		hosts = map[string]*hap.Host{}
+		for _, name := range names {
+			host := hf.Host(name)
+			if host == nil || host.Name != name {
+				return "", fmt.Errorf("error: host %s not found", name)
+			}
+			hosts[name] = host
+		}
+	}
+	stop := make(chan struct{})
+	c := make(chan os.Signal, 1)
+	signal.Notify(c, os.Interrupt, syscall.SIGTERM)
+	defer signal.Stop(c)
+	go func() {
+		<-c
+		close(stop)
+	}()
+	var wg sync.WaitGroup
+	var mu sync.Mutex
+	errors := []string{}
+	for name, host := range hosts {
+		if !host.IsSSH() {
+			continue
+		}
+		wg.Add(1)
+		go func(name string, host *hap.Host) {
+			defer wg.Done()
+			remote, err := hap.NewRemote(host, nil)
+			if err == nil {
+				defer remote.Close()
+				fmt.Printf("[%s] sharing the connection until idle for %s.\n", name, *idle)
+				err = remote.Mux(*idle, stop)
+			}
+			if err != nil {
+				mu.Lock()
+				errors = append(errors, err.Error())
+				mu.Unlock()
+			}
+		}(name, host)
+	}
+	wg.Wait()
+	if len(errors) > 0 {
+		sort.Strings(errors)
+		return "mux failed.", fmt.Errorf("%s", strings.Join(errors, "\n"))
+	}
+	return "mux stopped.", nil
+}
@@ -30,6 +30,10 @@ func (cmd *PushCmd) Help() string {
 
 // Run takes a remote and pushes to it
 func (cmd *PushCmd) Run(remote *hap.Remote) (string, error) {
+	if err := remote.Verify(); err != nil {
+		result := fmt.Sprintf("[%s] push refused.", remote.Host.Name)
+		return result, err
+	}
 	if err := remote.PushSubmodules(); err != nil {
 		result := fmt.Sprintf("[%s] push failed.", remote.Host.Name)
 		return result, err
@@ -38,6 +42,10 @@ func (cmd *PushCmd) Run(remote *hap.Remote) (string, error) {
 		result := fmt.Sprintf("[%s] push failed.", remote.Host.Name)
 		return result, err
 	}
+	if err := remote.Sync(); err != nil {
+		result := fmt.Sprintf("[%s] sync failed.", remote.Host.Name)
+		return result, err
+	}
 	result := fmt.Sprintf("[%s] push completed.", remote.Host.Name)
 	return result, nil
 }
@@ -0,0 +1,49 @@
+// Hap - the simple and effective provisioner
+// Copyright (c) 2015 Garrett Woodworth (https://github.com/gwoo)
+// The BSD License http://opensource.org/licenses/bsd-license.php.
+
+package cli
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/gwoo/hap"
+)
+
+// Add the repair command
+func init() {
+	Commands.Add("repair", &RepairCmd{})
+}
+
+// RepairCmd is the repair command
+type RepairCmd struct{}
+
+// IsRemote returns whether the command expects a remote
+func (cmd *RepairCmd) IsRemote() bool {
+	return true
+}
+
+// Help returns help on the hap repair command
+func (cmd *RepairCmd) Help() string {
+	return "hap repair\tDetect and fix broken state on the remote host."
+}
+
+// Run takes a remote and repairs it
+func (cmd *RepairCmd) Run(remote *hap.Remote) (string, error) {
+	report, err := remote.Repair()
+	if err != nil {
+		result := fmt.Sprintf("[%s] repair failed.", remote.Host.Name)
+		return result, err
+	}
+	if len(report) < 1 {
+		result := fmt.Sprintf("[%s] repair completed, nothing to fix.", remote.Host.Name)
+		return result, nil
+	}
+	lines := []string{}
+	for _, line := range report {
+		lines = append(lines, fmt.Sprintf("[%s] fixed: %s", remote.Host.Name, line))
+	}
+	lines = append(lines, fmt.Sprintf("[%s] repair completed.", remote.Host.Name))
+	return strings.Join(lines, "\n"), nil
+}
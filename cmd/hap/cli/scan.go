@@ -0,0 +1,86 @@
+// Hap - the simple and effective provisioner
+// Copyright (c) 2015 Garrett Woodworth (https://github.com/gwoo)
+// The BSD License http://opensource.org/licenses/bsd-license.php.
+
+package cli
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+	"sync"
+
+	"github.com/gwoo/hap"
+)
+
+// Add the scan command
+func init() {
+	Commands.Add("scan", &ScanCmd{})
+}
+
+// ScanCmd is the scan command
+type ScanCmd struct{}
+
+// IsRemote returns whether the command expects a remote
+func (cmd *ScanCmd) IsRemote() bool {
+	return false
+}
+
+// Help returns help on the hap scan command
+func (cmd *ScanCmd) Help() string {
+	return "hap scan\tCollect the host keys and facts of all the hosts."
+}
+
+// Run connects to all the hosts in parallel and writes their host keys
+// to the KnownHostsFile and their facts to the InventoryFile
+func (cmd *ScanCmd) Run(remote *hap.Remote) (string, error) {
+	hf, err := hap.NewHapfile()
+	if err != nil {
+		return "", err
+	}
+	hosts := hf.GetHosts("", true)
+	scans := []hap.Scan{}
+	var mu sync.Mutex
+	var wg sync.WaitGroup
+	workers := make(chan struct{}, hap.ScanWorkers)
+	for _, host := range hosts {
+		wg.Add(1)
+		go func(host *hap.Host) {
+			defer wg.Done()
+			workers <- struct{}{}
+			defer func() { <-workers }()
+			s := hap.Scan{Host: host.Name, Addr: host.Addr}
+			remote, err := hap.NewRemote(host, nil)
+			if err != nil {
+				s.Err = err
+			} else {
+				s = remote.Scan()
+				remote.Close()
+			}
+			mu.Lock()
+			scans = append(scans, s)
+			mu.Unlock()
+		}(host)
+	}
+	wg.Wait()
+	sort.Slice(scans, func(i, j int) bool { return scans[i].Host < scans[j].Host })
+	lines := []string{}
+	failed := 0
+	for _, s := range scans {
+		if s.Err != nil {
+			failed++
+		}
+		lines = append(lines, fmt.Sprintf("[%s] %s", s.Host, s))
+	}
+	if err := hap.WriteKnownHosts(hap.KnownHostsFile, scans); err != nil {
+		return strings.Join(lines, "\n"), err
+	}
+	if err := hap.WriteInventory(hap.InventoryFile, scans); err != nil {
+		return strings.Join(lines, "\n"), err
+	}
+	lines = append(lines, fmt.Sprintf("scan: %d scanned, %d failed.", len(scans)-failed, failed))
+	if failed > 0 {
+		return strings.Join(lines, "\n"), fmt.Errorf("scan failed on %d hosts", failed)
+	}
+	return strings.Join(lines, "\n"), nil
+}
@@ -0,0 +1,59 @@
+// Hap - the simple and effective provisioner
+// Copyright (c) 2015 Garrett Woodworth (https://github.com/gwoo)
+// The BSD License http://opensource.org/licenses/bsd-license.php.
+
+package cli
+
+import (
+	"flag"
+	"fmt"
+
+	"github.com/gwoo/hap"
+)
+
+// Add the secret command
+func init() {
+	Commands.Add("secret", &SecretCmd{})
+}
+
+// SecretCmd generates keys and encrypts values for the Hapfile
+type SecretCmd struct{}
+
+// IsRemote returns whether the command expects a remote
+func (cmd *SecretCmd) IsRemote() bool {
+	return false
+}
+
+// Help returns help on the hap secret command
+func (cmd *SecretCmd) Help() string {
+	return "hap secret <keygen|encrypt> [value]\tCreate a secret key or encrypt a value for env."
+}
+
+// Run generates a key or encrypts a value
+func (cmd *SecretCmd) Run(remote *hap.Remote) (string, error) {
+	file := hap.DefaultSecretKey
+	if hf, err := hap.NewHapfile(); err == nil && hf.Default.SecretKey != "" {
+		file = hf.Default.SecretKey
+	}
+	switch flag.Arg(1) {
+	case "keygen":
+		if _, err := hap.GenerateSecretKey(file); err != nil {
+			return "secret keygen failed.", err
+		}
+		return fmt.Sprintf("secret keygen %s completed.", file), nil
+	case "encrypt":
+		if len(flag.Args()) != 3 {
+			return "", fmt.Errorf("error: expects encrypt <value>")
+		}
+		key, err := hap.NewSecretKey(file)
+		if err != nil {
+			return "secret encrypt failed.", err
+		}
+		value, err := key.Encrypt(flag.Arg(2))
+		if err != nil {
+			return "secret encrypt failed.", err
+		}
+		return value, nil
+	}
+	return "", fmt.Errorf("error: expects keygen or encrypt <value>")
+}
@@ -0,0 +1,74 @@
+// Hap - the simple and effective provisioner
+// Copyright (c) 2015 Garrett Woodworth (https://github.com/gwoo)
+// The BSD License http://opensource.org/licenses/bsd-license.php.
+
+package cli
+
+import (
+	"flag"
+	"fmt"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/gwoo/hap"
+)
+
+// Add the state command
+func init() {
+	Commands.Add("state", &StateCmd{})
+}
+
+// StateCmd shares the local state between operators and ci
+type StateCmd struct{}
+
+// IsRemote returns whether the command expects a remote
+func (cmd *StateCmd) IsRemote() bool {
+	return false
+}
+
+// Help returns help on the hap state command
+func (cmd *StateCmd) Help() string {
+	return "hap state <export|import> [file]\tShare the known hosts, facts, and last run with another machine."
+}
+
+// Run exports the local state to the file, by default named after the
+// time, or imports it from the file
+func (cmd *StateCmd) Run(remote *hap.Remote) (string, error) {
+	file := flag.Arg(2)
+	switch flag.Arg(1) {
+	case "export":
+		if file == "" {
+			file = fmt.Sprintf("hap-state-%s.tar.gz", time.Now().UTC().Format("20060102-150405"))
+		}
+		f, err := os.OpenFile(file, os.O_CREATE|os.O_WRONLY|os.O_EXCL, 0600)
+		if err != nil {
+			return "state export failed.", err
+		}
+		err = hap.ExportState(f)
+		if cerr := f.Close(); err == nil {
+			err = cerr
+		}
+		if err != nil {
+			os.Remove(file)
+			return "state export failed.", err
+		}
+		return fmt.Sprintf("state exported to %s.", file), nil
+	case "import":
+		if file == "" {
+			return "", fmt.Errorf("error: expects import <file>")
+		}
+		f, err := os.Open(file)
+		if err != nil {
+			return "state import failed.", err
+		}
+		defer f.Close()
+		changes, err := hap.ImportState(f)
+		lines := append(changes, "state import failed.")
+		if err == nil {
+			lines[len(lines)-1] = fmt.Sprintf("state import %s completed.", file)
+		}
+		return strings.Join(lines, "\n"), err
+	}
+	return "", fmt.Errorf("error: expects export [file] or import <file>")
+}
@@ -0,0 +1,61 @@
+// Hap - the simple and effective provisioner
+// Copyright (c) 2015 Garrett Woodworth (https://github.com/gwoo)
+// The BSD License http://opensource.org/licenses/bsd-license.php.
+
+package cli
+
+import (
+	"flag"
+	"fmt"
+	"os"
+	"time"
+
+	"github.com/gwoo/hap"
+)
+
+// Add the support-bundle command
+func init() {
+	Commands.Add("support-bundle", &SupportBundleCmd{})
+}
+
+// SupportBundleCmd collects what is needed to reproduce a problem
+type SupportBundleCmd struct{}
+
+// IsRemote returns whether the command expects a remote
+func (cmd *SupportBundleCmd) IsRemote() bool {
+	return false
+}
+
+// Help returns help on the hap support-bundle command
+func (cmd *SupportBundleCmd) Help() string {
+	return "hap support-bundle [file]\tWrite a redacted archive of the config and last run for bug reports."
+}
+
+// Run writes the support bundle to the file, by default named after the time
+func (cmd *SupportBundleCmd) Run(remote *hap.Remote) (string, error) {
+	file := flag.Arg(1)
+	if file == "" {
+		file = fmt.Sprintf("hap-support-%s.tar.gz", time.Now().UTC().Format("20060102-150405"))
+	}
+	b, err := hap.ReadHapfile(hap.HapfileName)
+	if err != nil {
+		return "support-bundle failed.", err
+	}
+	cwd, err := os.Getwd()
+	if err != nil {
+		return "support-bundle failed.", err
+	}
+	f, err := os.OpenFile(file, os.O_CREATE|os.O_WRONLY|os.O_EXCL, 0600)
+	if err != nil {
+		return "support-bundle failed.", err
+	}
+	err = hap.SupportBundle(f, b, cwd)
+	if cerr := f.Close(); err == nil {
+		err = cerr
+	}
+	if err != nil {
+		os.Remove(file)
+		return "support-bundle failed.", err
+	}
+	return fmt.Sprintf("support-bundle written to %s, review it before attaching it.", file), nil
+}
@@ -0,0 +1,38 @@
+// Hap - the simple and effective provisioner
+// Copyright (c) 2015 Garrett Woodworth (https://github.com/gwoo)
+// The BSD License http://opensource.org/licenses/bsd-license.php.
+
+package cli
+
+import (
+	"fmt"
+
+	"github.com/gwoo/hap"
+)
+
+// Add the unfreeze command
+func init() {
+	Commands.Add("unfreeze", &UnfreezeCmd{})
+}
+
+// UnfreezeCmd is the unfreeze command
+type UnfreezeCmd struct{}
+
+// IsRemote returns whether the command expects a remote
+func (cmd *UnfreezeCmd) IsRemote() bool {
+	return true
+}
+
+// Help returns help on the hap unfreeze command
+func (cmd *UnfreezeCmd) Help() string {
+	return "hap unfreeze\tAllow builds of a frozen host again."
+}
+
+// Run takes a remote and unfreezes it in the shared state
+func (cmd *UnfreezeCmd) Run(remote *hap.Remote) (string, error) {
+	if err := remote.Unfreeze(); err != nil {
+		result := fmt.Sprintf("[%s] unfreeze failed.", remote.Host.Name)
+		return result, err
+	}
+	return fmt.Sprintf("[%s] unfrozen.", remote.Host.Name), nil
+}
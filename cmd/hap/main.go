@@ -9,8 +9,11 @@ import (
 	"fmt"
 	"log"
 	"os"
+	"os/signal"
 	"sort"
+	"strings"
 	"sync"
+	"syscall"
 	"text/tabwriter"
 
 	"github.com/gwoo/hap"
@@ -20,8 +23,29 @@ import (
 var all = flag.Bool("all", false, "Use ALL the hosts.")
 var host = flag.String("host", "", "Individual host to use for commands.")
 var v = flag.Bool("v", false, "Verbose flag to print command log.")
+var forceUnlock = flag.Bool("force-unlock", false, "Remove a stale build lock on the remote.")
+var waitLease = flag.Duration("wait-lease", 0, "Wait for hosts leased by another operator instead of failing.")
+var allowUnverified = flag.Bool("allow-unverified", false, "Deploy to protected hosts without a successful ci status.")
+var metricsFile = flag.String("metrics-file", hap.MetricsFile, "Write the metrics of the run to the file, empty to skip.")
 var logger VerboseLogger
 
+// The shared state of the operators, nil without a backend
+var state hap.Store
+
+// The fleet lease held for the command, released when interrupted
+var held *hap.Lease
+
+// Commands that change the hosts and lease them in the shared state
+var leased = map[string]bool{"build": true, "init": true, "migrate": true, "push": true, "repair": true}
+
+// The results of the hosts, kept in the LastRunFile for support bundles
+var lastRun hap.LastRun
+var lastRunMu sync.Mutex
+
+// Remotes with commands in flight, interrupted on SIGINT or SIGTERM
+var active = make(map[*hap.Remote]bool)
+var activeMu sync.Mutex
+
 // Version is just the version of hap
 var Version string
 
@@ -35,7 +59,9 @@ func main() {
 	if err := new(hap.Git).Exists(); err != nil {
 		log.Fatal(err)
 	}
+	var clock hap.Clock = hap.SystemClock{}
 	logger = VerboseLogger(*v)
+	hap.Version = Version
 	if cmd := flag.Arg(0); cmd != "" {
 		command := cli.Commands.Get(cmd)
 		if command == nil {
@@ -43,44 +69,167 @@ func main() {
 			return
 		}
 		if !command.IsRemote() {
-			run(nil, command)
+			if err := run(clock, nil, nil, command); err != nil {
+				fmt.Fprintln(os.Stderr, err)
+				os.Exit(1)
+			}
 			return
 		}
 		hf, err := hap.NewHapfile()
 		if err != nil {
 			log.Fatal(err)
 		}
+		if state, err = hf.State.Store(hap.Git{}); err != nil {
+			log.Fatal(err)
+		}
 		hosts := hf.GetHosts(*host, *all)
 		if len(hosts) < 1 {
 			fmt.Printf("Missing flag -all or -host\n")
 			return
 		}
-		var wg sync.WaitGroup
-		for _, h := range hosts {
-			wg.Add(1)
-			go func(h *hap.Host) {
-				defer wg.Done()
-				run(h, command)
-			}(h)
+		if state != nil && leased[cmd] {
+			if held, err = lease(clock, hf, cmd, hosts); err != nil {
+				fmt.Println(err)
+				os.Exit(1)
+			}
+			defer func() {
+				if err := held.Release(); err != nil {
+					fmt.Println(err)
+				}
+			}()
+		}
+		go interrupt()
+		lastRun = hap.LastRun{Command: strings.Join(flag.Args(), " "), Operator: hap.Operator(), Version: Version, Time: clock.Now().UTC()}
+		var mu sync.Mutex
+		results := make(map[string]error)
+		err = hf.Rollout.Run(hosts, func(h *hap.Host, turns *hap.Turns) error {
+			err := run(clock, h, turns, command)
+			mu.Lock()
+			results[h.Name] = err
+			mu.Unlock()
+			return err
+		})
+		if err != nil {
+			fmt.Println(err)
+		}
+		lastRunMu.Lock()
+		lastRun.Duration = clock.Now().Sub(lastRun.Time)
+		logger.Println(hap.WriteLastRun(hap.LastRunFile, lastRun))
+		if *metricsFile != "" {
+			logger.Println(hap.WriteMetrics(*metricsFile, lastRun))
+		}
+		lastRunMu.Unlock()
+		if cmd == "build" {
+			updated, err := hf.UpdateDNS(clock, results)
+			for _, line := range updated {
+				fmt.Println(line)
+			}
+			if err != nil {
+				fmt.Println(err)
+			}
 		}
-		wg.Wait()
 	}
 }
 
-func run(host *hap.Host, command cli.Command) {
+// lease takes the fleet lease of the hosts for the command
+// With -wait-lease it waits for the leases of other operators to end.
+func lease(clock hap.Clock, hf hap.Hapfile, cmd string, hosts map[string]*hap.Host) (*hap.Lease, error) {
+	ttl, err := hf.State.LeaseTTL()
+	if err != nil {
+		return nil, err
+	}
+	names := []string{}
+	for name := range hosts {
+		names = append(names, name)
+	}
+	lease, err := hap.AcquireLease(clock, state, cmd, names, ttl, *forceUnlock)
+	if _, taken := err.(*hap.LeaseError); taken && *waitLease > 0 {
+		fmt.Printf("%s\nwaiting up to %s for the lease.\n", err, *waitLease)
+		lease, err = hap.WaitLease(clock, state, cmd, names, ttl, *waitLease)
+	}
+	return lease, err
+}
+
+func run(clock hap.Clock, host *hap.Host, turns *hap.Turns, command cli.Command) error {
 	var remote *hap.Remote
 	var err error
 	if host != nil {
-		remote, err = hap.NewRemote(host)
+		remote, err = hap.NewRemote(host, clock)
 		if err != nil {
 			fmt.Println(err)
-			return
+			return err
 		}
-		defer remote.Close()
+		remote.ForceUnlock = *forceUnlock
+		remote.AllowUnverified = *allowUnverified
+		remote.State = state
+		remote.Turns = turns
+		activeMu.Lock()
+		active[remote] = true
+		activeMu.Unlock()
+	}
+	start := clock.Now()
+	var result string
+	if remote != nil {
+		// The remote stays active until the command returned, even
+		// after it timed out, so interrupts still reach it.
+		result, err = remote.WithinBudget(func() (string, error) {
+			return command.Run(remote)
+		}, func() {
+			remote.Close()
+			activeMu.Lock()
+			delete(active, remote)
+			activeMu.Unlock()
+		})
+	} else {
+		result, err = command.Run(remote)
 	}
-	result, err := command.Run(remote)
 	logger.Println(err)
 	fmt.Println(result)
+	if remote != nil {
+		run := hap.RunResult{Host: host.Name, Result: result, Duration: clock.Now().Sub(start)}
+		if _, timedOut := err.(*hap.TimeoutError); timedOut {
+			// The cancelled command is still returning in the background
+			run.TimedOut = true
+		} else {
+			run.Pushed, run.Tail = remote.Pushed, remote.Tail
+		}
+		if err != nil {
+			run.Error = err.Error()
+		}
+		lastRunMu.Lock()
+		lastRun.Hosts = append(lastRun.Hosts, run)
+		lastRunMu.Unlock()
+	}
+	return err
+}
+
+// interrupt waits for SIGINT or SIGTERM, terminates the commands
+// running on the active remotes, and reports interrupted builds
+func interrupt() {
+	c := make(chan os.Signal, 1)
+	signal.Notify(c, os.Interrupt, syscall.SIGTERM)
+	<-c
+	activeMu.Lock()
+	var wg sync.WaitGroup
+	for remote := range active {
+		wg.Add(1)
+		go func(remote *hap.Remote) {
+			defer wg.Done()
+			building, err := remote.Interrupt()
+			if building {
+				fmt.Printf("[%s] interrupted mid-build.\n", remote.Host.Name)
+			}
+			logger.Println(err)
+		}(remote)
+	}
+	wg.Wait()
+	activeMu.Unlock()
+	if held != nil {
+		if err := held.Release(); err != nil {
+			fmt.Println(err)
+		}
+	}
+	os.Exit(130)
 }
 
 // Usage prints out the hap CLI usage
@@ -0,0 +1,71 @@
+// Hap - the simple and effective provisioner
+// Copyright (c) 2015 Garrett Woodworth (https://github.com/gwoo)
+// The BSD License http://opensource.org/licenses/bsd-license.php.
+
+package hap
+
+import (
+	"fmt"
+	"os"
+	"os/exec"
+	"sync"
+)
+
+// Runs one confirm hook at a time so their prompts do not interleave
+var confirmMu sync.Mutex
+
+// hookEnv returns the environment of the local hooks of the host, with
+// the host in HAP_HOSTNAME, HAP_ADDR, HAP_USER, and HAP_ZONE
+func (r *Remote) hookEnv() []string {
+	return append(os.Environ(),
+		"HAP_HOSTNAME="+r.Host.Name,
+		"HAP_ADDR="+r.Host.Addr,
+		"HAP_USER="+r.Host.Username,
+		"HAP_ZONE="+r.Host.Zone,
+	)
+}
+
+// connectHook runs the pre-connect or post-disconnect hook of the host
+// on the local machine. The output goes to the prefixed stderr, so it
+// does not mix with the output of commands that is captured.
+func (r *Remote) connectHook(name, hook string) error {
+	if hook == "" {
+		return nil
+	}
+	_, stderr := r.writers()
+	cmd := exec.Command("sh", "-c", hook)
+	cmd.Stdout, cmd.Stderr = stderr, stderr
+	cmd.Env = r.hookEnv()
+	if err := cmd.Run(); err != nil {
+		return fmt.Errorf("[%s] %s %s: %s", r.Host.Name, name, hook, err)
+	}
+	return nil
+}
+
+// Confirm runs the confirm hook of the host on the local machine
+// Besides the host, the hook gets the local sha in HAP_SHA and the
+// operator in HAP_OPERATOR.
+// It may prompt on the terminal, and a non-zero exit blocks the deploy.
+// Mock hosts record the hook to their transcript instead.
+func (r *Remote) Confirm() error {
+	if r.Host.Confirm == "" {
+		return nil
+	}
+	if mocked, err := r.mocked("confirm " + r.Host.Confirm); mocked || err != nil {
+		return err
+	}
+	sha, err := r.Git.Head()
+	if err != nil {
+		sha = "-"
+	}
+	stdout, stderr := r.writers()
+	cmd := exec.Command("sh", "-c", r.Host.Confirm)
+	cmd.Stdin, cmd.Stdout, cmd.Stderr = os.Stdin, stdout, stderr
+	cmd.Env = append(r.hookEnv(), "HAP_SHA="+sha, "HAP_OPERATOR="+Operator())
+	confirmMu.Lock()
+	defer confirmMu.Unlock()
+	if err := cmd.Run(); err != nil {
+		return fmt.Errorf("[%s] confirm %s: %s", r.Host.Name, r.Host.Confirm, err)
+	}
+	return nil
+}
@@ -0,0 +1,62 @@
+// Hap - the simple and effective provisioner
+// Copyright (c) 2015 Garrett Woodworth (https://github.com/gwoo)
+// The BSD License http://opensource.org/licenses/bsd-license.php.
+
+package hap
+
+import (
+	"bytes"
+	"io/ioutil"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"golang.org/x/crypto/ssh"
+)
+
+func TestConfirm(t *testing.T) {
+	var out bytes.Buffer
+	host := &Host{Name: "web", Zone: "eu-1a", Confirm: "echo \"$HAP_HOSTNAME $HAP_ZONE\" && test -z \"$BLOCK\""}
+	r := &Remote{Host: host, Stdout: &out, Stderr: &out}
+	if err := r.Confirm(); err != nil {
+		t.Fatal(err)
+	}
+	if out.String() != "web eu-1a\n" {
+		t.Errorf("unexpected output %q", out.String())
+	}
+	t.Setenv("BLOCK", "incident")
+	if err := r.Confirm(); err == nil || !strings.Contains(err.Error(), "[web] confirm") {
+		t.Errorf("expected blocked deploy, got %v", err)
+	}
+	r.Host = &Host{Name: "db"}
+	if err := r.Confirm(); err != nil {
+		t.Errorf("expected no hook to confirm, got %v", err)
+	}
+}
+
+func TestConnectHooks(t *testing.T) {
+	file := filepath.Join(t.TempDir(), "hooks")
+	host := &Host{
+		Name:       "web",
+		Addr:       "127.0.0.1:1",
+		PreConnect: "echo \"knock $HAP_HOSTNAME\" >> " + file,
+		Disconnect: "echo \"close $HAP_ADDR\" >> " + file,
+	}
+	r := &Remote{Host: host, Stdout: ioutil.Discard, Stderr: ioutil.Discard}
+	r.sshConfig = SSHConfig{Addr: host.Addr, ClientConfig: &ssh.ClientConfig{HostKeyCallback: ssh.InsecureIgnoreHostKey()}}
+	if err := r.Connect(); err == nil {
+		t.Fatal("expected the connection to fail")
+	}
+	b, _ := ioutil.ReadFile(file)
+	if string(b) != "knock web\nclose 127.0.0.1:1\n" {
+		t.Errorf("unexpected hooks %q", b)
+	}
+	host.PreConnect = "exit 1"
+	err := r.Connect()
+	if err == nil || !strings.Contains(err.Error(), "[web] pre-connect exit 1") {
+		t.Errorf("expected pre-connect to fail, got %v", err)
+	}
+	if b, _ := ioutil.ReadFile(file); strings.Count(string(b), "close") != 1 {
+		t.Errorf("expected no post-disconnect without a connection, got %q", b)
+	}
+}
@@ -0,0 +1,161 @@
+// Hap - the simple and effective provisioner
+// Copyright (c) 2015 Garrett Woodworth (https://github.com/gwoo)
+// The BSD License http://opensource.org/licenses/bsd-license.php.
+
+package hap
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/base64"
+	"fmt"
+	"path/filepath"
+	"strings"
+)
+
+// Writes the cmds of the build to the .hapbuild file next to .happended.
+const markBuild string = "echo %s | base64 -d > .hapbuild.tmp && chmod 0644 .hapbuild.tmp && mv -f .hapbuild.tmp .hapbuild"
+
+// Prints the sha and, when they were recorded, the cmds of the last successful build.
+const lastBuild string = "echo \"$(cat .happended 2>/dev/null)\" && if [ -f .hapbuild ]; then echo cmds: && cat .hapbuild; fi"
+
+// BuildDiff describes what changed since the last successful build
+// Recorded is false for builds made before hap recorded the cmds.
+type BuildDiff struct {
+	Deployed string
+	Head     string
+	Commits  []string
+	Added    []string
+	Removed  []string
+	Scripts  []string
+	Recorded bool
+}
+
+// Pending returns whether hap build would run the cmds
+func (d BuildDiff) Pending() bool {
+	return d.Deployed != d.Head
+}
+
+// Changed returns whether the cmds or their scripts changed
+func (d BuildDiff) Changed() bool {
+	return len(d.Added) > 0 || len(d.Removed) > 0 || len(d.Scripts) > 0
+}
+
+// markBuild returns the command that records the cmds of the build
+func (r *Remote) markBuild() string {
+	cmds := strings.Join(r.Host.Cmds(), "\n") + "\n"
+	return fmt.Sprintf(markBuild, base64.StdEncoding.EncodeToString([]byte(cmds)))
+}
+
+// Pending returns whether hap build would run the cmds for the local sha
+func (r *Remote) Pending() (bool, error) {
+	head, err := r.Git.Head()
+	if err != nil {
+		return false, err
+	}
+	result, err := r.read([]string{
+		fmt.Sprintf("if [ -d %s ]; then cat %[1]s/.happended 2>/dev/null; fi", r.Dir),
+	})
+	if err != nil {
+		return false, err
+	}
+	return strings.TrimSpace(string(result.Stdout)) != head, nil
+}
+
+// Diff compares the cmds and the local repo with the last successful
+// build on the remote machine
+func (r *Remote) Diff() (*BuildDiff, error) {
+	head, err := r.Git.Head()
+	if err != nil {
+		return nil, err
+	}
+	result, err := r.read([]string{"cd " + r.Dir, lastBuild})
+	if err != nil {
+		return nil, err
+	}
+	deployed, previous, recorded := parseLastBuild(result.Stdout)
+	d := &BuildDiff{Deployed: deployed, Head: head, Recorded: recorded}
+	if deployed == "" {
+		d.Added = r.Host.Cmds()
+		return d, nil
+	}
+	if recorded {
+		d.Added, d.Removed = diffCmds(previous, r.Host.Cmds())
+	}
+	if !d.Pending() {
+		return d, nil
+	}
+	if d.Commits, err = r.Git.Log(d.Deployed, head); err != nil {
+		return d, fmt.Errorf("[%s] deployed sha %.7s is unknown locally: %s", r.Host.Name, d.Deployed, err)
+	}
+	changed, err := r.Git.Changed(d.Deployed, head)
+	if err != nil {
+		return d, err
+	}
+	d.Scripts = changedScripts(r.Host.Cmds(), changed)
+	return d, nil
+}
+
+// parseLastBuild takes the output of lastBuild and returns the sha,
+// the cmds, and whether the cmds were recorded
+func parseLastBuild(b []byte) (string, []string, bool) {
+	scanner := bufio.NewScanner(bytes.NewReader(b))
+	if !scanner.Scan() {
+		return "", nil, false
+	}
+	deployed := strings.TrimSpace(scanner.Text())
+	if !scanner.Scan() || scanner.Text() != "cmds:" {
+		return deployed, nil, false
+	}
+	cmds := []string{}
+	for scanner.Scan() {
+		if line := scanner.Text(); line != "" {
+			cmds = append(cmds, line)
+		}
+	}
+	return deployed, cmds, true
+}
+
+// diffCmds returns the cmds that were added and removed
+func diffCmds(previous, current []string) ([]string, []string) {
+	seen := map[string]bool{}
+	for _, cmd := range previous {
+		seen[cmd] = true
+	}
+	added := []string{}
+	for _, cmd := range current {
+		if !seen[cmd] {
+			added = append(added, cmd)
+		}
+		delete(seen, cmd)
+	}
+	removed := []string{}
+	for _, cmd := range previous {
+		if seen[cmd] {
+			removed = append(removed, cmd)
+		}
+	}
+	return added, removed
+}
+
+// changedScripts returns the scripts run by the cmds that are among
+// the changed files of the repo
+func changedScripts(cmds, changed []string) []string {
+	files := map[string]bool{}
+	for _, file := range changed {
+		files[file] = true
+	}
+	scripts := []string{}
+	for _, cmd := range cmds {
+		fields := strings.Fields(cmd)
+		if len(fields) < 1 {
+			continue
+		}
+		script := filepath.Clean(fields[0])
+		if files[script] {
+			scripts = append(scripts, fields[0])
+			delete(files, script)
+		}
+	}
+	return scripts
+}
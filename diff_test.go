@@ -0,0 +1,42 @@
+// Hap - the simple and effective provisioner
+// Copyright (c) 2015 Garrett Woodworth (https://github.com/gwoo)
+// The BSD License http://opensource.org/licenses/bsd-license.php.
+
+package hap
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestParseLastBuild(t *testing.T) {
+	deployed, cmds, recorded := parseLastBuild([]byte("abc123\ncmds:\n./init.sh\n./update.sh --all\n"))
+	if deployed != "abc123" || !recorded {
+		t.Errorf("unexpected %s %v", deployed, recorded)
+	}
+	if !reflect.DeepEqual(cmds, []string{"./init.sh", "./update.sh --all"}) {
+		t.Errorf("unexpected cmds %v", cmds)
+	}
+	deployed, cmds, recorded = parseLastBuild([]byte("abc123\n"))
+	if deployed != "abc123" || recorded || cmds != nil {
+		t.Errorf("unexpected %s %v %v", deployed, cmds, recorded)
+	}
+	if deployed, _, _ = parseLastBuild([]byte("\n")); deployed != "" {
+		t.Errorf("expected no deployed sha, got %s", deployed)
+	}
+}
+
+func TestDiffCmds(t *testing.T) {
+	added, removed := diffCmds([]string{"./init.sh", "./old.sh"}, []string{"./init.sh", "./new.sh"})
+	if !reflect.DeepEqual(added, []string{"./new.sh"}) || !reflect.DeepEqual(removed, []string{"./old.sh"}) {
+		t.Errorf("unexpected added %v and removed %v", added, removed)
+	}
+}
+
+func TestChangedScripts(t *testing.T) {
+	cmds := []string{"./init.sh", "scripts/update.sh --all", "apt-get update", "./init.sh"}
+	scripts := changedScripts(cmds, []string{"init.sh", "scripts/update.sh", "README.md"})
+	if !reflect.DeepEqual(scripts, []string{"./init.sh", "scripts/update.sh"}) {
+		t.Errorf("unexpected scripts %v", scripts)
+	}
+}
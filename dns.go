@@ -0,0 +1,425 @@
+// Hap - the simple and effective provisioner
+// Copyright (c) 2015 Garrett Woodworth (https://github.com/gwoo)
+// The BSD License http://opensource.org/licenses/bsd-license.php.
+
+package hap
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"net"
+	"net/http"
+	"os"
+	"os/exec"
+	"sort"
+	"strings"
+	"time"
+)
+
+// DefaultTTL is the ttl of the dns records in seconds
+const DefaultTTL = 300
+
+// The cloudflare api used for the cloudflare records
+var cloudflareAPI = "https://api.cloudflare.com/client/v4"
+
+// DNS holds the settings of a record pointed at the hosts of a group
+// Type is route53 or cloudflare and Zone the id of the hosted zone.
+// Record is A (the default) or AAAA. With a Weight, route53 keeps a
+// weighted record for each host, identified by the name of the host.
+type DNS struct {
+	Type   string
+	Zone   string
+	Name   string
+	Record string
+	TTL    int
+	Weight int
+	name   string
+}
+
+// DNSUpdater points a record at the hosts that are up and away from
+// the ones that are down. Both map host names to addresses.
+type DNSUpdater interface {
+	Update(up, down map[string]string) error
+}
+
+// NewDNSUpdater takes the dns settings and returns the DNSUpdater
+func NewDNSUpdater(d *DNS) (DNSUpdater, error) {
+	for _, setting := range []string{d.Zone, d.Name} {
+		if !validLB.MatchString(setting) {
+			return nil, fmt.Errorf("[%s] invalid dns setting %q", d.name, setting)
+		}
+	}
+	record, ttl := d.Record, d.TTL
+	if record == "" {
+		record = "A"
+	}
+	if record != "A" && record != "AAAA" {
+		return nil, fmt.Errorf("[%s] invalid dns record %q", d.name, record)
+	}
+	if ttl == 0 {
+		ttl = DefaultTTL
+	}
+	switch d.Type {
+	case "":
+		return nil, fmt.Errorf("[%s] dns not found or without type", d.name)
+	case "route53":
+		return &Route53{Zone: d.Zone, Name: d.Name, Record: record, TTL: ttl, Weight: d.Weight}, nil
+	case "cloudflare":
+		token := os.Getenv("CLOUDFLARE_API_TOKEN")
+		if token == "" {
+			return nil, fmt.Errorf("[%s] cloudflare expects CLOUDFLARE_API_TOKEN", d.name)
+		}
+		return &Cloudflare{Zone: d.Zone, Name: d.Name, Record: record, TTL: ttl, Token: token}, nil
+	}
+	return nil, fmt.Errorf("[%s] invalid dns type %q", d.name, d.Type)
+}
+
+// Route53 updates records in a hosted zone using the aws cli
+type Route53 struct {
+	Zone   string
+	Name   string
+	Record string
+	TTL    int
+	Weight int
+}
+
+type route53Value struct {
+	Value string
+}
+
+type route53Set struct {
+	Name            string
+	Type            string
+	SetIdentifier   string `json:",omitempty"`
+	Weight          *int   `json:",omitempty"`
+	TTL             int
+	ResourceRecords []route53Value
+}
+
+type route53Change struct {
+	Action            string
+	ResourceRecordSet route53Set
+}
+
+// Update upserts the record with the addresses of the hosts
+// Weighted records get the Weight for hosts that are up and 0 for
+// hosts that are down. Otherwise the record keeps the addresses of
+// the hosts that were not updated.
+func (r *Route53) Update(up, down map[string]string) error {
+	changes := []route53Change{}
+	if r.Weight > 0 {
+		for _, host := range sortedKeys(up, down) {
+			weight, addr := 0, down[host]
+			if _, ok := up[host]; ok {
+				weight, addr = r.Weight, up[host]
+			}
+			changes = append(changes, r.change(host, &weight, []string{addr}))
+		}
+	} else {
+		current, err := r.values()
+		if err != nil {
+			return err
+		}
+		values, err := dnsValues(current, up, down)
+		if err != nil {
+			return err
+		}
+		changes = append(changes, r.change("", nil, values))
+	}
+	batch, err := json.Marshal(map[string]interface{}{"Changes": changes})
+	if err != nil {
+		return err
+	}
+	_, err = r.aws("change-resource-record-sets", "--hosted-zone-id", r.Zone, "--change-batch", string(batch))
+	return err
+}
+
+// change returns the upsert of the record with the values
+func (r *Route53) change(id string, weight *int, values []string) route53Change {
+	set := route53Set{Name: r.Name, Type: r.Record, SetIdentifier: id, Weight: weight, TTL: r.TTL}
+	for _, value := range values {
+		set.ResourceRecords = append(set.ResourceRecords, route53Value{value})
+	}
+	return route53Change{Action: "UPSERT", ResourceRecordSet: set}
+}
+
+// values returns the current values of the record
+func (r *Route53) values() ([]string, error) {
+	output, err := r.aws("list-resource-record-sets", "--hosted-zone-id", r.Zone,
+		"--start-record-name", r.Name, "--start-record-type", r.Record, "--max-items", "1")
+	if err != nil {
+		return nil, err
+	}
+	var list struct {
+		ResourceRecordSets []route53Set
+	}
+	if err := json.Unmarshal(output, &list); err != nil {
+		return nil, err
+	}
+	values := []string{}
+	for _, set := range list.ResourceRecordSets {
+		if strings.TrimSuffix(set.Name, ".") != strings.TrimSuffix(r.Name, ".") || set.Type != r.Record {
+			continue
+		}
+		for _, record := range set.ResourceRecords {
+			values = append(values, record.Value)
+		}
+	}
+	return values, nil
+}
+
+// aws runs the route53 command of the aws cli and returns its output
+func (r *Route53) aws(args ...string) ([]byte, error) {
+	cmd := exec.Command(awsCommand, append([]string{"route53"}, args...)...)
+	var stderr bytes.Buffer
+	cmd.Stderr = &stderr
+	output, err := cmd.Output()
+	if err != nil {
+		return nil, fmt.Errorf("%s\n%s", stderr.String(), err)
+	}
+	return output, nil
+}
+
+// Cloudflare updates records in a zone using the cloudflare api
+// Each address is a record of its own.
+type Cloudflare struct {
+	Zone   string
+	Name   string
+	Record string
+	TTL    int
+	Token  string
+}
+
+type cloudflareRecord struct {
+	ID      string `json:"id,omitempty"`
+	Type    string `json:"type"`
+	Name    string `json:"name"`
+	Content string `json:"content"`
+	TTL     int    `json:"ttl"`
+}
+
+// Update creates the records of the hosts that are up and deletes the
+// records of the hosts that are down
+func (c *Cloudflare) Update(up, down map[string]string) error {
+	var records []cloudflareRecord
+	path := fmt.Sprintf("/zones/%s/dns_records?type=%s&name=%s", c.Zone, c.Record, c.Name)
+	if err := c.request("GET", path, nil, &records); err != nil {
+		return err
+	}
+	current := []string{}
+	for _, record := range records {
+		current = append(current, record.Content)
+	}
+	values, err := dnsValues(current, up, down)
+	if err != nil {
+		return err
+	}
+	keep := map[string]bool{}
+	for _, value := range values {
+		keep[value] = true
+	}
+	for _, record := range records {
+		if keep[record.Content] {
+			delete(keep, record.Content)
+			continue
+		}
+		path := fmt.Sprintf("/zones/%s/dns_records/%s", c.Zone, record.ID)
+		if err := c.request("DELETE", path, nil, nil); err != nil {
+			return err
+		}
+	}
+	for _, value := range values {
+		if !keep[value] {
+			continue
+		}
+		record := cloudflareRecord{Type: c.Record, Name: c.Name, Content: value, TTL: c.TTL}
+		if err := c.request("POST", fmt.Sprintf("/zones/%s/dns_records", c.Zone), record, nil); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// request sends the body to the path of the api and decodes the result
+func (c *Cloudflare) request(method, path string, body, result interface{}) error {
+	var b []byte
+	if body != nil {
+		var err error
+		if b, err = json.Marshal(body); err != nil {
+			return err
+		}
+	}
+	req, err := http.NewRequest(method, cloudflareAPI+path, bytes.NewReader(b))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Authorization", "Bearer "+c.Token)
+	req.Header.Set("Content-Type", "application/json")
+	client := &http.Client{Timeout: 30 * time.Second}
+	resp, err := client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	var response struct {
+		Success bool
+		Errors  []struct{ Message string }
+		Result  json.RawMessage
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&response); err != nil || !response.Success {
+		messages := []string{resp.Status}
+		for _, e := range response.Errors {
+			messages = append(messages, e.Message)
+		}
+		return fmt.Errorf("[cloudflare] %s %s", method, strings.Join(messages, ", "))
+	}
+	if result == nil {
+		return nil
+	}
+	return json.Unmarshal(response.Result, result)
+}
+
+// dnsValues returns the current values without the addresses that are
+// down and with the addresses that are up. It refuses to leave the
+// record without any value.
+func dnsValues(current []string, up, down map[string]string) ([]string, error) {
+	remove := map[string]bool{}
+	for _, addr := range down {
+		remove[addr] = true
+	}
+	seen := map[string]bool{}
+	values := []string{}
+	for _, addr := range up {
+		delete(remove, addr)
+	}
+	for _, value := range current {
+		if !remove[value] && !seen[value] {
+			seen[value] = true
+			values = append(values, value)
+		}
+	}
+	for _, addr := range up {
+		if !seen[addr] {
+			seen[addr] = true
+			values = append(values, addr)
+		}
+	}
+	if len(values) < 1 {
+		return nil, fmt.Errorf("refusing to remove every address of the record")
+	}
+	sort.Strings(values)
+	return values, nil
+}
+
+// sortedKeys returns the keys of the maps in order
+func sortedKeys(maps ...map[string]string) []string {
+	keys := []string{}
+	for _, m := range maps {
+		for key := range m {
+			keys = append(keys, key)
+		}
+	}
+	sort.Strings(keys)
+	return keys
+}
+
+// Address returns the address of the host for the dns record
+// Host names in the addr are resolved to an ipv4 address for A
+// records and an ipv6 address for AAAA records.
+func (h *Host) Address(record string) (string, error) {
+	addr := h.Addr
+	if host, _, err := net.SplitHostPort(addr); err == nil {
+		addr = host
+	}
+	ips := []net.IP{net.ParseIP(addr)}
+	if ips[0] == nil {
+		var err error
+		if ips, err = net.LookupIP(addr); err != nil {
+			return "", err
+		}
+	}
+	for _, ip := range ips {
+		if (ip.To4() != nil) == (record != "AAAA") {
+			return ip.String(), nil
+		}
+	}
+	return "", fmt.Errorf("[%s] no %s address for %q", h.Name, record, h.Addr)
+}
+
+// UpdateDNS points the dns records of the groups at the hosts that were
+// built and away from the hosts whose build failed with a BuildError.
+// It takes the error of each host build, nil when it succeeded. Hosts
+// that were not built, or were refused, completed already, or timed
+// out keep their records, and mock hosts record the update to their
+// transcript instead, at the time of the clock. It returns a line for
+// each updated record.
+func (h Hapfile) UpdateDNS(clock Clock, results map[string]error) ([]string, error) {
+	names := []string{}
+	for name := range h.Groups {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	updated, errors := []string{}, []string{}
+	for _, name := range names {
+		group := h.Groups[name]
+		for _, key := range group.DNS {
+			settings, ok := h.DNS[key]
+			if !ok {
+				settings = &DNS{}
+			}
+			settings.name = key
+			up, down := map[string]string{}, map[string]string{}
+			var err error
+			for _, host := range group.Host {
+				berr, built := results[host]
+				if _, failed := berr.(*BuildError); !built || (berr != nil && !failed) {
+					continue
+				}
+				if h.Host(host).IsMock() {
+					state := "up"
+					if berr != nil {
+						state = "down"
+					}
+					if err = h.Host(host).transcribe(orSystemClock(clock).Now(), nil, []string{fmt.Sprintf("dns %s %s", key, state)}); err != nil {
+						break
+					}
+					continue
+				}
+				var addr string
+				record := settings.Record
+				if record == "" {
+					record = "A"
+				}
+				if addr, err = h.Host(host).Address(record); err != nil {
+					break
+				}
+				if berr != nil {
+					down[host] = addr
+					continue
+				}
+				up[host] = addr
+			}
+			if err == nil && len(up)+len(down) < 1 {
+				continue
+			}
+			var updater DNSUpdater
+			if err == nil {
+				updater, err = NewDNSUpdater(settings)
+			}
+			if err == nil {
+				err = updater.Update(up, down)
+			}
+			if err != nil {
+				errors = append(errors, fmt.Sprintf("[%s] dns update of %s failed: %s", name, key, err))
+				continue
+			}
+			updated = append(updated, fmt.Sprintf("[%s] dns %s updated: %d up, %d down.",
+				name, settings.Name, len(up), len(down)))
+		}
+	}
+	if len(errors) > 0 {
+		return updated, fmt.Errorf("%s", strings.Join(errors, "\n"))
+	}
+	return updated, nil
+}
@@ -0,0 +1,212 @@
+// Hap - the simple and effective provisioner
+// Copyright (c) 2015 Garrett Woodworth (https://github.com/gwoo)
+// The BSD License http://opensource.org/licenses/bsd-license.php.
+
+package hap
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"reflect"
+	"sort"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestDNSValues(t *testing.T) {
+	values, err := dnsValues([]string{"10.0.0.1", "10.0.0.2", "10.0.0.9"},
+		map[string]string{"three": "10.0.0.3"}, map[string]string{"two": "10.0.0.2"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(values, []string{"10.0.0.1", "10.0.0.3", "10.0.0.9"}) {
+		t.Errorf("unexpected values %v", values)
+	}
+	if _, err := dnsValues([]string{"10.0.0.2"}, nil, map[string]string{"two": "10.0.0.2"}); err == nil {
+		t.Error("expected error for removing every address")
+	}
+}
+
+func TestNewDNSUpdaterInvalid(t *testing.T) {
+	os.Unsetenv("CLOUDFLARE_API_TOKEN")
+	records := []*DNS{
+		{name: "none", Zone: "Z1", Name: "api.example.com"},
+		{name: "type", Type: "bind", Zone: "Z1", Name: "api.example.com"},
+		{name: "record", Type: "route53", Zone: "Z1", Name: "api.example.com", Record: "MX"},
+		{name: "name", Type: "route53", Zone: "Z1", Name: "api example"},
+		{name: "token", Type: "cloudflare", Zone: "Z1", Name: "api.example.com"},
+	}
+	for _, d := range records {
+		if _, err := NewDNSUpdater(d); err == nil {
+			t.Errorf("expected error for dns %s", d.name)
+		}
+	}
+}
+
+func TestRoute53Weighted(t *testing.T) {
+	dir := t.TempDir()
+	log := filepath.Join(dir, "aws.log")
+	script := filepath.Join(dir, "aws")
+	ioutil.WriteFile(script, []byte("#!/bin/sh\nprintf '%s\\n' \"$@\" > "+log+"\n"), 0755)
+	defer func(command string) { awsCommand = command }(awsCommand)
+	awsCommand = script
+
+	r := &Route53{Zone: "Z1", Name: "api.example.com", Record: "A", TTL: 60, Weight: 10}
+	if err := r.Update(map[string]string{"one": "10.0.0.1"}, map[string]string{"two": "10.0.0.2"}); err != nil {
+		t.Fatal(err)
+	}
+	b, _ := ioutil.ReadFile(log)
+	args := strings.Split(strings.TrimSpace(string(b)), "\n")
+	if len(args) != 6 || args[1] != "change-resource-record-sets" || args[3] != "Z1" {
+		t.Fatalf("unexpected args %v", args)
+	}
+	var batch struct {
+		Changes []route53Change
+	}
+	if err := json.Unmarshal([]byte(args[5]), &batch); err != nil {
+		t.Fatal(err)
+	}
+	weights := []string{}
+	for _, change := range batch.Changes {
+		set := change.ResourceRecordSet
+		weights = append(weights, fmt.Sprintf("%s %s %d %s", change.Action, set.SetIdentifier, *set.Weight, set.ResourceRecords[0].Value))
+	}
+	if strings.Join(weights, ",") != "UPSERT one 10 10.0.0.1,UPSERT two 0 10.0.0.2" {
+		t.Errorf("unexpected changes %v", weights)
+	}
+}
+
+func TestUpdateDNSBuildErrors(t *testing.T) {
+	home := t.TempDir()
+	t.Setenv("HOME", home)
+	work := filepath.Join(home, "work", "app")
+	os.MkdirAll(work, 0755)
+	wd, _ := os.Getwd()
+	os.Chdir(work)
+	defer os.Chdir(wd)
+	ioutil.WriteFile("main.go", []byte("package main"), 0644)
+	for _, args := range [][]string{{"init", "-q"}, {"add", "."}, {"-c", "user.name=me", "-c", "user.email=me@localhost", "commit", "-q", "-m", "main"}} {
+		if out, err := exec.Command("git", args...).CombinedOutput(); err != nil {
+			t.Fatalf("%s %s", out, err)
+		}
+	}
+	log := filepath.Join(home, "aws.log")
+	script := filepath.Join(home, "aws")
+	ioutil.WriteFile(script, []byte("#!/bin/sh\nprintf '%s\\n' \"$@\" > "+log+"\n"), 0755)
+	defer func(command string) { awsCommand = command }(awsCommand)
+	awsCommand = script
+
+	hf := Hapfile{
+		Hosts: map[string]*Host{
+			"one":   {Type: "local", Addr: "10.0.0.1:22", Transfer: "bundle", Cmd: []string{"echo build"}},
+			"two":   {Addr: "10.0.0.2:22"},
+			"three": {Addr: "10.0.0.3:22"},
+		},
+		Groups: map[string]*Group{"production": {Host: []string{"one", "two", "three"}, DNS: []string{"api"}}},
+		DNS:    map[string]*DNS{"api": {Type: "route53", Zone: "Z1", Name: "api.example.com", Weight: 10}},
+	}
+	r, err := NewRemote(hf.Host("one"), nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer r.Close()
+	r.Stdout, r.Stderr = ioutil.Discard, ioutil.Discard
+	if err := r.Initialize(); err != nil {
+		t.Fatal(err)
+	}
+	if err := r.PushBundle(); err != nil {
+		t.Fatal(err)
+	}
+	if err := r.Build(); err != nil {
+		t.Fatal(err)
+	}
+	// Already completed
+	completed := r.Build()
+	if _, failed := completed.(*BuildError); completed == nil || failed {
+		t.Fatalf("expected the build to be refused, got %#v", completed)
+	}
+	updated, err := hf.UpdateDNS(nil, map[string]error{
+		"one":   completed,
+		"two":   &BuildError{Host: "two", Err: fmt.Errorf("exit status 1")},
+		"three": &TimeoutError{Host: "three", Budget: time.Minute},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(updated) != 1 || updated[0] != "[production] dns api.example.com updated: 0 up, 1 down." {
+		t.Errorf("unexpected updates %v", updated)
+	}
+	b, _ := ioutil.ReadFile(log)
+	if strings.Contains(string(b), "10.0.0.1") || strings.Contains(string(b), "10.0.0.3") || !strings.Contains(string(b), "10.0.0.2") {
+		t.Errorf("expected only the failed host to change, got %s", b)
+	}
+}
+
+func TestCloudflare(t *testing.T) {
+	var mu sync.Mutex
+	calls := []string{}
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Header.Get("Authorization") != "Bearer secret" {
+			w.WriteHeader(http.StatusForbidden)
+			fmt.Fprint(w, `{"success":false,"errors":[{"message":"denied"}]}`)
+			return
+		}
+		mu.Lock()
+		defer mu.Unlock()
+		switch r.Method {
+		case "GET":
+			calls = append(calls, "GET "+r.URL.Query().Get("name"))
+			fmt.Fprint(w, `{"success":true,"result":[{"id":"r1","content":"10.0.0.1"},{"id":"r2","content":"10.0.0.2"}]}`)
+		case "POST":
+			var record cloudflareRecord
+			json.NewDecoder(r.Body).Decode(&record)
+			calls = append(calls, fmt.Sprintf("POST %s %s %d", record.Name, record.Content, record.TTL))
+			fmt.Fprint(w, `{"success":true,"result":{}}`)
+		case "DELETE":
+			calls = append(calls, "DELETE "+r.URL.Path)
+			fmt.Fprint(w, `{"success":true,"result":{}}`)
+		}
+	}))
+	defer ts.Close()
+	defer func(api string) { cloudflareAPI = api }(cloudflareAPI)
+	cloudflareAPI = ts.URL
+	os.Setenv("CLOUDFLARE_API_TOKEN", "secret")
+	defer os.Unsetenv("CLOUDFLARE_API_TOKEN")
+
+	hf := Hapfile{
+		Hosts: map[string]*Host{
+			"one":   {Addr: "10.0.0.1:22"},
+			"two":   {Addr: "10.0.0.2:22"},
+			"three": {Addr: "10.0.0.3:22"},
+			"four":  {Addr: "10.0.0.4:22"},
+		},
+		Groups: map[string]*Group{
+			"production": {Host: []string{"one", "two", "three", "four"}, DNS: []string{"api"}},
+			"staging":    {Host: []string{"four"}, DNS: []string{"missing"}},
+		},
+		DNS: map[string]*DNS{"api": {Type: "cloudflare", Zone: "Z1", Name: "api.example.com", TTL: 60}},
+	}
+	updated, err := hf.UpdateDNS(nil, map[string]error{"two": &BuildError{Host: "two", Err: fmt.Errorf("exit status 1")}, "three": nil})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(updated) != 1 || updated[0] != "[production] dns api.example.com updated: 1 up, 1 down." {
+		t.Errorf("unexpected updates %v", updated)
+	}
+	sort.Strings(calls)
+	expected := []string{"DELETE /zones/Z1/dns_records/r2", "GET api.example.com", "POST api.example.com 10.0.0.3 60"}
+	if !reflect.DeepEqual(calls, expected) {
+		t.Errorf("unexpected calls %v", calls)
+	}
+	if _, err := hf.UpdateDNS(nil, map[string]error{"four": nil}); err == nil {
+		t.Error("expected error for missing dns")
+	}
+}
@@ -0,0 +1,115 @@
+// Hap - the simple and effective provisioner
+// Copyright (c) 2015 Garrett Woodworth (https://github.com/gwoo)
+// The BSD License http://opensource.org/licenses/bsd-license.php.
+
+package hap
+
+import (
+	"fmt"
+	"path"
+	"regexp"
+	"strings"
+	"time"
+)
+
+// ExpectWait is how long services get to start listening after a build
+const ExpectWait = 10 * time.Second
+
+// Lists the listening tcp sockets and the running processes.
+// The expected names are matched locally, so they never show up in
+// the process list themselves.
+const listening string = "echo \"-- listen\" && (ss -ltn 2>/dev/null || netstat -ltn) && " +
+	"echo \"-- comm\" && ps -eo comm= && echo \"-- args\" && ps -eo args="
+
+// Matches expect-listen addresses like 8080, :8080, or 127.0.0.1:8080
+var validListen = regexp.MustCompile(`^(\[?[A-Za-z0-9.:*-]*\]?:)?[0-9]+$`)
+
+// Addresses that listen on every interface
+var wildcards = map[string]bool{"": true, "*": true, "0.0.0.0": true, "::": true}
+
+// Expect checks that the host listens on the expect-listen addresses
+// and runs the expect-process processes after a build. It keeps
+// checking for up to ExpectWait for services that start slowly.
+func (r *Remote) Expect() error {
+	if len(r.Host.Listen) < 1 && len(r.Host.Process) < 1 {
+		return nil
+	}
+	for _, listen := range r.Host.Listen {
+		if !validListen.MatchString(listen) {
+			return fmt.Errorf("[%s] invalid expect-listen %q", r.Host.Name, listen)
+		}
+	}
+	clock := r.clock()
+	deadline := clock.Now().Add(ExpectWait)
+	for {
+		result, err := r.read([]string{listening})
+		if err != nil {
+			return err
+		}
+		missing := expectMissing(string(result.Stdout), r.Host.Listen, r.Host.Process)
+		if len(missing) < 1 {
+			return nil
+		}
+		if clock.Now().After(deadline) {
+			return fmt.Errorf("[%s] %s", r.Host.Name, strings.Join(missing, ", "))
+		}
+		clock.Sleep(time.Second)
+	}
+}
+
+// expectMissing takes the output of listening and returns what is missing
+func expectMissing(output string, listen, process []string) []string {
+	addrs, names := []string{}, map[string]bool{}
+	section := ""
+	for _, line := range strings.Split(output, "\n") {
+		if strings.HasPrefix(line, "-- ") {
+			section = strings.TrimPrefix(line, "-- ")
+			continue
+		}
+		fields := strings.Fields(line)
+		if len(fields) < 1 {
+			continue
+		}
+		switch section {
+		case "listen":
+			if len(fields) > 3 {
+				addrs = append(addrs, fields[3])
+			}
+		case "comm":
+			names[fields[0]] = true
+		case "args":
+			names[path.Base(fields[0])] = true
+		}
+	}
+	missing := []string{}
+	for _, expected := range listen {
+		found := false
+		for _, addr := range addrs {
+			found = found || listensOn(addr, expected)
+		}
+		if !found {
+			missing = append(missing, fmt.Sprintf("not listening on %s", expected))
+		}
+	}
+	for _, name := range process {
+		if !names[name] {
+			missing = append(missing, fmt.Sprintf("process %s not running", name))
+		}
+	}
+	return missing
+}
+
+// listensOn returns whether the local address of a socket, like
+// 0.0.0.0:8080 or [::]:8080, serves the expected address
+func listensOn(addr, expected string) bool {
+	if !strings.Contains(expected, ":") {
+		expected = ":" + expected
+	}
+	i, j := strings.LastIndex(addr, ":"), strings.LastIndex(expected, ":")
+	if i < 0 || addr[i+1:] != expected[j+1:] {
+		return false
+	}
+	host := strings.Trim(strings.SplitN(addr[:i], "%", 2)[0], "[]")
+	want := strings.Trim(expected[:j], "[]")
+	return wildcards[want] || wildcards[host] || host == want
+}
@@ -0,0 +1,41 @@
+// Hap - the simple and effective provisioner
+// Copyright (c) 2015 Garrett Woodworth (https://github.com/gwoo)
+// The BSD License http://opensource.org/licenses/bsd-license.php.
+
+package hap
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+)
+
+const listeningOutput = `-- listen
+State  Recv-Q Send-Q Local Address:Port  Peer Address:Port
+LISTEN 0      128          0.0.0.0:22         0.0.0.0:*
+LISTEN 0      128        127.0.0.1:5432       0.0.0.0:*
+LISTEN 0      128             [::]:8080          [::]:*
+-- comm
+systemd
+myapp-worker-lo
+-- args
+/sbin/init
+/usr/local/bin/myapp-worker-long --queue default
+`
+
+func TestExpectMissing(t *testing.T) {
+	missing := expectMissing(listeningOutput,
+		[]string{"22", ":8080", "127.0.0.1:8080", "127.0.0.1:5432", "10.0.0.1:5432", ":9000"},
+		[]string{"systemd", "myapp-worker-long", "nginx"})
+	expected := []string{"not listening on 10.0.0.1:5432", "not listening on :9000", "process nginx not running"}
+	if !reflect.DeepEqual(missing, expected) {
+		t.Errorf("unexpected missing %v", missing)
+	}
+}
+
+func TestExpectInvalid(t *testing.T) {
+	r := &Remote{Host: &Host{Name: "one", Listen: []string{"8080; reboot"}}}
+	if err := r.Expect(); err == nil || !strings.Contains(err.Error(), "invalid expect-listen") {
+		t.Errorf("expected invalid expect-listen, got %v", err)
+	}
+}
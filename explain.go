@@ -0,0 +1,164 @@
+// Hap - the simple and effective provisioner
+// Copyright (c) 2015 Garrett Woodworth (https://github.com/gwoo)
+// The BSD License http://opensource.org/licenses/bsd-license.php.
+
+package hap
+
+import (
+	"fmt"
+	"reflect"
+	"regexp"
+	"sort"
+	"strings"
+)
+
+// Matches values that need no quotes in the Hapfile
+var plainValue = regexp.MustCompile(`^[A-Za-z0-9_./:@~,+=-]*$`)
+
+// Explain returns the resolved config of the host in Hapfile syntax
+// Each setting is marked with where it came from, followed by the
+// deploy dir, groups, keys, load balancers, smoke tests, notifiers,
+// and the commands of the build in the order they run. It takes the
+// working dir, which names the deploy dir by default.
+func (h Hapfile) Explain(name, cwd string) (string, error) {
+	configured, ok := h.Hosts[name]
+	if !ok {
+		return "", fmt.Errorf("host %q not found", name)
+	}
+	raw := *configured
+	host := h.Host(name)
+	resolved := *host
+	var resolveErr error
+	if resolved.SSHConfig && resolved.IsSSH() {
+		resolveErr = resolved.ResolveSSHConfig()
+	}
+	lines := []string{fmt.Sprintf("[host %q]", name)}
+	rv, hv, dv := reflect.ValueOf(raw), reflect.ValueOf(*host), reflect.ValueOf(resolved)
+	t := rv.Type()
+	for i := 0; i < t.NumField(); i++ {
+		field := t.Field(i)
+		if field.PkgPath != "" || field.Name == "Name" {
+			continue
+		}
+		value := dv.Field(i)
+		if value.IsZero() {
+			continue
+		}
+		source := ""
+		switch {
+		case !reflect.DeepEqual(value.Interface(), hv.Field(i).Interface()):
+			source = "ssh-config"
+		case rv.Field(i).IsZero():
+			source = "default"
+		}
+		key := field.Tag.Get("gcfg")
+		if key == "" {
+			key = strings.ToLower(field.Name)
+		}
+		values := []string{fmt.Sprint(value.Interface())}
+		if value.Kind() == reflect.Slice {
+			values = value.Interface().([]string)
+		}
+		for _, v := range values {
+			if field.Name == "Password" {
+				v = "***"
+			}
+			if !plainValue.MatchString(v) {
+				v = fmt.Sprintf("%q", v)
+			}
+			line := fmt.Sprintf("\t%s = %s", key, v)
+			if source != "" {
+				line += " # " + source
+			}
+			lines = append(lines, line)
+		}
+	}
+	if resolveErr != nil {
+		lines = append(lines, fmt.Sprintf("# ssh-config failed: %s", resolveErr))
+	}
+	dir, err := deployDir(&resolved, cwd)
+	if err != nil {
+		return "", err
+	}
+	repo, err := hostRepo(&resolved, dir, cwd)
+	if err != nil {
+		return "", err
+	}
+	lines = append(lines, fmt.Sprintf("# dir: %s", dir), fmt.Sprintf("# repo: %s", repo))
+	for _, file := range resolved.Identities() {
+		lines = append(lines, fmt.Sprintf("# identity: %s (ssh-config)", file))
+	}
+	groups := []string{}
+	for group, g := range h.Groups {
+		for _, member := range g.Host {
+			if member == name {
+				groups = append(groups, group)
+			}
+		}
+	}
+	sort.Strings(groups)
+	for _, group := range groups {
+		line := "# group: " + group
+		if dns := h.Groups[group].DNS; len(dns) > 0 {
+			line += " dns " + strings.Join(dns, " ")
+		}
+		lines = append(lines, line)
+	}
+	for _, key := range host.Keys() {
+		lines = append(lines, fmt.Sprintf("# deploy key: %s", key))
+	}
+	for _, lb := range host.lbs {
+		lines = append(lines, fmt.Sprintf("# lb: %s %s target %s", lb.name, lb.Type, host.Target(lb)))
+	}
+	for _, test := range host.smoke {
+		lines = append(lines, fmt.Sprintf("# smoketest: %s %s", test.name, strings.Replace(test.URL, "{addr}", host.hostname(), -1)))
+	}
+	notifiers := []string{}
+	for key, n := range h.Notify {
+		if n.Wants(name, true) || n.Wants(name, false) {
+			notifiers = append(notifiers, key)
+		}
+	}
+	sort.Strings(notifiers)
+	for _, key := range notifiers {
+		n := h.Notify[key]
+		lines = append(lines, fmt.Sprintf("# notify: %s %s %s", key, n.Type, n.On))
+	}
+	lines = append(lines, "# commands:")
+	for _, build := range host.Build {
+		b, ok := h.Builds[build]
+		if !ok {
+			lines = append(lines, fmt.Sprintf("#   build %s not found", build))
+			continue
+		}
+		label := "build " + build
+		if b.Parallel {
+			label += ", parallel"
+		}
+		if b.MaxOutput != "" {
+			label += ", max-output " + b.MaxOutput
+		}
+		if b.Serial != "" {
+			label += ", serial in group " + b.Serial
+		}
+		for _, cmd := range b.Cmd {
+			lines = append(lines, fmt.Sprintf("#   %s (%s)", cmd, label))
+		}
+	}
+	for _, cmd := range host.Cmd {
+		lines = append(lines, fmt.Sprintf("#   %s (cmd)", cmd))
+	}
+	for _, restart := range host.restarts {
+		if len(restart.Cmd) < 1 {
+			lines = append(lines, fmt.Sprintf("#   restart %s not found", restart.name))
+			continue
+		}
+		for _, cmd := range restart.Cmd {
+			lines = append(lines, fmt.Sprintf("#   %s (restart %s, on changes to %s)", cmd, restart.name, strings.Join(restart.Path, " ")))
+		}
+	}
+	for i, line := range lines {
+		lines[i] = strings.TrimRight(line, " ")
+	}
+	return strings.Join(lines, "\n"), nil
+}
@@ -0,0 +1,56 @@
+// Hap - the simple and effective provisioner
+// Copyright (c) 2015 Garrett Woodworth (https://github.com/gwoo)
+// The BSD License http://opensource.org/licenses/bsd-license.php.
+
+package hap
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestExplain(t *testing.T) {
+	hf := Hapfile{
+		Default: Default{Username: "deploy", Password: "hunter2", Build: []string{"default", "missing"}, Retries: 2},
+		Hosts: map[string]*Host{
+			"one": {Addr: "10.0.0.1:22", Dir: "/srv/app", Env: []string{"MODE=prod fast"}, Cmd: []string{"./notify.sh"}, LB: []string{"web"}},
+		},
+		Builds: map[string]*Build{"default": {Cmd: []string{"./init.sh", "./update.sh"}}},
+		Groups: map[string]*Group{"production": {Host: []string{"one"}, DNS: []string{"api"}}},
+		LBs:    map[string]*LB{"web": {Type: "aws", TargetGroup: "arn"}},
+		Notify: map[string]*Notify{"team": {Type: "slack", On: "failure"}, "other": {Host: []string{"two"}}},
+	}
+	out, err := hf.Explain("one", "/src/app")
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected := strings.Join([]string{
+		`[host "one"]`,
+		"\taddr = 10.0.0.1:22",
+		"\tdir = /srv/app",
+		"\tusername = deploy # default",
+		"\tpassword = \"***\" # default",
+		"\tlb = web",
+		"\tretries = 2 # default",
+		"\tenv = \"MODE=prod fast\"",
+		"\tbuild = default # default",
+		"\tbuild = missing # default",
+		"\tcmd = ./notify.sh",
+		"# dir: /srv/app",
+		"# repo: ssh://deploy@10.0.0.1:22/srv/app",
+		"# group: production dns api",
+		"# lb: web aws target 10.0.0.1",
+		"# notify: team slack failure",
+		"# commands:",
+		"#   ./init.sh (build default)",
+		"#   ./update.sh (build default)",
+		"#   build missing not found",
+		"#   ./notify.sh (cmd)",
+	}, "\n")
+	if out != expected {
+		t.Errorf("unexpected explain\n%s", out)
+	}
+	if _, err := hf.Explain("two", "/src/app"); err == nil {
+		t.Error("expected error for missing host")
+	}
+}
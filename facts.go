@@ -0,0 +1,85 @@
+// Hap - the simple and effective provisioner
+// Copyright (c) 2015 Garrett Woodworth (https://github.com/gwoo)
+// The BSD License http://opensource.org/licenses/bsd-license.php.
+
+package hap
+
+import (
+	"bufio"
+	"bytes"
+	"fmt"
+	"sort"
+	"strings"
+)
+
+// Probes the remote machine and prints the facts as KEY=value lines.
+var probe = []string{
+	"if [ -f /etc/os-release ]; then . /etc/os-release; fi",
+	"echo \"OS=$(uname -s)\"",
+	"echo \"DISTRO=${ID:-unknown}\"",
+	"echo \"DISTRO_VERSION=${VERSION_ID:-unknown}\"",
+	"echo \"ARCH=$(uname -m)\"",
+	"echo \"CPUS=$(getconf _NPROCESSORS_ONLN 2>/dev/null || nproc 2>/dev/null || echo 1)\"",
+	"KB=$(sed -n \"s/^MemTotal: *\\([0-9]*\\) kB/\\1/p\" /proc/meminfo 2>/dev/null)",
+	"echo \"MEMORY_MB=$(( ${KB:-0} / 1024 ))\"",
+	"echo \"HOSTNAME=$(hostname -f 2>/dev/null || hostname)\"",
+}
+
+// Maps the machine names of uname -m to the names used by Go
+var arches = map[string]string{
+	"x86_64":  "amd64",
+	"i386":    "386",
+	"i686":    "386",
+	"aarch64": "arm64",
+	"armv7l":  "arm",
+}
+
+// Facts holds information gathered from the remote machine
+// Keys are OS, DISTRO, DISTRO_VERSION, ARCH, CPUS, MEMORY_MB, and HOSTNAME.
+type Facts map[string]string
+
+// ParseFacts takes the output of the probe and returns the Facts
+func ParseFacts(output []byte) Facts {
+	facts := make(Facts)
+	scanner := bufio.NewScanner(bytes.NewReader(output))
+	for scanner.Scan() {
+		parts := strings.SplitN(scanner.Text(), "=", 2)
+		if len(parts) != 2 {
+			continue
+		}
+		facts[parts[0]] = parts[1]
+	}
+	if arch, ok := arches[facts["ARCH"]]; ok {
+		facts["ARCH"] = arch
+	}
+	return facts
+}
+
+// Env returns the facts as HAP_FACT_* exports
+func (f Facts) Env() string {
+	keys := []string{}
+	for key := range f {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	env := ""
+	for _, key := range keys {
+		value := unsafeChars.ReplaceAllString(f[key], "_")
+		env += fmt.Sprintf("export HAP_FACT_%s=\"%s\";", key, value)
+	}
+	return env
+}
+
+// Facts gathers the Facts of the remote machine
+// The facts are gathered once and exported to every following command.
+func (r *Remote) Facts() (Facts, error) {
+	if r.facts != nil {
+		return r.facts, nil
+	}
+	result, err := r.read(probe)
+	if err != nil {
+		return nil, err
+	}
+	r.facts = ParseFacts(result.Stdout)
+	return r.facts, nil
+}
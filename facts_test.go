@@ -0,0 +1,32 @@
+// Hap - the simple and effective provisioner
+// Copyright (c) 2015 Garrett Woodworth (https://github.com/gwoo)
+// The BSD License http://opensource.org/licenses/bsd-license.php.
+
+package hap
+
+import (
+	"testing"
+)
+
+func TestParseFacts(t *testing.T) {
+	output := []byte("OS=Linux\nDISTRO=debian\nARCH=aarch64\nCPUS=4\nnoise\n")
+	facts := ParseFacts(output)
+	expected := map[string]string{
+		"OS":     "Linux",
+		"DISTRO": "debian",
+		"ARCH":   "arm64",
+		"CPUS":   "4",
+	}
+	if len(facts) != len(expected) {
+		t.Errorf("expected %d facts, got %d", len(expected), len(facts))
+	}
+	for key, value := range expected {
+		if facts[key] != value {
+			t.Errorf("expected %s=%s, got %s", key, value, facts[key])
+		}
+	}
+	env := Facts{"OS": "Linux", "ARCH": "amd64"}.Env()
+	if env != "export HAP_FACT_ARCH=\"amd64\";export HAP_FACT_OS=\"Linux\";" {
+		t.Errorf("unexpected env %s", env)
+	}
+}
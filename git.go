@@ -6,7 +6,13 @@ package hap
 
 import (
 	"fmt"
+	"io/ioutil"
+	"os"
 	"os/exec"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
 )
 
 // Git struct
@@ -27,29 +33,186 @@ func (g Git) Exists() error {
 // Commit takes a commit message. It adds and commits all
 // files, including untracked, to the repo
 func (g Git) Commit(message string) ([]byte, error) {
-	cmd := exec.Command("git", "add", ".")
-	cmd.Dir = g.Work
+	cmd := g.command("add", ".")
 	result, err := cmd.CombinedOutput()
 	if err != nil {
 		return result, err
 	}
-	cmd = exec.Command("git", "commit", "-q", "-m", message)
-	cmd.Dir = g.Work
+	cmd = g.command("commit", "-q", "-m", message)
 	return cmd.CombinedOutput()
 }
 
+// Head returns the sha of the current commit
+func (g Git) Head() (string, error) {
+	cmd := g.command("rev-parse", "HEAD")
+	b, err := cmd.CombinedOutput()
+	if err != nil {
+		return "", fmt.Errorf("%s\n%s", b, err)
+	}
+	return strings.TrimSpace(string(b)), nil
+}
+
+// Project returns the sha of the root commit, which identifies the project
+// across clones and forks
+func (g Git) Project() (string, error) {
+	cmd := g.command("rev-list", "--max-parents=0", "HEAD")
+	b, err := cmd.CombinedOutput()
+	if err != nil {
+		return "", fmt.Errorf("%s\n%s", b, err)
+	}
+	roots := strings.Fields(string(b))
+	if len(roots) < 1 {
+		return "", fmt.Errorf("no root commit")
+	}
+	sort.Strings(roots)
+	return roots[0], nil
+}
+
+// Branch returns the name of the current branch or HEAD when detached
+func (g Git) Branch() (string, error) {
+	cmd := g.command("rev-parse", "--abbrev-ref", "HEAD")
+	b, err := cmd.CombinedOutput()
+	if err != nil {
+		return "", fmt.Errorf("%s\n%s", b, err)
+	}
+	return strings.TrimSpace(string(b)), nil
+}
+
+// Bundle takes a basis and a rev and returns a git bundle of the commits
+// of rev that are not in basis. Without a basis, or when the basis is
+// unknown locally, the bundle holds the full history of rev.
+func (g Git) Bundle(basis, rev string) ([]byte, error) {
+	file, err := ioutil.TempFile("", "hap-bundle")
+	if err != nil {
+		return nil, err
+	}
+	file.Close()
+	defer os.Remove(file.Name())
+	args := []string{"bundle", "create", file.Name(), rev}
+	if basis != "" {
+		cmd := g.command("cat-file", "-e", basis+"^{commit}")
+		if cmd.Run() == nil {
+			args = append(args, "^"+basis)
+		}
+	}
+	cmd := g.command(args...)
+	if b, err := cmd.CombinedOutput(); err != nil {
+		return nil, fmt.Errorf("%s\n%s", b, err)
+	}
+	return ioutil.ReadFile(file.Name())
+}
+
+// Unbundle fetches the ref from the git bundle into the same ref of the repo
+func (g Git) Unbundle(bundle []byte, ref string) error {
+	file, err := ioutil.TempFile("", "hap-bundle")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(file.Name())
+	_, err = file.Write(bundle)
+	if cerr := file.Close(); err == nil {
+		err = cerr
+	}
+	if err != nil {
+		return err
+	}
+	cmd := g.command("fetch", "-q", file.Name(), ref+":"+ref)
+	if b, err := cmd.CombinedOutput(); err != nil {
+		return fmt.Errorf("%s\n%s", b, err)
+	}
+	return nil
+}
+
+// Log returns the oneline log of the commits after from up to and including to
+func (g Git) Log(from, to string) ([]string, error) {
+	cmd := g.command("log", "--oneline", "--no-decorate", from+".."+to)
+	b, err := cmd.CombinedOutput()
+	if err != nil {
+		return nil, fmt.Errorf("%s\n%s", b, err)
+	}
+	lines := []string{}
+	for _, line := range strings.Split(strings.TrimSpace(string(b)), "\n") {
+		if line != "" {
+			lines = append(lines, line)
+		}
+	}
+	return lines, nil
+}
+
+// Changed returns the files changed between from and to
+// The names are read NUL separated, so they keep their spaces and
+// are not quoted.
+func (g Git) Changed(from, to string) ([]string, error) {
+	cmd := g.command("diff", "--name-only", "-z", from, to)
+	b, err := cmd.Output()
+	if err != nil {
+		if exit, ok := err.(*exec.ExitError); ok {
+			return nil, fmt.Errorf("%s\n%s", exit.Stderr, err)
+		}
+		return nil, err
+	}
+	files := []string{}
+	for _, file := range strings.Split(string(b), "\x00") {
+		if file != "" {
+			files = append(files, file)
+		}
+	}
+	return files, nil
+}
+
 // Push takes a branch and force pushes it to the git remote
+// The output includes the progress, for packWritten to read the size
+// of the pack from.
 func (g Git) Push(branch string) ([]byte, error) {
 	if branch == "" {
 		branch = "master"
 	}
-	cmd := exec.Command("git", "push", "-f", "-q", g.Repo, branch)
-	cmd.Dir = g.Work
+	cmd := g.command("push", "-f", "-q", "--progress", g.Repo, branch)
 	return cmd.CombinedOutput()
 }
 
+// command returns the git command in the work tree, run in the
+// DefaultLocale since IsRetryable and packWritten match its English
+// messages
+func (g Git) command(args ...string) *exec.Cmd {
+	cmd := exec.Command("git", args...)
+	cmd.Dir = g.Work
+	cmd.Env = append(os.Environ(), parsedEnv...)
+	return cmd
+}
+
+// Matches the size of the pack in the progress of a git push
+var packSize = regexp.MustCompile(`Writing objects: 100% \([0-9]+/[0-9]+\), ([0-9.]+) (bytes|KiB|MiB|GiB)`)
+
+// Matches the progress lines of a git push
+var pushProgress = regexp.MustCompile(`^(Enumerating objects|Counting objects|Compressing objects|Writing objects|Delta compression using|Total [0-9]+ \(delta)`)
+
+// packWritten returns the bytes of the pack a git push with --progress
+// wrote, and its output without the progress lines. The size is rounded
+// the way git shows it.
+func packWritten(output []byte) (int64, []byte) {
+	var size int64
+	if m := packSize.FindSubmatch(output); m != nil {
+		n, _ := strconv.ParseFloat(string(m[1]), 64)
+		unit := map[string]float64{"bytes": 1, "KiB": 1 << 10, "MiB": 1 << 20, "GiB": 1 << 30}[string(m[2])]
+		size = int64(n * unit)
+	}
+	lines := []string{}
+	for _, line := range strings.Split(string(output), "\n") {
+		if !pushProgress.MatchString(line) {
+			lines = append(lines, line)
+		}
+	}
+	return size, []byte(strings.Join(lines, "\n"))
+}
+
+// Moves a post-receive hook that was not installed by hap out of the way
+const preserveHook string = `if [ -f .git/hooks/post-receive ] && ! grep -q "git checkout -q" .git/hooks/post-receive; then mv -f .git/hooks/post-receive .git/hooks/post-receive.orig; fi`
+
 // Add this hook to the remote repo
-const postReceiveHook string = `cat > ".git/hooks/post-receive" << "EOF"
+// It is written to a temporary file with an explicit mode and moved
+// into place, so re-running init never leaves a partial hook behind.
+const postReceiveHook string = `rm -rf .git/hooks/post-receive.hap && cat > ".git/hooks/post-receive.hap" << "EOF" && chmod 0755 .git/hooks/post-receive.hap && mv -f .git/hooks/post-receive.hap .git/hooks/post-receive
 #!/bin/bash
 
 test "${PWD%/.git}" != "$PWD" && cd ..
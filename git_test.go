@@ -8,6 +8,7 @@ import (
 	"io/ioutil"
 	"os"
 	"os/exec"
+	"strings"
 	"testing"
 )
 
@@ -61,5 +62,70 @@ func TestGitPush(t *testing.T) {
 	if result, err := git.Push("master"); err != nil {
 		t.Log(string(result))
 		t.Error(err)
+	} else if size, _ := packWritten(result); size < 1 {
+		t.Errorf("expected the size of the pack in %q", result)
+	}
+}
+
+func TestPackWritten(t *testing.T) {
+	output := "Enumerating objects: 3, done.\nCounting objects:  33% (1/3)\rCounting objects: 100% (3/3), done.\n" +
+		"Writing objects:  33% (1/3)\rWriting objects: 100% (3/3), 293.23 KiB | 18.33 MiB/s, done.\n" +
+		"Total 3 (delta 0), reused 0 (delta 0), pack-reused 0\nremote: error: denied\n"
+	size, rest := packWritten([]byte(output))
+	if size != int64(300267) {
+		t.Errorf("expected %d bytes, got %d", int64(300267), size)
+	}
+	if string(rest) != "remote: error: denied\n" {
+		t.Errorf("expected the progress to be removed, got %q", rest)
+	}
+	if size, _ := packWritten([]byte("Writing objects: 100% (1/1), 232 bytes | 232.00 KiB/s, done.")); size != 232 {
+		t.Errorf("expected 232 bytes, got %d", size)
+	}
+}
+
+func TestGitBundle(t *testing.T) {
+	work, err := ioutil.TempDir("", "hap")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(work)
+	cmd := exec.Command("git", "init", ".")
+	cmd.Dir = work
+	if result, err := cmd.CombinedOutput(); err != nil {
+		t.Fatalf("%s\n%s", result, err)
+	}
+	git := Git{Work: work}
+	ioutil.WriteFile(work+"/test", []byte("one"), 0644)
+	if result, err := git.Commit("one"); err != nil {
+		t.Fatalf("%s\n%s", result, err)
+	}
+	basis, err := git.Head()
+	if err != nil {
+		t.Fatal(err)
+	}
+	ioutil.WriteFile(work+"/test", []byte("two"), 0644)
+	if result, err := git.Commit("two"); err != nil {
+		t.Fatalf("%s\n%s", result, err)
+	}
+	full, err := git.Bundle("", "HEAD")
+	if err != nil {
+		t.Fatal(err)
+	}
+	delta, err := git.Bundle(basis, "HEAD")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(delta) >= len(full) {
+		t.Errorf("expected delta bundle to be smaller than %d, got %d", len(full), len(delta))
+	}
+	if !strings.Contains(string(delta), "-"+basis) {
+		t.Error("expected delta bundle to require the basis")
+	}
+	unknown, err := git.Bundle("0123456789012345678901234567890123456789", "HEAD")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(unknown) != len(full) {
+		t.Error("expected a full bundle for an unknown basis")
 	}
 }
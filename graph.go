@@ -0,0 +1,235 @@
+// Hap - the simple and effective provisioner
+// Copyright (c) 2015 Garrett Woodworth (https://github.com/gwoo)
+// The BSD License http://opensource.org/licenses/bsd-license.php.
+
+package hap
+
+import (
+	"fmt"
+	"regexp"
+	"sort"
+	"strings"
+)
+
+// Matches characters that are not allowed in mermaid node ids
+var unsafeID = regexp.MustCompile(`[^A-Za-z0-9_]`)
+
+// The dot shape and the mermaid brackets of each kind of node
+var graphShapes = map[string][3]string{
+	"group":     {"ellipse", "(", ")"},
+	"host":      {"box", "[", "]"},
+	"build":     {"note", "[/", "/]"},
+	"lb":        {"diamond", "{", "}"},
+	"dns":       {"hexagon", "{{", "}}"},
+	"smoketest": {"component", "[[", "]]"},
+	"restart":   {"cds", ">", "]"},
+}
+
+// graphNode is a section of the Hapfile
+type graphNode struct {
+	kind  string
+	name  string
+	zone  string
+	found bool
+}
+
+// id returns the id of the node, unique across kinds
+func (n graphNode) id() string {
+	return n.kind + ":" + n.name
+}
+
+// label returns the name of the node, marking references to missing sections
+func (n graphNode) label() string {
+	if !n.found {
+		return n.name + " (missing)"
+	}
+	return n.name
+}
+
+// graphEdge is a reference from one section to another
+type graphEdge struct {
+	from  string
+	to    string
+	label string
+}
+
+// Graph renders the hosts, groups, builds, load balancers, dns records,
+// smoke tests, and restarts of the Hapfile with the references between them.
+// The format is dot (graphviz) or mermaid.
+func (h Hapfile) Graph(format string) (string, error) {
+	nodes, edges := h.graph()
+	switch format {
+	case "", "dot":
+		return graphDot(nodes, edges), nil
+	case "mermaid":
+		return graphMermaid(nodes, edges), nil
+	}
+	return "", fmt.Errorf("invalid graph format %q", format)
+}
+
+// graph returns the sorted nodes and edges of the Hapfile
+func (h Hapfile) graph() ([]graphNode, []graphEdge) {
+	nodes := map[string]graphNode{}
+	edges := []graphEdge{}
+	add := func(kind, name string, found bool) string {
+		n := graphNode{kind: kind, name: name, found: found}
+		if existing, ok := nodes[n.id()]; ok {
+			n = existing
+			n.found = n.found || found
+		}
+		nodes[n.id()] = n
+		return n.id()
+	}
+	link := func(from, kind, name string, found bool, label string) {
+		edges = append(edges, graphEdge{from: from, to: add(kind, name, found), label: label})
+	}
+	for name := range h.Hosts {
+		host := h.Host(name)
+		id := add("host", name, true)
+		n := nodes[id]
+		n.zone = host.Zone
+		nodes[id] = n
+		for _, build := range host.Build {
+			_, ok := h.Builds[build]
+			link(id, "build", build, ok, "build")
+		}
+		for _, lb := range host.LB {
+			_, ok := h.LBs[lb]
+			link(id, "lb", lb, ok, "lb")
+		}
+		for _, test := range host.Smoketest {
+			_, ok := h.Smoke[test]
+			link(id, "smoketest", test, ok, "smoketest")
+		}
+		for _, restart := range host.Restart {
+			_, ok := h.Restarts[restart]
+			link(id, "restart", restart, ok, "restart")
+		}
+	}
+	for name := range h.Builds {
+		add("build", name, true)
+	}
+	for name, lb := range h.LBs {
+		id := add("lb", name, true)
+		if lb.Host != "" {
+			_, ok := h.Hosts[lb.Host]
+			link(id, "host", lb.Host, ok, "runs on")
+		}
+	}
+	for name, group := range h.Groups {
+		id := add("group", name, true)
+		for _, host := range group.Host {
+			_, ok := h.Hosts[host]
+			link(id, "host", host, ok, "")
+		}
+		for _, dns := range group.DNS {
+			_, ok := h.DNS[dns]
+			link(id, "dns", dns, ok, "dns")
+		}
+	}
+	for name := range h.DNS {
+		add("dns", name, true)
+	}
+	for name := range h.Smoke {
+		add("smoketest", name, true)
+	}
+	for name := range h.Restarts {
+		add("restart", name, true)
+	}
+	sorted := []graphNode{}
+	for _, n := range nodes {
+		sorted = append(sorted, n)
+	}
+	sort.Slice(sorted, func(i, j int) bool { return sorted[i].id() < sorted[j].id() })
+	sort.SliceStable(edges, func(i, j int) bool {
+		if edges[i].from != edges[j].from {
+			return edges[i].from < edges[j].from
+		}
+		return edges[i].to < edges[j].to
+	})
+	return sorted, edges
+}
+
+// graphDot renders the nodes and edges in the dot language
+// Hosts of a zone are drawn in a cluster of their own.
+func graphDot(nodes []graphNode, edges []graphEdge) string {
+	quote := func(s string) string {
+		return "\"" + strings.Replace(strings.Replace(s, "\\", "\\\\", -1), "\"", "\\\"", -1) + "\""
+	}
+	lines := []string{"digraph hap {", "\trankdir=LR;"}
+	zones := map[string][]graphNode{}
+	for _, n := range nodes {
+		if n.zone != "" {
+			zones[n.zone] = append(zones[n.zone], n)
+			continue
+		}
+		lines = append(lines, fmt.Sprintf("\t%s [label=%s, shape=%s];",
+			quote(n.id()), quote(n.label()), graphShapes[n.kind][0]))
+	}
+	for _, zone := range sortedZones(zones) {
+		lines = append(lines, fmt.Sprintf("\tsubgraph %s {", quote("cluster_"+zone)),
+			fmt.Sprintf("\t\tlabel=%s;", quote("zone "+zone)))
+		for _, n := range zones[zone] {
+			lines = append(lines, fmt.Sprintf("\t\t%s [label=%s, shape=%s];",
+				quote(n.id()), quote(n.label()), graphShapes[n.kind][0]))
+		}
+		lines = append(lines, "\t}")
+	}
+	for _, e := range edges {
+		line := fmt.Sprintf("\t%s -> %s", quote(e.from), quote(e.to))
+		if e.label != "" {
+			line += fmt.Sprintf(" [label=%s]", quote(e.label))
+		}
+		lines = append(lines, line+";")
+	}
+	return strings.Join(append(lines, "}"), "\n")
+}
+
+// graphMermaid renders the nodes and edges as a mermaid flowchart
+// Hosts of a zone are drawn in a subgraph of their own.
+func graphMermaid(nodes []graphNode, edges []graphEdge) string {
+	ids := map[string]string{}
+	for i, n := range nodes {
+		ids[n.id()] = fmt.Sprintf("%s_%d_%s", n.kind, i, unsafeID.ReplaceAllString(n.name, "_"))
+	}
+	node := func(n graphNode) string {
+		shape := graphShapes[n.kind]
+		label := strings.Replace(n.label(), "\"", "#quot;", -1)
+		return fmt.Sprintf("%s%s\"%s\"%s", ids[n.id()], shape[1], label, shape[2])
+	}
+	lines := []string{"flowchart LR"}
+	zones := map[string][]graphNode{}
+	for _, n := range nodes {
+		if n.zone != "" {
+			zones[n.zone] = append(zones[n.zone], n)
+			continue
+		}
+		lines = append(lines, "\t"+node(n))
+	}
+	for i, zone := range sortedZones(zones) {
+		label := strings.Replace("zone "+zone, "\"", "#quot;", -1)
+		lines = append(lines, fmt.Sprintf("\tsubgraph zone_%d[\"%s\"]", i, label))
+		for _, n := range zones[zone] {
+			lines = append(lines, "\t\t"+node(n))
+		}
+		lines = append(lines, "\tend")
+	}
+	for _, e := range edges {
+		arrow := "-->"
+		if e.label != "" {
+			arrow = fmt.Sprintf("-->|%s|", e.label)
+		}
+		lines = append(lines, fmt.Sprintf("\t%s %s %s", ids[e.from], arrow, ids[e.to]))
+	}
+	return strings.Join(lines, "\n")
+}
+
+// sortedZones returns the names of the zones in order
+func sortedZones(zones map[string][]graphNode) []string {
+	names := []string{}
+	for zone := range zones {
+		names = append(names, zone)
+	}
+	sort.Strings(names)
+	return names
+}
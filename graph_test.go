@@ -0,0 +1,69 @@
+// Hap - the simple and effective provisioner
+// Copyright (c) 2015 Garrett Woodworth (https://github.com/gwoo)
+// The BSD License http://opensource.org/licenses/bsd-license.php.
+
+package hap
+
+import (
+	"strings"
+	"testing"
+)
+
+func graphHapfile() Hapfile {
+	return Hapfile{
+		Default: Default{Build: []string{"default"}},
+		Hosts: map[string]*Host{
+			"one": {Addr: "10.0.0.1:22", Zone: "eu-1a", LB: []string{"web"}},
+			"two": {Addr: "10.0.0.2:22", Build: []string{"worker"}},
+			"lb1": {Addr: "10.0.0.9:22"},
+		},
+		Builds: map[string]*Build{"default": {Cmd: []string{"./init.sh"}}},
+		Groups: map[string]*Group{"production": {Host: []string{"one", "two"}, DNS: []string{"api"}}},
+		LBs:    map[string]*LB{"web": {Type: "haproxy", Host: "lb1"}},
+		DNS:    map[string]*DNS{"api": {Type: "route53"}},
+	}
+}
+
+func TestGraphDot(t *testing.T) {
+	out, err := graphHapfile().Graph("dot")
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, line := range []string{
+		"digraph hap {",
+		"\t\"build:worker\" [label=\"worker (missing)\", shape=note];",
+		"\tsubgraph \"cluster_eu-1a\" {",
+		"\t\t\"host:one\" [label=\"one\", shape=box];",
+		"\t\"group:production\" -> \"dns:api\" [label=\"dns\"];",
+		"\t\"group:production\" -> \"host:one\";",
+		"\t\"host:one\" -> \"build:default\" [label=\"build\"];",
+		"\t\"host:one\" -> \"lb:web\" [label=\"lb\"];",
+		"\t\"lb:web\" -> \"host:lb1\" [label=\"runs on\"];",
+	} {
+		if !strings.Contains("\n"+out+"\n", "\n"+line+"\n") {
+			t.Errorf("expected %q in\n%s", line, out)
+		}
+	}
+}
+
+func TestGraphMermaid(t *testing.T) {
+	out, err := graphHapfile().Graph("mermaid")
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, line := range []string{
+		"flowchart LR",
+		"\tsubgraph zone_0[\"zone eu-1a\"]",
+		"\t\thost_5_one[\"one\"]",
+		"\tbuild_1_worker[/\"worker (missing)\"/]",
+		"\tlb_7_web -->|runs on| host_4_lb1",
+		"\tgroup_3_production --> host_5_one",
+	} {
+		if !strings.Contains("\n"+out+"\n", "\n"+line+"\n") {
+			t.Errorf("expected %q in\n%s", line, out)
+		}
+	}
+	if _, err := graphHapfile().Graph("svg"); err == nil {
+		t.Error("expected error for invalid format")
+	}
+}
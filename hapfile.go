@@ -6,16 +6,51 @@ package hap
 
 import (
 	"encoding/json"
+	"fmt"
+	"os"
 	"sort"
 
 	"code.google.com/p/gcfg"
 )
 
-// Hapfile defines the hosts, builds, and default
+// Hapfile defines the hosts, builds, groups, notifiers, load balancers,
+// dns records, smoke tests, restarts, rollout, shared state, and default
 type Hapfile struct {
-	Default Default
-	Hosts   map[string]*Host  `gcfg:"host"`
-	Builds  map[string]*Build `gcfg:"build"`
+	Default  Default
+	Rollout  Rollout
+	State    State
+	Hosts    map[string]*Host      `gcfg:"host"`
+	Builds   map[string]*Build     `gcfg:"build"`
+	Groups   map[string]*Group     `gcfg:"group"`
+	Notify   map[string]*Notify    `gcfg:"notify"`
+	LBs      map[string]*LB        `gcfg:"lb"`
+	DNS      map[string]*DNS       `gcfg:"dns"`
+	Smoke    map[string]*Smoketest `gcfg:"smoketest"`
+	Restarts map[string]*Restart   `gcfg:"restart"`
+}
+
+// Group holds the names of the hosts in the group, the dns records
+// pointed at them after a build, and whether the group is protected
+type Group struct {
+	Host      []string
+	DNS       []string
+	Github    string
+	Protected bool
+}
+
+// GetGroup takes a name and returns the hosts of the group
+func (h Hapfile) GetGroup(name string) map[string]*Host {
+	group, ok := h.Groups[name]
+	if !ok {
+		return nil
+	}
+	results := make(map[string]*Host)
+	for _, key := range group.Host {
+		if host := h.Host(key); host != nil && host.Name == key {
+			results[key] = host
+		}
+	}
+	return results
 }
 
 // GetHosts takes a name and returns the list of hosts
@@ -40,24 +75,47 @@ func (h Hapfile) GetHosts(name string, all bool) map[string]*Host {
 }
 
 // Host takes a name and returns the host
-// If the name is empty and default addr exists return default.
+// If the name is empty and default addr exists, or default is local, return default.
 // If no default is set it returns a random host.
 func (h Hapfile) Host(name string) *Host {
 	if host, ok := h.Hosts[name]; ok {
 		host.Name = name
 		host.SetDefaults(h.Default)
 		host.BuildCmds(h.Builds)
+		host.GroupKeys(h.Groups)
+		host.Notifiers(h.Notify)
+		host.Balancers(h.LBs, h.lbHost)
+		host.Smoketests(h.Smoke)
+		host.Restarts(h.Restarts)
 		return host
 	}
-	if h.Default.Addr != "" {
+	if h.Default.Addr != "" || h.Default.Type == "local" {
 		host := Host(h.Default)
 		host.Name = "default"
 		host.BuildCmds(h.Builds)
+		host.Notifiers(h.Notify)
+		host.Balancers(h.LBs, h.lbHost)
+		host.Smoketests(h.Smoke)
+		host.Restarts(h.Restarts)
 		return &host
 	}
 	return nil
 }
 
+// lbHost takes a name and returns the host running a load balancer
+// It leaves out the load balancers of the host itself.
+func (h Hapfile) lbHost(name string) *Host {
+	host, ok := h.Hosts[name]
+	if !ok {
+		return nil
+	}
+	lb := *host
+	lb.Name = name
+	lb.SetDefaults(h.Default)
+	lb.GroupKeys(h.Groups)
+	return &lb
+}
+
 // String returns the hapfile config as json
 func (h Hapfile) String() string {
 	b, err := json.Marshal(h)
@@ -72,18 +130,80 @@ type Default Host
 
 // Host describes a remote machine
 type Host struct {
-	Name     string
-	Addr     string
-	Username string
-	Identity string
-	Password string
-	Build    []string
-	Cmd      []string
-	cmds     []string
+	Name        string
+	Type        string
+	Addr        string
+	Zone        string
+	Dir         string
+	Repo        string
+	Username    string
+	Identity    string
+	Password    string
+	Owner       string
+	Mode        string
+	GitName     string `gcfg:"git-name"`
+	GitEmail    string `gcfg:"git-email"`
+	SafeDir     bool   `gcfg:"safe-directory"`
+	Bootstrap   bool
+	Health      string
+	Confirm     string
+	Listen      []string `gcfg:"expect-listen"`
+	Process     []string `gcfg:"expect-process"`
+	Smoketest   []string
+	Restart     []string
+	LB          []string
+	LBTarget    string `gcfg:"lb-target"`
+	DiskWarn    int    `gcfg:"disk-warn"`
+	SkewWarn    string `gcfg:"skew-warn"`
+	SkewFail    string `gcfg:"skew-fail"`
+	Transfer    string
+	Relay       string
+	Github      string
+	Protected   bool
+	Record      bool
+	AuditDir    string `gcfg:"audit-dir"`
+	Changelog   bool
+	SSHConfig   bool `gcfg:"ssh-config"`
+	Retries     int
+	RetryDelay  string `gcfg:"retry-delay"`
+	RetryJitter string `gcfg:"retry-jitter"`
+	Supervise   bool
+	Budget      string `gcfg:"time-budget"`
+	VaultSSH    string `gcfg:"vault-ssh"`
+	CertCommand string `gcfg:"cert-command"`
+	PreConnect  string `gcfg:"pre-connect"`
+	Disconnect  string `gcfg:"post-disconnect"`
+	Locale      string
+	SecretKey   string `gcfg:"secret-key"`
+	Env         []string
+	Build       []string
+	Cmd         []string
+	Sync        []string
+	cmds        []string
+	steps       []Step
+	keys        []string
+	groups      []string
+	identities  []string
+	notify      []*Notify
+	lbs         []*LB
+	smoke       []*Smoketest
+	restarts    []*Restart
 }
 
 // SetDefaults fills in missing host specific configs with defaults
 func (h *Host) SetDefaults(d Default) {
+	if h.Type == "" {
+		h.Type = d.Type
+	}
+	if h.Zone == "" {
+		h.Zone = d.Zone
+	}
+	if h.Dir == "" {
+		h.Dir = d.Dir
+	}
+	if h.Repo == "" {
+		h.Repo = d.Repo
+	}
 	if h.Username == "" {
 		h.Username = d.Username
 	}
@@ -93,23 +213,202 @@ func (h *Host) SetDefaults(d Default) {
 	if h.Password == "" {
 		h.Password = d.Password
 	}
+	if h.Owner == "" {
+		h.Owner = d.Owner
+	}
+	if h.Mode == "" {
+		h.Mode = d.Mode
+	}
+	if h.GitName == "" {
+		h.GitName = d.GitName
+	}
+	if h.GitEmail == "" {
+		h.GitEmail = d.GitEmail
+	}
+	if !h.SafeDir {
+		h.SafeDir = d.SafeDir
+	}
+	if !h.Bootstrap {
+		h.Bootstrap = d.Bootstrap
+	}
+	if h.Health == "" {
+		h.Health = d.Health
+	}
+	if h.Confirm == "" {
+		h.Confirm = d.Confirm
+	}
+	if len(h.Listen) < 1 {
+		h.Listen = d.Listen
+	}
+	if len(h.Process) < 1 {
+		h.Process = d.Process
+	}
+	if len(h.Smoketest) < 1 {
+		h.Smoketest = d.Smoketest
+	}
+	if len(h.Restart) < 1 {
+		h.Restart = d.Restart
+	}
+	if len(h.LB) < 1 {
+		h.LB = d.LB
+	}
+	if h.LBTarget == "" {
+		h.LBTarget = d.LBTarget
+	}
+	if h.DiskWarn == 0 {
+		h.DiskWarn = d.DiskWarn
+	}
+	if h.SkewWarn == "" {
+		h.SkewWarn = d.SkewWarn
+	}
+	if h.SkewFail == "" {
+		h.SkewFail = d.SkewFail
+	}
+	if h.Transfer == "" {
+		h.Transfer = d.Transfer
+	}
+	if h.Relay == "" {
+		h.Relay = d.Relay
+	}
+	if h.Github == "" {
+		h.Github = d.Github
+	}
+	if !h.Protected {
+		h.Protected = d.Protected
+	}
+	if !h.Record {
+		h.Record = d.Record
+	}
+	if h.AuditDir == "" {
+		h.AuditDir = d.AuditDir
+	}
+	if !h.Changelog {
+		h.Changelog = d.Changelog
+	}
+	if !h.SSHConfig {
+		h.SSHConfig = d.SSHConfig
+	}
+	if h.Retries == 0 {
+		h.Retries = d.Retries
+	}
+	if h.RetryDelay == "" {
+		h.RetryDelay = d.RetryDelay
+	}
+	if h.RetryJitter == "" {
+		h.RetryJitter = d.RetryJitter
+	}
+	if !h.Supervise {
+		h.Supervise = d.Supervise
+	}
+	if h.Budget == "" {
+		h.Budget = d.Budget
+	}
+	if h.VaultSSH == "" {
+		h.VaultSSH = d.VaultSSH
+	}
+	if h.CertCommand == "" {
+		h.CertCommand = d.CertCommand
+	}
+	if h.PreConnect == "" {
+		h.PreConnect = d.PreConnect
+	}
+	if h.Disconnect == "" {
+		h.Disconnect = d.Disconnect
+	}
+	if h.Locale == "" {
+		h.Locale = d.Locale
+	}
+	if h.SecretKey == "" {
+		h.SecretKey = d.SecretKey
+	}
+	if len(h.Env) < 1 {
+		h.Env = d.Env
+	}
 	if len(h.Build) < 1 {
 		h.Build = d.Build
 	}
 	if len(h.Cmd) < 1 {
 		h.Cmd = d.Cmd
 	}
+	if len(h.Sync) < 1 {
+		h.Sync = d.Sync
+	}
 }
 
 // BuildCmds combines the builds and cmds
+// The cmds of parallel builds are grouped into their own Step.
 func (h *Host) BuildCmds(builds map[string]*Build) {
 	h.cmds = []string{}
+	h.steps = []Step{}
+	serial := func(step Step) {
+		if n := len(h.steps); n > 0 && !h.steps[n-1].Parallel &&
+			h.steps[n-1].MaxOutput == step.MaxOutput && h.steps[n-1].Spool == step.Spool &&
+			h.steps[n-1].Serial == step.Serial {
+			h.steps[n-1].Cmds = append(h.steps[n-1].Cmds, step.Cmds...)
+			return
+		}
+		step.Cmds = append([]string{}, step.Cmds...)
+		h.steps = append(h.steps, step)
+	}
 	for _, build := range h.Build {
 		if b, ok := builds[build]; ok {
 			h.cmds = append(h.cmds, b.Cmd...)
+			step := Step{Name: build, Cmds: b.Cmd, Parallel: b.Parallel, MaxOutput: b.MaxOutput, Spool: b.Spool, Serial: b.Serial}
+			if b.Parallel && len(b.Cmd) > 0 {
+				h.steps = append(h.steps, step)
+			} else if len(b.Cmd) > 0 {
+				serial(step)
+			}
 		}
 	}
 	h.cmds = append(h.cmds, h.Cmd...)
+	if len(h.Cmd) > 0 {
+		serial(Step{Name: "cmd", Cmds: h.Cmd})
+	}
+}
+
+// GroupKeys finds the groups of the host and the deploy keys issued
+// for them. Hosts of protected groups are protected too.
+func (h *Host) GroupKeys(groups map[string]*Group) {
+	h.keys = []string{}
+	h.groups = []string{}
+	names := []string{}
+	for name := range groups {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		for _, host := range groups[name].Host {
+			if host != h.Name {
+				continue
+			}
+			h.groups = append(h.groups, name)
+			if groups[name].Protected {
+				h.Protected = true
+			}
+			if h.Github == "" {
+				h.Github = groups[name].Github
+			}
+			if _, err := os.Stat(GroupKeyFile(name)); err == nil {
+				h.keys = append(h.keys, GroupKeyFile(name))
+			}
+		}
+	}
+}
+
+// InGroup returns whether the host is in the group
+func (h *Host) InGroup(group string) bool {
+	for _, name := range h.groups {
+		if name == group {
+			return true
+		}
+	}
+	return false
+}
+
+// Keys returns the deploy key files of the groups of the host
+func (h *Host) Keys() []string {
+	return h.keys
 }
 
 // Cmds returns the cmds to build
@@ -117,14 +416,150 @@ func (h *Host) Cmds() []string {
 	return h.cmds
 }
 
+// Steps returns the cmds to build grouped into the steps they run in
+func (h *Host) Steps() []Step {
+	return h.steps
+}
+
+// Parallel returns whether any step of the build runs its cmds in parallel
+func (h *Host) Parallel() bool {
+	for _, step := range h.steps {
+		if step.Parallel {
+			return true
+		}
+	}
+	return false
+}
+
+// Serialized returns whether any step of the build runs one host of
+// a group of the host at a time
+func (h *Host) Serialized() bool {
+	for _, step := range h.steps {
+		if step.Serial != "" && h.InGroup(step.Serial) {
+			return true
+		}
+	}
+	return false
+}
+
+// Capped returns whether any step of the build caps or spools its output
+func (h *Host) Capped() bool {
+	for _, step := range h.steps {
+		if step.MaxOutput != "" || step.Spool {
+			return true
+		}
+	}
+	return false
+}
+
 // Build holds the cmds
+// With Parallel the cmds run at the same time, each in its own session.
+// MaxOutput caps the output shown of each session, like 10M, keeping its
+// head and tail. With Spool the full output is written to the SpoolDir.
+// Serial names a group whose hosts run the build one at a time.
 type Build struct {
-	Cmd []string
+	Cmd       []string
+	Parallel  bool
+	MaxOutput string `gcfg:"max-output"`
+	Spool     bool   `gcfg:"spool-output"`
+	Serial    string
+}
+
+// Step is a run of cmds of the build
+// The cmds of a Parallel step run at the same time, otherwise one after another.
+type Step struct {
+	Name      string
+	Cmds      []string
+	Parallel  bool
+	MaxOutput string
+	Spool     bool
+	Serial    string
 }
 
 // NewHapfile constructs a new hapfile config
+// An encrypted Hapfile is decrypted in memory with the HapfileKey.
 func NewHapfile() (Hapfile, error) {
+	b, err := ReadHapfile(HapfileName)
+	if err != nil {
+		return Hapfile{}, err
+	}
+	return ParseHapfile(b)
+}
+
+// ParseHapfile takes the contents of a Hapfile and returns the Hapfile
+// The durations and sizes in it are checked too, so a typo fails the
+// load instead of a host in the middle of a rollout.
+func ParseHapfile(b []byte) (Hapfile, error) {
 	var hf Hapfile
-	err := gcfg.ReadFileInto(&hf, "Hapfile")
-	return hf, err
+	if err := gcfg.ReadStringInto(&hf, string(b)); err != nil {
+		return hf, err
+	}
+	return hf, hf.validate()
+}
+
+// The types of hosts, hosts without one connect over ssh
+var hostTypes = map[string]bool{"": true, "ssh": true, "local": true, "mock": true}
+
+// validate checks the durations and sizes of the builds, the cmds of
+// the restarts, the types, durations, sizes, and restarts of the hosts
+// with their defaults, and the state
+func (h Hapfile) validate() error {
+	if _, err := h.State.LeaseTTL(); err != nil {
+		return err
+	}
+	names := []string{}
+	for name := range h.Builds {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		if b := h.Builds[name]; b.MaxOutput != "" {
+			if _, err := ParseSize(b.MaxOutput); err != nil {
+				return fmt.Errorf("[%s] invalid max-output %q", name, b.MaxOutput)
+			}
+		}
+	}
+	names = []string{}
+	for name := range h.Restarts {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		if len(h.Restarts[name].Cmd) < 1 {
+			return fmt.Errorf("[%s] restart without cmd", name)
+		}
+	}
+	hosts := []Host{Host(h.Default)}
+	hosts[0].Name = "default"
+	names = []string{}
+	for name := range h.Hosts {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		host := *h.Hosts[name]
+		host.Name = name
+		host.SetDefaults(h.Default)
+		hosts = append(hosts, host)
+	}
+	for _, host := range hosts {
+		if !hostTypes[host.Type] {
+			return fmt.Errorf("[%s] invalid type %q", host.Name, host.Type)
+		}
+		if _, err := host.TimeBudget(); err != nil {
+			return err
+		}
+		if _, err := host.Retry(); err != nil {
+			return err
+		}
+		if _, _, err := host.SkewLimits(); err != nil {
+			return err
+		}
+		for _, name := range host.Restart {
+			if _, ok := h.Restarts[name]; !ok {
+				return fmt.Errorf("[%s] restart %s not found", host.Name, name)
+			}
+		}
+	}
+	return nil
 }
@@ -0,0 +1,172 @@
+// Hap - the simple and effective provisioner
+// Copyright (c) 2015 Garrett Woodworth (https://github.com/gwoo)
+// The BSD License http://opensource.org/licenses/bsd-license.php.
+
+package hap
+
+import (
+	"bufio"
+	"bytes"
+	"fmt"
+	"regexp"
+	"strings"
+	"time"
+)
+
+// Matches characters that are not safe to write as text to the remote.
+var unsafeText = regexp.MustCompile(`[^A-Za-z0-9 .,:;!?()\[\]{}#/+=@_%&*<>|~^-]`)
+
+// Deploy is an entry in the deployment history on the remote machine
+// Local and Remote hold the sha of the local and remote repos.
+// Changelog holds the commits deployed when the host enables changelog.
+type Deploy struct {
+	Time      time.Time
+	Operator  string
+	Local     string
+	Remote    string
+	Result    string
+	Duration  time.Duration
+	Changelog []string
+}
+
+// String returns the deploy as a line in the history
+func (d Deploy) String() string {
+	fields := []string{
+		d.Time.UTC().Format(time.RFC3339),
+		d.Operator,
+		d.Local,
+		d.Remote,
+		d.Result,
+		d.Duration.String(),
+	}
+	for _, line := range d.Changelog {
+		fields = append(fields, unsafeText.ReplaceAllString(line, ""))
+	}
+	return strings.Join(fields, "\t")
+}
+
+// ParseDeploy takes a line from the history and returns the Deploy
+func ParseDeploy(line string) (Deploy, error) {
+	var d Deploy
+	fields := strings.Split(line, "\t")
+	if len(fields) < 6 {
+		return d, fmt.Errorf("invalid history line %q", line)
+	}
+	t, err := time.Parse(time.RFC3339, fields[0])
+	if err != nil {
+		return d, err
+	}
+	duration, err := time.ParseDuration(fields[5])
+	if err != nil {
+		return d, err
+	}
+	d = Deploy{
+		Time:     t,
+		Operator: fields[1],
+		Local:    fields[2],
+		Remote:   fields[3],
+		Result:   fields[4],
+		Duration: duration,
+	}
+	if len(fields) > 6 {
+		d.Changelog = fields[6:]
+	}
+	return d, nil
+}
+
+// History returns the last n deploys on the remote machine, oldest first
+// With a shared State it returns the deploys of every operator from it.
+func (r *Remote) History(n int) ([]Deploy, error) {
+	var history []byte
+	if r.State != nil {
+		var err error
+		if history, err = r.sharedHistory(n); err != nil {
+			return nil, err
+		}
+	} else {
+		result, err := r.read([]string{
+			"cd " + r.Dir,
+			fmt.Sprintf("if [ -f .haphistory ]; then tail -n %d .haphistory; fi", n),
+		})
+		if err != nil {
+			return nil, err
+		}
+		history = result.Stdout
+	}
+	deploys := []Deploy{}
+	scanner := bufio.NewScanner(bytes.NewReader(history))
+	for scanner.Scan() {
+		d, err := ParseDeploy(scanner.Text())
+		if err != nil {
+			return deploys, err
+		}
+		deploys = append(deploys, d)
+	}
+	return deploys, scanner.Err()
+}
+
+// record appends the result of a build to the history on the remote machine
+// The remote sha is filled in on the remote after the build. The line is
+// also appended to the shared history with a State, which mock hosts
+// record instead.
+func (r *Remote) record(result *Result) error {
+	if result == nil {
+		return nil
+	}
+	local, err := r.Git.Head()
+	if err != nil {
+		local = "-"
+	}
+	d := Deploy{
+		Time:      r.clock().Now().Add(-result.Duration),
+		Operator:  Operator(),
+		Local:     local,
+		Remote:    "$(git rev-parse HEAD 2>/dev/null || echo -)",
+		Result:    "success",
+		Duration:  result.Duration,
+		Changelog: r.Changelog,
+	}
+	if result.ExitCode != 0 {
+		d.Result = fmt.Sprintf("failed:%d", result.ExitCode)
+	}
+	cmds := []string{
+		"cd " + r.Dir,
+		fmt.Sprintf("echo \"%s\" >> .haphistory", d),
+	}
+	mocked := false
+	if r.State != nil {
+		var err error
+		if mocked, err = r.mocked("state append history/" + r.Host.Name); err != nil {
+			return err
+		}
+	}
+	if r.State == nil || mocked {
+		return r.Execute(cmds)
+	}
+	recorded, err := r.Capture(append(cmds, "tail -n 1 .haphistory"))
+	if err != nil {
+		return err
+	}
+	return r.share(strings.TrimSpace(string(recorded.Stdout)))
+}
+
+// changelog returns the commits between the sha deployed on the remote
+// machine and the local sha. It returns nil on the first deploy.
+func (r *Remote) changelog() ([]string, error) {
+	result, err := r.read([]string{
+		"cd " + r.Dir,
+		"cat .happended 2>/dev/null || true",
+	})
+	if err != nil {
+		return nil, err
+	}
+	deployed := strings.TrimSpace(string(result.Stdout))
+	if deployed == "" {
+		return nil, nil
+	}
+	head, err := r.Git.Head()
+	if err != nil {
+		return nil, err
+	}
+	return r.Git.Log(deployed, head)
+}
@@ -0,0 +1,108 @@
+// Hap - the simple and effective provisioner
+// Copyright (c) 2015 Garrett Woodworth (https://github.com/gwoo)
+// The BSD License http://opensource.org/licenses/bsd-license.php.
+
+package hap
+
+import (
+	"io/ioutil"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"reflect"
+	"testing"
+	"time"
+)
+
+func TestParseDeploy(t *testing.T) {
+	d := Deploy{
+		Time:     time.Date(2015, 6, 1, 12, 0, 0, 0, time.UTC),
+		Operator: "gwoo@laptop",
+		Local:    "abc123",
+		Remote:   "abc123",
+		Result:   "success",
+		Duration: 90 * time.Second,
+	}
+	parsed, err := ParseDeploy(d.String())
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	if parsed.String() != d.String() {
+		t.Errorf("expected %v, got %v", d, parsed)
+	}
+	d.Changelog = []string{"abc123 Fix the \"build\"", "def456 Add $HOME"}
+	parsed, err = ParseDeploy(d.String())
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	if len(parsed.Changelog) != 2 || parsed.Changelog[0] != "abc123 Fix the build" {
+		t.Errorf("unexpected changelog %v", parsed.Changelog)
+	}
+	if _, err := ParseDeploy("invalid"); err == nil {
+		t.Error("expected error for invalid line")
+	}
+}
+
+func TestBuildHistory(t *testing.T) {
+	home := t.TempDir()
+	t.Setenv("HOME", home)
+	work := filepath.Join(home, "work", "app")
+	os.MkdirAll(work, 0755)
+	wd, _ := os.Getwd()
+	os.Chdir(work)
+	defer os.Chdir(wd)
+	commit := func(file string) {
+		ioutil.WriteFile(file, []byte(file), 0644)
+		for _, args := range [][]string{{"add", "."}, {"-c", "user.name=me", "-c", "user.email=me@localhost", "commit", "-q", "-m", file}} {
+			if out, err := exec.Command("git", args...).CombinedOutput(); err != nil {
+				t.Fatalf("%s %s", out, err)
+			}
+		}
+	}
+	exec.Command("git", "init", "-q").Run()
+	commit("main.go")
+	h := Hapfile{Hosts: map[string]*Host{"me": {Type: "local", Transfer: "bundle", Cmd: []string{"echo build"}}}}
+	r, err := NewRemote(h.Host("me"), nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer r.Close()
+	r.Stdout, r.Stderr = ioutil.Discard, ioutil.Discard
+	if err := r.Initialize(); err != nil {
+		t.Fatal(err)
+	}
+	build := func(expected ...string) {
+		t.Helper()
+		r.Build()
+		history, err := r.History(10)
+		if err != nil {
+			t.Fatal(err)
+		}
+		results := []string{}
+		for _, d := range history {
+			results = append(results, d.Result)
+		}
+		if !reflect.DeepEqual(results, expected) {
+			t.Errorf("expected the results %v, got %v", expected, results)
+		}
+	}
+	if err := r.PushBundle(); err != nil {
+		t.Fatal(err)
+	}
+	build("success")
+	// Built already
+	build("success")
+	commit("README")
+	if err := r.PushBundle(); err != nil {
+		t.Fatal(err)
+	}
+	r.Execute([]string{"cd " + r.Dir, "echo other > .haplock"})
+	// Refused by the lock
+	build("success")
+	r.Execute([]string{"cd " + r.Dir, "rm .haplock"})
+	r.Host.Cmd = []string{"exit 3"}
+	r.Host.BuildCmds(nil)
+	build("success", "failed:3")
+}
@@ -0,0 +1,201 @@
+// Hap - the simple and effective provisioner
+// Copyright (c) 2015 Garrett Woodworth (https://github.com/gwoo)
+// The BSD License http://opensource.org/licenses/bsd-license.php.
+
+package hap
+
+import (
+	"fmt"
+	"net"
+	"regexp"
+	"sort"
+	"strings"
+	"time"
+)
+
+// Lists the running services, the packages installed by hand, the
+// login users, and the listening tcp sockets of a machine.
+var inventory = []string{
+	"echo \"-- services\"",
+	"(systemctl list-units --type=service --state=running --no-legend --plain 2>/dev/null || true)",
+	"echo \"-- packages\"",
+	"(apt-mark showmanual 2>/dev/null || cat /etc/apk/world 2>/dev/null || dnf repoquery --userinstalled --qf \"%{name}\" -q 2>/dev/null || true)",
+	"echo \"-- users\"",
+	"(awk -F : \"\\$3 >= 1000 && \\$3 < 65534 { print \\$1 }\" /etc/passwd 2>/dev/null || true)",
+	"echo \"-- listen\"",
+	"(ss -ltn 2>/dev/null || netstat -ltn 2>/dev/null || true)",
+}
+
+// Services every machine runs, which are left out of the skeleton
+var systemServices = regexp.MustCompile(`^(systemd-.*|dbus.*|ssh|sshd|cron|crond|getty@.*|serial-getty@.*|user@.*|rsyslog|polkit|` +
+	`networkd-dispatcher|NetworkManager|chronyd|chrony|ntp|unattended-upgrades|snapd|irqbalance|multipathd|udisks2|` +
+	`accounts-daemon|packagekit|qemu-guest-agent|atd|auditd|firewalld|tuned|ModemManager|containerd|cloud-.*)$`)
+
+// Matches names that are safe to use in the suggested commands
+var validName = regexp.MustCompile(`^[A-Za-z0-9@_.+-]+$`)
+
+// Inventory holds what runs on an existing machine
+type Inventory struct {
+	Facts    Facts
+	Services []string
+	Packages []string
+	Users    []string
+	Ports    []string
+}
+
+// ParseInventory takes the facts and the output of the inventory and
+// returns the Inventory, leaving out the services every machine runs
+// and ports only listening on loopback
+func ParseInventory(facts Facts, output []byte) Inventory {
+	inv := Inventory{Facts: facts}
+	section := ""
+	seen := map[string]bool{}
+	for _, line := range strings.Split(string(output), "\n") {
+		if strings.HasPrefix(line, "-- ") {
+			section = strings.TrimPrefix(line, "-- ")
+			continue
+		}
+		fields := strings.Fields(line)
+		if len(fields) < 1 {
+			continue
+		}
+		name := fields[0]
+		// systemctl marks units with a bullet, which is * without UTF-8
+		if (name == "●" || name == "*") && len(fields) > 1 {
+			name = fields[1]
+		}
+		switch section {
+		case "services":
+			name = strings.TrimSuffix(name, ".service")
+			if !systemServices.MatchString(name) && validName.MatchString(name) {
+				inv.Services = append(inv.Services, name)
+			}
+		case "packages":
+			if validName.MatchString(name) {
+				inv.Packages = append(inv.Packages, name)
+			}
+		case "users":
+			if validName.MatchString(name) {
+				inv.Users = append(inv.Users, name)
+			}
+		case "listen":
+			if len(fields) < 4 {
+				continue
+			}
+			i := strings.LastIndex(fields[3], ":")
+			if i < 0 {
+				continue
+			}
+			host, port := strings.Trim(fields[3][:i], "[]"), fields[3][i+1:]
+			if ip := net.ParseIP(host); ip != nil && ip.IsLoopback() || port == "22" || seen[port] {
+				continue
+			}
+			if _, err := fmt.Sscanf(port, "%d", new(int)); err != nil {
+				continue
+			}
+			seen[port] = true
+			inv.Ports = append(inv.Ports, port)
+		}
+	}
+	sort.Strings(inv.Services)
+	sort.Strings(inv.Packages)
+	sort.Strings(inv.Users)
+	sort.Strings(inv.Ports)
+	return inv
+}
+
+// Inventory gathers the facts and the Inventory of the remote machine
+func (r *Remote) Inventory() (Inventory, error) {
+	facts, err := r.Facts()
+	if err != nil {
+		return Inventory{}, err
+	}
+	result, err := r.read(inventory)
+	if err != nil {
+		return Inventory{}, err
+	}
+	return ParseInventory(facts, result.Stdout), nil
+}
+
+// ImportHost takes an address like user@host:port and returns the host
+// The name is the short hostname, or the host of the address.
+func ImportHost(target string) *Host {
+	host := &Host{Addr: target}
+	if i := strings.LastIndex(target, "@"); i >= 0 {
+		host.Username, host.Addr = target[:i], target[i+1:]
+	}
+	if _, _, err := net.SplitHostPort(host.Addr); err != nil {
+		host.Addr = net.JoinHostPort(host.Addr, "22")
+	}
+	host.Name = host.hostname()
+	return host
+}
+
+// Skeleton returns a commented Hapfile for the host with builds that
+// recreate the Inventory: installing the packages, adding the users,
+// and enabling the services.
+func (inv Inventory) Skeleton(host *Host) string {
+	name := host.Name
+	if hostname := strings.SplitN(inv.Facts["HOSTNAME"], ".", 2)[0]; validName.MatchString(hostname) {
+		name = hostname
+	}
+	lines := []string{
+		fmt.Sprintf("# Hapfile skeleton imported from %s on %s.", host.Addr, time.Now().Format("2006-01-02")),
+		fmt.Sprintf("# %s %s %s, %s cpus, %s MB memory.", inv.Facts["DISTRO"], inv.Facts["DISTRO_VERSION"],
+			inv.Facts["ARCH"], inv.Facts["CPUS"], inv.Facts["MEMORY_MB"]),
+		"# Review the builds before running hap build, they are suggestions.",
+		"",
+		fmt.Sprintf("[host %q]", name),
+		fmt.Sprintf("\taddr = %q", host.Addr),
+	}
+	if host.Username != "" {
+		lines = append(lines, fmt.Sprintf("\tusername = %q", host.Username))
+	}
+	builds := [][]string{}
+	if install := installCmd(inv.Facts["DISTRO"]); install != "" && len(inv.Packages) > 0 {
+		builds = append(builds, []string{"packages",
+			"\t; every package installed by hand, trim it to what the host needs",
+			fmt.Sprintf("\tcmd = %q", install+" "+strings.Join(inv.Packages, " "))})
+	}
+	if len(inv.Users) > 0 {
+		build := []string{"users"}
+		for _, user := range inv.Users {
+			build = append(build, fmt.Sprintf("\tcmd = %q", fmt.Sprintf("id -u %s >/dev/null 2>&1 || sudo useradd -m %s", user, user)))
+		}
+		builds = append(builds, build)
+	}
+	if len(inv.Services) > 0 {
+		build := []string{"services"}
+		for _, service := range inv.Services {
+			build = append(build, fmt.Sprintf("\tcmd = %q", "sudo systemctl enable --now "+service))
+		}
+		builds = append(builds, build)
+	}
+	for _, build := range builds {
+		lines = append(lines, fmt.Sprintf("\tbuild = %q", build[0]))
+	}
+	for _, port := range inv.Ports {
+		lines = append(lines, fmt.Sprintf("\texpect-listen = %q", ":"+port))
+	}
+	for _, service := range inv.Services {
+		lines = append(lines, fmt.Sprintf("\t; expect-process = %q", service))
+	}
+	for _, build := range builds {
+		lines = append(lines, "", fmt.Sprintf("[build %q]", build[0]))
+		lines = append(lines, build[1:]...)
+	}
+	return strings.Join(lines, "\n")
+}
+
+// installCmd returns the command installing packages on the distro
+func installCmd(distro string) string {
+	switch distro {
+	case "debian", "ubuntu", "raspbian":
+		return "sudo apt-get install -y"
+	case "alpine":
+		return "sudo apk add"
+	case "fedora", "rhel", "centos", "rocky", "almalinux", "amzn", "ol":
+		return "sudo dnf install -y"
+	}
+	return ""
+}
@@ -0,0 +1,91 @@
+// Hap - the simple and effective provisioner
+// Copyright (c) 2015 Garrett Woodworth (https://github.com/gwoo)
+// The BSD License http://opensource.org/licenses/bsd-license.php.
+
+package hap
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+
+	"code.google.com/p/gcfg"
+)
+
+const inventoryOutput = `-- services
+cron.service
+nginx.service
+postgresql@15-main.service
+systemd-journald.service
+-- packages
+nginx
+postgresql-15
+-- users
+app
+-- listen
+State  Recv-Q Send-Q Local Address:Port  Peer Address:Port
+LISTEN 0      128          0.0.0.0:22         0.0.0.0:*
+LISTEN 0      128          0.0.0.0:80         0.0.0.0:*
+LISTEN 0      128             [::]:80            [::]:*
+LISTEN 0      128        127.0.0.1:5432       0.0.0.0:*
+`
+
+func TestParseInventory(t *testing.T) {
+	inv := ParseInventory(Facts{"DISTRO": "debian"}, []byte(inventoryOutput))
+	if !reflect.DeepEqual(inv.Services, []string{"nginx", "postgresql@15-main"}) {
+		t.Errorf("unexpected services %v", inv.Services)
+	}
+	if !reflect.DeepEqual(inv.Packages, []string{"nginx", "postgresql-15"}) {
+		t.Errorf("unexpected packages %v", inv.Packages)
+	}
+	if !reflect.DeepEqual(inv.Users, []string{"app"}) {
+		t.Errorf("unexpected users %v", inv.Users)
+	}
+	if !reflect.DeepEqual(inv.Ports, []string{"80"}) {
+		t.Errorf("unexpected ports %v", inv.Ports)
+	}
+	output := "-- services\n● redis.service loaded active running Redis\n* cron.service loaded active running Cron\n"
+	if inv := ParseInventory(Facts{}, []byte(output)); !reflect.DeepEqual(inv.Services, []string{"redis"}) {
+		t.Errorf("unexpected services %v", inv.Services)
+	}
+}
+
+func TestImportHost(t *testing.T) {
+	host := ImportHost("deploy@10.0.0.1")
+	if host.Username != "deploy" || host.Addr != "10.0.0.1:22" || host.Name != "10.0.0.1" {
+		t.Errorf("unexpected host %+v", host)
+	}
+	if host := ImportHost("web.example.com:2222"); host.Username != "" || host.Addr != "web.example.com:2222" {
+		t.Errorf("unexpected host %+v", host)
+	}
+}
+
+func TestSkeleton(t *testing.T) {
+	facts := Facts{"DISTRO": "debian", "DISTRO_VERSION": "12", "ARCH": "amd64", "CPUS": "2", "MEMORY_MB": "3923", "HOSTNAME": "web1.example.com"}
+	inv := ParseInventory(facts, []byte(inventoryOutput))
+	skeleton := inv.Skeleton(ImportHost("deploy@10.0.0.1"))
+	for _, line := range []string{
+		"# debian 12 amd64, 2 cpus, 3923 MB memory.",
+		"[host \"web1\"]",
+		"\taddr = \"10.0.0.1:22\"",
+		"\tusername = \"deploy\"",
+		"\tbuild = \"packages\"",
+		"\texpect-listen = \":80\"",
+		"\t; expect-process = \"nginx\"",
+		"[build \"packages\"]",
+		"\tcmd = \"sudo apt-get install -y nginx postgresql-15\"",
+		"\tcmd = \"id -u app >/dev/null 2>&1 || sudo useradd -m app\"",
+		"\tcmd = \"sudo systemctl enable --now postgresql@15-main\"",
+	} {
+		if !strings.Contains(skeleton+"\n", line+"\n") {
+			t.Errorf("expected %q in\n%s", line, skeleton)
+		}
+	}
+	var hf Hapfile
+	if err := gcfg.ReadStringInto(&hf, skeleton); err != nil {
+		t.Fatal(err)
+	}
+	if host := hf.Host("web1"); len(host.Cmds()) != 4 || len(host.Listen) != 1 {
+		t.Errorf("unexpected host %+v", host)
+	}
+}
@@ -0,0 +1,85 @@
+// Hap - the simple and effective provisioner
+// Copyright (c) 2015 Garrett Woodworth (https://github.com/gwoo)
+// The BSD License http://opensource.org/licenses/bsd-license.php.
+
+package hap
+
+import (
+	"crypto/ed25519"
+	"crypto/rand"
+	"encoding/pem"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strings"
+
+	"golang.org/x/crypto/ssh"
+)
+
+// KeyDir holds the encrypted deploy keys issued for groups
+const KeyDir = ".hap/keys"
+
+// Matches valid group names
+var validGroup = regexp.MustCompile(`^[A-Za-z0-9_.-]+$`)
+
+// Installs a public key in the authorized_keys unless it is already there.
+const authorize string = "mkdir -p ~/.ssh && chmod 0700 ~/.ssh && touch ~/.ssh/authorized_keys && " +
+	"chmod 0600 ~/.ssh/authorized_keys && (grep -qxF \"%[1]s\" ~/.ssh/authorized_keys || echo \"%[1]s\" >> ~/.ssh/authorized_keys)"
+
+// GroupKeyFile returns the file of the deploy key issued for a group
+func GroupKeyFile(group string) string {
+	return filepath.Join(KeyDir, group+".key")
+}
+
+// IssueKey generates a deploy key for the group and writes it encrypted
+// with the secret key to the GroupKeyFile. It returns the public key
+// as an authorized_keys line.
+func IssueKey(group string, secret *SecretKey) (string, error) {
+	if !validGroup.MatchString(group) {
+		return "", fmt.Errorf("[key] invalid group %q", group)
+	}
+	public, private, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		return "", err
+	}
+	block, err := ssh.MarshalPrivateKey(private, "hap-"+group)
+	if err != nil {
+		return "", err
+	}
+	value, err := secret.Encrypt(string(pem.EncodeToMemory(block)))
+	if err != nil {
+		return "", err
+	}
+	if err := os.MkdirAll(KeyDir, 0700); err != nil {
+		return "", err
+	}
+	if err := ioutil.WriteFile(GroupKeyFile(group), []byte(value+"\n"), 0600); err != nil {
+		return "", err
+	}
+	pub, err := ssh.NewPublicKey(public)
+	if err != nil {
+		return "", err
+	}
+	line := strings.TrimSpace(string(ssh.MarshalAuthorizedKey(pub)))
+	return fmt.Sprintf("%s hap-%s", line, group), nil
+}
+
+// NewGroupKey decrypts a group key file and returns the private key as pem
+func NewGroupKey(file string, secret *SecretKey) ([]byte, error) {
+	b, err := ioutil.ReadFile(file)
+	if err != nil {
+		return nil, fmt.Errorf("[key] %s", err)
+	}
+	plain, err := secret.Decrypt(strings.TrimSpace(string(b)))
+	if err != nil {
+		return nil, fmt.Errorf("[key] %s %s", file, err)
+	}
+	return []byte(plain), nil
+}
+
+// Authorize installs the public key in the authorized_keys of the remote user
+func (r *Remote) Authorize(public string) error {
+	return r.Execute([]string{fmt.Sprintf(authorize, public)})
+}
@@ -0,0 +1,333 @@
+// Hap - the simple and effective provisioner
+// Copyright (c) 2015 Garrett Woodworth (https://github.com/gwoo)
+// The BSD License http://opensource.org/licenses/bsd-license.php.
+
+package hap
+
+import (
+	"fmt"
+	"net"
+	"os/exec"
+	"regexp"
+	"strings"
+	"sync"
+	"time"
+)
+
+// DefaultDrain is how long haproxy waits for sessions to drain
+const DefaultDrain = 30 * time.Second
+
+// The aws cli used for the aws load balancers
+var awsCommand = "aws"
+
+// Matches lb settings that are safe to use unquoted in commands
+var validLB = regexp.MustCompile(`^[A-Za-z0-9_.:/=-]+$`)
+
+// Serializes the rewrites of each nginx config, since the hosts of a
+// rolling deploy share it.
+var lbLocks = struct {
+	sync.Mutex
+	m map[string]*sync.Mutex
+}{m: map[string]*sync.Mutex{}}
+
+// LB holds the settings of a load balancer
+// Type is aws, haproxy, or nginx. The aws type uses the aws cli with
+// the TargetGroup of an ALB or NLB, or the name of a classic ELB. The
+// haproxy type uses the admin Socket on the Host running haproxy and
+// the nginx type rewrites the upstream File on the Host running nginx.
+// Drain is how long to wait for connections to drain.
+type LB struct {
+	Type        string
+	TargetGroup string `gcfg:"target-group"`
+	ELB         string
+	Region      string
+	Host        string
+	Socket      string
+	Backend     string
+	File        string
+	Reload      string
+	Drain       string
+	name        string
+	host        *Host
+}
+
+// Balancer takes hosts out of and back into a load balancer
+type Balancer interface {
+	Deregister(target string) error
+	Register(target string) error
+}
+
+// NewBalancer takes the lb settings and returns the Balancer
+// Balancers on a host wait out drains with the clock.
+func NewBalancer(lb *LB, clock Clock) (Balancer, error) {
+	for _, setting := range []string{lb.TargetGroup, lb.ELB, lb.Region, lb.Socket, lb.Backend, lb.File} {
+		if setting != "" && !validLB.MatchString(setting) {
+			return nil, fmt.Errorf("[%s] invalid lb setting %q", lb.name, setting)
+		}
+	}
+	drain := time.Duration(0)
+	if lb.Drain != "" {
+		var err error
+		if drain, err = time.ParseDuration(lb.Drain); err != nil {
+			return nil, fmt.Errorf("[%s] invalid drain %q", lb.name, lb.Drain)
+		}
+	}
+	switch lb.Type {
+	case "":
+		return nil, fmt.Errorf("[%s] lb not found or without type", lb.name)
+	case "aws":
+		if (lb.TargetGroup == "") == (lb.ELB == "") {
+			return nil, fmt.Errorf("[%s] aws expects either target-group or elb", lb.name)
+		}
+		return &AWSBalancer{TargetGroup: lb.TargetGroup, ELB: lb.ELB, Region: lb.Region}, nil
+	case "haproxy", "nginx":
+		if lb.host == nil {
+			return nil, fmt.Errorf("[%s] %s expects the host running it", lb.name, lb.Type)
+		}
+		remote, err := NewRemote(lb.host, clock)
+		if err != nil {
+			return nil, err
+		}
+		if lb.Type == "haproxy" {
+			if lb.Socket == "" || lb.Backend == "" {
+				return nil, fmt.Errorf("[%s] haproxy expects socket and backend", lb.name)
+			}
+			if drain == 0 {
+				drain = DefaultDrain
+			}
+			return &HAProxyBalancer{Remote: remote, Socket: lb.Socket, Backend: lb.Backend, Drain: drain}, nil
+		}
+		if lb.File == "" {
+			return nil, fmt.Errorf("[%s] nginx expects file", lb.name)
+		}
+		reload := lb.Reload
+		if reload == "" {
+			reload = "nginx -s reload"
+		}
+		return &NginxBalancer{Remote: remote, File: lb.File, Reload: reload, Drain: drain}, nil
+	}
+	return nil, fmt.Errorf("[%s] invalid lb type %q", lb.name, lb.Type)
+}
+
+// AWSBalancer registers targets with an ALB or NLB target group, or
+// instances with a classic ELB, using the aws cli
+type AWSBalancer struct {
+	TargetGroup string
+	ELB         string
+	Region      string
+}
+
+// Deregister takes the target out and waits until it is drained
+func (b *AWSBalancer) Deregister(target string) error {
+	if b.ELB != "" {
+		return b.aws(
+			[]string{"elb", "deregister-instances-from-load-balancer", "--load-balancer-name", b.ELB, "--instances", target},
+			[]string{"elb", "wait", "instance-deregistered", "--load-balancer-name", b.ELB, "--instances", target},
+		)
+	}
+	return b.aws(
+		[]string{"elbv2", "deregister-targets", "--target-group-arn", b.TargetGroup, "--targets", "Id=" + target},
+		[]string{"elbv2", "wait", "target-deregistered", "--target-group-arn", b.TargetGroup, "--targets", "Id=" + target},
+	)
+}
+
+// Register puts the target back and waits until it is in service
+func (b *AWSBalancer) Register(target string) error {
+	if b.ELB != "" {
+		return b.aws(
+			[]string{"elb", "register-instances-with-load-balancer", "--load-balancer-name", b.ELB, "--instances", target},
+			[]string{"elb", "wait", "instance-in-service", "--load-balancer-name", b.ELB, "--instances", target},
+		)
+	}
+	return b.aws(
+		[]string{"elbv2", "register-targets", "--target-group-arn", b.TargetGroup, "--targets", "Id=" + target},
+		[]string{"elbv2", "wait", "target-in-service", "--target-group-arn", b.TargetGroup, "--targets", "Id=" + target},
+	)
+}
+
+// aws runs the aws cli with each of the args in turn
+func (b *AWSBalancer) aws(args ...[]string) error {
+	for _, arg := range args {
+		if b.Region != "" {
+			arg = append(arg, "--region", b.Region)
+		}
+		if output, err := exec.Command(awsCommand, arg...).CombinedOutput(); err != nil {
+			return fmt.Errorf("%s\n%s", output, err)
+		}
+	}
+	return nil
+}
+
+// HAProxyBalancer drains and readies servers of a backend through the
+// admin socket of haproxy
+type HAProxyBalancer struct {
+	Remote  *Remote
+	Socket  string
+	Backend string
+	Drain   time.Duration
+}
+
+// Deregister drains the server, waits for its sessions to end, and
+// puts it into maintenance
+func (b *HAProxyBalancer) Deregister(server string) error {
+	defer b.Remote.Close()
+	return b.Remote.Execute([]string{
+		b.admin(fmt.Sprintf("set server %s/%s state drain", b.Backend, server)),
+		fmt.Sprintf("i=0; while [ $i -lt %d ]; do SESSIONS=$(echo \"show stat\" | socat stdio %s | grep \"^%s,%s,\" | cut -d , -f 5); "+
+			"if [ \"$SESSIONS\" = \"0\" ]; then break; fi; sleep 1; i=$((i+1)); done",
+			int(b.Drain.Seconds()), b.Socket, b.Backend, server),
+		b.admin(fmt.Sprintf("set server %s/%s state maint", b.Backend, server)),
+	})
+}
+
+// Register puts the server back into rotation
+func (b *HAProxyBalancer) Register(server string) error {
+	defer b.Remote.Close()
+	return b.Remote.Execute([]string{
+		b.admin(fmt.Sprintf("set server %s/%s state ready", b.Backend, server)),
+	})
+}
+
+// admin returns the command that sends cmd to the admin socket
+// The socket answers with an empty line on success.
+func (b *HAProxyBalancer) admin(cmd string) string {
+	return fmt.Sprintf("OUT=$(echo \"%s\" | socat stdio %s) && if [ -n \"$(echo $OUT)\" ]; then echo \"$OUT\" >&2; exit 1; fi",
+		cmd, b.Socket)
+}
+
+// NginxBalancer marks servers of an upstream down in the nginx config
+// and reloads nginx
+type NginxBalancer struct {
+	Remote *Remote
+	File   string
+	Reload string
+	Drain  time.Duration
+}
+
+// Deregister marks the server down and waits for the Drain
+func (b *NginxBalancer) Deregister(server string) error {
+	err := b.rewrite(fmt.Sprintf("%s{/[[:space:]]down[[:space:]]*;/!s/;/ down;/}", b.match(server)))
+	if err != nil {
+		return err
+	}
+	b.Remote.clock().Sleep(b.Drain)
+	return nil
+}
+
+// Register marks the server up again
+func (b *NginxBalancer) Register(server string) error {
+	return b.rewrite(fmt.Sprintf("%ss/[[:space:]]+down[[:space:]]*;/;/", b.match(server)))
+}
+
+// rewrite edits the upstream file with the sed script and reloads nginx
+func (b *NginxBalancer) rewrite(script string) error {
+	lock := lbLock(b.Remote.Host.Name, b.File)
+	lock.Lock()
+	defer lock.Unlock()
+	defer b.Remote.Close()
+	return b.Remote.Execute([]string{
+		sudo,
+		fmt.Sprintf("$SUDO sed -i -E \"%s\" %s", script, b.File),
+		"$SUDO " + b.Reload,
+	})
+}
+
+// match returns the sed address of the server lines of the upstream
+func (b *NginxBalancer) match(server string) string {
+	return fmt.Sprintf("/^[[:space:]]*server[[:space:]]+%s([:;[:space:]]|$)/", strings.Replace(server, ".", "\\.", -1))
+}
+
+// Balancers sets the load balancers of the host
+// It takes the lb settings and the hosts running haproxy or nginx.
+func (h *Host) Balancers(lbs map[string]*LB, host func(string) *Host) {
+	h.lbs = []*LB{}
+	for _, name := range h.LB {
+		lb, ok := lbs[name]
+		if !ok {
+			lb = &LB{}
+		}
+		lb.name = name
+		if lb.Host != "" && lb.host == nil {
+			lb.host = host(lb.Host)
+		}
+		h.lbs = append(h.lbs, lb)
+	}
+}
+
+// Target returns the name of the host in the load balancer
+// It is the lb-target, the name for haproxy, and the ip of the addr otherwise.
+func (h *Host) Target(lb *LB) string {
+	if h.LBTarget != "" {
+		return h.LBTarget
+	}
+	if lb.Type == "haproxy" {
+		return h.Name
+	}
+	if host, _, err := net.SplitHostPort(h.Addr); err == nil {
+		return host
+	}
+	return h.Addr
+}
+
+// Deregister takes the host out of its load balancers
+// It skips hosts where hap build would not run and returns whether
+// the host was taken out.
+func (r *Remote) Deregister() (bool, error) {
+	if len(r.Host.lbs) < 1 {
+		return false, nil
+	}
+	if pending, err := r.Pending(); err != nil || !pending {
+		return false, err
+	}
+	err := r.balance("deregister", r.Host.lbs, func(b Balancer, target string) error {
+		return b.Deregister(target)
+	})
+	return err == nil, err
+}
+
+// Register puts the host back into its load balancers in reverse order
+func (r *Remote) Register() error {
+	lbs := []*LB{}
+	for i := len(r.Host.lbs) - 1; i >= 0; i-- {
+		lbs = append(lbs, r.Host.lbs[i])
+	}
+	return r.balance("register", lbs, func(b Balancer, target string) error {
+		return b.Register(target)
+	})
+}
+
+// balance calls fn with the balancer and target of each load balancer
+// Mock hosts record the action on each load balancer to their
+// transcript instead.
+func (r *Remote) balance(action string, lbs []*LB, fn func(Balancer, string) error) error {
+	for _, lb := range lbs {
+		target := r.Host.Target(lb)
+		if !validLB.MatchString(target) {
+			return fmt.Errorf("[%s] invalid lb target %q", lb.name, target)
+		}
+		if mocked, err := r.mocked(fmt.Sprintf("lb %s %s %s", lb.name, action, target)); err != nil {
+			return err
+		} else if mocked {
+			continue
+		}
+		b, err := NewBalancer(lb, r.clock())
+		if err != nil {
+			return err
+		}
+		if err := fn(b, target); err != nil {
+			return fmt.Errorf("[%s] %s", lb.name, err)
+		}
+	}
+	return nil
+}
+
+// lbLock returns the lock of the file on the host
+func lbLock(host, file string) *sync.Mutex {
+	lbLocks.Lock()
+	defer lbLocks.Unlock()
+	key := host + ":" + file
+	if _, ok := lbLocks.m[key]; !ok {
+		lbLocks.m[key] = &sync.Mutex{}
+	}
+	return lbLocks.m[key]
+}
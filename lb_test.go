@@ -0,0 +1,106 @@
+// Hap - the simple and effective provisioner
+// Copyright (c) 2015 Garrett Woodworth (https://github.com/gwoo)
+// The BSD License http://opensource.org/licenses/bsd-license.php.
+
+package hap
+
+import (
+	"io/ioutil"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestNewBalancerInvalid(t *testing.T) {
+	lbs := []*LB{
+		{name: "none"},
+		{name: "type", Type: "f5"},
+		{name: "aws", Type: "aws"},
+		{name: "both", Type: "aws", TargetGroup: "arn", ELB: "web"},
+		{name: "quote", Type: "aws", ELB: "web'; rm -rf /"},
+		{name: "drain", Type: "aws", ELB: "web", Drain: "soon"},
+		{name: "haproxy", Type: "haproxy", Socket: "/run/haproxy.sock", Backend: "app"},
+		{name: "nginx", Type: "nginx", host: &Host{Name: "lb", Type: "local"}},
+	}
+	for _, lb := range lbs {
+		if _, err := NewBalancer(lb, nil); err == nil {
+			t.Errorf("expected error for lb %s", lb.name)
+		}
+	}
+}
+
+func TestHostTarget(t *testing.T) {
+	host := &Host{Name: "web1", Addr: "10.0.0.1:22"}
+	if target := host.Target(&LB{Type: "aws"}); target != "10.0.0.1" {
+		t.Errorf("expected the ip, got %s", target)
+	}
+	if target := host.Target(&LB{Type: "haproxy"}); target != "web1" {
+		t.Errorf("expected the name, got %s", target)
+	}
+	host.LBTarget = "i-0123"
+	if target := host.Target(&LB{Type: "aws"}); target != "i-0123" {
+		t.Errorf("expected the lb-target, got %s", target)
+	}
+}
+
+func TestAWSBalancer(t *testing.T) {
+	dir := t.TempDir()
+	log := filepath.Join(dir, "aws.log")
+	script := filepath.Join(dir, "aws")
+	ioutil.WriteFile(script, []byte("#!/bin/sh\necho \"$@\" >> "+log+"\n"), 0755)
+	defer func(command string) { awsCommand = command }(awsCommand)
+	awsCommand = script
+
+	host := &Host{Name: "web1", Addr: "10.0.0.1:22", LB: []string{"alb", "elb"}, LBTarget: "i-0123"}
+	host.Balancers(map[string]*LB{
+		"alb": {Type: "aws", TargetGroup: "arn:aws:tg/web", Region: "eu-west-1"},
+		"elb": {Type: "aws", ELB: "web"},
+	}, nil)
+	r := &Remote{Host: host}
+	if err := r.balance("deregister", host.lbs, func(b Balancer, target string) error { return b.Deregister(target) }); err != nil {
+		t.Fatal(err)
+	}
+	if err := r.Register(); err != nil {
+		t.Fatal(err)
+	}
+	b, _ := ioutil.ReadFile(log)
+	expected := []string{
+		"elbv2 deregister-targets --target-group-arn arn:aws:tg/web --targets Id=i-0123 --region eu-west-1",
+		"elbv2 wait target-deregistered --target-group-arn arn:aws:tg/web --targets Id=i-0123 --region eu-west-1",
+		"elb deregister-instances-from-load-balancer --load-balancer-name web --instances i-0123",
+		"elb wait instance-deregistered --load-balancer-name web --instances i-0123",
+		"elb register-instances-with-load-balancer --load-balancer-name web --instances i-0123",
+		"elb wait instance-in-service --load-balancer-name web --instances i-0123",
+		"elbv2 register-targets --target-group-arn arn:aws:tg/web --targets Id=i-0123 --region eu-west-1",
+		"elbv2 wait target-in-service --target-group-arn arn:aws:tg/web --targets Id=i-0123 --region eu-west-1",
+	}
+	if calls := strings.Split(strings.TrimSpace(string(b)), "\n"); strings.Join(calls, "\n") != strings.Join(expected, "\n") {
+		t.Errorf("unexpected aws calls\n%s", strings.Join(calls, "\n"))
+	}
+}
+
+func TestDeregisterWithoutLB(t *testing.T) {
+	r := &Remote{Host: &Host{Name: "web1"}}
+	if out, err := r.Deregister(); out || err != nil {
+		t.Errorf("expected host without lb to be skipped, got %v %v", out, err)
+	}
+}
+
+func TestLBHost(t *testing.T) {
+	hf := Hapfile{
+		Default: Default{Username: "deploy"},
+		Hosts: map[string]*Host{
+			"web1": {Addr: "10.0.0.1:22", LB: []string{"haproxy"}},
+			"lb1":  {Addr: "10.0.0.9:22", LB: []string{"haproxy"}},
+		},
+		LBs: map[string]*LB{"haproxy": {Type: "haproxy", Host: "lb1"}},
+	}
+	host := hf.Host("web1")
+	if len(host.lbs) != 1 || host.lbs[0].host == nil {
+		t.Fatalf("expected the lb host, got %v", host.lbs)
+	}
+	lb := host.lbs[0].host
+	if lb.Name != "lb1" || lb.Username != "deploy" || len(lb.lbs) != 0 {
+		t.Errorf("unexpected lb host %+v", lb)
+	}
+}
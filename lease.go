@@ -0,0 +1,215 @@
+// Hap - the simple and effective provisioner
+// Copyright (c) 2015 Garrett Woodworth (https://github.com/gwoo)
+// The BSD License http://opensource.org/licenses/bsd-license.php.
+
+package hap
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+// DefaultLeaseTTL is how long a lease lasts without being renewed
+const DefaultLeaseTTL = 10 * time.Minute
+
+// How often WaitLease tries to acquire a lease held by another operator
+var leasePoll = 5 * time.Second
+
+// The key of the leases in the shared state
+const leasesKey = "leases"
+
+// Lease reserves hosts of the fleet for a run of an operator
+// The lease is renewed while held and expires after the ttl when the
+// operator's machine goes away without releasing it.
+type Lease struct {
+	ID       string
+	Operator string
+	Command  string
+	Hosts    []string
+	Expires  time.Time
+	store    Store
+	ttl      time.Duration
+	clock    Clock
+	done     chan struct{}
+	release  *sync.Once
+	mu       *sync.Mutex
+	err      error
+}
+
+// LeaseError is returned when hosts are leased by another operator
+type LeaseError struct {
+	Held []Lease
+}
+
+// Error returns who holds the leases, for which hosts and how long
+func (e *LeaseError) Error() string {
+	lines := []string{}
+	for _, l := range e.Held {
+		lines = append(lines, fmt.Sprintf("%s leased by %s for %s until %s",
+			strings.Join(l.Hosts, " "), l.Operator, l.Command, l.Expires.Local().Format("15:04:05")))
+	}
+	return strings.Join(lines, "\n")
+}
+
+// LeaseTTL returns the lease ttl of the state, or DefaultLeaseTTL
+func (s State) LeaseTTL() (time.Duration, error) {
+	if s.Lease == "" {
+		return DefaultLeaseTTL, nil
+	}
+	ttl, err := time.ParseDuration(s.Lease)
+	if err != nil || ttl < time.Second {
+		return 0, fmt.Errorf("invalid state lease %q", s.Lease)
+	}
+	return ttl, nil
+}
+
+// AcquireLease leases the hosts for the command in the store
+// It fails with a LeaseError when another unexpired lease holds any of
+// the hosts, unless force takes those leases over. The lease is renewed
+// every third of the ttl until it is released, as told by the clock.
+func AcquireLease(clock Clock, store Store, command string, hosts []string, ttl time.Duration, force bool) (*Lease, error) {
+	clock = orSystemClock(clock)
+	hosts = append([]string{}, hosts...)
+	sort.Strings(hosts)
+	l := &Lease{
+		ID:       fmt.Sprintf("%s-%d-%d", Operator(), os.Getpid(), time.Now().UnixNano()),
+		Operator: Operator(),
+		Command:  command,
+		Hosts:    hosts,
+		store:    store,
+		ttl:      ttl,
+		clock:    clock,
+		done:     make(chan struct{}),
+		release:  &sync.Once{},
+		mu:       &sync.Mutex{},
+	}
+	err := l.update(func(leases []Lease) ([]Lease, error) {
+		now := clock.Now()
+		kept, held := []Lease{}, []Lease{}
+		for _, other := range leases {
+			if !other.Expires.After(now) {
+				continue
+			}
+			if other.overlaps(hosts) {
+				if force {
+					continue
+				}
+				held = append(held, other)
+			}
+			kept = append(kept, other)
+		}
+		if len(held) > 0 {
+			return nil, &LeaseError{Held: held}
+		}
+		l.Expires = now.Add(ttl)
+		return append(kept, *l), nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	go l.keep()
+	return l, nil
+}
+
+// WaitLease acquires the lease like AcquireLease, but while the hosts
+// are leased by others it keeps trying for up to wait
+func WaitLease(clock Clock, store Store, command string, hosts []string, ttl, wait time.Duration) (*Lease, error) {
+	clock = orSystemClock(clock)
+	deadline := clock.Now().Add(wait)
+	for {
+		l, err := AcquireLease(clock, store, command, hosts, ttl, false)
+		if _, held := err.(*LeaseError); !held || clock.Now().Add(leasePoll).After(deadline) {
+			return l, err
+		}
+		clock.Sleep(leasePoll)
+	}
+}
+
+// Release stops renewing the lease and removes it from the store
+// It is safe to call more than once. It fails when a renewal failed,
+// since the lease may have expired while it was held.
+func (l *Lease) Release() error {
+	l.release.Do(func() { close(l.done) })
+	err := l.update(func(leases []Lease) ([]Lease, error) {
+		kept := []Lease{}
+		for _, other := range leases {
+			if other.ID != l.ID {
+				kept = append(kept, other)
+			}
+		}
+		return kept, nil
+	})
+	if err != nil {
+		return err
+	}
+	return l.Err()
+}
+
+// Err returns the error of the last failed renewal, if any
+func (l *Lease) Err() error {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	return l.err
+}
+
+// keep renews the lease until it is released
+// A lease removed by an operator who took it over is not renewed.
+func (l *Lease) keep() {
+	for {
+		select {
+		case <-l.done:
+			return
+		case <-l.clock.After(l.ttl / 3):
+			err := l.update(func(leases []Lease) ([]Lease, error) {
+				for i := range leases {
+					if leases[i].ID == l.ID {
+						leases[i].Expires = l.clock.Now().Add(l.ttl)
+					}
+				}
+				return leases, nil
+			})
+			if err != nil {
+				l.mu.Lock()
+				l.err = fmt.Errorf("lease renewal failed: %s", err)
+				l.mu.Unlock()
+			}
+		}
+	}
+}
+
+// update changes the leases in the store with fn
+func (l *Lease) update(fn func([]Lease) ([]Lease, error)) error {
+	return update(l.store, leasesKey, func(value []byte) ([]byte, error) {
+		leases := []Lease{}
+		if value != nil {
+			if err := json.Unmarshal(value, &leases); err != nil {
+				return nil, fmt.Errorf("invalid leases: %s", err)
+			}
+		}
+		leases, err := fn(leases)
+		if err != nil {
+			return nil, err
+		}
+		if len(leases) == 0 {
+			return nil, nil
+		}
+		return json.MarshalIndent(leases, "", "  ")
+	})
+}
+
+// overlaps returns whether the lease holds any of the hosts
+func (l Lease) overlaps(hosts []string) bool {
+	for _, held := range l.Hosts {
+		for _, host := range hosts {
+			if held == host {
+				return true
+			}
+		}
+	}
+	return false
+}
@@ -0,0 +1,128 @@
+// Hap - the simple and effective provisioner
+// Copyright (c) 2015 Garrett Woodworth (https://github.com/gwoo)
+// The BSD License http://opensource.org/licenses/bsd-license.php.
+
+package hap
+
+import (
+	"encoding/json"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestAcquireLease(t *testing.T) {
+	store := newMemoryStore()
+	a, err := AcquireLease(nil, store, "build", []string{"two", "one"}, time.Minute, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, err = AcquireLease(nil, store, "push", []string{"two", "three"}, time.Minute, false)
+	if _, ok := err.(*LeaseError); !ok || !strings.Contains(err.Error(), "one two leased by "+Operator()+" for build until") {
+		t.Errorf("expected lease error, got %v", err)
+	}
+	b, err := AcquireLease(nil, store, "push", []string{"three"}, time.Minute, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	c, err := AcquireLease(nil, store, "build", []string{"one"}, time.Minute, true)
+	if err != nil {
+		t.Fatal(err)
+	}
+	leases := []Lease{}
+	value, _, _ := store.Get(leasesKey)
+	json.Unmarshal(value, &leases)
+	if len(leases) != 2 || leases[0].ID != b.ID || leases[1].ID != c.ID {
+		t.Errorf("unexpected leases %v", leases)
+	}
+	for _, l := range []*Lease{a, b, c} {
+		if err := l.Release(); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if value, _, _ := store.Get(leasesKey); value != nil {
+		t.Errorf("expected no leases, got %s", value)
+	}
+}
+
+func TestLeaseExpiresAndRenews(t *testing.T) {
+	store := newMemoryStore()
+	expired, _ := json.Marshal([]Lease{{ID: "gone", Hosts: []string{"one"}, Expires: time.Now().Add(-time.Second)}})
+	store.values[leasesKey] = expired
+	l, err := AcquireLease(nil, store, "build", []string{"one"}, 60*time.Millisecond, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer l.Release()
+	first := l.Expires
+	time.Sleep(100 * time.Millisecond)
+	leases := []Lease{}
+	value, _, _ := store.Get(leasesKey)
+	json.Unmarshal(value, &leases)
+	if len(leases) != 1 || !leases[0].Expires.After(first) {
+		t.Errorf("expected renewed lease, got %v", leases)
+	}
+}
+
+func TestWaitLease(t *testing.T) {
+	poll := leasePoll
+	leasePoll = 10 * time.Millisecond
+	defer func() { leasePoll = poll }()
+	store := newMemoryStore()
+	a, err := AcquireLease(nil, store, "build", []string{"one"}, time.Minute, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := WaitLease(nil, store, "build", []string{"one"}, time.Minute, 30*time.Millisecond); err == nil {
+		t.Error("expected lease error after waiting")
+	}
+	go func() {
+		time.Sleep(30 * time.Millisecond)
+		a.Release()
+	}()
+	b, err := WaitLease(nil, store, "build", []string{"one"}, time.Minute, time.Second)
+	if err != nil {
+		t.Fatal(err)
+	}
+	b.Release()
+}
+
+func TestLeaseReleaseTwice(t *testing.T) {
+	l, err := AcquireLease(nil, newMemoryStore(), "build", []string{"one"}, time.Minute, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var wg sync.WaitGroup
+	for i := 0; i < 2; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			if err := l.Release(); err != nil {
+				t.Error(err)
+			}
+		}()
+	}
+	wg.Wait()
+}
+
+func TestLeaseRenewalFailed(t *testing.T) {
+	store := newMemoryStore()
+	l, err := AcquireLease(nil, store, "build", []string{"one"}, 30*time.Millisecond, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	valid, _, _ := store.Get(leasesKey)
+	store.mu.Lock()
+	store.values[leasesKey] = []byte("nope")
+	store.mu.Unlock()
+	for i := 0; i < 100 && l.Err() == nil; i++ {
+		time.Sleep(5 * time.Millisecond)
+	}
+	store.mu.Lock()
+	store.values[leasesKey] = valid
+	store.mu.Unlock()
+	if err := l.Release(); err == nil || !strings.Contains(err.Error(), "lease renewal failed: invalid leases") {
+		t.Errorf("expected the failed renewal, got %v", err)
+	}
+}
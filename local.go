@@ -0,0 +1,63 @@
+// Hap - the simple and effective provisioner
+// Copyright (c) 2015 Garrett Woodworth (https://github.com/gwoo)
+// The BSD License http://opensource.org/licenses/bsd-license.php.
+
+package hap
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"os/exec"
+	"path/filepath"
+)
+
+// IsLocal returns whether the host is the machine running hap
+// Local hosts run the commands with os/exec instead of ssh.
+func (h *Host) IsLocal() bool {
+	return h.Type == "local"
+}
+
+// localRepo returns the path of the repo of a local host
+// It refuses to deploy into the working repo itself.
+func localRepo(host *Host, dir, cwd string) (string, error) {
+	home, err := expandHome("~")
+	if err != nil {
+		return "", err
+	}
+	repo := dir
+	if !filepath.IsAbs(dir) {
+		repo = filepath.Join(home, dir)
+	}
+	if repo == cwd {
+		return "", fmt.Errorf("[%s] local deploy dir %s is the working repo", host.Name, repo)
+	}
+	return repo, nil
+}
+
+// runLocal executes the commands on the local machine from the home dir
+// just like an ssh session would.
+func (r *Remote) runLocal(commands []string, stdout, stderr io.Writer) (*Result, error) {
+	home, err := expandHome("~")
+	if err != nil {
+		return nil, err
+	}
+	cmd := exec.Command("sh", "-c", r.command(commands))
+	cmd.Dir = home
+	cmd.Stdout = stdout
+	cmd.Stderr = stderr
+	if r.stdin != nil {
+		cmd.Stdin = bytes.NewReader(r.stdin)
+	}
+	start := r.clock().Now()
+	err = cmd.Run()
+	result := &Result{Duration: r.clock().Now().Sub(start)}
+	if err != nil {
+		result.ExitCode = -1
+		if exit, ok := err.(*exec.ExitError); ok {
+			result.ExitCode = exit.ExitCode()
+		}
+		return result, err
+	}
+	return result, nil
+}
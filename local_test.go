@@ -0,0 +1,46 @@
+// Hap - the simple and effective provisioner
+// Copyright (c) 2015 Garrett Woodworth (https://github.com/gwoo)
+// The BSD License http://opensource.org/licenses/bsd-license.php.
+
+package hap
+
+import (
+	"bytes"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestLocalRepo(t *testing.T) {
+	home := os.Getenv("HOME")
+	host := &Host{Name: "me", Type: "local"}
+	repo, err := localRepo(host, "app", "/src/app")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if repo != filepath.Join(home, "app") {
+		t.Errorf("unexpected repo %s", repo)
+	}
+	if _, err := localRepo(host, "app", filepath.Join(home, "app")); err == nil {
+		t.Error("expected error deploying into the working repo")
+	}
+}
+
+func TestRunLocal(t *testing.T) {
+	host := &Host{Name: "me", Type: "local"}
+	if !host.IsLocal() {
+		t.Fatal("expected host to be local")
+	}
+	var stdout bytes.Buffer
+	r := &Remote{Host: host}
+	result, err := r.runLocal([]string{"echo $HAP_HOSTNAME", "exit 3"}, &stdout, &stdout)
+	if err == nil {
+		t.Fatal("expected error")
+	}
+	if result.ExitCode != 3 {
+		t.Errorf("expected exit code 3, got %d", result.ExitCode)
+	}
+	if stdout.String() != "me\n" {
+		t.Errorf("unexpected output %q", stdout.String())
+	}
+}
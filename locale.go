@@ -0,0 +1,47 @@
+// Hap - the simple and effective provisioner
+// Copyright (c) 2015 Garrett Woodworth (https://github.com/gwoo)
+// The BSD License http://opensource.org/licenses/bsd-license.php.
+
+package hap
+
+import (
+	"fmt"
+	"regexp"
+)
+
+// DefaultLocale is the locale of the commands whose output hap parses
+const DefaultLocale = "C"
+
+// Matches locale names like C, C.UTF-8, or en_US.UTF-8@euro
+var validLocale = regexp.MustCompile(`^[A-Za-z0-9_.@-]+$`)
+
+// The env of local commands whose output hap parses
+var parsedEnv = []string{"LC_ALL=" + DefaultLocale}
+
+// ParsedLocale returns the locale the commands whose output hap parses
+// run in. It uses the DefaultLocale when locale is not set.
+func (h *Host) ParsedLocale() (string, error) {
+	if h.Locale == "" {
+		return DefaultLocale, nil
+	}
+	if !validLocale.MatchString(h.Locale) {
+		return "", fmt.Errorf("[%s] invalid locale %q", h.Name, h.Locale)
+	}
+	return h.Locale, nil
+}
+
+// localized returns the commands set to run in the ParsedLocale
+// Messages of tools like git, df, and systemctl are translated in other
+// locales, which breaks parsing their output and matching their errors.
+func (r *Remote) localized(commands []string) ([]string, error) {
+	locale, err := r.Host.ParsedLocale()
+	if err != nil {
+		return nil, err
+	}
+	if len(commands) < 1 {
+		return commands, nil
+	}
+	localized := append([]string{}, commands...)
+	localized[0] = fmt.Sprintf("export LC_ALL=%s; unset LANGUAGE; %s", locale, commands[0])
+	return localized, nil
+}
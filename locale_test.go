@@ -0,0 +1,47 @@
+// Hap - the simple and effective provisioner
+// Copyright (c) 2015 Garrett Woodworth (https://github.com/gwoo)
+// The BSD License http://opensource.org/licenses/bsd-license.php.
+
+package hap
+
+import (
+	"testing"
+)
+
+func TestCaptureLocale(t *testing.T) {
+	r := &Remote{Host: &Host{Name: "me", Type: "local"}}
+	result, err := r.Capture([]string{"echo \"$LC_ALL\""})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(result.Stdout) != "C\n" {
+		t.Errorf("unexpected locale %q", result.Stdout)
+	}
+	r.Host.Locale = "C.UTF-8"
+	result, err = r.Capture([]string{"true", "echo \"$LC_ALL\""})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(result.Stdout) != "C.UTF-8\n" {
+		t.Errorf("unexpected locale %q", result.Stdout)
+	}
+	r.Host.Locale = "C; rm -rf /"
+	if _, err := r.Capture([]string{"true"}); err == nil {
+		t.Error("expected error for invalid locale")
+	}
+}
+
+func TestLocalizedEmpty(t *testing.T) {
+	r := &Remote{Host: &Host{Name: "me"}}
+	if commands, err := r.localized(nil); err != nil || len(commands) != 0 {
+		t.Errorf("expected no commands, got %v %v", commands, err)
+	}
+}
+
+func TestGitLocale(t *testing.T) {
+	t.Setenv("LC_ALL", "de_DE.UTF-8")
+	cmd := Git{}.command("version")
+	if env := cmd.Env[len(cmd.Env)-1]; env != "LC_ALL=C" {
+		t.Errorf("expected git to run with LC_ALL=C, got %s", env)
+	}
+}
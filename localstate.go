@@ -0,0 +1,195 @@
+// Hap - the simple and effective provisioner
+// Copyright (c) 2015 Garrett Woodworth (https://github.com/gwoo)
+// The BSD License http://opensource.org/licenses/bsd-license.php.
+
+package hap
+
+import (
+	"archive/tar"
+	"bytes"
+	"compress/gzip"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"golang.org/x/crypto/ssh"
+)
+
+// LocalStateFiles are the state kept on the machine of the operator, the
+// trusted host keys, the cached facts, and the results of the last run.
+// The shared state of the backend is left to the backend.
+var LocalStateFiles = []string{KnownHostsFile, InventoryFile, LastRunFile}
+
+// ExportState writes a gzipped tar archive of the LocalStateFiles to w
+// Files that do not exist yet are left out.
+func ExportState(w io.Writer) error {
+	gz := gzip.NewWriter(w)
+	tw := tar.NewWriter(gz)
+	for _, file := range LocalStateFiles {
+		b, err := ioutil.ReadFile(file)
+		if os.IsNotExist(err) {
+			continue
+		}
+		if err != nil {
+			return err
+		}
+		header := &tar.Header{Name: "hap-state/" + filepath.Base(file), Mode: 0600, Size: int64(len(b)), ModTime: time.Now()}
+		if err := tw.WriteHeader(header); err != nil {
+			return err
+		}
+		if _, err := tw.Write(b); err != nil {
+			return err
+		}
+	}
+	if err := tw.Close(); err != nil {
+		return err
+	}
+	return gz.Close()
+}
+
+// ImportState merges an archive of ExportState into the local state and
+// returns a line for each change. Host keys are added unless they do not
+// match the trusted ones, which is returned as an error, the facts of a
+// host are taken when they are newer, and so is the last run.
+func ImportState(r io.Reader) ([]string, error) {
+	gz, err := gzip.NewReader(r)
+	if err != nil {
+		return nil, fmt.Errorf("invalid state archive: %s", err)
+	}
+	files := map[string][]byte{}
+	tr := tar.NewReader(gz)
+	for {
+		header, err := tr.Next()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return nil, fmt.Errorf("invalid state archive: %s", err)
+		}
+		b, err := ioutil.ReadAll(tr)
+		if err != nil {
+			return nil, fmt.Errorf("invalid state archive: %s", err)
+		}
+		files[strings.TrimPrefix(header.Name, "hap-state/")] = b
+	}
+	changes := []string{}
+	var keyErr error
+	if b, ok := files[filepath.Base(KnownHostsFile)]; ok {
+		var added int
+		added, keyErr = importKnownHosts(b)
+		if added > 0 {
+			changes = append(changes, fmt.Sprintf("added %d host keys to %s", added, KnownHostsFile))
+		}
+	}
+	if b, ok := files[filepath.Base(InventoryFile)]; ok {
+		updated, err := importInventory(b)
+		if err != nil {
+			return changes, err
+		}
+		if updated > 0 {
+			changes = append(changes, fmt.Sprintf("updated the facts of %d hosts in %s", updated, InventoryFile))
+		}
+	}
+	if b, ok := files[filepath.Base(LastRunFile)]; ok {
+		updated, err := importLastRun(b)
+		if err != nil {
+			return changes, err
+		}
+		if updated {
+			changes = append(changes, fmt.Sprintf("replaced the last run in %s", LastRunFile))
+		}
+	}
+	return changes, keyErr
+}
+
+// importKnownHosts adds the host keys to the KnownHostsFile like a scan
+// and returns how many were added
+func importKnownHosts(b []byte) (int, error) {
+	scans := []Scan{}
+	for len(b) > 0 {
+		marker, hosts, key, _, rest, err := ssh.ParseKnownHosts(b)
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return 0, fmt.Errorf("invalid %s in state archive: %s", KnownHostsFile, err)
+		}
+		b = rest
+		if marker != "" {
+			continue
+		}
+		for _, host := range hosts {
+			addr := host
+			if _, _, err := net.SplitHostPort(host); err != nil {
+				addr = net.JoinHostPort(host, "22")
+			}
+			scans = append(scans, Scan{Host: host, Addr: addr, Key: key})
+		}
+	}
+	before := countLines(KnownHostsFile)
+	err := WriteKnownHosts(KnownHostsFile, scans)
+	return countLines(KnownHostsFile) - before, err
+}
+
+// countLines returns the number of lines in the file
+func countLines(file string) int {
+	b, err := ioutil.ReadFile(file)
+	if err != nil {
+		return 0
+	}
+	return bytes.Count(b, []byte("\n"))
+}
+
+// importInventory takes the cached facts of the hosts that are newer
+// than those in the InventoryFile and returns how many were taken
+func importInventory(b []byte) (int, error) {
+	imported := map[string]Inventoried{}
+	if err := json.Unmarshal(b, &imported); err != nil {
+		return 0, fmt.Errorf("invalid %s in state archive: %s", InventoryFile, err)
+	}
+	inventory := map[string]Inventoried{}
+	if b, err := ioutil.ReadFile(InventoryFile); err == nil {
+		if err := json.Unmarshal(b, &inventory); err != nil {
+			return 0, fmt.Errorf("invalid %s: %s", InventoryFile, err)
+		}
+	}
+	updated := 0
+	for name, entry := range imported {
+		if current, ok := inventory[name]; ok && !entry.Time.After(current.Time) {
+			continue
+		}
+		inventory[name] = entry
+		updated++
+	}
+	if updated < 1 {
+		return 0, nil
+	}
+	b, err := json.MarshalIndent(inventory, "", "  ")
+	if err != nil {
+		return 0, err
+	}
+	if err := os.MkdirAll(filepath.Dir(InventoryFile), 0700); err != nil {
+		return 0, err
+	}
+	return updated, ioutil.WriteFile(InventoryFile, append(b, '\n'), 0644)
+}
+
+// importLastRun replaces the LastRunFile when the imported run is newer
+func importLastRun(b []byte) (bool, error) {
+	var imported, current LastRun
+	if err := json.Unmarshal(b, &imported); err != nil {
+		return false, fmt.Errorf("invalid %s in state archive: %s", LastRunFile, err)
+	}
+	if b, err := ioutil.ReadFile(LastRunFile); err == nil {
+		if json.Unmarshal(b, &current) == nil && !imported.Time.After(current.Time) {
+			return false, nil
+		}
+	}
+	return true, WriteLastRun(LastRunFile, imported)
+}
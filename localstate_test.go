@@ -0,0 +1,84 @@
+// Hap - the simple and effective provisioner
+// Copyright (c) 2015 Garrett Woodworth (https://github.com/gwoo)
+// The BSD License http://opensource.org/licenses/bsd-license.php.
+
+package hap
+
+import (
+	"bytes"
+	"encoding/json"
+	"io/ioutil"
+	"net"
+	"os"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestExportImportState(t *testing.T) {
+	wd, _ := os.Getwd()
+	defer os.Chdir(wd)
+	one, two, other := testHostKey(t), testHostKey(t), testHostKey(t)
+	old, now := time.Date(2024, 1, 2, 3, 4, 5, 0, time.UTC), time.Date(2024, 2, 3, 4, 5, 6, 0, time.UTC)
+
+	os.Chdir(t.TempDir())
+	WriteKnownHosts(KnownHostsFile, []Scan{{Host: "one", Addr: "10.0.0.1:22", Key: one}, {Host: "two", Addr: "10.0.0.2:2222", Key: two}})
+	ioutil.WriteFile(InventoryFile, []byte(`{"one": {"Addr": "10.0.0.1:22", "Time": "2024-02-03T04:05:06Z"}, "two": {"Addr": "10.0.0.2:2222", "Time": "2024-01-02T03:04:05Z"}}`), 0644)
+	WriteLastRun(LastRunFile, LastRun{Command: "build", Time: now})
+	var archive bytes.Buffer
+	if err := ExportState(&archive); err != nil {
+		t.Fatal(err)
+	}
+
+	os.Chdir(t.TempDir())
+	changes, err := ImportState(bytes.NewReader(archive.Bytes()))
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected := []string{
+		"added 2 host keys to .hap/known_hosts",
+		"updated the facts of 2 hosts in .hap/inventory.json",
+		"replaced the last run in .hap/last-run.json",
+	}
+	if !reflect.DeepEqual(changes, expected) {
+		t.Errorf("unexpected changes %q", changes)
+	}
+	check := KnownHosts(KnownHostsFile)
+	if err := check("10.0.0.2:2222", &net.TCPAddr{}, two); err != nil {
+		t.Errorf("expected the imported key to be trusted, got %s", err)
+	}
+	if err := check("10.0.0.1:22", &net.TCPAddr{}, other); err == nil {
+		t.Error("expected other keys to be refused")
+	}
+
+	os.Chdir(t.TempDir())
+	WriteKnownHosts(KnownHostsFile, []Scan{{Host: "one", Addr: "10.0.0.1:22", Key: other}})
+	ioutil.WriteFile(InventoryFile, []byte(`{"one": {"Addr": "10.0.0.9:22", "Time": "2024-03-01T00:00:00Z"}}`), 0644)
+	WriteLastRun(LastRunFile, LastRun{Command: "exec", Time: now.Add(time.Hour)})
+	changes, err = ImportState(bytes.NewReader(archive.Bytes()))
+	if err == nil || !strings.Contains(err.Error(), "[10.0.0.1] host key") {
+		t.Errorf("expected the conflicting host key to be refused, got %v", err)
+	}
+	expected = []string{
+		"added 1 host keys to .hap/known_hosts",
+		"updated the facts of 1 hosts in .hap/inventory.json",
+	}
+	if !reflect.DeepEqual(changes, expected) {
+		t.Errorf("unexpected changes %q", changes)
+	}
+	inventory := map[string]Inventoried{}
+	b, _ := ioutil.ReadFile(InventoryFile)
+	json.Unmarshal(b, &inventory)
+	if inventory["one"].Addr != "10.0.0.9:22" || !inventory["two"].Time.Equal(old) {
+		t.Errorf("expected the newer facts to be kept, got %v", inventory)
+	}
+	b, _ = ioutil.ReadFile(LastRunFile)
+	if !strings.Contains(string(b), `"Command": "exec"`) {
+		t.Errorf("expected the newer last run to be kept, got %s", b)
+	}
+
+	if _, err := ImportState(strings.NewReader("nope")); err == nil || !strings.Contains(err.Error(), "invalid state archive") {
+		t.Errorf("expected an invalid archive, got %v", err)
+	}
+}
@@ -0,0 +1,102 @@
+// Hap - the simple and effective provisioner
+// Copyright (c) 2015 Garrett Woodworth (https://github.com/gwoo)
+// The BSD License http://opensource.org/licenses/bsd-license.php.
+
+package hap
+
+import (
+	"bytes"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+)
+
+// MetricsFile is where the metrics of the last run are written by default
+const MetricsFile = ".hap/metrics.prom"
+
+// Escapes label values in the Prometheus text format
+var labelEscaper = strings.NewReplacer(`\`, `\\`, `"`, `\"`, "\n", `\n`)
+
+// Metrics returns the metrics of the run in the Prometheus text format
+func Metrics(run LastRun) []byte {
+	command := ""
+	if fields := strings.Fields(run.Command); len(fields) > 0 {
+		command = fields[0]
+	}
+	labels := fmt.Sprintf(`command="%s"`, labelEscaper.Replace(command))
+	hosts := append([]RunResult{}, run.Hosts...)
+	sort.Slice(hosts, func(i, j int) bool { return hosts[i].Host < hosts[j].Host })
+	succeeded, failed, timedOut, pushed := 0, 0, 0, int64(0)
+	for _, host := range hosts {
+		switch {
+		case host.TimedOut:
+			timedOut++
+		case host.Error == "":
+			succeeded++
+		default:
+			failed++
+		}
+		pushed += host.Pushed
+	}
+	var b bytes.Buffer
+	metric := func(name, kind, help string) {
+		fmt.Fprintf(&b, "# HELP %s %s\n# TYPE %s %s\n", name, help, name, kind)
+	}
+	metric("hap_run_timestamp_seconds", "gauge", "Unix time the last run of hap started.")
+	fmt.Fprintf(&b, "hap_run_timestamp_seconds{%s} %d\n", labels, run.Time.Unix())
+	metric("hap_run_duration_seconds", "gauge", "Duration of the last run of hap.")
+	fmt.Fprintf(&b, "hap_run_duration_seconds{%s} %g\n", labels, run.Duration.Seconds())
+	metric("hap_run_hosts", "gauge", "Hosts of the last run of hap by result.")
+	fmt.Fprintf(&b, "hap_run_hosts{%s,result=\"succeeded\"} %d\n", labels, succeeded)
+	fmt.Fprintf(&b, "hap_run_hosts{%s,result=\"failed\"} %d\n", labels, failed)
+	fmt.Fprintf(&b, "hap_run_hosts{%s,result=\"timed_out\"} %d\n", labels, timedOut)
+	metric("hap_run_pushed_bytes", "gauge", "Bytes uploaded to the hosts by the last run of hap.")
+	fmt.Fprintf(&b, "hap_run_pushed_bytes{%s} %d\n", labels, pushed)
+	if len(hosts) < 1 {
+		return b.Bytes()
+	}
+	metric("hap_host_success", "gauge", "Whether the last run of hap succeeded on the host.")
+	for _, host := range hosts {
+		success := 1
+		if host.Error != "" {
+			success = 0
+		}
+		fmt.Fprintf(&b, "hap_host_success{%s,host=\"%s\"} %d\n", labels, labelEscaper.Replace(host.Host), success)
+	}
+	metric("hap_host_duration_seconds", "gauge", "Duration of the last run of hap on the host.")
+	for _, host := range hosts {
+		fmt.Fprintf(&b, "hap_host_duration_seconds{%s,host=\"%s\"} %g\n", labels, labelEscaper.Replace(host.Host), host.Duration.Seconds())
+	}
+	metric("hap_host_pushed_bytes", "gauge", "Bytes uploaded to the host by the last run of hap.")
+	for _, host := range hosts {
+		fmt.Fprintf(&b, "hap_host_pushed_bytes{%s,host=\"%s\"} %d\n", labels, labelEscaper.Replace(host.Host), host.Pushed)
+	}
+	return b.Bytes()
+}
+
+// WriteMetrics replaces the file with the Metrics of the run
+// The file is swapped in whole, so collectors never read a partial one.
+func WriteMetrics(file string, run LastRun) error {
+	if err := os.MkdirAll(filepath.Dir(file), 0700); err != nil {
+		return err
+	}
+	tmp, err := ioutil.TempFile(filepath.Dir(file), ".metrics")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name())
+	_, err = tmp.Write(Metrics(run))
+	if cerr := tmp.Close(); err == nil {
+		err = cerr
+	}
+	if err != nil {
+		return err
+	}
+	if err := os.Chmod(tmp.Name(), 0644); err != nil {
+		return err
+	}
+	return os.Rename(tmp.Name(), file)
+}
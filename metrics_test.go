@@ -0,0 +1,52 @@
+// Hap - the simple and effective provisioner
+// Copyright (c) 2015 Garrett Woodworth (https://github.com/gwoo)
+// The BSD License http://opensource.org/licenses/bsd-license.php.
+
+package hap
+
+import (
+	"io/ioutil"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestMetrics(t *testing.T) {
+	run := LastRun{
+		Command:  "build -x",
+		Time:     time.Unix(1700000000, 0),
+		Duration: 3500 * time.Millisecond,
+		Hosts: []RunResult{
+			{Host: "web\"2", Error: "exit status 1", Duration: time.Second},
+			{Host: "web1", Duration: 2 * time.Second, Pushed: 2048},
+			{Host: "web3", Error: "timed out", TimedOut: true, Duration: time.Second},
+		},
+	}
+	metrics := string(Metrics(run))
+	for _, line := range []string{
+		"# TYPE hap_run_duration_seconds gauge",
+		`hap_run_timestamp_seconds{command="build"} 1700000000`,
+		`hap_run_duration_seconds{command="build"} 3.5`,
+		`hap_run_hosts{command="build",result="succeeded"} 1`,
+		`hap_run_hosts{command="build",result="failed"} 1`,
+		`hap_run_hosts{command="build",result="timed_out"} 1`,
+		`hap_run_pushed_bytes{command="build"} 2048`,
+		`hap_host_success{command="build",host="web\"2"} 0`,
+		`hap_host_success{command="build",host="web1"} 1`,
+		`hap_host_success{command="build",host="web3"} 0`,
+		`hap_host_duration_seconds{command="build",host="web1"} 2`,
+		`hap_host_pushed_bytes{command="build",host="web1"} 2048`,
+	} {
+		if !strings.Contains(metrics, line+"\n") {
+			t.Errorf("expected %s in\n%s", line, metrics)
+		}
+	}
+	file := filepath.Join(t.TempDir(), ".hap", "metrics.prom")
+	if err := WriteMetrics(file, run); err != nil {
+		t.Fatal(err)
+	}
+	if b, err := ioutil.ReadFile(file); err != nil || string(b) != metrics {
+		t.Errorf("unexpected metrics file %q %v", b, err)
+	}
+}
@@ -0,0 +1,159 @@
+// Hap - the simple and effective provisioner
+// Copyright (c) 2015 Garrett Woodworth (https://github.com/gwoo)
+// The BSD License http://opensource.org/licenses/bsd-license.php.
+
+package hap
+
+import (
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+// MockDir keeps the transcripts of the mock hosts
+const MockDir = ".hap/mock"
+
+// Serializes the writes to the transcripts and keeps the environment
+// last written to each of them
+var mockMu sync.Mutex
+var mockEnvs = map[string]string{}
+
+// IsMock returns whether the host is a mock for testing the Hapfile
+// Mock hosts accept every operation and record it to their transcript
+// instead of touching a machine.
+func (h *Host) IsMock() bool {
+	return h.Type == "mock"
+}
+
+// Transcript returns the file the operations on the mock host go to
+func (h *Host) Transcript() string {
+	name := strings.TrimLeft(unsafeName.ReplaceAllString(h.Name, "_"), ".")
+	return filepath.Join(MockDir, name+".log")
+}
+
+// mockEnv returns the environment of the commands as NAME=value lines
+// Encrypted env values are masked, they were decrypted all the same.
+func (r *Remote) mockEnv() []string {
+	env := []string{
+		"HAP_HOSTNAME=" + r.Host.Name,
+		"HAP_ADDR=" + r.Host.Addr,
+		"HAP_USER=" + r.Host.Username,
+	}
+	keys := []string{}
+	for key := range r.facts {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	for _, key := range keys {
+		env = append(env, fmt.Sprintf("HAP_FACT_%s=%s", key, r.facts[key]))
+	}
+	for i, pair := range r.env {
+		if i < len(r.Host.Env) && IsEncrypted(strings.SplitN(r.Host.Env[i], "=", 2)[1]) {
+			pair = strings.SplitN(pair, "=", 2)[0] + "=***"
+		}
+		env = append(env, pair)
+	}
+	return env
+}
+
+// runMock records the commands and the size of their stdin to the
+// transcript of the host, preceded by their environment when it
+// changed. The commands succeed, with the answer of a healthy host to
+// the queries whose output is checked and without output otherwise.
+func (r *Remote) runMock(commands []string, stdout io.Writer) (*Result, error) {
+	lines := []string{}
+	for _, command := range commands {
+		lines = append(lines, "$ "+command)
+	}
+	if r.stdin != nil {
+		lines = append(lines, fmt.Sprintf("< %d bytes", len(r.stdin)))
+	}
+	if err := r.Host.transcribe(r.clock().Now(), r.mockEnv(), lines); err != nil {
+		return nil, err
+	}
+	if _, err := io.WriteString(stdout, r.mockAnswer(commands)); err != nil {
+		return nil, err
+	}
+	return &Result{}, nil
+}
+
+// mockAnswer returns the output of a host that is as the Hapfile
+// expects it for the queries whose output is checked: its clock agrees
+// with the local one, and it listens on the expect-listen addresses
+// and runs the expect-process processes.
+func (r *Remote) mockAnswer(commands []string) string {
+	for _, command := range commands {
+		switch {
+		case strings.HasSuffix(command, remoteTime):
+			now := r.clock().Now()
+			return fmt.Sprintf("%d.%09d\n", now.Unix(), now.Nanosecond())
+		case strings.HasSuffix(command, listening):
+			lines := []string{"-- listen"}
+			for _, listen := range r.Host.Listen {
+				if !strings.Contains(listen, ":") {
+					listen = "*:" + listen
+				}
+				lines = append(lines, fmt.Sprintf("LISTEN 0 128 %s *:*", listen))
+			}
+			lines = append(lines, "-- comm")
+			lines = append(lines, r.Host.Process...)
+			return strings.Join(lines, "\n") + "\n"
+		}
+	}
+	return ""
+}
+
+// mocked records a local side effect of an operation, like a confirm
+// hook, an lb change, or a notification, to the transcript of mock
+// hosts and returns whether the host is a mock, which skips it
+func (r *Remote) mocked(line string) (bool, error) {
+	if !r.Host.IsMock() {
+		return false, nil
+	}
+	return true, r.Host.transcribe(r.clock().Now(), nil, []string{line})
+}
+
+// transcribe appends the time and the lines to the transcript of the
+// host, preceded by the env when it differs from the last one written
+func (h *Host) transcribe(now time.Time, env, lines []string) error {
+	mockMu.Lock()
+	defer mockMu.Unlock()
+	if err := os.MkdirAll(MockDir, 0700); err != nil {
+		return err
+	}
+	file := h.Transcript()
+	head := []string{fmt.Sprintf("# %s", now.UTC().Format(time.RFC3339))}
+	if joined := strings.Join(env, "\n"); env != nil && joined != mockEnvs[file] {
+		for _, pair := range env {
+			head = append(head, "env "+pair)
+		}
+		mockEnvs[file] = joined
+	}
+	f, err := os.OpenFile(file, os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0600)
+	if err != nil {
+		return err
+	}
+	_, err = io.WriteString(f, strings.Join(append(head, lines...), "\n")+"\n")
+	if cerr := f.Close(); err == nil {
+		err = cerr
+	}
+	return err
+}
+
+// pushMock records the push of the current branch to the transcript
+func (r *Remote) pushMock() error {
+	rev, target, err := r.target()
+	if err != nil {
+		return err
+	}
+	head, err := r.Git.Head()
+	if err != nil {
+		return err
+	}
+	return r.Host.transcribe(r.clock().Now(), nil, []string{fmt.Sprintf("push %s (%s) to refs/heads/%s", rev, head, target)})
+}
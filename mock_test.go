@@ -0,0 +1,219 @@
+// Hap - the simple and effective provisioner
+// Copyright (c) 2015 Garrett Woodworth (https://github.com/gwoo)
+// The BSD License http://opensource.org/licenses/bsd-license.php.
+
+package hap
+
+import (
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestMockHost(t *testing.T) {
+	work := filepath.Join(t.TempDir(), "app")
+	os.MkdirAll(work, 0755)
+	wd, _ := os.Getwd()
+	os.Chdir(work)
+	defer os.Chdir(wd)
+	for _, args := range [][]string{{"init", "-q"}, {"commit", "-q", "--allow-empty", "-m", "one"}} {
+		if out, err := exec.Command("git", args...).CombinedOutput(); err != nil {
+			t.Fatalf("%s %s", out, err)
+		}
+	}
+	hf, err := ParseHapfile([]byte(`
+[default]
+env = STAGE=test
+build = app
+
+[host "web"]
+type = mock
+addr = 10.0.0.1:22
+cmd = ./restart.sh
+expect-listen = 8080
+expect-listen = 127.0.0.1:9090
+expect-process = app
+
+[build "app"]
+cmd = make
+cmd = make install
+`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	r, err := NewRemote(hf.Host("web"), nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	r.Stdout, r.Stderr = ioutil.Discard, ioutil.Discard
+	if err := r.Initialize(); err != nil {
+		t.Fatal(err)
+	}
+	if err := r.Push(); err != nil {
+		t.Fatal(err)
+	}
+	r.Clock = NewSimulatedClock(time.Now())
+	if err := r.Build(); err != nil {
+		t.Fatal(err)
+	}
+	start := r.Clock.Now()
+	if err := r.Expect(); err != nil {
+		t.Fatal(err)
+	}
+	if waited := r.Clock.Now().Sub(start); waited != 0 {
+		t.Errorf("expected the checks to pass at once, waited %s", waited)
+	}
+	r.Close()
+	b, err := ioutil.ReadFile(filepath.Join(MockDir, "web.log"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	transcript := string(b)
+	last := -1
+	for _, line := range []string{"$ git init -q", "push refs/heads/", "$ make\n", "$ make install\n", "$ ./restart.sh\n", "$ rm -rf $HOME/.hap/tmp/"} {
+		i := strings.Index(transcript, line)
+		if i <= last {
+			t.Errorf("expected %q after the previous operation in\n%s", line, transcript)
+			continue
+		}
+		last = i
+	}
+	for _, env := range []string{"env HAP_HOSTNAME=web\n", "env HAP_ADDR=10.0.0.1:22\n", "env STAGE=test\n"} {
+		if !strings.Contains(transcript, env) {
+			t.Errorf("expected %s in\n%s", env, transcript)
+		}
+	}
+}
+
+func TestMockHostSideEffects(t *testing.T) {
+	work := filepath.Join(t.TempDir(), "app")
+	os.MkdirAll(work, 0755)
+	wd, _ := os.Getwd()
+	os.Chdir(work)
+	defer os.Chdir(wd)
+	for _, args := range [][]string{{"init", "-q"}, {"commit", "-q", "--allow-empty", "-m", "one"}} {
+		if out, err := exec.Command("git", args...).CombinedOutput(); err != nil {
+			t.Fatalf("%s %s", out, err)
+		}
+	}
+	calls := filepath.Join(t.TempDir(), "calls.log")
+	script := filepath.Join(t.TempDir(), "aws")
+	ioutil.WriteFile(script, []byte("#!/bin/sh\necho \"$@\" >> "+calls+"\n"), 0755)
+	defer func(command string) { awsCommand = command }(awsCommand)
+	awsCommand = script
+	hooked := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		t.Errorf("unexpected request %s %s", req.Method, req.URL)
+	}))
+	defer hooked.Close()
+	hf, err := ParseHapfile([]byte(`
+[host "web"]
+type = mock
+addr = 10.0.0.1:22
+confirm = echo confirmed >> ` + calls + ` && exit 1
+lb = alb
+smoketest = health
+cmd = ./restart.sh
+
+[group "app"]
+host = web
+dns = api
+
+[lb "alb"]
+type = aws
+target-group = arn:aws:tg/web
+
+[smoketest "health"]
+url = ` + hooked.URL + `/health
+from = both
+
+[notify "hook"]
+url = ` + hooked.URL + `
+
+[dns "api"]
+type = route53
+zone = Z1
+name = api.example.com
+`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	r, err := NewRemote(hf.Host("web"), nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	r.Stdout, r.Stderr = ioutil.Discard, ioutil.Discard
+	r.State = newMemoryStore()
+	if err := r.Confirm(); err != nil {
+		t.Fatal(err)
+	}
+	if deregistered, err := r.Deregister(); err != nil || !deregistered {
+		t.Fatalf("expected the deregister to be recorded, got %v %s", deregistered, err)
+	}
+	if err := r.Build(); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := r.Smoketest(); err != nil {
+		t.Fatal(err)
+	}
+	if err := r.Register(); err != nil {
+		t.Fatal(err)
+	}
+	if err := r.Notify(nil); err != nil {
+		t.Fatal(err)
+	}
+	r.Close()
+	if _, err := hf.UpdateDNS(nil, map[string]error{"web": nil}); err != nil {
+		t.Fatal(err)
+	}
+	if b, err := ioutil.ReadFile(calls); err == nil {
+		t.Errorf("expected nothing to run, got\n%s", b)
+	}
+	if value, _, _ := r.State.Get("history/web"); value != nil {
+		t.Errorf("expected no shared history, got %s", value)
+	}
+	b, err := ioutil.ReadFile(filepath.Join(MockDir, "web.log"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	transcript := string(b)
+	last := -1
+	for _, line := range []string{
+		"confirm echo confirmed",
+		"lb alb deregister 10.0.0.1\n",
+		"state lock locks/web\n",
+		"$ ./restart.sh\n",
+		"state append history/web\n",
+		"smoketest health " + hooked.URL + "/health from local\n",
+		"smoketest health " + hooked.URL + "/health from host\n",
+		"lb alb register 10.0.0.1\n",
+		"notify webhook success=true\n",
+		"dns api up\n",
+	} {
+		i := strings.Index(transcript, line)
+		if i <= last {
+			t.Errorf("expected %q after the previous operation in\n%s", line, transcript)
+			continue
+		}
+		last = i
+	}
+}
+
+func TestParseHapfileInvalidType(t *testing.T) {
+	for _, kind := range []string{"", "ssh", "local", "mock"} {
+		if _, err := ParseHapfile([]byte("[host \"web\"]\ntype = " + kind + "\n")); err != nil {
+			t.Errorf("expected type %q to parse, got %s", kind, err)
+		}
+	}
+	if _, err := ParseHapfile([]byte("[host \"web\"]\ntype = mokc\n")); err == nil || err.Error() != `[web] invalid type "mokc"` {
+		t.Errorf("expected the type to be invalid, got %v", err)
+	}
+	if _, err := ParseHapfile([]byte("[default]\ntype = Local\n")); err == nil || err.Error() != `[default] invalid type "Local"` {
+		t.Errorf("expected the default type to be invalid, got %v", err)
+	}
+}
@@ -0,0 +1,303 @@
+// Hap - the simple and effective provisioner
+// Copyright (c) 2015 Garrett Woodworth (https://github.com/gwoo)
+// The BSD License http://opensource.org/licenses/bsd-license.php.
+
+package hap
+
+import (
+	"crypto/ed25519"
+	"crypto/rand"
+	"crypto/sha256"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net"
+	"os"
+	"path/filepath"
+	"sync"
+	"syscall"
+	"time"
+
+	"golang.org/x/crypto/ssh"
+)
+
+// MuxDir keeps the sockets of the shared connections
+const MuxDir = "~/.hap/mux"
+
+// DefaultMuxIdle is how long a shared connection stays up without channels
+const DefaultMuxIdle = 30 * time.Minute
+
+// How often the shared connection is checked with a keepalive
+var muxKeepalive = 30 * time.Second
+
+// muxSocket returns the socket of the shared connection to the user and addr
+func muxSocket(config SSHConfig) (string, error) {
+	dir, err := expandHome(MuxDir)
+	if err != nil {
+		return "", err
+	}
+	sum := sha256.Sum256([]byte(config.Username + "@" + config.Addr))
+	return filepath.Join(dir, fmt.Sprintf("%x.sock", sum[:8])), nil
+}
+
+// privateDir creates the dir and makes sure only the user can reach it
+// A dir that exists already is tightened to 0700, and one that is a
+// symlink or owned by another user is refused, since the sockets in it
+// accept clients without authentication.
+func privateDir(dir string) error {
+	if err := os.MkdirAll(dir, 0700); err != nil {
+		return err
+	}
+	info, err := os.Lstat(dir)
+	if err != nil {
+		return err
+	}
+	if !info.IsDir() {
+		return fmt.Errorf("%s is not a dir", dir)
+	}
+	if stat, ok := info.Sys().(*syscall.Stat_t); ok && int(stat.Uid) != os.Getuid() {
+		return fmt.Errorf("%s is owned by another user", dir)
+	}
+	if info.Mode().Perm() != 0700 {
+		return os.Chmod(dir, 0700)
+	}
+	return nil
+}
+
+// muxClient returns a client over the shared connection of hap mux, or
+// nil when there is none. The socket is only reachable by the user and
+// the host key is pinned to the one hap mux wrote next to it.
+func (r *Remote) muxClient() *ssh.Client {
+	socket, err := muxSocket(r.sshConfig)
+	if err != nil {
+		return nil
+	}
+	b, err := ioutil.ReadFile(socket + ".pub")
+	if err != nil {
+		return nil
+	}
+	key, _, _, _, err := ssh.ParseAuthorizedKey(b)
+	if err != nil {
+		return nil
+	}
+	conn, err := net.DialTimeout("unix", socket, time.Second)
+	if err != nil {
+		return nil
+	}
+	c, chans, reqs, err := ssh.NewClientConn(conn, socket, &ssh.ClientConfig{
+		User:            r.sshConfig.Username,
+		HostKeyCallback: ssh.FixedHostKey(key),
+		Timeout:         time.Second,
+	})
+	if err != nil {
+		conn.Close()
+		return nil
+	}
+	return ssh.NewClient(c, chans, reqs)
+}
+
+// Mux keeps the connection to the remote machine up and shares it over
+// a unix socket in the MuxDir, so later runs of hap open their sessions
+// over it instead of connecting and authenticating again. It reconnects
+// when the connection drops and returns once no channel was open for
+// the idle time or when stop is closed.
+func (r *Remote) Mux(idle time.Duration, stop <-chan struct{}) error {
+	if !r.Host.IsSSH() {
+		return fmt.Errorf("[%s] %s hosts have no connection to share", r.Host.Name, r.Host.Type)
+	}
+	socket, err := muxSocket(r.sshConfig)
+	if err != nil {
+		return err
+	}
+	if client := r.muxClient(); client != nil {
+		client.Close()
+		return fmt.Errorf("[%s] connection already shared at %s", r.Host.Name, socket)
+	}
+	r.dialMu.Lock()
+	r.muxing = true
+	r.dialMu.Unlock()
+	if err := privateDir(filepath.Dir(socket)); err != nil {
+		return fmt.Errorf("[%s] %s", r.Host.Name, err)
+	}
+	config, err := muxServerConfig(socket)
+	if err != nil {
+		return err
+	}
+	defer os.Remove(socket + ".pub")
+	if _, err := r.dial(); err != nil {
+		return err
+	}
+	os.Remove(socket)
+	l, err := net.Listen("unix", socket)
+	if err != nil {
+		return err
+	}
+	defer os.Remove(socket)
+	defer l.Close()
+	if err := os.Chmod(socket, 0600); err != nil {
+		return err
+	}
+	m := &mux{remote: r, config: config, last: time.Now()}
+	go m.serve(l)
+	ticker := time.NewTicker(muxKeepalive)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-stop:
+			return nil
+		case <-ticker.C:
+		}
+		r.dialMu.Lock()
+		client := r.client
+		r.dialMu.Unlock()
+		if client != nil {
+			if _, _, err := client.SendRequest("keepalive@openssh.com", true, nil); err != nil {
+				r.hangup(client)
+			}
+		}
+		if m.idle() > idle {
+			return nil
+		}
+	}
+}
+
+// muxServerConfig returns the config of the server of the shared
+// connection with a new host key, whose public key is written next to
+// the socket. Clients need no auth, as only the user reaches the socket.
+func muxServerConfig(socket string) (*ssh.ServerConfig, error) {
+	_, priv, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		return nil, err
+	}
+	signer, err := ssh.NewSignerFromKey(priv)
+	if err != nil {
+		return nil, err
+	}
+	if err := ioutil.WriteFile(socket+".pub", ssh.MarshalAuthorizedKey(signer.PublicKey()), 0600); err != nil {
+		return nil, err
+	}
+	config := &ssh.ServerConfig{NoClientAuth: true}
+	config.AddHostKey(signer)
+	return config, nil
+}
+
+// mux forwards the channels of the clients of a shared connection
+type mux struct {
+	remote *Remote
+	config *ssh.ServerConfig
+	mu     sync.Mutex
+	open   int
+	last   time.Time
+}
+
+// idle returns how long no channel has been open
+func (m *mux) idle() time.Duration {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if m.open > 0 {
+		return 0
+	}
+	return time.Since(m.last)
+}
+
+// track counts a channel as opened or closed
+func (m *mux) track(delta int) {
+	m.mu.Lock()
+	m.open += delta
+	m.last = time.Now()
+	m.mu.Unlock()
+}
+
+// serve accepts the clients of the shared connection until l is closed
+func (m *mux) serve(l net.Listener) {
+	for {
+		conn, err := l.Accept()
+		if err != nil {
+			return
+		}
+		go func() {
+			sc, chans, reqs, err := ssh.NewServerConn(conn, m.config)
+			if err != nil {
+				conn.Close()
+				return
+			}
+			defer sc.Close()
+			go ssh.DiscardRequests(reqs)
+			for nc := range chans {
+				go m.forward(nc)
+			}
+		}()
+	}
+}
+
+// forward opens the channel over the connection to the remote machine,
+// dialing again once when it was lost, and pipes the data and requests
+// of both ends until they are closed
+func (m *mux) forward(nc ssh.NewChannel) {
+	open := func() (ssh.Channel, <-chan *ssh.Request, error) {
+		client, err := m.remote.dial()
+		if err != nil {
+			return nil, nil, err
+		}
+		ch, reqs, err := client.OpenChannel(nc.ChannelType(), nc.ExtraData())
+		if _, rejected := err.(*ssh.OpenChannelError); err != nil && !rejected {
+			m.remote.hangup(client)
+		}
+		return ch, reqs, err
+	}
+	up, upReqs, err := open()
+	if _, rejected := err.(*ssh.OpenChannelError); err != nil && !rejected {
+		up, upReqs, err = open()
+	}
+	if err != nil {
+		if e, ok := err.(*ssh.OpenChannelError); ok {
+			nc.Reject(e.Reason, e.Message)
+		} else {
+			nc.Reject(ssh.ConnectionFailed, err.Error())
+		}
+		return
+	}
+	down, downReqs, err := nc.Accept()
+	if err != nil {
+		up.Close()
+		return
+	}
+	m.track(1)
+	defer m.track(-1)
+	var wg sync.WaitGroup
+	wg.Add(3)
+	go func() {
+		defer wg.Done()
+		io.Copy(down, up)
+	}()
+	go func() {
+		defer wg.Done()
+		io.Copy(down.Stderr(), up.Stderr())
+	}()
+	go func() {
+		defer wg.Done()
+		forwardRequests(upReqs, down)
+	}()
+	go func() {
+		io.Copy(up, down)
+		up.CloseWrite()
+	}()
+	go func() {
+		forwardRequests(downReqs, up)
+		up.Close()
+	}()
+	wg.Wait()
+	down.CloseWrite()
+	down.Close()
+}
+
+// forwardRequests sends the channel requests to the other end and
+// relays the replies
+func forwardRequests(reqs <-chan *ssh.Request, ch ssh.Channel) {
+	for req := range reqs {
+		ok, err := ch.SendRequest(req.Type, req.WantReply, req.Payload)
+		if req.WantReply {
+			req.Reply(ok && err == nil, nil)
+		}
+	}
+}
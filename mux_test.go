@@ -0,0 +1,182 @@
+// Hap - the simple and effective provisioner
+// Copyright (c) 2015 Garrett Woodworth (https://github.com/gwoo)
+// The BSD License http://opensource.org/licenses/bsd-license.php.
+
+package hap
+
+import (
+	"crypto/ed25519"
+	"crypto/rand"
+	"encoding/binary"
+	"io/ioutil"
+	"net"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"sync/atomic"
+	"syscall"
+	"testing"
+	"time"
+
+	"golang.org/x/crypto/ssh"
+)
+
+// testServer runs an ssh server executing commands with sh and returns
+// its addr and a counter of the connections. Commands exiting with 254
+// lose their session without an exit status.
+func testServer(t *testing.T) (string, *int32) {
+	_, priv, _ := ed25519.GenerateKey(rand.Reader)
+	signer, _ := ssh.NewSignerFromKey(priv)
+	config := &ssh.ServerConfig{NoClientAuth: true}
+	config.AddHostKey(signer)
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { l.Close() })
+	var conns int32
+	go func() {
+		for {
+			conn, err := l.Accept()
+			if err != nil {
+				return
+			}
+			atomic.AddInt32(&conns, 1)
+			go func() {
+				_, chans, reqs, err := ssh.NewServerConn(conn, config)
+				if err != nil {
+					return
+				}
+				go ssh.DiscardRequests(reqs)
+				for nc := range chans {
+					ch, reqs, err := nc.Accept()
+					if err != nil {
+						continue
+					}
+					go func() {
+						defer ch.Close()
+						for req := range reqs {
+							if req.Type != "exec" {
+								req.Reply(false, nil)
+								continue
+							}
+							req.Reply(true, nil)
+							cmd := exec.Command("sh", "-c", string(req.Payload[4:]))
+							cmd.Stdin, cmd.Stdout, cmd.Stderr = ch, ch, ch.Stderr()
+							code := 0
+							if err, ok := cmd.Run().(*exec.ExitError); ok {
+								code = err.Sys().(syscall.WaitStatus).ExitStatus()
+							}
+							if code == 254 {
+								return
+							}
+							status := make([]byte, 4)
+							binary.BigEndian.PutUint32(status, uint32(code))
+							ch.SendRequest("exit-status", false, status)
+							return
+						}
+					}()
+				}
+			}()
+		}
+	}()
+	return l.Addr().String(), &conns
+}
+
+func testMuxRemote(addr string) *Remote {
+	r := &Remote{Host: &Host{Name: "web", Addr: addr}, Stdout: ioutil.Discard, Stderr: ioutil.Discard}
+	r.sshConfig = SSHConfig{Addr: addr, Username: "deploy", ClientConfig: &ssh.ClientConfig{
+		User:            "deploy",
+		HostKeyCallback: ssh.InsecureIgnoreHostKey(),
+	}}
+	return r
+}
+
+func TestMuxSocket(t *testing.T) {
+	home := t.TempDir()
+	t.Setenv("HOME", home)
+	one, err := muxSocket(SSHConfig{Addr: "10.0.0.1:22", Username: "deploy"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if filepath.Dir(one) != filepath.Join(home, ".hap", "mux") || !strings.HasSuffix(one, ".sock") {
+		t.Errorf("unexpected socket %s", one)
+	}
+	two, _ := muxSocket(SSHConfig{Addr: "10.0.0.1:22", Username: "root"})
+	if one == two {
+		t.Errorf("expected a socket per user and addr, got %s", one)
+	}
+}
+
+func TestPrivateDir(t *testing.T) {
+	dir := filepath.Join(t.TempDir(), "mux")
+	if err := os.Mkdir(dir, 0755); err != nil {
+		t.Fatal(err)
+	}
+	if err := privateDir(dir); err != nil {
+		t.Fatal(err)
+	}
+	if info, _ := os.Stat(dir); info.Mode().Perm() != 0700 {
+		t.Errorf("expected the dir to be tightened, got %s", info.Mode())
+	}
+	link := filepath.Join(t.TempDir(), "link")
+	os.Symlink(dir, link)
+	if err := privateDir(link); err == nil {
+		t.Error("expected a symlink to be refused")
+	}
+	fresh := filepath.Join(t.TempDir(), "a", "mux")
+	if err := privateDir(fresh); err != nil {
+		t.Fatal(err)
+	}
+	if info, _ := os.Stat(fresh); info.Mode().Perm() != 0700 {
+		t.Errorf("expected a private dir, got %s", info.Mode())
+	}
+}
+
+func TestMux(t *testing.T) {
+	t.Setenv("HOME", t.TempDir())
+	addr, conns := testServer(t)
+	m := testMuxRemote(addr)
+	stop := make(chan struct{})
+	done := make(chan error)
+	go func() { done <- m.Mux(time.Minute, stop) }()
+	socket, _ := muxSocket(m.sshConfig)
+	for i := 0; i < 100; i++ {
+		if _, err := os.Stat(socket); err == nil {
+			break
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	if err := m.Mux(time.Minute, stop); err == nil || !strings.Contains(err.Error(), "already shared") {
+		t.Errorf("expected the connection to be shared once, got %v", err)
+	}
+	for i := 0; i < 2; i++ {
+		r := testMuxRemote(addr)
+		result, err := r.Capture([]string{"echo hi; echo oops >&2; exit 3"})
+		if err == nil || result.ExitCode != 3 {
+			t.Errorf("expected exit code 3, got %v", err)
+		} else if string(result.Stdout) != "hi\n" || string(result.Stderr) != "oops\n" {
+			t.Errorf("unexpected output %q %q", result.Stdout, result.Stderr)
+		}
+		if !r.muxed {
+			t.Error("expected the shared connection to be used")
+		}
+		r.Close()
+	}
+	if n := atomic.LoadInt32(conns); n != 1 {
+		t.Errorf("expected 1 connection, got %d", n)
+	}
+	close(stop)
+	if err := <-done; err != nil {
+		t.Fatal(err)
+	}
+	if _, err := os.Stat(socket); !os.IsNotExist(err) {
+		t.Errorf("expected the socket to be removed, got %v", err)
+	}
+	r := testMuxRemote(addr)
+	if _, err := r.Capture([]string{"true"}); err != nil || r.muxed {
+		t.Errorf("expected a direct connection, got %v", err)
+	}
+	r.Close()
+}
@@ -0,0 +1,247 @@
+// Hap - the simple and effective provisioner
+// Copyright (c) 2015 Garrett Woodworth (https://github.com/gwoo)
+// The BSD License http://opensource.org/licenses/bsd-license.php.
+
+package hap
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+// TailLines is the number of output lines kept for notifications
+const TailLines = 20
+
+// Notify holds the settings of a notifier
+// Type is webhook or slack, On is always (the default), success, or
+// failure. Host limits the notifier to the named hosts.
+type Notify struct {
+	Type string
+	URL  string
+	On   string
+	Host []string
+}
+
+// Notification holds the result of a host build
+type Notification struct {
+	Host      string            `json:"host"`
+	Sha       string            `json:"sha"`
+	Operator  string            `json:"operator"`
+	Time      time.Time         `json:"time"`
+	Duration  time.Duration     `json:"-"`
+	Seconds   float64           `json:"duration"`
+	Success   bool              `json:"success"`
+	Error     string            `json:"error,omitempty"`
+	Output    []string          `json:"output"`
+	Changelog []string          `json:"changelog,omitempty"`
+	Outputs   map[string]string `json:"outputs,omitempty"`
+}
+
+// Notifier is told about the result of each host build
+type Notifier interface {
+	Notify(n Notification) error
+}
+
+// NewNotifier takes the notify settings and returns the Notifier
+func NewNotifier(n *Notify) (Notifier, error) {
+	if !strings.HasPrefix(n.URL, "https://") && !strings.HasPrefix(n.URL, "http://") {
+		return nil, fmt.Errorf("invalid notify url %q", n.URL)
+	}
+	switch n.Type {
+	case "", "webhook":
+		return Webhook{URL: n.URL}, nil
+	case "slack":
+		return Slack{URL: n.URL}, nil
+	}
+	return nil, fmt.Errorf("invalid notify type %q", n.Type)
+}
+
+// Wants returns whether the notifier is told about the result
+func (n *Notify) Wants(host string, success bool) bool {
+	if len(n.Host) > 0 {
+		found := false
+		for _, name := range n.Host {
+			found = found || name == host
+		}
+		if !found {
+			return false
+		}
+	}
+	switch n.On {
+	case "success":
+		return success
+	case "failure":
+		return !success
+	}
+	return true
+}
+
+// Webhook posts notifications as json to the URL
+type Webhook struct {
+	URL string
+}
+
+// Notify posts the notification to the webhook
+func (w Webhook) Notify(n Notification) error {
+	n.Seconds = n.Duration.Seconds()
+	return post(w.URL, n)
+}
+
+// Slack posts notifications as messages to the incoming webhook URL
+type Slack struct {
+	URL string
+}
+
+// Notify posts the notification as a message to Slack
+func (s Slack) Notify(n Notification) error {
+	status := "deployed"
+	if !n.Success {
+		status = "failed to deploy"
+	}
+	lines := []string{fmt.Sprintf("[%s] %s %.7s in %s by %s",
+		n.Host, status, n.Sha, n.Duration.Round(time.Millisecond), n.Operator)}
+	if n.Error != "" {
+		lines = append(lines, n.Error)
+	}
+	for _, line := range n.Changelog {
+		lines = append(lines, "• "+line)
+	}
+	for _, line := range OutputLines(n.Outputs) {
+		lines = append(lines, "`"+line+"`")
+	}
+	if len(n.Output) > 0 {
+		lines = append(lines, "```"+strings.Join(n.Output, "\n")+"```")
+	}
+	return post(s.URL, map[string]string{"text": strings.Join(lines, "\n")})
+}
+
+// post sends v as json to the url
+func post(url string, v interface{}) error {
+	b, err := json.Marshal(v)
+	if err != nil {
+		return err
+	}
+	client := &http.Client{Timeout: 30 * time.Second}
+	resp, err := client.Post(url, "application/json", bytes.NewReader(b))
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		return fmt.Errorf("[notify] %s %s", url, resp.Status)
+	}
+	return nil
+}
+
+// Notifiers sets the notify settings that apply to the host
+func (h *Host) Notifiers(notify map[string]*Notify) {
+	h.notify = []*Notify{}
+	names := []string{}
+	for name := range notify {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		h.notify = append(h.notify, notify[name])
+	}
+}
+
+// Notify tells the notifiers of the host about the result of the build
+// It takes the error of the build, nil when it succeeded. Mock hosts
+// record the notifications to their transcript instead of sending them.
+func (r *Remote) Notify(err error) error {
+	n := Notification{
+		Host:      r.Host.Name,
+		Operator:  Operator(),
+		Time:      r.clock().Now(),
+		Success:   err == nil,
+		Output:    r.Tail,
+		Changelog: r.Changelog,
+		Outputs:   r.Outputs,
+	}
+	if err != nil {
+		n.Error = err.Error()
+	}
+	if r.last != nil {
+		n.Duration = r.last.Duration
+		n.Time = n.Time.Add(-r.last.Duration)
+	}
+	n.Sha, _ = r.Git.Head()
+	var wg sync.WaitGroup
+	var mu sync.Mutex
+	errors := []string{}
+	for _, settings := range r.Host.notify {
+		if !settings.Wants(n.Host, n.Success) {
+			continue
+		}
+		kind := settings.Type
+		if kind == "" {
+			kind = "webhook"
+		}
+		if mocked, err := r.mocked(fmt.Sprintf("notify %s success=%t", kind, n.Success)); err != nil {
+			errors = append(errors, err.Error())
+			continue
+		} else if mocked {
+			continue
+		}
+		wg.Add(1)
+		go func(settings *Notify) {
+			defer wg.Done()
+			notifier, err := NewNotifier(settings)
+			if err == nil {
+				err = notifier.Notify(n)
+			}
+			if err != nil {
+				mu.Lock()
+				errors = append(errors, err.Error())
+				mu.Unlock()
+			}
+		}(settings)
+	}
+	wg.Wait()
+	if len(errors) > 0 {
+		sort.Strings(errors)
+		return fmt.Errorf("%s", strings.Join(errors, "\n"))
+	}
+	return nil
+}
+
+// tailWriter keeps the last lines written to it
+type tailWriter struct {
+	lines   []string
+	partial string
+	mu      sync.Mutex
+}
+
+// Write implements the io.Writer interface
+func (t *tailWriter) Write(p []byte) (int, error) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	parts := strings.Split(t.partial+string(p), "\n")
+	t.partial = parts[len(parts)-1]
+	t.lines = append(t.lines, parts[:len(parts)-1]...)
+	if len(t.lines) > TailLines {
+		t.lines = t.lines[len(t.lines)-TailLines:]
+	}
+	return len(p), nil
+}
+
+// Lines returns the last lines, including a final unterminated line
+func (t *tailWriter) Lines() []string {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	lines := append([]string{}, t.lines...)
+	if t.partial != "" {
+		lines = append(lines, t.partial)
+	}
+	if len(lines) > TailLines {
+		lines = lines[len(lines)-TailLines:]
+	}
+	return lines
+}
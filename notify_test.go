@@ -0,0 +1,72 @@
+// Hap - the simple and effective provisioner
+// Copyright (c) 2015 Garrett Woodworth (https://github.com/gwoo)
+// The BSD License http://opensource.org/licenses/bsd-license.php.
+
+package hap
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestNotify(t *testing.T) {
+	var mu sync.Mutex
+	bodies := map[string]map[string]interface{}{}
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var body map[string]interface{}
+		json.NewDecoder(r.Body).Decode(&body)
+		mu.Lock()
+		bodies[r.URL.Path] = body
+		mu.Unlock()
+	}))
+	defer ts.Close()
+	host := &Host{Name: "one"}
+	host.Notifiers(map[string]*Notify{
+		"hook":    {URL: ts.URL + "/hook"},
+		"slack":   {Type: "slack", URL: ts.URL + "/slack", On: "failure"},
+		"success": {URL: ts.URL + "/success", On: "success"},
+		"two":     {URL: ts.URL + "/two", Host: []string{"two"}},
+	})
+	r := &Remote{Host: host, Tail: []string{"installing", "exit 1"}, last: &Result{Duration: 2 * time.Second}}
+	if err := r.Notify(fmt.Errorf("exit status 1")); err != nil {
+		t.Fatal(err)
+	}
+	if len(bodies) != 2 {
+		t.Fatalf("expected hook and slack, got %v", bodies)
+	}
+	hook := bodies["/hook"]
+	if hook["host"] != "one" || hook["success"] != false || hook["duration"] != 2.0 || hook["error"] != "exit status 1" {
+		t.Errorf("unexpected webhook %v", hook)
+	}
+	text, _ := bodies["/slack"]["text"].(string)
+	if !strings.HasPrefix(text, "[one] failed to deploy") || !strings.Contains(text, "installing\nexit 1") {
+		t.Errorf("unexpected slack message %q", text)
+	}
+}
+
+func TestNotifyInvalid(t *testing.T) {
+	host := &Host{Name: "one"}
+	host.Notifiers(map[string]*Notify{"bad": {Type: "pager", URL: "https://example.com"}})
+	r := &Remote{Host: host}
+	if err := r.Notify(nil); err == nil {
+		t.Error("expected error for invalid notify type")
+	}
+}
+
+func TestTailWriter(t *testing.T) {
+	tail := &tailWriter{}
+	for i := 0; i < TailLines+5; i++ {
+		fmt.Fprintf(tail, "line %d\n", i)
+	}
+	tail.Write([]byte("partial"))
+	lines := tail.Lines()
+	if len(lines) != TailLines || lines[0] != "line 6" || lines[len(lines)-1] != "partial" {
+		t.Errorf("unexpected lines %v", lines)
+	}
+}
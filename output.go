@@ -0,0 +1,72 @@
+// Hap - the simple and effective provisioner
+// Copyright (c) 2015 Garrett Woodworth (https://github.com/gwoo)
+// The BSD License http://opensource.org/licenses/bsd-license.php.
+
+package hap
+
+import (
+	"bufio"
+	"bytes"
+	"fmt"
+	"regexp"
+	"sort"
+	"strings"
+)
+
+// OutputLimit is the number of bytes of the output file read after a build
+const OutputLimit = 64 * 1024
+
+// Exports the path of the output file in the temp dir as HAP_OUTPUT.
+const exportOutput string = "export HAP_OUTPUT=\"%[1]s/output\""
+
+// Empties the output file in the temp dir and exports its path as HAP_OUTPUT.
+const resetOutput string = ": > %[1]s/output && " + exportOutput
+
+// Matches the keys of output variables
+var validOutput = regexp.MustCompile(`^[A-Za-z_][A-Za-z0-9_.-]*$`)
+
+// ParseOutputs takes the key=value lines written to HAP_OUTPUT and
+// returns the variables. Later lines win and invalid lines are skipped.
+func ParseOutputs(b []byte) map[string]string {
+	outputs := map[string]string{}
+	scanner := bufio.NewScanner(bytes.NewReader(b))
+	for scanner.Scan() {
+		parts := strings.SplitN(strings.TrimRight(scanner.Text(), "\r"), "=", 2)
+		if len(parts) != 2 || !validOutput.MatchString(parts[0]) {
+			continue
+		}
+		outputs[parts[0]] = parts[1]
+	}
+	if len(outputs) == 0 {
+		return nil
+	}
+	return outputs
+}
+
+// OutputLines returns the outputs as sorted key=value lines
+func OutputLines(outputs map[string]string) []string {
+	lines := []string{}
+	for key, value := range outputs {
+		lines = append(lines, fmt.Sprintf("%s=%s", key, value))
+	}
+	sort.Strings(lines)
+	return lines
+}
+
+// outputs reads the variables the build wrote to HAP_OUTPUT
+// It also returns whether the build started, as the output file is only
+// created once the build took the lock and was not built already.
+func (r *Remote) outputs() (map[string]string, bool, error) {
+	tmp, err := r.Temp()
+	if err != nil {
+		return nil, false, err
+	}
+	result, err := r.read([]string{
+		fmt.Sprintf("if [ -f %[2]s/output ]; then echo started && head -c %[1]d %[2]s/output; fi", OutputLimit, tmp),
+	})
+	if err != nil {
+		return nil, false, err
+	}
+	output := bytes.TrimPrefix(result.Stdout, []byte("started\n"))
+	return ParseOutputs(output), len(output) < len(result.Stdout), nil
+}
@@ -0,0 +1,49 @@
+// Hap - the simple and effective provisioner
+// Copyright (c) 2015 Garrett Woodworth (https://github.com/gwoo)
+// The BSD License http://opensource.org/licenses/bsd-license.php.
+
+package hap
+
+import (
+	"bytes"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestParseOutputs(t *testing.T) {
+	outputs := ParseOutputs([]byte("version=1.2.3\r\nmigrations=4\ninvalid line\n1bad=x\nurl=http://x/?a=b\nmigrations=5\n"))
+	lines := strings.Join(OutputLines(outputs), " ")
+	if lines != "migrations=5 url=http://x/?a=b version=1.2.3" {
+		t.Errorf("unexpected outputs %q", lines)
+	}
+	if ParseOutputs(nil) != nil {
+		t.Error("expected no outputs")
+	}
+}
+
+func TestOutputs(t *testing.T) {
+	home := t.TempDir()
+	t.Setenv("HOME", home)
+	os.MkdirAll(filepath.Join(home, "app"), 0755)
+	var out bytes.Buffer
+	r := &Remote{Host: &Host{Name: "me", Type: "local"}, Dir: "app", Stdout: &out, Stderr: &out}
+	tmp, err := r.Temp()
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, cmd := range []string{"echo version=1 >> $HAP_OUTPUT", "echo version=2 >> $HAP_OUTPUT"} {
+		if err := r.Execute([]string{"cd app", fmt.Sprintf(resetOutput, tmp), cmd}); err != nil {
+			t.Fatalf("%s %s", err, out.String())
+		}
+	}
+	outputs, started, err := r.outputs()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(outputs) != 1 || outputs["version"] != "2" || !started {
+		t.Errorf("unexpected outputs %v %v", outputs, started)
+	}
+}
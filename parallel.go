@@ -0,0 +1,147 @@
+// Hap - the simple and effective provisioner
+// Copyright (c) 2015 Garrett Woodworth (https://github.com/gwoo)
+// The BSD License http://opensource.org/licenses/bsd-license.php.
+
+package hap
+
+import (
+	"fmt"
+	"io"
+	"io/ioutil"
+	"strings"
+	"sync"
+)
+
+// Removes the .haplock and .happid files when the first session of a
+// build with steps fails, otherwise they are kept for the next steps.
+const unlockFailed string = "trap \"if [ \\$? -ne 0 ]; then rm -f .haplock .happid; fi\" EXIT && trap \"exit 130\" HUP INT TERM"
+
+// Adds the process group of a step to .happid so it can be interrupted.
+const appendPgid string = "PGID=$(ps -o pgid= -p $$ 2>/dev/null || echo $$) && echo $PGID >> .happid"
+
+// lockedWriter serializes the writes of parallel cmds
+type lockedWriter struct {
+	mu *sync.Mutex
+	w  io.Writer
+}
+
+// Write implements the io.Writer interface
+func (lw *lockedWriter) Write(p []byte) (int, error) {
+	lw.mu.Lock()
+	defer lw.mu.Unlock()
+	return lw.w.Write(p)
+}
+
+// buildSteps runs the build with a session for each Step, after the
+// first session took the lock. The cmds of parallel steps each get a
+// session of their own over the shared connection. The lock is held
+// until the last step is done, and released even when a step fails.
+// The output of every session is capped to the max-output of its step.
+// Steps of serial builds wait for the other hosts of their group.
+// The restart cmds run in a session of their own after the last step.
+func (r *Remote) buildSteps(cmds, restart []string, tmp string, stdout, stderr io.Writer) (*Result, error) {
+	start := r.clock().Now()
+	cmds = append(cmds,
+		fmt.Sprintf(lock, r.LockInfo()),
+		unlockFailed,
+		": > .happid",
+		"touch .happended",
+		happened,
+		fmt.Sprintf(resetOutput, tmp),
+	)
+	result, err := r.run(cmds, stdout, stderr)
+	if err != nil {
+		return result, err
+	}
+	// Releases the lock even when the remote was cancelled meanwhile
+	defer r.runOnce([]string{"cd " + r.Dir, "rm -f .haplock .happid"}, ioutil.Discard, ioutil.Discard)
+	for _, step := range r.Host.Steps() {
+		result, err = r.serialize(step, stdout, func() (*Result, error) {
+			if step.Parallel {
+				return r.parallel(step, tmp, stdout, stderr)
+			}
+			return r.capped(step, step.Name, stdout, stderr, func(stdout, stderr io.Writer) (*Result, error) {
+				return r.step(step.Cmds, tmp, stdout, stderr)
+			})
+		})
+		if err != nil {
+			break
+		}
+	}
+	if err == nil && len(restart) > 0 {
+		result, err = r.step(restart, tmp, stdout, stderr)
+	}
+	if err == nil {
+		result, err = r.run([]string{"cd " + r.Dir, markHappened, r.markBuild()}, stdout, stderr)
+	}
+	if result != nil {
+		result.Duration = r.clock().Now().Sub(start)
+	}
+	return result, err
+}
+
+// step runs the cmds in a session of their own over the shared connection
+func (r *Remote) step(cmds []string, tmp string, stdout, stderr io.Writer) (*Result, error) {
+	root := r
+	if r.parent != nil {
+		root = r.parent
+	}
+	sr := &Remote{
+		sshConfig: r.sshConfig,
+		Dir:       r.Dir,
+		Host:      r.Host,
+		Stdout:    stdout,
+		Stderr:    stderr,
+		Retry:     r.Retry,
+		env:       r.env,
+		facts:     r.facts,
+		parent:    root,
+	}
+	defer sr.Close()
+	run := sr.run
+	if r.Host.Supervise {
+		run = sr.supervise
+	}
+	cmds = append([]string{"cd " + r.Dir, appendPgid, fmt.Sprintf(tmpPgid, tmp), fmt.Sprintf(exportOutput, tmp)}, cmds...)
+	return run(cmds, stdout, stderr)
+}
+
+// parallel runs the cmds of the step at the same time, labeling their
+// output with the step and the number of the cmd. It waits for all of
+// them and reports every cmd that failed.
+func (r *Remote) parallel(step Step, tmp string, stdout, stderr io.Writer) (*Result, error) {
+	var mu sync.Mutex
+	stdout, stderr = &lockedWriter{mu: &mu, w: stdout}, &lockedWriter{mu: &mu, w: stderr}
+	results := make([]*Result, len(step.Cmds))
+	errs := make([]error, len(step.Cmds))
+	var wg sync.WaitGroup
+	for i, cmd := range step.Cmds {
+		label := fmt.Sprintf("%s.%d", step.Name, i+1)
+		wg.Add(1)
+		go func(i int, label, cmd string) {
+			defer wg.Done()
+			results[i], errs[i] = r.capped(step, label, NewRemoteWriter(label, stdout), NewRemoteWriter(label, stderr), func(stdout, stderr io.Writer) (*Result, error) {
+				return r.step([]string{cmd}, tmp, stdout, stderr)
+			})
+		}(i, label, cmd)
+	}
+	wg.Wait()
+	var failed *Result
+	errors := []string{}
+	for i, err := range errs {
+		if err == nil {
+			continue
+		}
+		if failed == nil {
+			failed = results[i]
+			if failed == nil {
+				failed = &Result{ExitCode: -1}
+			}
+		}
+		errors = append(errors, fmt.Sprintf("%s.%d `%s`: %s", step.Name, i+1, step.Cmds[i], err))
+	}
+	if len(errors) > 0 {
+		return failed, fmt.Errorf("%d of %d cmds of %s failed\n%s", len(errors), len(step.Cmds), step.Name, strings.Join(errors, "\n"))
+	}
+	return &Result{}, nil
+}
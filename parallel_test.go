@@ -0,0 +1,101 @@
+// Hap - the simple and effective provisioner
+// Copyright (c) 2015 Garrett Woodworth (https://github.com/gwoo)
+// The BSD License http://opensource.org/licenses/bsd-license.php.
+
+package hap
+
+import (
+	"bytes"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestBuildCmdsSteps(t *testing.T) {
+	builds := map[string]*Build{
+		"apt":  {Cmd: []string{"apt-get update"}},
+		"deps": {Cmd: []string{"./fetch a", "./fetch b"}, Parallel: true},
+		"app":  {Cmd: []string{"make"}},
+	}
+	h := &Host{Build: []string{"apt", "deps", "app"}, Cmd: []string{"./restart"}}
+	h.BuildCmds(builds)
+	expected := []Step{
+		{Name: "apt", Cmds: []string{"apt-get update"}},
+		{Name: "deps", Cmds: []string{"./fetch a", "./fetch b"}, Parallel: true},
+		{Name: "app", Cmds: []string{"make", "./restart"}},
+	}
+	if !reflect.DeepEqual(h.Steps(), expected) || !h.Parallel() {
+		t.Errorf("unexpected steps %v", h.Steps())
+	}
+	if len(h.Cmds()) != 5 {
+		t.Errorf("unexpected cmds %v", h.Cmds())
+	}
+	if len(builds["apt"].Cmd) != 1 {
+		t.Errorf("expected the builds to be unchanged, got %v", builds["apt"].Cmd)
+	}
+}
+
+func TestBuildParallel(t *testing.T) {
+	home := t.TempDir()
+	t.Setenv("HOME", home)
+	work := filepath.Join(home, "work", "app")
+	os.MkdirAll(work, 0755)
+	wd, _ := os.Getwd()
+	os.Chdir(work)
+	defer os.Chdir(wd)
+	for _, args := range [][]string{{"init", "-q"}, {"commit", "-q", "--allow-empty", "-m", "one"}} {
+		if out, err := exec.Command("git", args...).CombinedOutput(); err != nil {
+			t.Fatalf("%s %s", out, err)
+		}
+	}
+	host := &Host{Name: "me", Type: "local", Transfer: "bundle", Build: []string{"deps"}, Cmd: []string{"echo done"}}
+	host.BuildCmds(map[string]*Build{"deps": {Cmd: []string{"sleep 0.2 && echo a=1 >> $HAP_OUTPUT", "echo b=2 >> $HAP_OUTPUT && exit 3"}, Parallel: true}})
+	r, err := NewRemote(host, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer r.Close()
+	var out bytes.Buffer
+	r.Stdout, r.Stderr = &out, &out
+	if err := r.Initialize(); err != nil {
+		t.Fatal(err)
+	}
+	if err := r.PushBundle(); err != nil {
+		t.Fatal(err)
+	}
+	err = r.Build()
+	if err == nil || !strings.Contains(err.Error(), "1 of 2 cmds of deps failed") || !strings.Contains(err.Error(), "deps.2") {
+		t.Fatalf("expected deps.2 to fail, got %v", err)
+	}
+	if r.Outputs["a"] != "1" || r.Outputs["b"] != "2" {
+		t.Errorf("expected outputs of both cmds, got %v", r.Outputs)
+	}
+	if r.last == nil || r.last.ExitCode != 3 {
+		t.Errorf("expected exit code 3, got %v", r.last)
+	}
+	if strings.Contains(out.String(), "done") {
+		t.Errorf("expected the build to stop after the failed step, got %q", out.String())
+	}
+	for _, file := range []string{".haplock", ".happid"} {
+		if _, err := os.Stat(filepath.Join(home, "app", file)); !os.IsNotExist(err) {
+			t.Errorf("expected %s to be removed, got %v", file, err)
+		}
+	}
+	host.BuildCmds(map[string]*Build{"deps": {Cmd: []string{"echo one", "echo two"}, Parallel: true}})
+	out.Reset()
+	if err := r.Build(); err != nil {
+		t.Fatalf("%s %s", err, out.String())
+	}
+	if !strings.Contains(out.String(), "[deps.1] one") || !strings.Contains(out.String(), "[deps.2] two") || !strings.Contains(out.String(), "done") {
+		t.Errorf("expected labeled output, got %q", out.String())
+	}
+	if _, err := os.Stat(filepath.Join(home, "app", ".haplock")); !os.IsNotExist(err) {
+		t.Errorf("expected the lock to be removed, got %v", err)
+	}
+	if err := r.Build(); err == nil {
+		t.Error("expected the build to be already completed")
+	}
+}
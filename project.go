@@ -0,0 +1,111 @@
+// Hap - the simple and effective provisioner
+// Copyright (c) 2015 Garrett Woodworth (https://github.com/gwoo)
+// The BSD License http://opensource.org/licenses/bsd-license.php.
+
+package hap
+
+import (
+	"bufio"
+	"bytes"
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+// Fails when the deploy dir was initialized by another project.
+const checkProject string = "if [ -f .happroject ] && [ \"$(cat .happroject)\" != \"%s\" ]; then " +
+	"echo \"$PWD belongs to project $(cat .happroject), set a different dir or repo\" >&2; exit 5; fi"
+
+// Writes the project to the .happroject file.
+const stampProject string = "echo \"%s\" > .happroject"
+
+// Adds the deploy dir to the ~/.hap/remotes registry unless it is already there.
+const register string = "mkdir -p ~/.hap && touch ~/.hap/remotes && " +
+	"{ grep -qxF \"$PWD\" ~/.hap/remotes || echo \"$PWD\" >> ~/.hap/remotes; }"
+
+// Lists the registered deploy dirs, and the ones in the home dir created
+// before the registry existed, with their project, schema, last deploy, and lock.
+const listDeployments string = "{ cat ~/.hap/remotes 2>/dev/null; " +
+	"for f in ~/*/.hapschema ~/*/.happended; do if [ -f \"$f\" ]; then dirname \"$f\"; fi; done; } | sort -u | " +
+	"while IFS= read -r dir; do if [ -d \"$dir/.git\" ]; then " +
+	"printf \"%s\\t%s\\t%s\\t%s\\t%s\\n\" \"$dir\" \"$(cat \"$dir/.happroject\" 2>/dev/null)\" " +
+	"\"$(cut -d \" \" -f 1 \"$dir/.hapschema\" 2>/dev/null || echo 1)\" " +
+	"\"$(tail -n 1 \"$dir/.haphistory\" 2>/dev/null | cut -f 1,5 | tr \"\\t\" \" \")\" " +
+	"\"$(cat \"$dir/.haplock\" 2>/dev/null)\"; fi; done"
+
+// Deployment is a deploy dir managed by hap on the remote machine
+type Deployment struct {
+	Dir     string
+	Project string
+	Schema  int
+	Last    string
+	Lock    string
+}
+
+// String returns the deployment as a line for hap list-remote
+func (d Deployment) String() string {
+	project := d.Project
+	if project == "" {
+		project = "unknown"
+	}
+	line := fmt.Sprintf("%s project=%.7s schema=%d", d.Dir, project, d.Schema)
+	if d.Last != "" {
+		line += " last=" + strings.Replace(d.Last, " ", "/", -1)
+	}
+	if d.Lock != "" {
+		line += " locked by " + d.Lock
+	}
+	return line
+}
+
+// ParseDeployment takes a line of the deployment listing and returns the Deployment
+func ParseDeployment(line string) (Deployment, error) {
+	fields := strings.Split(line, "\t")
+	if len(fields) != 5 {
+		return Deployment{}, fmt.Errorf("invalid deployment %q", line)
+	}
+	schema, err := strconv.Atoi(fields[2])
+	if err != nil {
+		return Deployment{}, fmt.Errorf("invalid schema in deployment %q", line)
+	}
+	return Deployment{
+		Dir:     fields[0],
+		Project: fields[1],
+		Schema:  schema,
+		Last:    fields[3],
+		Lock:    fields[4],
+	}, nil
+}
+
+// Deployments returns everything hap manages on the remote machine
+func (r *Remote) Deployments() ([]Deployment, error) {
+	result, err := r.read([]string{listDeployments})
+	if err != nil {
+		return nil, err
+	}
+	deployments := []Deployment{}
+	scanner := bufio.NewScanner(bytes.NewReader(result.Stdout))
+	for scanner.Scan() {
+		d, err := ParseDeployment(scanner.Text())
+		if err != nil {
+			return nil, err
+		}
+		deployments = append(deployments, d)
+	}
+	return deployments, scanner.Err()
+}
+
+// project returns the commands that guard and stamp the deploy dir
+// with the project of the local repo. Repos without commits are not
+// guarded.
+func (r *Remote) project(stamp bool) []string {
+	project, err := r.Git.Project()
+	if err != nil {
+		return nil
+	}
+	cmds := []string{fmt.Sprintf(checkProject, project)}
+	if stamp {
+		cmds = append(cmds, fmt.Sprintf(stampProject, project))
+	}
+	return cmds
+}
@@ -0,0 +1,27 @@
+// Hap - the simple and effective provisioner
+// Copyright (c) 2015 Garrett Woodworth (https://github.com/gwoo)
+// The BSD License http://opensource.org/licenses/bsd-license.php.
+
+package hap
+
+import (
+	"testing"
+)
+
+func TestParseDeployment(t *testing.T) {
+	line := "/home/deploy/app\t3f4e5d6c7b8a\t2\t2015-06-01T10:00:00Z success\t"
+	d, err := ParseDeployment(line)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if d.Dir != "/home/deploy/app" || d.Project != "3f4e5d6c7b8a" || d.Schema != 2 || d.Lock != "" {
+		t.Errorf("unexpected deployment %+v", d)
+	}
+	expected := "/home/deploy/app project=3f4e5d6 schema=2 last=2015-06-01T10:00:00Z/success"
+	if d.String() != expected {
+		t.Errorf("expected %q, got %q", expected, d.String())
+	}
+	if _, err := ParseDeployment("/home/deploy/app"); err == nil {
+		t.Error("expected error for missing fields")
+	}
+}
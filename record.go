@@ -0,0 +1,113 @@
+// Hap - the simple and effective provisioner
+// Copyright (c) 2015 Garrett Woodworth (https://github.com/gwoo)
+// The BSD License http://opensource.org/licenses/bsd-license.php.
+
+package hap
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+)
+
+// DefaultAuditDir holds the session recordings unless audit-dir is set
+const DefaultAuditDir = ".hap/audit"
+
+// Recorder writes the output of a session as an asciicast v2 file
+type Recorder struct {
+	file  *os.File
+	start time.Time
+	mu    sync.Mutex
+}
+
+// NewRecorder creates the asciicast file and writes the header
+func NewRecorder(file, title, command string) (*Recorder, error) {
+	if err := os.MkdirAll(filepath.Dir(file), 0700); err != nil {
+		return nil, err
+	}
+	f, err := os.OpenFile(file, os.O_WRONLY|os.O_CREATE|os.O_EXCL, 0600)
+	if err != nil {
+		return nil, err
+	}
+	rec := &Recorder{file: f, start: time.Now()}
+	header, err := json.Marshal(map[string]interface{}{
+		"version":   2,
+		"width":     80,
+		"height":    24,
+		"timestamp": rec.start.Unix(),
+		"title":     title,
+		"command":   command,
+	})
+	if err != nil {
+		f.Close()
+		return nil, err
+	}
+	if _, err := fmt.Fprintf(f, "%s\n", header); err != nil {
+		f.Close()
+		return nil, err
+	}
+	return rec, nil
+}
+
+// Write records p as an output event
+// Line feeds become carriage return line feeds so players render the
+// output of sessions without a pty as a terminal would.
+func (rec *Recorder) Write(p []byte) (int, error) {
+	rec.mu.Lock()
+	defer rec.mu.Unlock()
+	data := bytes.Replace(p, []byte("\r\n"), []byte("\n"), -1)
+	data = bytes.Replace(data, []byte("\n"), []byte("\r\n"), -1)
+	event, err := json.Marshal([]interface{}{
+		time.Since(rec.start).Seconds(), "o", string(data),
+	})
+	if err != nil {
+		return 0, err
+	}
+	if _, err := fmt.Fprintf(rec.file, "%s\n", event); err != nil {
+		return 0, err
+	}
+	return len(p), nil
+}
+
+// Close closes the asciicast file
+func (rec *Recorder) Close() error {
+	return rec.file.Close()
+}
+
+// Record starts recording the output of the remote to the audit dir
+// when the host is protected and has record enabled. It returns the
+// func to stop recording.
+func (r *Remote) Record(command string) (func() error, error) {
+	if !r.Host.Protected || !r.Host.Record {
+		return func() error { return nil }, nil
+	}
+	dir := r.Host.AuditDir
+	if dir == "" {
+		dir = DefaultAuditDir
+	}
+	dir, err := expandHome(dir)
+	if err != nil {
+		return nil, err
+	}
+	name := fmt.Sprintf("%s-%s.cast", r.Host.Name, r.clock().Now().UTC().Format("20060102T150405.000000000Z"))
+	title := fmt.Sprintf("[%s] %s", r.Host.Name, Operator())
+	rec, err := NewRecorder(filepath.Join(dir, name), title, command)
+	if err != nil {
+		return nil, err
+	}
+	// The writers default to os.Stdout and os.Stderr when unset, the
+	// fields themselves are restored when recording stops.
+	original, originalErr := r.Stdout, r.Stderr
+	stdout, stderr := r.writers()
+	r.Stdout = io.MultiWriter(stdout, rec)
+	r.Stderr = io.MultiWriter(stderr, rec)
+	return func() error {
+		r.Stdout, r.Stderr = original, originalErr
+		return rec.Close()
+	}, nil
+}
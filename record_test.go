@@ -0,0 +1,91 @@
+// Hap - the simple and effective provisioner
+// Copyright (c) 2015 Garrett Woodworth (https://github.com/gwoo)
+// The BSD License http://opensource.org/licenses/bsd-license.php.
+
+package hap
+
+import (
+	"bytes"
+	"encoding/json"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestRecord(t *testing.T) {
+	dir, err := ioutil.TempDir("", "hap")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	var stdout bytes.Buffer
+	host := &Host{Name: "one", Protected: true, Record: true, AuditDir: dir}
+	r := &Remote{Host: host, Stdout: &stdout, Stderr: &stdout}
+	stop, err := r.Record("uptime")
+	if err != nil {
+		t.Fatal(err)
+	}
+	r.Stdout.Write([]byte("up 3 days\n"))
+	if err := stop(); err != nil {
+		t.Fatal(err)
+	}
+	if r.Stdout != &stdout {
+		t.Error("expected stdout to be restored")
+	}
+	files, _ := filepath.Glob(filepath.Join(dir, "one-*.cast"))
+	if len(files) != 1 {
+		t.Fatalf("expected 1 recording, got %d", len(files))
+	}
+	b, err := ioutil.ReadFile(files[0])
+	if err != nil {
+		t.Fatal(err)
+	}
+	lines := bytes.Split(bytes.TrimSpace(b), []byte("\n"))
+	if len(lines) != 2 {
+		t.Fatalf("expected header and 1 event, got %d lines", len(lines))
+	}
+	var header map[string]interface{}
+	if err := json.Unmarshal(lines[0], &header); err != nil {
+		t.Fatal(err)
+	}
+	if header["version"] != 2.0 || header["command"] != "uptime" {
+		t.Errorf("unexpected header %s", lines[0])
+	}
+	var event []interface{}
+	if err := json.Unmarshal(lines[1], &event); err != nil {
+		t.Fatal(err)
+	}
+	if event[1] != "o" || event[2] != "up 3 days\r\n" {
+		t.Errorf("unexpected event %s", lines[1])
+	}
+	if stdout.String() != "up 3 days\n" {
+		t.Errorf("unexpected output %q", stdout.String())
+	}
+}
+
+func TestRecordUnprotected(t *testing.T) {
+	r := &Remote{Host: &Host{Name: "one", Record: true}}
+	stop, err := r.Record("uptime")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if r.Stdout != nil {
+		t.Error("expected no recording for unprotected host")
+	}
+	stop()
+}
+
+func TestRecordRestoresUnset(t *testing.T) {
+	r := &Remote{Host: &Host{Name: "one", Protected: true, Record: true, AuditDir: t.TempDir()}}
+	stop, err := r.Record("uptime")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := stop(); err != nil {
+		t.Fatal(err)
+	}
+	if r.Stdout != nil || r.Stderr != nil {
+		t.Errorf("expected the unset writers to stay unset, got %v %v", r.Stdout, r.Stderr)
+	}
+}
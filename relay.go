@@ -0,0 +1,42 @@
+// Hap - the simple and effective provisioner
+// Copyright (c) 2015 Garrett Woodworth (https://github.com/gwoo)
+// The BSD License http://opensource.org/licenses/bsd-license.php.
+
+package hap
+
+import (
+	"fmt"
+	"regexp"
+)
+
+// Matches https git urls that are safe to use unquoted in commands
+var validRelay = regexp.MustCompile(`^https://[A-Za-z0-9@._:/~%+-]+$`)
+
+// PushRelay updates the repo on the remote machine through the https
+// git endpoint of the relay. The local repo is pushed to the relay and
+// the remote machine fetches from it, so the operator only needs
+// outbound https besides the ssh session.
+func (r *Remote) PushRelay() error {
+	if !validRelay.MatchString(r.Host.Relay) {
+		return fmt.Errorf("[%s] invalid relay %q, expects an https git url", r.Host.Name, r.Host.Relay)
+	}
+	rev, target, err := r.target()
+	if err != nil {
+		return err
+	}
+	ref := "refs/heads/" + target
+	err = r.retry(func() error {
+		cmd := r.Git.command("push", "-f", "-q", "--progress", r.Host.Relay, fmt.Sprintf("%s:%s", rev, ref))
+		output, err := cmd.CombinedOutput()
+		size, output := packWritten(output)
+		if err != nil {
+			return fmt.Errorf("%s\n%s", output, err)
+		}
+		r.pushed(size)
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+	return r.fetch(r.Host.Relay, ref, target)
+}
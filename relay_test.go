@@ -0,0 +1,23 @@
+// Hap - the simple and effective provisioner
+// Copyright (c) 2015 Garrett Woodworth (https://github.com/gwoo)
+// The BSD License http://opensource.org/licenses/bsd-license.php.
+
+package hap
+
+import (
+	"testing"
+)
+
+func TestPushInvalidTransfer(t *testing.T) {
+	for _, host := range []*Host{
+		{Name: "one", Transfer: "https"},
+		{Name: "one", Transfer: "https", Relay: "git@example.com:app.git"},
+		{Name: "one", Transfer: "https", Relay: "https://example.com/app'.git"},
+		{Name: "one", Transfer: "ftp"},
+	} {
+		r := &Remote{Host: host}
+		if err := r.Push(); err == nil {
+			t.Errorf("expected error for %+v", host)
+		}
+	}
+}
@@ -9,75 +9,366 @@ import (
 	"bytes"
 	"fmt"
 	"io"
+	"io/ioutil"
 	"os"
 	"os/exec"
+	"os/user"
 	"path/filepath"
+	"regexp"
+	"sort"
+	"strconv"
 	"strings"
+	"sync"
+	"time"
 
 	"code.google.com/p/gcfg"
 	"golang.org/x/crypto/ssh"
 )
 
 // Formatted script that checks if the build happened.
-const happened string = "if [[ $(git rev-parse HEAD) = $(cat .happended) ]]; then echo \"Already completed. Commit again?\"; exit 2; fi"
+const happened string = "if [ \"$(git rev-parse HEAD)\" = \"$(cat .happended 2>/dev/null)\" ]; then echo \"Already completed. Commit again?\"; exit 2; fi"
+
+// Writes the current sha to the .happended marker with an explicit mode.
+const markHappened string = "git rev-parse HEAD > .happended.tmp && chmod 0644 .happended.tmp && mv -f .happended.tmp .happended"
+
+// Schema is the version of the layout hap creates on the remote machine
+// Bump it whenever hooks or marker files change in incompatible ways.
+const Schema = 2
+
+// Version of hap, stamped on the remote along with the Schema
+var Version string
+
+// Writes the Schema and Version to the .hapschema file.
+const stampSchema string = "echo \"%d %s\" > .hapschema.tmp && chmod 0644 .hapschema.tmp && mv -f .hapschema.tmp .hapschema"
+
+// Fails when the .hapschema on the remote does not match the Schema.
+// Deployments without a .hapschema were created before it existed.
+const checkSchema string = "SCHEMA=$(cut -d \" \" -f 1 .hapschema 2>/dev/null || echo 1) && " +
+	"if [ \"$SCHEMA\" -gt %[1]d ]; then echo \"remote schema $SCHEMA is newer than %[1]d, upgrade hap\" >&2; exit 4; fi && " +
+	"if [ \"$SCHEMA\" -lt %[1]d ]; then echo \"remote schema $SCHEMA is older than %[1]d, run hap migrate\" >&2; exit 4; fi"
+
+// Creates the .haplock file unless it already exists.
+const lock string = "(set -C; echo \"%s\" > .haplock) 2>/dev/null || { echo \"locked by $(cat .haplock)\" >&2; exit 3; }"
+
+// Removes the .haplock and .happid files when the build exits or is interrupted.
+const unlock string = "trap \"rm -f .haplock .happid\" EXIT && trap \"exit 130\" HUP INT TERM"
+
+// Writes the process group of the build to .happid so it can be interrupted.
+const pgid string = "PGID=$(ps -o pgid= -p $$ 2>/dev/null || echo $$) && echo $PGID > .happid"
+
+// Matches characters that are not safe to write to the remote.
+var unsafeChars = regexp.MustCompile(`[^A-Za-z0-9@._:= -]`)
+
+// Fails when the deploy directory exists but is not a directory.
+const notDir string = "if [ -e $GIT_DIR ] && [ ! -d $GIT_DIR ]; then echo \"$GIT_DIR is not a directory\" >&2; exit 1; fi"
+
+// Sets $SUDO when the remote user needs sudo to change ownership.
+const sudo string = "SUDO=\"\"; if [ \"$(id -u)\" != \"0\" ]; then SUDO=\"sudo\"; fi"
+
+// Matches user or user:group owners
+var validOwner = regexp.MustCompile(`^[a-z_][a-z0-9_-]*[$]?(:[a-z_][a-z0-9_-]*[$]?)?$`)
+
+// Matches git user names and emails that are safe to double quote in commands
+var validGitUser = regexp.MustCompile("^[^\"'`$\\\\\n]+$")
+
+// Trusts the deploy dir in the global git config of the remote user,
+// so git works in it when the dir is owned by another user.
+const safeDirectory string = "(git config --global --get-all safe.directory | grep -qxF \"$(pwd -P)\" || " +
+	"git config --global --add safe.directory \"$(pwd -P)\")"
+
+// Matches deploy dirs that are safe to use unquoted in commands
+var validDir = regexp.MustCompile(`^[A-Za-z0-9_./-]+$`)
 
 // Remote defines the remote machine to provision
+// Stdout and Stderr receive the output of executed commands.
+// When nil, output goes to os.Stdout and os.Stderr prefixed with the host.
+// ForceUnlock removes an existing build lock before building.
+// AllowUnverified deploys to protected hosts regardless of ci status.
+// Changelog holds the commits deployed by the last Build.
+// Tail holds the last lines of output of the last Build.
+// Outputs holds the key=value lines the last Build wrote to $HAP_OUTPUT.
+// Pushed counts the bytes sent to the host by uploads over the ssh
+// sessions, like bundles, and by git pushes and rsync.
+// Retry is the policy for retrying transient ssh and git failures.
+// State is the shared state of the operators, nil to keep it on the remote.
+// Clock tells the time and waits for the remote, sessions of a build use
+// the Clock of their parent.
+// Turns are shared by the remotes of a rollout to run serial builds one
+// at a time, without them the remote runs them at once.
 type Remote struct {
-	Git       Git
-	Dir       string
-	Host      *Host
-	sshConfig SSHConfig
-	session   *ssh.Session
-	writer    io.Writer
+	Git             Git
+	Dir             string
+	Host            *Host
+	Stdout          io.Writer
+	Stderr          io.Writer
+	ForceUnlock     bool
+	AllowUnverified bool
+	Changelog       []string
+	Tail            []string
+	Outputs         map[string]string
+	Pushed          int64
+	Retry           Retry
+	State           Store
+	Clock           Clock
+	Turns           *Turns
+	sshConfig       SSHConfig
+	parent          *Remote
+	client          *ssh.Client
+	session         *ssh.Session
+	env             []string
+	facts           Facts
+	building        bool
+	cancelled       bool
+	stdin           []byte
+	last            *Result
+	tmp             string
+	keepTmp         bool
+	muxed           bool
+	muxing          bool
+	mu              sync.Mutex
+	dialMu          sync.Mutex
+	tmpMu           sync.Mutex
 }
 
-// NewRemote constructs a new remote machine
-func NewRemote(host *Host) (*Remote, error) {
-	sshConfig := SSHConfig{
-		Addr:     host.Addr,
-		Username: host.Username,
-		Identity: host.Identity,
-		Password: host.Password,
+// Result holds the captured output and exit code of executed commands
+// ExitCode is -1 when the commands did not exit with a status.
+type Result struct {
+	Stdout   []byte
+	Stderr   []byte
+	Duration time.Duration
+	ExitCode int
+}
+
+// NewRemote constructs a new remote machine telling the time with the
+// clock, the SystemClock when it is nil
+func NewRemote(host *Host, clock Clock) (*Remote, error) {
+	var sshConfig SSHConfig
+	if host.IsSSH() {
+		if host.SSHConfig {
+			// The ssh config is resolved into a copy, so the host keeps
+			// its alias for the next remote and explain.
+			resolved := *host
+			if err := resolved.ResolveSSHConfig(); err != nil {
+				return nil, err
+			}
+			host = &resolved
+		}
+		var err error
+		if sshConfig, err = newSSHConfig(host, clock); err != nil {
+			return nil, err
+		}
 	}
-	clientConfig, err := NewClientConfig(sshConfig)
+	env, err := host.Environment()
+	if err != nil {
+		return nil, err
+	}
+	retry, err := host.Retry()
 	if err != nil {
 		return nil, err
 	}
-	sshConfig.ClientConfig = clientConfig
 	cwd, err := os.Getwd()
 	if err != nil {
 		return nil, err
 	}
-	dir := filepath.Base(cwd)
-	repo := fmt.Sprintf("ssh://%s@%s/~/%s", host.Username, host.Addr, dir)
+	dir, err := deployDir(host, cwd)
+	if err != nil {
+		return nil, err
+	}
+	repo, err := hostRepo(host, dir, cwd)
+	if err != nil {
+		return nil, err
+	}
 	r := &Remote{
 		sshConfig: sshConfig,
 		Git:       Git{Repo: repo},
 		Dir:       dir,
 		Host:      host,
+		Retry:     retry,
+		Clock:     clock,
+		env:       env,
 	}
 	return r, nil
 }
 
+// deployDir returns the deploy dir of the host on the remote machine
+// It is the dir of the host, or the repo name (the basename of the
+// working repo by default) in the home dir. Paths starting with ~/ are
+// relative to the home dir.
+func deployDir(host *Host, cwd string) (string, error) {
+	dir := host.Dir
+	if dir == "" {
+		dir = host.Repo
+		if dir == "" {
+			dir = filepath.Base(cwd)
+		}
+		if strings.Contains(dir, "/") {
+			return "", fmt.Errorf("[%s] invalid repo %q", host.Name, dir)
+		}
+	}
+	if strings.HasPrefix(dir, "~/") {
+		dir = dir[2:]
+	}
+	dir = filepath.Clean(dir)
+	if !validDir.MatchString(dir) || dir == "." || dir == "/" || strings.HasPrefix(dir, "..") {
+		return "", fmt.Errorf("[%s] invalid dir %q", host.Name, dir)
+	}
+	return dir, nil
+}
+
+// hostRepo returns the url of the repo in the deploy dir of the host
+func hostRepo(host *Host, dir, cwd string) (string, error) {
+	if host.IsLocal() {
+		return localRepo(host, dir, cwd)
+	}
+	if filepath.IsAbs(dir) {
+		return fmt.Sprintf("ssh://%s@%s%s", host.Username, host.Addr, dir), nil
+	}
+	return fmt.Sprintf("ssh://%s@%s/~/%s", host.Username, host.Addr, dir), nil
+}
+
+// newSSHConfig returns the config for ssh connections to the host
+func newSSHConfig(host *Host, clock Clock) (SSHConfig, error) {
+	if err := host.Certify(clock); err != nil {
+		return SSHConfig{}, err
+	}
+	sshConfig := SSHConfig{
+		Addr:       host.Addr,
+		Username:   host.Username,
+		Identity:   host.Identity,
+		Password:   host.Password,
+		Identities: host.Identities(),
+	}
+	if len(host.Keys()) > 0 {
+		secret, err := host.Secret()
+		if err != nil {
+			return sshConfig, err
+		}
+		for _, file := range host.Keys() {
+			key, err := NewGroupKey(file, secret)
+			if err != nil {
+				return sshConfig, err
+			}
+			sshConfig.Keys = append(sshConfig.Keys, key)
+		}
+	}
+	clientConfig, err := NewClientConfig(sshConfig)
+	if err != nil {
+		return sshConfig, err
+	}
+	sshConfig.ClientConfig = clientConfig
+	return sshConfig, nil
+}
+
 // Connect starts an ssh session to a remote machine
+// Sessions share the ssh connection of the remote, which is dialed once.
 func (r *Remote) Connect() error {
-	if r.session != nil {
+	if !r.Host.IsSSH() {
+		return nil
+	}
+	r.mu.Lock()
+	connected := r.session != nil
+	r.mu.Unlock()
+	if connected {
 		return nil
 	}
-	client, err := ssh.Dial("tcp", r.sshConfig.Addr, r.sshConfig.ClientConfig)
+	client, err := r.dial()
 	if err != nil {
 		return err
 	}
 	session, err := client.NewSession()
 	if err != nil {
-		return err
+		// The connection was lost, so dial again
+		r.hangup(client)
+		if client, err = r.dial(); err != nil {
+			return err
+		}
+		if session, err = client.NewSession(); err != nil {
+			return err
+		}
 	}
+	r.mu.Lock()
 	r.session = session
+	r.mu.Unlock()
 	return nil
 }
 
-// Close ends an ssh session with a remote machine
-func (r *Remote) Close() error {
+// dial returns the ssh connection to the remote machine
+// Remotes of submodules use the connection of the parent, and a
+// connection shared by hap mux is used when there is one.
+// The pre-connect hook of the host runs before every new connection
+// and the post-disconnect hook once a connection is closed or failed.
+func (r *Remote) dial() (*ssh.Client, error) {
+	if r.parent != nil {
+		return r.parent.dial()
+	}
+	r.dialMu.Lock()
+	defer r.dialMu.Unlock()
+	if r.client != nil {
+		return r.client, nil
+	}
+	if r.Cancelled() {
+		return nil, fmt.Errorf("[%s] cancelled", r.Host.Name)
+	}
+	if !r.muxing {
+		if client := r.muxClient(); client != nil {
+			r.client, r.muxed = client, true
+			return client, nil
+		}
+	}
+	if err := r.connectHook("pre-connect", r.Host.PreConnect); err != nil {
+		return nil, err
+	}
+	var client *ssh.Client
+	err := r.retry(func() error {
+		var err error
+		client, err = ssh.Dial("tcp", r.sshConfig.Addr, r.sshConfig.ClientConfig)
+		return err
+	})
+	if err != nil {
+		r.disconnected()
+		return nil, err
+	}
+	r.client = client
+	return client, nil
+}
+
+// hangup closes the ssh connection if it is still the current one
+func (r *Remote) hangup(client *ssh.Client) {
+	if r.parent != nil {
+		r.parent.hangup(client)
+		return
+	}
+	r.dialMu.Lock()
+	defer r.dialMu.Unlock()
+	client.Close()
+	if r.client == client {
+		r.client = nil
+		if !r.muxed {
+			r.disconnected()
+		}
+		r.muxed = false
+	}
+}
+
+// disconnected runs the post-disconnect hook of the host
+// Failures are reported to the prefixed stderr, the connection is gone anyway.
+func (r *Remote) disconnected() {
+	if err := r.connectHook("post-disconnect", r.Host.Disconnect); err != nil {
+		_, stderr := r.writers()
+		fmt.Fprintln(stderr, err)
+	}
+}
+
+// retrying reports a retry to the prefixed stderr
+func (r *Remote) retrying(attempt int, err error) {
+	_, stderr := r.writers()
+	fmt.Fprintf(stderr, "attempt %d/%d: %s\n", attempt, r.Retry.Attempts, err)
+}
+
+// closeSession ends the ssh session but keeps the connection
+func (r *Remote) closeSession() error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
 	if r.session != nil {
 		err := r.session.Close()
 		r.session = nil
@@ -86,85 +377,355 @@ func (r *Remote) Close() error {
 	return nil
 }
 
+// Close removes the temp dir of the run, ends an ssh session with a
+// remote machine, and closes the connection unless it belongs to the parent.
+func (r *Remote) Close() error {
+	r.removeTemp()
+	err := r.closeSession()
+	if r.parent != nil {
+		return err
+	}
+	r.dialMu.Lock()
+	defer r.dialMu.Unlock()
+	if r.client != nil {
+		r.client.Close()
+		r.client = nil
+		if r.muxed {
+			r.muxed = false
+			return err
+		}
+		if derr := r.connectHook("post-disconnect", r.Host.Disconnect); derr != nil && err == nil {
+			err = derr
+		}
+	}
+	return err
+}
+
 // Initialize sets up a git repo on the remote machine
+// With bootstrap the missing prerequisites are installed first.
 func (r *Remote) Initialize() error {
 	if err := r.Connect(); err != nil {
 		return err
 	}
+	if r.Host.Bootstrap {
+		if err := r.Bootstrap(); err != nil {
+			return err
+		}
+	}
+	perms, err := r.permissions()
+	if err != nil {
+		return err
+	}
+	identity, err := r.gitIdentity()
+	if err != nil {
+		return err
+	}
 	commands := []string{
 		fmt.Sprintf("GIT_DIR=\"%s\"", r.Dir),
-		fmt.Sprint("mkdir -p $GIT_DIR"),
-		fmt.Sprint("cd $GIT_DIR"),
+		notDir,
+	}
+	if r.Host.Owner != "" {
+		commands = append(commands,
+			sudo,
+			fmt.Sprint("$SUDO mkdir -p $GIT_DIR"),
+			fmt.Sprint("$SUDO chown $(id -un) $GIT_DIR"),
+		)
+	} else {
+		commands = append(commands, fmt.Sprint("mkdir -p $GIT_DIR"))
+	}
+	commands = append(commands, fmt.Sprint("cd $GIT_DIR"))
+	commands = append(commands, r.project(true)...)
+	if r.Host.SafeDir {
+		commands = append(commands, safeDirectory)
+	}
+	commands = append(commands,
 		fmt.Sprint("git init -q"),
 		fmt.Sprint("git config receive.denyCurrentBranch ignore"),
-		fmt.Sprint("touch .git/hooks/post-receive"),
-		fmt.Sprint("chmod a+x .git/hooks/post-receive"),
-		fmt.Sprint(postReceiveHook),
+	)
+	commands = append(commands, identity...)
+	commands = append(commands,
+		r.stamp(),
+		register,
+		fmt.Sprint("mkdir -p .git/hooks"),
+		preserveHook,
+		postReceiveHook,
+	)
+	if err := r.Execute(commands); err != nil {
+		return err
+	}
+	if len(perms) < 1 {
+		return nil
+	}
+	return r.Execute(perms)
+}
+
+// stamp returns the command that writes the Schema and Version
+func (r *Remote) stamp() string {
+	version := unsafeChars.ReplaceAllString(Version, "_")
+	if version == "" {
+		version = "unknown"
+	}
+	return fmt.Sprintf(stampSchema, Schema, version)
+}
+
+// RemoteSchema returns the Schema of the deployment on the remote machine
+// It returns 0 when the remote is not initialized and 1 for deployments
+// created before the Schema was stamped.
+func (r *Remote) RemoteSchema() (int, error) {
+	result, err := r.read([]string{
+		fmt.Sprintf("GIT_DIR=\"%s\"", r.Dir),
+		"if [ ! -d $GIT_DIR/.git ]; then echo 0; exit 0; fi",
+		"cd $GIT_DIR",
+		"cut -d \" \" -f 1 .hapschema 2>/dev/null || echo 1",
+	})
+	if err != nil {
+		return 0, err
+	}
+	return strconv.Atoi(strings.TrimSpace(string(result.Stdout)))
+}
+
+// Migrate upgrades the deployment on the remote machine to the Schema
+// It returns the Schema the remote had before migrating.
+func (r *Remote) Migrate() (int, error) {
+	schema, err := r.RemoteSchema()
+	if err != nil {
+		return 0, err
 	}
-	return r.Execute(commands)
+	if schema == 0 {
+		return 0, fmt.Errorf("[%s] %s is not initialized, run hap init", r.Host.Name, r.Dir)
+	}
+	if schema > Schema {
+		return schema, fmt.Errorf("[%s] remote schema %d is newer than %d, upgrade hap", r.Host.Name, schema, Schema)
+	}
+	return schema, r.Initialize()
+}
+
+// permissions returns the commands that apply the Owner and Mode
+// of the host to the deploy directory
+func (r *Remote) permissions() ([]string, error) {
+	commands := []string{}
+	if r.Host.Owner != "" {
+		if !validOwner.MatchString(r.Host.Owner) {
+			return nil, fmt.Errorf("[%s] invalid owner %q", r.Host.Name, r.Host.Owner)
+		}
+		commands = append(commands, fmt.Sprintf("$SUDO chown -R %s $GIT_DIR", r.Host.Owner))
+	}
+	if r.Host.Mode != "" {
+		if _, err := strconv.ParseUint(r.Host.Mode, 8, 32); err != nil {
+			return nil, fmt.Errorf("[%s] invalid mode %q", r.Host.Name, r.Host.Mode)
+		}
+		commands = append(commands, fmt.Sprintf("$SUDO chmod %s $GIT_DIR", r.Host.Mode))
+	}
+	if len(commands) < 1 {
+		return nil, nil
+	}
+	setup := []string{fmt.Sprintf("GIT_DIR=\"%s\"", r.Dir)}
+	if r.Host.Owner != "" {
+		setup = append(setup, sudo)
+	}
+	return append(setup, commands...), nil
+}
+
+// gitIdentity returns the commands that set the git-name and git-email
+// of the host as the user of the repo, for commits made on the host
+func (r *Remote) gitIdentity() ([]string, error) {
+	commands := []string{}
+	for _, c := range []struct{ key, setting, value string }{
+		{"user.name", "git-name", r.Host.GitName},
+		{"user.email", "git-email", r.Host.GitEmail},
+	} {
+		if c.value == "" {
+			continue
+		}
+		if !validGitUser.MatchString(c.value) {
+			return nil, fmt.Errorf("[%s] invalid %s %q", r.Host.Name, c.setting, c.value)
+		}
+		commands = append(commands, fmt.Sprintf("git config %s \"%s\"", c.key, c.value))
+	}
+	return commands, nil
+}
+
+// Repair detects and fixes broken state on the remote machine
+// It returns a report of what was fixed.
+func (r *Remote) Repair() ([]string, error) {
+	perms, err := r.permissions()
+	if err != nil {
+		return nil, err
+	}
+	commands := []string{
+		fmt.Sprintf("GIT_DIR=\"%s\"", r.Dir),
+		notDir,
+		"if [ ! -d $GIT_DIR ]; then mkdir -p $GIT_DIR; echo \"created missing $GIT_DIR\"; fi",
+		"cd $GIT_DIR",
+		"if [ -d .git ] && ! git fsck --no-dangling >/dev/null 2>&1; then CORRUPT=.git.corrupt.$(date +%s); mv .git $CORRUPT; echo \"moved corrupt repo to $CORRUPT\"; fi",
+		"if [ ! -d .git ]; then git init -q; echo \"initialized missing repo\"; fi",
+		"if [ -n \"$(find .git -maxdepth 1 -name index.lock -mmin +10)\" ]; then rm -f .git/index.lock; echo \"removed stale .git/index.lock\"; fi",
+		"if [ \"$(git config receive.denyCurrentBranch)\" != \"ignore\" ]; then git config receive.denyCurrentBranch ignore; echo \"set receive.denyCurrentBranch to ignore\"; fi",
+		"if [ ! -x .git/hooks/post-receive ] || ! grep -q \"git checkout -q\" .git/hooks/post-receive; then echo \"reinstalled post-receive hook\"; fi",
+		fmt.Sprintf("if [ \"$(cut -d \" \" -f 1 .hapschema 2>/dev/null)\" != \"%d\" ]; then echo \"stamped schema %[1]d\"; fi", Schema),
+		r.stamp(),
+		"mkdir -p .git/hooks",
+		preserveHook,
+		postReceiveHook,
+	}
+	result, err := r.Capture(commands)
+	if err != nil {
+		if result != nil {
+			err = fmt.Errorf("%s%s", result.Stderr, err)
+		}
+		return nil, err
+	}
+	report := []string{}
+	scanner := bufio.NewScanner(bytes.NewReader(result.Stdout))
+	for scanner.Scan() {
+		report = append(report, scanner.Text())
+	}
+	if len(perms) > 0 {
+		if err := r.Execute(perms); err != nil {
+			return report, err
+		}
+	}
+	return report, nil
 }
 
 // Push updates the repo on the remote machine
+// Mock hosts record the push to their transcript instead.
 func (r *Remote) Push() error {
+	if r.Host.IsMock() {
+		return r.pushMock()
+	}
+	switch r.Host.Transfer {
+	case "bundle":
+		return r.PushBundle()
+	case "https":
+		return r.PushRelay()
+	case "", "git":
+	default:
+		return fmt.Errorf("[%s] invalid transfer %q", r.Host.Name, r.Host.Transfer)
+	}
 	if err := r.Connect(); err != nil {
 		return err
 	}
-	key, err := NewKeyFile(r.sshConfig.Identity)
-	if err != nil {
-		return err
+	if r.sshConfig.Identity != "" {
+		key, err := NewKeyFile(r.sshConfig.Identity)
+		if err != nil {
+			return err
+		}
+		cmd := exec.Command("ssh-add", key)
+		if output, err := cmd.CombinedOutput(); err != nil {
+			return fmt.Errorf("%s\n%s", output, err)
+		}
 	}
-	cmd := exec.Command("ssh-add", key)
-	_, err = cmd.CombinedOutput()
-	if err != nil {
-		return err
+	// Like ssh, identity files of the ssh config that do not exist are skipped
+	for _, identity := range r.sshConfig.Identities {
+		key, err := NewKeyFile(identity)
+		if os.IsNotExist(err) {
+			continue
+		}
+		if err != nil {
+			return err
+		}
+		if output, err := exec.Command("ssh-add", key).CombinedOutput(); err != nil {
+			return fmt.Errorf("%s\n%s", output, err)
+		}
 	}
-	cmd = exec.Command("git", "rev-parse", "--abbrev-ref", "HEAD")
-	cmd.Dir = r.Git.Work
-	b, err := cmd.CombinedOutput()
+	for _, key := range r.sshConfig.Keys {
+		if err := AddKey(key); err != nil {
+			return fmt.Errorf("[%s] deploy key: %s", r.Host.Name, err)
+		}
+	}
+	branch, err := r.Git.Branch()
 	if err != nil {
 		return err
 	}
-	branch := strings.TrimSpace(string(b))
 	if branch == "HEAD" {
 		branch = fmt.Sprintf("%s:refs/heads/happened", branch)
 	}
-	if output, err := r.Git.Push(branch); err != nil {
-		return fmt.Errorf("%s\n%s", string(output), err)
-	}
-	return nil
+	return r.retry(func() error {
+		output, err := r.Git.Push(branch)
+		size, output := packWritten(output)
+		if err != nil {
+			return fmt.Errorf("%s\n%s", string(output), err)
+		}
+		r.pushed(size)
+		return nil
+	})
 }
 
-// PushSubmodules runs Initialize() and Push() to put submodules
-// into the proper location on the remote machine
+// SubmoduleWorkers is the number of submodules pushed at once
+// It stays below the default MaxSessions of sshd, since the submodules
+// share the ssh connection of the remote.
+const SubmoduleWorkers = 4
+
+// PushSubmodules runs Initialize() and Push() to put submodules,
+// including nested ones, into the proper location on the remote machine
 func (r *Remote) PushSubmodules() error {
+	return r.pushSubmodules(make(chan struct{}, SubmoduleWorkers))
+}
+
+// pushSubmodules pushes the submodules of the repo in parallel, holding
+// a worker while pushing each. Nested submodules are pushed once their
+// parent is in place.
+func (r *Remote) pushSubmodules(workers chan struct{}) error {
 	var modules struct {
 		Submodules map[string]*struct {
 			Path string
 			URL  string
 		} `gcfg:"submodule"`
 	}
-	if err := gcfg.ReadFileInto(&modules, ".gitmodules"); err != nil {
+	err := gcfg.ReadFileInto(&modules, filepath.Join(r.Git.Work, ".gitmodules"))
+	if os.IsNotExist(err) {
+		return nil
+	}
+	if err != nil {
 		return err
 	}
+	root := r
+	if r.parent != nil {
+		root = r.parent
+	}
+	var wg sync.WaitGroup
+	var mu sync.Mutex
 	errors := []string{}
 	for _, module := range modules.Submodules {
 		sr := &Remote{
 			sshConfig: r.sshConfig,
 			Dir:       filepath.Join(r.Dir, module.Path),
 			Host:      r.Host,
+			Stdout:    r.Stdout,
+			Stderr:    r.Stderr,
+			Retry:     r.Retry,
 			Git: Git{
 				Repo: fmt.Sprint(r.Git.Repo, "/", module.Path),
-				Work: module.Path,
+				Work: filepath.Join(r.Git.Work, module.Path),
 			},
+			env:    r.env,
+			parent: root,
 		}
-		if err := sr.Initialize(); err != nil {
-			errors = append(errors, fmt.Sprintf("[%s] %s", module.Path, err))
-		}
-		if err := sr.Push(); err != nil {
-			errors = append(errors, fmt.Sprintf("[%s] %s", module.Path, err))
-		}
+		wg.Add(1)
+		go func(sr *Remote) {
+			defer wg.Done()
+			defer sr.Close()
+			workers <- struct{}{}
+			err := sr.Initialize()
+			if err == nil {
+				err = sr.Push()
+			}
+			<-workers
+			if err == nil {
+				err = sr.pushSubmodules(workers)
+			}
+			r.pushed(sr.Pushed)
+			if err != nil {
+				mu.Lock()
+				errors = append(errors, fmt.Sprintf("[%s] %s", sr.Git.Work, err))
+				mu.Unlock()
+			}
+		}(sr)
 	}
+	wg.Wait()
 	if len(errors) > 0 {
+		sort.Strings(errors)
 		return fmt.Errorf("%s", strings.Join(errors, "\n"))
 	}
 	return nil
@@ -173,33 +734,351 @@ func (r *Remote) PushSubmodules() error {
 // Build executes the builds and cmds
 // It first executes the builds specified in the Hapfile
 // and then executes any cmds speficied in the Hapfile
+// The build holds a lock on the remote so concurrent builds are refused
+// and every build is recorded in the history.
+// Cmds can write key=value lines to the file in $HAP_OUTPUT to set Outputs.
+// Builds with parallel steps run every step in its own session, and so
+// do serial builds, which run on one host of their group at a time.
+// Supervised hosts run the build detached so it survives lost connections.
+// The Facts of the remote are exported to the cmds as HAP_FACT_*.
+// A remote clock skewed from the local clock warns or fails first.
+// The restarts affected by the files changed since the last build run
+// after the cmds.
+// With a shared State, frozen hosts are refused and the lock is also
+// held in the State so operators on other machines see it. Mock hosts
+// record taking the shared lock instead.
+// Builds that started and failed return a BuildError.
 func (r *Remote) Build() error {
+	if frozen, err := r.Frozen(); err != nil {
+		return err
+	} else if frozen != "" {
+		return fmt.Errorf("[%s] frozen by %s", r.Host.Name, frozen)
+	}
+	if r.State != nil {
+		if mocked, err := r.mocked("state lock locks/" + r.Host.Name); err != nil {
+			return err
+		} else if !mocked {
+			release, err := r.lockShared()
+			if err != nil {
+				return err
+			}
+			defer release()
+		}
+	}
+	if _, err := r.Facts(); err != nil {
+		return err
+	}
+	if err := r.CheckClock(); err != nil {
+		return err
+	}
+	tmp, err := r.Temp()
+	if err != nil {
+		return err
+	}
+	if r.Host.Changelog {
+		changelog, err := r.changelog()
+		if err != nil {
+			_, stderr := r.writers()
+			fmt.Fprintf(stderr, "changelog unavailable: %s\n", err)
+		}
+		r.Changelog = changelog
+	}
+	restart, err := r.restartCmds()
+	if err != nil {
+		return err
+	}
 	cmds := []string{
 		"cd " + r.Dir,
-		"touch .happended",
-		happened,
+		fmt.Sprintf("rm -f %s/output", tmp),
+		fmt.Sprintf(checkSchema, Schema),
+	}
+	cmds = append(cmds, r.project(false)...)
+	if r.ForceUnlock {
+		cmds = append(cmds, "rm -f .haplock")
+	}
+	r.mu.Lock()
+	r.building = true
+	r.mu.Unlock()
+	defer func() {
+		r.mu.Lock()
+		r.building = false
+		r.mu.Unlock()
+	}()
+	stdout, stderr := r.writers()
+	tail := &tailWriter{}
+	var result *Result
+	if r.Host.Parallel() || r.Host.Capped() || r.Host.Serialized() {
+		result, err = r.buildSteps(cmds, restart, tmp, io.MultiWriter(stdout, tail), io.MultiWriter(stderr, tail))
+	} else {
+		cmds = append(cmds,
+			fmt.Sprintf(lock, r.LockInfo()),
+			unlock,
+			pgid,
+			fmt.Sprintf(tmpPgid, tmp),
+			"touch .happended",
+			happened,
+			fmt.Sprintf(resetOutput, tmp),
+		)
+		cmds = append(cmds, r.Host.Cmds()...)
+		cmds = append(cmds, restart...)
+		cmds = append(cmds, markHappened, r.markBuild())
+		run := r.run
+		if r.Host.Supervise {
+			run = r.supervise
+		}
+		result, err = run(cmds, io.MultiWriter(stdout, tail), io.MultiWriter(stderr, tail))
+	}
+	r.Tail, r.last = tail.Lines(), result
+	started := true
+	if result != nil {
+		outputs, ok, oerr := r.outputs()
+		if oerr != nil {
+			fmt.Fprintf(stderr, "outputs unavailable: %s\n", oerr)
+		}
+		r.Outputs, started = outputs, ok || oerr != nil
+	}
+	// Builds refused by the lock or built already deployed nothing
+	if result != nil && result.ExitCode != 0 && !started {
+		return err
+	}
+	if rerr := r.record(result); rerr != nil && err == nil {
+		return rerr
+	}
+	if err != nil {
+		return &BuildError{Host: r.Host.Name, Err: err}
+	}
+	return nil
+}
+
+// BuildError is the error of a host whose build started and failed, or
+// that failed its checks after the build, as opposed to builds that
+// were refused, completed already, or never started
+type BuildError struct {
+	Host string
+	Err  error
+}
+
+// Error returns the error of the build
+func (e *BuildError) Error() string {
+	return e.Err.Error()
+}
+
+// Interrupt terminates the commands running on the remote machine
+// It signals the running session and, during a build, kills the process
+// group of every session of the build over a new connection since not
+// every sshd delivers signals. The
+// temp dir of the run is removed over that connection as well.
+// It returns whether a build was interrupted.
+func (r *Remote) Interrupt() (bool, error) {
+	r.mu.Lock()
+	session, building := r.session, r.building
+	r.mu.Unlock()
+	if session != nil {
+		session.Signal(ssh.SIGTERM)
+	}
+	r.tmpMu.Lock()
+	tmp := r.tmp
+	if r.keepTmp {
+		tmp = ""
+	}
+	r.tmpMu.Unlock()
+	if !building && tmp == "" {
+		return false, nil
+	}
+	kr := &Remote{
+		sshConfig: r.sshConfig,
+		Dir:       r.Dir,
+		Host:      r.Host,
+		Stdout:    ioutil.Discard,
+		Stderr:    ioutil.Discard,
+		Clock:     r.clock(),
+	}
+	defer kr.Close()
+	var err error
+	if building {
+		err = kr.Execute([]string{
+			"cd " + r.Dir,
+			"test -f .happid",
+			"for P in $(cat .happid); do kill -TERM -$P 2>/dev/null || true; done",
+		})
+	}
+	if tmp != "" {
+		kr.Execute([]string{"rm -rf " + tmp})
+	}
+	return building, err
+}
+
+// Health runs the health check of the host in the repo
+func (r *Remote) Health() error {
+	if r.Host.Health == "" {
+		return nil
+	}
+	return r.Execute([]string{"cd " + r.Dir, r.Host.Health})
+}
+
+// LockInfo returns the owner, pid, and time written to the build lock
+func (r *Remote) LockInfo() string {
+	info := fmt.Sprintf("%s pid=%d time=%s",
+		Operator(), os.Getpid(), r.clock().Now().UTC().Format(time.RFC3339))
+	return unsafeChars.ReplaceAllString(info, "_")
+}
+
+// Operator returns the local user@hostname running hap
+func Operator() string {
+	username := "unknown"
+	if u, err := user.Current(); err == nil {
+		username = u.Username
 	}
-	cmds = append(cmds, r.Host.Cmds()...)
-	cmds = append(cmds, "echo `git rev-parse HEAD` > .happended")
-	return r.Execute(cmds)
+	hostname, err := os.Hostname()
+	if err != nil {
+		hostname = "unknown"
+	}
+	operator := fmt.Sprintf("%s@%s", username, hostname)
+	return unsafeChars.ReplaceAllString(operator, "_")
 }
 
 // Execute will shell out to run one or more commands
 func (r *Remote) Execute(commands []string) error {
-	if err := r.Connect(); err != nil {
+	stdout, stderr := r.writers()
+	_, err := r.run(commands, stdout, stderr)
+	return err
+}
+
+// writers returns Stdout and Stderr or the prefixed os.Stdout and os.Stderr
+func (r *Remote) writers() (io.Writer, io.Writer) {
+	stdout, stderr := r.Stdout, r.Stderr
+	if stdout == nil {
+		stdout = NewRemoteWriter(r.Host.Name, os.Stdout)
+	}
+	if stderr == nil {
+		stderr = NewRemoteWriter(r.Host.Name, os.Stderr)
+	}
+	return stdout, stderr
+}
+
+// clock returns the Clock of the remote, or of its parent for the
+// sessions of a build
+func (r *Remote) clock() Clock {
+	if r.Clock == nil && r.parent != nil {
+		return r.parent.clock()
+	}
+	return orSystemClock(r.Clock)
+}
+
+// retry calls fn with the Retry policy of the remote, waiting on its clock
+func (r *Remote) retry(fn func() error) error {
+	rt := r.Retry
+	if rt.Clock == nil {
+		rt.Clock = r.clock()
+	}
+	return rt.Do(fn, r.retrying)
+}
+
+// Capture runs one or more commands and returns the Result
+// The Result is returned along with the error when the commands fail.
+// The commands run in the ParsedLocale, as their output gets parsed.
+func (r *Remote) Capture(commands []string) (*Result, error) {
+	commands, err := r.localized(commands)
+	if err != nil {
+		return nil, err
+	}
+	var stdout, stderr bytes.Buffer
+	result, err := r.run(commands, &stdout, &stderr)
+	if result != nil {
+		result.Stdout = stdout.Bytes()
+		result.Stderr = stderr.Bytes()
+	}
+	return result, err
+}
+
+// read captures the output of commands that only read, like Capture,
+// but retries them when the session is lost. Each attempt starts with
+// empty output.
+func (r *Remote) read(commands []string) (*Result, error) {
+	commands, err := r.localized(commands)
+	if err != nil {
+		return nil, err
+	}
+	if r.Cancelled() {
+		return nil, fmt.Errorf("[%s] cancelled", r.Host.Name)
+	}
+	var result *Result
+	var connectErr error
+	err = r.retry(func() error {
+		var stdout, stderr bytes.Buffer
+		var err error
+		result, err = r.runOnce(commands, &stdout, &stderr)
+		if result == nil {
+			// Connect already retried, so give up
+			connectErr = err
+			return nil
+		}
+		result.Stdout, result.Stderr = stdout.Bytes(), stderr.Bytes()
 		return err
+	})
+	if connectErr != nil {
+		return nil, connectErr
+	}
+	if err != nil {
+		return result, fmt.Errorf("[%s] %s", r.Host.Name, err)
+	}
+	return result, nil
+}
+
+// run executes the commands writing the output to stdout and stderr
+// Only the connection is retried, not the commands, since they may have
+// run already when the session is lost. Cancelled remotes run nothing.
+func (r *Remote) run(commands []string, stdout, stderr io.Writer) (*Result, error) {
+	if r.Cancelled() {
+		return nil, fmt.Errorf("[%s] cancelled", r.Host.Name)
+	}
+	result, err := r.runOnce(commands, stdout, stderr)
+	if err != nil && result != nil {
+		return result, fmt.Errorf("[%s] %s", r.Host.Name, err)
+	}
+	return result, err
+}
+
+// runOnce executes the commands over a single session
+func (r *Remote) runOnce(commands []string, stdout, stderr io.Writer) (*Result, error) {
+	if r.Host.IsMock() {
+		return r.runMock(commands, stdout)
+	}
+	if r.Host.IsLocal() {
+		return r.runLocal(commands, stdout, stderr)
+	}
+	if err := r.Connect(); err != nil {
+		return nil, err
 	}
-	defer r.Close()
-	r.session.Stdout = NewRemoteWriter(r.Host.Name, os.Stdout)
-	r.session.Stderr = NewRemoteWriter(r.Host.Name, os.Stderr)
+	defer r.closeSession()
+	r.mu.Lock()
+	session := r.session
+	r.mu.Unlock()
+	session.Stdout = stdout
+	session.Stderr = stderr
+	if r.stdin != nil {
+		session.Stdin = bytes.NewReader(r.stdin)
+	}
+	start := r.clock().Now()
+	err := session.Run(r.command(commands))
+	result := &Result{Duration: r.clock().Now().Sub(start)}
+	if err != nil {
+		result.ExitCode = -1
+		if exit, ok := err.(*ssh.ExitError); ok {
+			result.ExitCode = exit.ExitStatus()
+		}
+		return result, err
+	}
+	return result, nil
+}
+
+// command returns the commands with the Env as a single shell command
+func (r *Remote) command(commands []string) string {
 	cmd := fmt.Sprintf("%s%s", r.Env(), commands[0])
 	if len(commands) > 1 {
 		cmd = fmt.Sprintf("sh -c '%s%s'", r.Env(), strings.Join(commands, "&&"))
 	}
-	if err := r.session.Run(cmd); err != nil {
-		return fmt.Errorf("[%s] %s", r.Host.Name, err)
-	}
-	return nil
+	return cmd
 }
 
 // Env returns the preset environment variables to pass to execute
@@ -208,6 +1087,8 @@ func (r *Remote) Env() string {
 		"export HAP_HOSTNAME=\"", r.Host.Name, "\";",
 		"export HAP_ADDR=\"", r.Host.Addr, "\";",
 		"export HAP_USER=\"", r.Host.Username, "\";",
+		r.facts.Env(),
+		exportEnv(r.env),
 	)
 }
 
@@ -5,7 +5,15 @@
 package hap
 
 import (
+	"fmt"
+	"io/ioutil"
+	"os/exec"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"syscall"
 	"testing"
+	"time"
 )
 
 func TestRemoteConnect(t *testing.T) {
@@ -15,3 +23,123 @@ func TestRemoteConnect(t *testing.T) {
 func TestRemoteInitialize(t *testing.T) {
 
 }
+
+func TestDeployDir(t *testing.T) {
+	tests := []struct {
+		host     Host
+		expected string
+	}{
+		{Host{}, "app"},
+		{Host{Repo: "api"}, "api"},
+		{Host{Dir: "/srv/app-blue"}, "/srv/app-blue"},
+		{Host{Dir: "~/releases/app", Repo: "api"}, "releases/app"},
+		{Host{Dir: "deploy/"}, "deploy"},
+	}
+	for _, test := range tests {
+		dir, err := deployDir(&test.host, "/home/me/app")
+		if err != nil {
+			t.Error(err)
+		}
+		if dir != test.expected {
+			t.Errorf("expected %s, got %s", test.expected, dir)
+		}
+	}
+	for _, invalid := range []Host{{Dir: "/"}, {Dir: "~"}, {Dir: "../app"}, {Dir: "/srv/my app"}, {Repo: "a/b"}, {Dir: "$(id)"}} {
+		if dir, err := deployDir(&invalid, "/home/me/app"); err == nil {
+			t.Errorf("expected error for %+v, got %s", invalid, dir)
+		}
+	}
+}
+
+func TestNewRemoteRepo(t *testing.T) {
+	r, err := NewRemote(&Host{Name: "blue", Type: "local", Dir: "/srv/app-blue"}, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if r.Dir != "/srv/app-blue" || r.Git.Repo != "/srv/app-blue" {
+		t.Errorf("unexpected dir %s and repo %s", r.Dir, r.Git.Repo)
+	}
+}
+
+func TestPushSubmodulesWithoutGitmodules(t *testing.T) {
+	r := &Remote{Host: &Host{Name: "one"}, Git: Git{Work: "/tmp/hap-without-submodules"}}
+	if err := r.PushSubmodules(); err != nil {
+		t.Error(err)
+	}
+}
+
+func TestInitializeGitIdentity(t *testing.T) {
+	home := t.TempDir()
+	t.Setenv("HOME", home)
+	dir := filepath.Join(home, "app")
+	host := &Host{Name: "me", Type: "local", Dir: dir, GitName: "Deploy Bot", GitEmail: "deploy@example.com", SafeDir: true}
+	r, err := NewRemote(host, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer r.Close()
+	r.Stdout, r.Stderr = &strings.Builder{}, &strings.Builder{}
+	for i := 0; i < 2; i++ {
+		if err := r.Initialize(); err != nil {
+			t.Fatal(err)
+		}
+	}
+	for key, expected := range map[string]string{"user.name": "Deploy Bot", "user.email": "deploy@example.com"} {
+		out, err := exec.Command("git", "-C", dir, "config", key).Output()
+		if err != nil || strings.TrimSpace(string(out)) != expected {
+			t.Errorf("expected %s %q, got %q %v", key, expected, out, err)
+		}
+	}
+	out, err := exec.Command("git", "config", "--global", "--get-all", "safe.directory").Output()
+	if err != nil || string(out) != dir+"\n" {
+		t.Errorf("expected safe.directory %s once, got %q %v", dir, out, err)
+	}
+	host.GitName = "$(id)"
+	if err := r.Initialize(); err == nil {
+		t.Error("expected error for invalid git-name")
+	}
+}
+
+func TestRemoteInterrupt(t *testing.T) {
+	dir := t.TempDir()
+	pgids := []string{}
+	for i := 0; i < 2; i++ {
+		cmd := exec.Command("sh", "-c", fmt.Sprintf("sleep 60 & echo $! > child.%d; wait", i))
+		cmd.Dir = dir
+		cmd.SysProcAttr = &syscall.SysProcAttr{Setpgid: true}
+		if err := cmd.Start(); err != nil {
+			t.Fatal(err)
+		}
+		defer syscall.Kill(-cmd.Process.Pid, syscall.SIGKILL)
+		go cmd.Wait()
+		pgids = append(pgids, fmt.Sprint(cmd.Process.Pid))
+	}
+	ioutil.WriteFile(filepath.Join(dir, ".happid"), []byte(strings.Join(pgids, "\n")+"\n"), 0644)
+	children := []int{}
+	for i := range pgids {
+		file := filepath.Join(dir, fmt.Sprintf("child.%d", i))
+		for j := 0; j < 100; j++ {
+			if b, _ := ioutil.ReadFile(file); strings.HasSuffix(string(b), "\n") {
+				pid, _ := strconv.Atoi(strings.TrimSpace(string(b)))
+				children = append(children, pid)
+				break
+			}
+			time.Sleep(10 * time.Millisecond)
+		}
+	}
+	r := &Remote{Host: &Host{Name: "web", Type: "local"}, Dir: dir, building: true}
+	if building, err := r.Interrupt(); !building || err != nil {
+		t.Fatalf("expected the build to be interrupted, got %v %v", building, err)
+	}
+	for i, pid := range children {
+		alive := true
+		for j := 0; j < 100 && alive; j++ {
+			stat, err := ioutil.ReadFile(fmt.Sprintf("/proc/%d/stat", pid))
+			alive = err == nil && !strings.Contains(string(stat), ") Z ")
+			time.Sleep(10 * time.Millisecond)
+		}
+		if alive {
+			t.Errorf("expected the cmds of session %d to be terminated", i+1)
+		}
+	}
+}
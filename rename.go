@@ -0,0 +1,194 @@
+// Hap - the simple and effective provisioner
+// Copyright (c) 2015 Garrett Woodworth (https://github.com/gwoo)
+// The BSD License http://opensource.org/licenses/bsd-license.php.
+
+package hap
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strings"
+)
+
+// Moves a deploy dir and swaps it in the ~/.hap/remotes registry.
+// Refuses to move locked deployments or to overwrite existing dirs.
+const moveDeployDir string = "if [ ! -d \"%[1]s/.git\" ]; then echo \"%[1]s is not a deployment\" >&2; exit 1; fi && " +
+	"if [ -e \"%[2]s\" ]; then echo \"%[2]s already exists\" >&2; exit 1; fi && " +
+	"if [ -f \"%[1]s/.haplock\" ]; then echo \"%[1]s is locked by $(cat \"%[1]s/.haplock\")\" >&2; exit 3; fi && " +
+	"FROM=$(cd \"%[1]s\" && pwd) && mkdir -p \"$(dirname \"%[2]s\")\" && mv \"%[1]s\" \"%[2]s\" && " +
+	"if [ -f ~/.hap/remotes ]; then { grep -vxF \"$FROM\" ~/.hap/remotes || true; } > ~/.hap/remotes.tmp && mv -f ~/.hap/remotes.tmp ~/.hap/remotes; fi"
+
+// RenameHost moves the local state of the old host name to the host
+// It renames the entry in the InventoryFile and the session recordings
+// in the audit dir, and returns a line for each change.
+func RenameHost(old string, host *Host) ([]string, error) {
+	if old == host.Name {
+		return nil, fmt.Errorf("[%s] host is already named %s", old, host.Name)
+	}
+	changes := []string{}
+	inventory := map[string]Inventoried{}
+	if b, err := ioutil.ReadFile(InventoryFile); err == nil {
+		if err := json.Unmarshal(b, &inventory); err != nil {
+			return nil, fmt.Errorf("invalid %s: %s", InventoryFile, err)
+		}
+	}
+	if entry, ok := inventory[old]; ok {
+		delete(inventory, old)
+		entry.Addr = host.Addr
+		inventory[host.Name] = entry
+		b, err := json.MarshalIndent(inventory, "", "  ")
+		if err != nil {
+			return nil, err
+		}
+		if err := ioutil.WriteFile(InventoryFile, append(b, '\n'), 0644); err != nil {
+			return nil, err
+		}
+		changes = append(changes, fmt.Sprintf("renamed %s in %s", old, InventoryFile))
+	}
+	dir := host.AuditDir
+	if dir == "" {
+		dir = DefaultAuditDir
+	}
+	dir, err := expandHome(dir)
+	if err != nil {
+		return nil, err
+	}
+	recording := regexp.MustCompile(`^` + regexp.QuoteMeta(old) + `-([0-9]{8}T[0-9]{6}\.[0-9]{9}Z\.cast)$`)
+	files, err := ioutil.ReadDir(dir)
+	if err != nil && !os.IsNotExist(err) {
+		return nil, err
+	}
+	renamed := 0
+	for _, file := range files {
+		match := recording.FindStringSubmatch(file.Name())
+		if match == nil {
+			continue
+		}
+		to := filepath.Join(dir, host.Name+"-"+match[1])
+		if err := os.Rename(filepath.Join(dir, file.Name()), to); err != nil {
+			return changes, err
+		}
+		renamed++
+	}
+	if renamed > 0 {
+		changes = append(changes, fmt.Sprintf("renamed %d recordings in %s", renamed, dir))
+	}
+	return changes, nil
+}
+
+// RenameShared moves the shared history, freeze, and leases of the old
+// host name to the host in the shared state. The history of the old
+// name goes before any of the new name. It refuses to move a host that
+// is locked, and returns a line for each change.
+func RenameShared(store Store, old string, host *Host) ([]string, error) {
+	if lock, _, err := store.Get("locks/" + old); err != nil {
+		return nil, err
+	} else if lock != nil {
+		return nil, fmt.Errorf("[%s] locked by %s", old, lock)
+	}
+	changes := []string{}
+	for _, kind := range []string{"history", "freezes"} {
+		value, _, err := store.Get(kind + "/" + old)
+		if err != nil {
+			return changes, err
+		}
+		if value == nil {
+			continue
+		}
+		err = update(store, kind+"/"+host.Name, func(current []byte) ([]byte, error) {
+			if kind == "freezes" {
+				if current != nil {
+					return current, nil
+				}
+				return value, nil
+			}
+			lines := strings.Split(strings.TrimSpace(strings.TrimSpace(string(value))+"\n"+string(current)), "\n")
+			if len(lines) > HistoryLimit {
+				lines = lines[len(lines)-HistoryLimit:]
+			}
+			return []byte(strings.Join(lines, "\n") + "\n"), nil
+		})
+		if err == nil {
+			err = update(store, kind+"/"+old, func([]byte) ([]byte, error) {
+				return nil, nil
+			})
+		}
+		if err != nil {
+			return changes, err
+		}
+		changes = append(changes, fmt.Sprintf("moved the shared %s of %s", kind, old))
+	}
+	renamed := 0
+	err := update(store, leasesKey, func(value []byte) ([]byte, error) {
+		if value == nil {
+			return nil, nil
+		}
+		leases := []Lease{}
+		if err := json.Unmarshal(value, &leases); err != nil {
+			return nil, fmt.Errorf("invalid leases: %s", err)
+		}
+		renamed = 0
+		for i := range leases {
+			for j, name := range leases[i].Hosts {
+				if name == old {
+					leases[i].Hosts[j] = host.Name
+					renamed++
+				}
+			}
+		}
+		if renamed == 0 {
+			return value, nil
+		}
+		return json.MarshalIndent(leases, "", "  ")
+	})
+	if err != nil {
+		return changes, err
+	}
+	if renamed > 0 {
+		changes = append(changes, fmt.Sprintf("moved %d shared leases of %s", renamed, old))
+	}
+	return changes, nil
+}
+
+// MoveDir moves the deployment in the dir on the remote machine to the
+// deploy dir of the host, updating the registry and hooks
+func (r *Remote) MoveDir(from string) error {
+	if strings.HasPrefix(from, "~/") {
+		from = from[2:]
+	}
+	from = filepath.Clean(from)
+	if !validDir.MatchString(from) || from == "." || from == "/" || strings.HasPrefix(from, "..") {
+		return fmt.Errorf("[%s] invalid dir %q", r.Host.Name, from)
+	}
+	if from == r.Dir {
+		return fmt.Errorf("[%s] deployment is already in %s", r.Host.Name, r.Dir)
+	}
+	return r.Execute([]string{
+		fmt.Sprintf(moveDeployDir, from, r.Dir),
+		"cd " + r.Dir,
+		register,
+		"mkdir -p .git/hooks",
+		preserveHook,
+		postReceiveHook,
+	})
+}
+
+// VerifyDir checks that the deploy dir of the host holds a deployment of
+// the local repo and returns its schema
+func (r *Remote) VerifyDir() (int, error) {
+	schema, err := r.RemoteSchema()
+	if err != nil {
+		return 0, err
+	}
+	if schema == 0 {
+		return 0, fmt.Errorf("[%s] no deployment in %s", r.Host.Name, r.Dir)
+	}
+	if err := r.Execute(append([]string{"cd " + r.Dir}, r.project(false)...)); err != nil {
+		return schema, err
+	}
+	return schema, nil
+}
@@ -0,0 +1,110 @@
+// Hap - the simple and effective provisioner
+// Copyright (c) 2015 Garrett Woodworth (https://github.com/gwoo)
+// The BSD License http://opensource.org/licenses/bsd-license.php.
+
+package hap
+
+import (
+	"bytes"
+	"encoding/json"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestRenameHost(t *testing.T) {
+	dir := t.TempDir()
+	wd, _ := os.Getwd()
+	os.Chdir(dir)
+	defer os.Chdir(wd)
+	os.MkdirAll(DefaultAuditDir, 0700)
+	for _, name := range []string{"web-20240102T030405.000000000Z.cast", "web-2-20240102T030405.000000000Z.cast"} {
+		ioutil.WriteFile(filepath.Join(DefaultAuditDir, name), nil, 0600)
+	}
+	ioutil.WriteFile(InventoryFile, []byte(`{"web": {"Addr": "10.0.0.1:22"}, "db": {"Addr": "10.0.0.2:22"}}`), 0644)
+	changes, err := RenameHost("web", &Host{Name: "web1", Addr: "10.0.1.1:22"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(changes) != 2 {
+		t.Errorf("unexpected changes %v", changes)
+	}
+	files, _ := filepath.Glob(filepath.Join(DefaultAuditDir, "*.cast"))
+	if strings.Join(files, " ") != ".hap/audit/web-2-20240102T030405.000000000Z.cast .hap/audit/web1-20240102T030405.000000000Z.cast" {
+		t.Errorf("unexpected recordings %v", files)
+	}
+	inventory := map[string]Inventoried{}
+	b, _ := ioutil.ReadFile(InventoryFile)
+	json.Unmarshal(b, &inventory)
+	if _, ok := inventory["web"]; ok || inventory["web1"].Addr != "10.0.1.1:22" || inventory["db"].Addr != "10.0.0.2:22" {
+		t.Errorf("unexpected inventory %v", inventory)
+	}
+}
+
+func TestMoveDir(t *testing.T) {
+	home := t.TempDir()
+	t.Setenv("HOME", home)
+	os.MkdirAll(filepath.Join(home, "app", ".git"), 0755)
+	os.MkdirAll(filepath.Join(home, ".hap"), 0755)
+	ioutil.WriteFile(filepath.Join(home, ".hap", "remotes"), []byte(filepath.Join(home, "app")+"\n/srv/other\n"), 0644)
+	var out bytes.Buffer
+	r := &Remote{Host: &Host{Name: "web1", Type: "local"}, Dir: "releases/app", Stdout: &out, Stderr: &out}
+	if err := r.MoveDir("~/app"); err != nil {
+		t.Fatalf("%s %s", err, out.String())
+	}
+	if _, err := os.Stat(filepath.Join(home, "releases", "app", ".git", "hooks", "post-receive")); err != nil {
+		t.Error(err)
+	}
+	b, _ := ioutil.ReadFile(filepath.Join(home, ".hap", "remotes"))
+	if string(b) != "/srv/other\n"+filepath.Join(home, "releases", "app")+"\n" {
+		t.Errorf("unexpected registry %q", b)
+	}
+	if schema, err := r.VerifyDir(); err != nil || schema != 1 {
+		t.Errorf("expected schema 1, got %d %v", schema, err)
+	}
+	if err := r.MoveDir("app"); err == nil {
+		t.Error("expected error moving a missing deployment")
+	}
+	if err := r.MoveDir("../app"); err == nil {
+		t.Error("expected error for invalid dir")
+	}
+}
+
+func TestRenameShared(t *testing.T) {
+	store := newMemoryStore()
+	store.values["history/web"] = []byte("one\ntwo\n")
+	store.values["history/web1"] = []byte("three\n")
+	store.values["freezes/web"] = []byte("deploy@box reason=incident")
+	leases, _ := json.Marshal([]Lease{{ID: "a", Hosts: []string{"db", "web"}}})
+	store.values[leasesKey] = leases
+	changes, err := RenameShared(store, "web", &Host{Name: "web1"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(changes) != 3 {
+		t.Errorf("unexpected changes %v", changes)
+	}
+	if value, _, _ := store.Get("history/web1"); string(value) != "one\ntwo\nthree\n" {
+		t.Errorf("expected the old history first, got %q", value)
+	}
+	if value, _, _ := store.Get("freezes/web1"); string(value) != "deploy@box reason=incident" {
+		t.Errorf("expected the freeze to move, got %q", value)
+	}
+	for _, key := range []string{"history/web", "freezes/web"} {
+		if value, _, _ := store.Get(key); value != nil {
+			t.Errorf("expected %s to be removed, got %q", key, value)
+		}
+	}
+	value, _, _ := store.Get(leasesKey)
+	moved := []Lease{}
+	json.Unmarshal(value, &moved)
+	if len(moved) != 1 || strings.Join(moved[0].Hosts, " ") != "db web1" {
+		t.Errorf("expected the lease to move, got %v", moved)
+	}
+	store.values["locks/web1"] = []byte("deploy@box")
+	if _, err := RenameShared(store, "web1", &Host{Name: "web2"}); err == nil || !strings.Contains(err.Error(), "locked by deploy@box") {
+		t.Errorf("expected a locked host to be refused, got %v", err)
+	}
+}
@@ -0,0 +1,115 @@
+// Hap - the simple and effective provisioner
+// Copyright (c) 2015 Garrett Woodworth (https://github.com/gwoo)
+// The BSD License http://opensource.org/licenses/bsd-license.php.
+
+package hap
+
+import (
+	"fmt"
+	"path"
+	"strings"
+)
+
+// Restart holds the cmds that restart a service when the paths change
+// The paths are globs of the files in the repo, where ** matches any
+// number of directories, like nginx/** or app/**/*.go.
+type Restart struct {
+	Path []string
+	Cmd  []string
+	name string
+}
+
+// Restarts sets the restarts of the host
+func (h *Host) Restarts(restarts map[string]*Restart) {
+	h.restarts = []*Restart{}
+	for _, name := range h.Restart {
+		restart, ok := restarts[name]
+		if !ok {
+			restart = &Restart{}
+		}
+		restart.name = name
+		h.restarts = append(h.restarts, restart)
+	}
+}
+
+// Affected returns whether any of the changed files matches the paths
+func (rs *Restart) Affected(changed []string) bool {
+	for _, file := range changed {
+		for _, pattern := range rs.Path {
+			if matchPath(pattern, file) {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// matchPath returns whether the file matches the glob pattern, where **
+// matches zero or more directories
+func matchPath(pattern, file string) bool {
+	return matchSegments(strings.Split(strings.Trim(pattern, "/"), "/"), strings.Split(file, "/"))
+}
+
+// matchSegments matches the segments of a path with those of a pattern
+func matchSegments(pattern, file []string) bool {
+	for len(pattern) > 0 {
+		if pattern[0] == "**" {
+			for i := len(file); i >= 0; i-- {
+				if matchSegments(pattern[1:], file[i:]) {
+					return true
+				}
+			}
+			return false
+		}
+		if len(file) < 1 {
+			return false
+		}
+		if ok, err := path.Match(pattern[0], file[0]); err != nil || !ok {
+			return false
+		}
+		pattern, file = pattern[1:], file[1:]
+	}
+	return len(file) < 1
+}
+
+// restartCmds returns the cmds of the restarts affected by the commits
+// between the last build and the commit checked out on the remote. Every
+// restart is affected on the first build and when either sha is unknown
+// locally, as the changes are unknown.
+func (r *Remote) restartCmds() ([]string, error) {
+	if len(r.Host.restarts) < 1 {
+		return nil, nil
+	}
+	for _, restart := range r.Host.restarts {
+		if len(restart.Cmd) < 1 {
+			return nil, fmt.Errorf("[%s] restart %s not found or without cmd", r.Host.Name, restart.name)
+		}
+	}
+	result, err := r.read([]string{
+		"cd " + r.Dir,
+		"cat .happended 2>/dev/null || true",
+		"echo",
+		"git rev-parse HEAD 2>/dev/null || true",
+	})
+	if err != nil {
+		return nil, err
+	}
+	shas := strings.SplitN(string(result.Stdout), "\n", 2)
+	deployed, head := strings.TrimSpace(shas[0]), ""
+	if len(shas) > 1 {
+		head = strings.TrimSpace(shas[1])
+	}
+	var changed []string
+	if deployed == "" || head == "" {
+		deployed = ""
+	} else if changed, err = r.Git.Changed(deployed, head); err != nil {
+		deployed = ""
+	}
+	cmds := []string{}
+	for _, restart := range r.Host.restarts {
+		if deployed == "" || restart.Affected(changed) {
+			cmds = append(cmds, restart.Cmd...)
+		}
+	}
+	return cmds, nil
+}
@@ -0,0 +1,127 @@
+// Hap - the simple and effective provisioner
+// Copyright (c) 2015 Garrett Woodworth (https://github.com/gwoo)
+// The BSD License http://opensource.org/licenses/bsd-license.php.
+
+package hap
+
+import (
+	"bytes"
+	"io/ioutil"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestMatchPath(t *testing.T) {
+	tests := []struct {
+		pattern, file string
+		expected      bool
+	}{
+		{"nginx/**", "nginx/sites/app.conf", true},
+		{"nginx/**", "nginx", true},
+		{"nginx/**", "nginxs/app.conf", false},
+		{"app/**/*.go", "app/main.go", true},
+		{"app/**/*.go", "app/cmd/server/main.go", true},
+		{"app/**/*.go", "app/cmd/README.md", false},
+		{"**/*.service", "systemd/app.service", true},
+		{"Gemfile*", "Gemfile.lock", true},
+		{"Gemfile*", "vendor/Gemfile", false},
+		{"/config.yml", "config.yml", true},
+	}
+	for _, test := range tests {
+		if matched := matchPath(test.pattern, test.file); matched != test.expected {
+			t.Errorf("expected %s matching %s to be %t", test.pattern, test.file, test.expected)
+		}
+	}
+}
+
+func TestBuildRestarts(t *testing.T) {
+	home := t.TempDir()
+	t.Setenv("HOME", home)
+	work := filepath.Join(home, "work", "app")
+	os.MkdirAll(filepath.Join(work, "nginx"), 0755)
+	wd, _ := os.Getwd()
+	os.Chdir(work)
+	defer os.Chdir(wd)
+	commit := func(file string) {
+		ioutil.WriteFile(file, []byte(file), 0644)
+		for _, args := range [][]string{{"add", "."}, {"-c", "user.name=me", "-c", "user.email=me@localhost", "commit", "-q", "-m", file}} {
+			if out, err := exec.Command("git", args...).CombinedOutput(); err != nil {
+				t.Fatalf("%s %s", out, err)
+			}
+		}
+	}
+	exec.Command("git", "init", "-q").Run()
+	commit("main.go")
+	h := Hapfile{
+		Hosts: map[string]*Host{"me": {Type: "local", Transfer: "bundle", Cmd: []string{"echo build"}, Restart: []string{"nginx", "app"}}},
+		Restarts: map[string]*Restart{
+			"nginx": {Path: []string{"nginx/**"}, Cmd: []string{"echo reload nginx"}},
+			"app":   {Path: []string{"**/*.go"}, Cmd: []string{"echo restart app"}},
+		},
+	}
+	r, err := NewRemote(h.Host("me"), nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer r.Close()
+	var out bytes.Buffer
+	r.Stdout, r.Stderr = &out, &out
+	if err := r.Initialize(); err != nil {
+		t.Fatal(err)
+	}
+	for i, file := range []string{"", "nginx/app.conf", "README"} {
+		if file != "" {
+			commit(file)
+		}
+		out.Reset()
+		if err := r.PushBundle(); err != nil {
+			t.Fatal(err)
+		}
+		if err := r.Build(); err != nil {
+			t.Fatal(err)
+		}
+		expected := []string{"build\nreload nginx\nrestart app\n", "build\nreload nginx\n", "build\n"}[i]
+		if !strings.HasSuffix(out.String(), expected) || strings.Contains(out.String(), "restart app") != (i == 0) {
+			t.Errorf("expected %q after changing %s, got %q", expected, file, out.String())
+		}
+	}
+	// Only the pushed commits count, not the ones made locally since
+	commit("nginx/site one.conf")
+	if err := r.PushBundle(); err != nil {
+		t.Fatal(err)
+	}
+	commit("later.go")
+	out.Reset()
+	if err := r.Build(); err != nil {
+		t.Fatal(err)
+	}
+	if !strings.HasSuffix(out.String(), "build\nreload nginx\n") || strings.Contains(out.String(), "restart app") {
+		t.Errorf("expected only nginx to reload, got %q", out.String())
+	}
+	changed, err := r.Git.Changed("HEAD~2", "HEAD~1")
+	if err != nil || !reflect.DeepEqual(changed, []string{"nginx/site one.conf"}) {
+		t.Errorf("expected the file with a space, got %q %v", changed, err)
+	}
+}
+
+func TestParseHapfileInvalidRestarts(t *testing.T) {
+	if _, err := ParseHapfile([]byte("[host \"web\"]\nrestart = nginx\n[restart \"nginx\"]\ncmd = nginx -s reload\n")); err != nil {
+		t.Errorf("expected the restart to parse, got %s", err)
+	}
+	tests := []struct {
+		hapfile, expected string
+	}{
+		{"[host \"web\"]\nrestart = nginx\n", "[web] restart nginx not found"},
+		{"[default]\nrestart = nginx\n", "[default] restart nginx not found"},
+		{"[restart \"nginx\"]\npath = nginx/**\n", "[nginx] restart without cmd"},
+	}
+	for _, test := range tests {
+		if _, err := ParseHapfile([]byte(test.hapfile)); err == nil || err.Error() != test.expected {
+			t.Errorf("expected %q, got %v", test.expected, err)
+		}
+	}
+}
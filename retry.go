@@ -0,0 +1,111 @@
+// Hap - the simple and effective provisioner
+// Copyright (c) 2015 Garrett Woodworth (https://github.com/gwoo)
+// The BSD License http://opensource.org/licenses/bsd-license.php.
+
+package hap
+
+import (
+	"fmt"
+	"io"
+	"math/rand"
+	"net"
+	"strings"
+	"time"
+
+	"golang.org/x/crypto/ssh"
+)
+
+// Messages of transport failures that are worth retrying
+var transient = []string{
+	"connection reset",
+	"connection refused",
+	"connection timed out",
+	"connection closed",
+	"broken pipe",
+	"i/o timeout",
+	"no route to host",
+	"network is unreachable",
+	"handshake failed: eof",
+	"the remote end hung up unexpectedly",
+	"early eof",
+	"ssh: connect to host",
+}
+
+// Retry holds the policy for retrying transient failures
+// Attempts is the total number of tries, 0 or 1 means no retries.
+// Delay doubles after each attempt and up to Jitter is added at random.
+// Clock waits between the attempts.
+type Retry struct {
+	Attempts int
+	Delay    time.Duration
+	Jitter   time.Duration
+	Clock    Clock
+}
+
+// Do calls fn until it succeeds, fails with an error that is not
+// retryable, or the attempts are used up. Before each retry notify
+// is called with the next attempt and the error.
+func (rt Retry) Do(fn func() error, notify func(attempt int, err error)) error {
+	delay := rt.Delay
+	for attempt := 1; ; attempt++ {
+		err := fn()
+		if err == nil || attempt >= rt.Attempts || !IsRetryable(err) {
+			return err
+		}
+		wait := delay
+		if rt.Jitter > 0 {
+			wait += time.Duration(rand.Int63n(int64(rt.Jitter)))
+		}
+		if notify != nil {
+			notify(attempt+1, fmt.Errorf("%s, retrying in %s", err, wait))
+		}
+		orSystemClock(rt.Clock).Sleep(wait)
+		delay *= 2
+	}
+}
+
+// IsRetryable returns whether an error is a transient transport failure
+// Commands that exited with a status are genuine failures and not retried.
+func IsRetryable(err error) bool {
+	switch e := err.(type) {
+	case nil:
+		return false
+	case *ssh.ExitError:
+		return false
+	case *ssh.ExitMissingError:
+		return true
+	case net.Error:
+		return true
+	default:
+		if e == io.EOF || e == io.ErrUnexpectedEOF {
+			return true
+		}
+	}
+	message := strings.ToLower(err.Error())
+	if strings.Contains(message, "unable to authenticate") {
+		return false
+	}
+	for _, t := range transient {
+		if strings.Contains(message, t) {
+			return true
+		}
+	}
+	return false
+}
+
+// Retry returns the retry policy of the host
+func (h *Host) Retry() (Retry, error) {
+	rt := Retry{Attempts: h.Retries + 1}
+	var err error
+	if h.RetryDelay != "" {
+		if rt.Delay, err = time.ParseDuration(h.RetryDelay); err != nil {
+			return rt, fmt.Errorf("[%s] invalid retry-delay %q", h.Name, h.RetryDelay)
+		}
+	}
+	if h.RetryJitter != "" {
+		if rt.Jitter, err = time.ParseDuration(h.RetryJitter); err != nil {
+			return rt, fmt.Errorf("[%s] invalid retry-jitter %q", h.Name, h.RetryJitter)
+		}
+	}
+	return rt, nil
+}
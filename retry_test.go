@@ -0,0 +1,79 @@
+// Hap - the simple and effective provisioner
+// Copyright (c) 2015 Garrett Woodworth (https://github.com/gwoo)
+// The BSD License http://opensource.org/licenses/bsd-license.php.
+
+package hap
+
+import (
+	"fmt"
+	"io"
+	"io/ioutil"
+	"testing"
+	"time"
+)
+
+func TestRetryDo(t *testing.T) {
+	rt := Retry{Attempts: 3, Delay: time.Millisecond, Jitter: time.Millisecond}
+	calls, notified := 0, 0
+	err := rt.Do(func() error {
+		calls++
+		if calls < 3 {
+			return io.EOF
+		}
+		return nil
+	}, func(attempt int, err error) {
+		notified++
+	})
+	if err != nil {
+		t.Error(err)
+	}
+	if calls != 3 || notified != 2 {
+		t.Errorf("expected 3 calls and 2 notifications, got %d and %d", calls, notified)
+	}
+	calls = 0
+	err = rt.Do(func() error {
+		calls++
+		return fmt.Errorf("command failed")
+	}, nil)
+	if err == nil || calls != 1 {
+		t.Errorf("expected 1 call for a genuine failure, got %d", calls)
+	}
+}
+
+func TestIsRetryable(t *testing.T) {
+	tests := map[string]bool{
+		"read tcp: connection reset by peer":                 true,
+		"fatal: the remote end hung up unexpectedly":         true,
+		"ssh: handshake failed: ssh: unable to authenticate": false,
+		"Process exited with status 1":                       false,
+	}
+	for message, expected := range tests {
+		if IsRetryable(fmt.Errorf("%s", message)) != expected {
+			t.Errorf("expected IsRetryable(%q) to be %v", message, expected)
+		}
+	}
+}
+
+func TestRetryOnlyReads(t *testing.T) {
+	t.Setenv("HOME", t.TempDir())
+	dir := t.TempDir()
+	addr, _ := testServer(t)
+	r := testMuxRemote(addr)
+	r.muxing = true
+	r.Retry = Retry{Attempts: 3}
+	defer r.Close()
+	err := r.Execute([]string{fmt.Sprintf("echo run >> %s/runs; exit 254", dir)})
+	if err == nil {
+		t.Fatal("expected the lost session to fail")
+	}
+	if b, _ := ioutil.ReadFile(dir + "/runs"); string(b) != "run\n" {
+		t.Errorf("expected the commands to run once, got %q", b)
+	}
+	result, err := r.read([]string{fmt.Sprintf("echo out; if [ ! -f %s/seen ]; then touch %[1]s/seen; exit 254; fi", dir)})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(result.Stdout) != "out\n" {
+		t.Errorf("expected the output of the last attempt, got %q", result.Stdout)
+	}
+}
@@ -0,0 +1,100 @@
+// Hap - the simple and effective provisioner
+// Copyright (c) 2015 Garrett Woodworth (https://github.com/gwoo)
+// The BSD License http://opensource.org/licenses/bsd-license.php.
+
+package hap
+
+import (
+	"fmt"
+	"sort"
+	"sync"
+)
+
+// Rollout holds the settings for rolling out to many hosts
+// Serial is the number of hosts in each batch, 0 means all at once.
+// MaxFail is the number of failed hosts tolerated before aborting.
+// Strategy zone keeps the hosts of each zone in batches of their own.
+type Rollout struct {
+	Serial   int
+	MaxFail  int `gcfg:"max-fail"`
+	Strategy string
+}
+
+// Run takes the hosts and calls fn for each of them in batches
+// It waits for every host in a batch before starting the next one
+// and aborts the rollout once more than MaxFail hosts have failed.
+// Hosts that timed out do not count as failed. fn gets the Turns of
+// the rollout for the remotes of the hosts.
+func (ro Rollout) Run(hosts map[string]*Host, fn func(*Host, *Turns) error) error {
+	batches, err := ro.Batches(hosts)
+	if err != nil {
+		return err
+	}
+	turns := &Turns{}
+	failed, timedOut, done := 0, 0, 0
+	for _, batch := range batches {
+		var wg sync.WaitGroup
+		var mu sync.Mutex
+		for _, key := range batch {
+			wg.Add(1)
+			go func(h *Host) {
+				defer wg.Done()
+				err := fn(h, turns)
+				mu.Lock()
+				defer mu.Unlock()
+				if _, ok := err.(*TimeoutError); ok {
+					timedOut++
+				} else if err != nil {
+					failed++
+				}
+			}(hosts[key])
+		}
+		wg.Wait()
+		done += len(batch)
+		if failed > ro.MaxFail && done < len(hosts) {
+			return fmt.Errorf("rollout aborted: %d failed, %d timed out, %d skipped", failed, timedOut, len(hosts)-done)
+		}
+	}
+	return nil
+}
+
+// Batches returns the names of the hosts in the order of the rollout
+// With the zone strategy no batch spans more than one zone, so a
+// failing batch never takes down hosts in two zones at once.
+func (ro Rollout) Batches(hosts map[string]*Host) ([][]string, error) {
+	zones := map[string][]string{}
+	switch ro.Strategy {
+	case "":
+		for key := range hosts {
+			zones[""] = append(zones[""], key)
+		}
+	case "zone":
+		for key, host := range hosts {
+			zones[host.Zone] = append(zones[host.Zone], key)
+		}
+	default:
+		return nil, fmt.Errorf("invalid rollout strategy %q", ro.Strategy)
+	}
+	names := []string{}
+	for zone := range zones {
+		names = append(names, zone)
+	}
+	sort.Strings(names)
+	batches := [][]string{}
+	for _, zone := range names {
+		keys := zones[zone]
+		sort.Strings(keys)
+		size := ro.Serial
+		if size < 1 {
+			size = len(keys)
+		}
+		for i := 0; i < len(keys); i += size {
+			end := i + size
+			if end > len(keys) {
+				end = len(keys)
+			}
+			batches = append(batches, keys[i:end])
+		}
+	}
+	return batches, nil
+}
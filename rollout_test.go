@@ -0,0 +1,153 @@
+// Hap - the simple and effective provisioner
+// Copyright (c) 2015 Garrett Woodworth (https://github.com/gwoo)
+// The BSD License http://opensource.org/licenses/bsd-license.php.
+
+package hap
+
+import (
+	"fmt"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestRolloutRun(t *testing.T) {
+	hosts := map[string]*Host{
+		"a": {Name: "a"}, "b": {Name: "b"}, "c": {Name: "c"}, "d": {Name: "d"},
+	}
+	var mu sync.Mutex
+	seen := []string{}
+	ro := Rollout{Serial: 2}
+	err := ro.Run(hosts, func(h *Host, turns *Turns) error {
+		mu.Lock()
+		seen = append(seen, h.Name)
+		mu.Unlock()
+		return nil
+	})
+	if err != nil {
+		t.Error(err)
+	}
+	if len(seen) != 4 {
+		t.Errorf("expected 4 hosts, got %d", len(seen))
+	}
+}
+
+func TestRolloutRunMaxFail(t *testing.T) {
+	hosts := map[string]*Host{
+		"a": {Name: "a"}, "b": {Name: "b"}, "c": {Name: "c"}, "d": {Name: "d"},
+	}
+	count := 0
+	ro := Rollout{Serial: 1, MaxFail: 1}
+	err := ro.Run(hosts, func(h *Host, turns *Turns) error {
+		count++
+		return fmt.Errorf("failed")
+	})
+	if err == nil {
+		t.Error("expected rollout to abort")
+	}
+	if count != 2 {
+		t.Errorf("expected 2 hosts before abort, got %d", count)
+	}
+}
+
+func TestRolloutBatchesByZone(t *testing.T) {
+	hosts := map[string]*Host{
+		"a": {Name: "a", Zone: "eu-1a"}, "b": {Name: "b", Zone: "eu-1b"},
+		"c": {Name: "c", Zone: "eu-1a"}, "d": {Name: "d", Zone: "eu-1b"},
+		"e": {Name: "e", Zone: "eu-1a"}, "f": {Name: "f"},
+	}
+	ro := Rollout{Serial: 2, Strategy: "zone"}
+	batches, err := ro.Batches(hosts)
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected := "[[f] [a c] [e] [b d]]"
+	if fmt.Sprint(batches) != expected {
+		t.Errorf("expected %s, got %v", expected, batches)
+	}
+	ro.Serial = 0
+	batches, _ = ro.Batches(hosts)
+	expected = "[[f] [a c e] [b d]]"
+	if fmt.Sprint(batches) != expected {
+		t.Errorf("expected %s, got %v", expected, batches)
+	}
+	if _, err := (Rollout{Strategy: "random"}).Batches(hosts); err == nil {
+		t.Error("expected error for invalid strategy")
+	}
+}
+
+func TestRolloutRunTimedOut(t *testing.T) {
+	hosts := map[string]*Host{
+		"a": {Name: "a"}, "b": {Name: "b"}, "c": {Name: "c"},
+	}
+	count := 0
+	ro := Rollout{Serial: 1}
+	err := ro.Run(hosts, func(h *Host, turns *Turns) error {
+		count++
+		if h.Name == "a" {
+			return &TimeoutError{Host: h.Name, Budget: time.Minute}
+		}
+		return nil
+	})
+	if err != nil || count != 3 {
+		t.Errorf("expected the rollout to proceed past the timed out host, got %d hosts and %v", count, err)
+	}
+}
+
+func TestRolloutClock(t *testing.T) {
+	hosts := map[string]*Host{
+		"a": {Name: "a", Type: "local", Budget: "1h"},
+		"b": {Name: "b", Type: "local", Budget: "1h"},
+	}
+	clock := NewSimulatedClock(time.Unix(0, 0))
+	release := make(chan struct{})
+	defer close(release)
+	var mu sync.Mutex
+	took := map[string]time.Duration{}
+	ro := Rollout{Serial: 1}
+	finished := make(chan error, 1)
+	go func() {
+		finished <- ro.Run(hosts, func(h *Host, turns *Turns) error {
+			r, err := NewRemote(h, clock)
+			if err != nil {
+				return err
+			}
+			start := r.clock().Now()
+			_, err = r.WithinBudget(func() (string, error) {
+				<-release
+				return "", nil
+			}, func() {})
+			mu.Lock()
+			took[h.Name] = r.clock().Now().Sub(start)
+			mu.Unlock()
+			return err
+		})
+	}()
+	for range hosts {
+		// Waits for the budget of the host to be on the clock
+		for {
+			clock.mu.Lock()
+			waiting := len(clock.timers)
+			clock.mu.Unlock()
+			if waiting > 0 {
+				break
+			}
+			time.Sleep(time.Millisecond)
+		}
+		clock.Advance(time.Hour)
+	}
+	select {
+	case err := <-finished:
+		if err != nil {
+			t.Error(err)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("expected the budgets to run out on the simulated clock")
+	}
+	if took["a"] != time.Hour || took["b"] != time.Hour {
+		t.Errorf("expected an hour for each host, got %v", took)
+	}
+	if passed := clock.Now().Sub(time.Unix(0, 0)); passed != 2*time.Hour {
+		t.Errorf("expected 2h on the clock, got %s", passed)
+	}
+}
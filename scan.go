@@ -0,0 +1,181 @@
+// Hap - the simple and effective provisioner
+// Copyright (c) 2015 Garrett Woodworth (https://github.com/gwoo)
+// The BSD License http://opensource.org/licenses/bsd-license.php.
+
+package hap
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+
+	"golang.org/x/crypto/ssh"
+	"golang.org/x/crypto/ssh/knownhosts"
+)
+
+// KnownHostsFile holds the trusted host keys of the hosts
+const KnownHostsFile = ".hap/known_hosts"
+
+// InventoryFile caches the facts of the hosts
+const InventoryFile = ".hap/inventory.json"
+
+// ScanWorkers is the number of hosts scanned at once
+const ScanWorkers = 16
+
+// KnownHosts returns a callback that checks host keys against the file
+// Hosts that are not in the file, or a missing file, are accepted, so
+// only hosts that were scanned are verified.
+func KnownHosts(file string) ssh.HostKeyCallback {
+	return func(hostname string, remote net.Addr, key ssh.PublicKey) error {
+		if _, err := os.Stat(file); err != nil {
+			return nil
+		}
+		check, err := knownhosts.New(file)
+		if err != nil {
+			return err
+		}
+		err = check(hostname, remote, key)
+		if keyErr, ok := err.(*knownhosts.KeyError); ok && len(keyErr.Want) == 0 {
+			return nil
+		}
+		if err != nil {
+			return fmt.Errorf("host key of %s does not match %s: %s", hostname, file, err)
+		}
+		return nil
+	}
+}
+
+// Scan holds the host key and facts collected from a host
+type Scan struct {
+	Host  string
+	Addr  string
+	Key   ssh.PublicKey
+	Facts Facts
+	Time  time.Time
+	Err   error
+}
+
+// String returns the key fingerprint and facts, or the error
+func (s Scan) String() string {
+	parts := []string{}
+	if s.Key != nil {
+		parts = append(parts, s.Key.Type(), ssh.FingerprintSHA256(s.Key))
+	}
+	if s.Facts != nil {
+		parts = append(parts, s.Facts["DISTRO"], s.Facts["DISTRO_VERSION"], s.Facts["ARCH"])
+	}
+	if s.Err != nil {
+		parts = append(parts, "failed: "+s.Err.Error())
+	}
+	return strings.Join(parts, " ")
+}
+
+// Scan connects to the host to collect its host key and facts
+// The key is collected even when the facts are not.
+func (r *Remote) Scan() Scan {
+	s := Scan{Host: r.Host.Name, Addr: r.Host.Addr}
+	if r.Host.IsSSH() {
+		config := *r.sshConfig.ClientConfig
+		check := config.HostKeyCallback
+		config.HostKeyCallback = func(hostname string, remote net.Addr, key ssh.PublicKey) error {
+			s.Key = key
+			if check == nil {
+				return nil
+			}
+			return check(hostname, remote, key)
+		}
+		r.sshConfig.ClientConfig = &config
+		s.Addr = r.sshConfig.Addr
+	}
+	s.Facts, s.Err = r.Facts()
+	s.Time = r.clock().Now()
+	return s
+}
+
+// WriteKnownHosts adds the host keys of the scans to the file
+// Keys already in the file are skipped, and keys that do not match
+// the ones in the file are left out and returned as an error.
+func WriteKnownHosts(file string, scans []Scan) error {
+	lines, errors := []string{}, []string{}
+	var check ssh.HostKeyCallback
+	if _, err := os.Stat(file); err == nil {
+		var err error
+		if check, err = knownhosts.New(file); err != nil {
+			return err
+		}
+	}
+	for _, s := range scans {
+		if s.Key == nil {
+			continue
+		}
+		if check != nil {
+			err := check(s.Addr, &net.TCPAddr{}, s.Key)
+			if err == nil {
+				continue
+			}
+			if keyErr, ok := err.(*knownhosts.KeyError); !ok || len(keyErr.Want) > 0 {
+				errors = append(errors, fmt.Sprintf("[%s] host key %s does not match %s", s.Host, ssh.FingerprintSHA256(s.Key), file))
+				continue
+			}
+		}
+		lines = append(lines, knownhosts.Line([]string{knownhosts.Normalize(s.Addr)}, s.Key))
+	}
+	if len(lines) > 0 {
+		if err := os.MkdirAll(filepath.Dir(file), 0700); err != nil {
+			return err
+		}
+		f, err := os.OpenFile(file, os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0644)
+		if err != nil {
+			return err
+		}
+		_, err = f.WriteString(strings.Join(lines, "\n") + "\n")
+		if cerr := f.Close(); err == nil {
+			err = cerr
+		}
+		if err != nil {
+			return err
+		}
+	}
+	if len(errors) > 0 {
+		sort.Strings(errors)
+		return fmt.Errorf("%s", strings.Join(errors, "\n"))
+	}
+	return nil
+}
+
+// Inventoried holds the cached facts of a host
+type Inventoried struct {
+	Addr  string
+	Facts Facts
+	Time  time.Time
+}
+
+// WriteInventory updates the cached facts of the scanned hosts in the file
+func WriteInventory(file string, scans []Scan) error {
+	inventory := map[string]Inventoried{}
+	if b, err := ioutil.ReadFile(file); err == nil {
+		if err := json.Unmarshal(b, &inventory); err != nil {
+			return fmt.Errorf("invalid %s: %s", file, err)
+		}
+	}
+	for _, s := range scans {
+		if s.Facts == nil {
+			continue
+		}
+		inventory[s.Host] = Inventoried{Addr: s.Addr, Facts: s.Facts, Time: s.Time.UTC()}
+	}
+	b, err := json.MarshalIndent(inventory, "", "  ")
+	if err != nil {
+		return err
+	}
+	if err := os.MkdirAll(filepath.Dir(file), 0700); err != nil {
+		return err
+	}
+	return ioutil.WriteFile(file, append(b, '\n'), 0644)
+}
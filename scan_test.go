@@ -0,0 +1,79 @@
+// Hap - the simple and effective provisioner
+// Copyright (c) 2015 Garrett Woodworth (https://github.com/gwoo)
+// The BSD License http://opensource.org/licenses/bsd-license.php.
+
+package hap
+
+import (
+	"crypto/ed25519"
+	"crypto/rand"
+	"encoding/json"
+	"io/ioutil"
+	"net"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"golang.org/x/crypto/ssh"
+)
+
+func testHostKey(t *testing.T) ssh.PublicKey {
+	public, _, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	key, err := ssh.NewPublicKey(public)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return key
+}
+
+func TestKnownHosts(t *testing.T) {
+	file := filepath.Join(t.TempDir(), "known_hosts")
+	one, two, other := testHostKey(t), testHostKey(t), testHostKey(t)
+	check := KnownHosts(file)
+	if err := check("10.0.0.1:22", &net.TCPAddr{}, one); err != nil {
+		t.Fatalf("expected hosts to be accepted without the file, got %s", err)
+	}
+	scans := []Scan{{Host: "one", Addr: "10.0.0.1:22", Key: one}, {Host: "two", Addr: "10.0.0.2:2222", Key: two}, {Host: "three"}}
+	if err := WriteKnownHosts(file, scans); err != nil {
+		t.Fatal(err)
+	}
+	if err := WriteKnownHosts(file, scans); err != nil {
+		t.Fatal(err)
+	}
+	b, _ := ioutil.ReadFile(file)
+	if lines := strings.Split(strings.TrimSpace(string(b)), "\n"); len(lines) != 2 || !strings.HasPrefix(lines[1], "[10.0.0.2]:2222 ") {
+		t.Errorf("unexpected known hosts\n%s", b)
+	}
+	if err := check("10.0.0.2:2222", &net.TCPAddr{}, two); err != nil {
+		t.Errorf("expected known key to match, got %s", err)
+	}
+	if err := check("10.0.0.1:22", &net.TCPAddr{}, other); err == nil {
+		t.Error("expected error for changed host key")
+	}
+	if err := check("10.0.0.3:22", &net.TCPAddr{}, other); err != nil {
+		t.Errorf("expected unknown host to be accepted, got %s", err)
+	}
+	if err := WriteKnownHosts(file, []Scan{{Host: "one", Addr: "10.0.0.1:22", Key: other}}); err == nil {
+		t.Error("expected error for changed host key")
+	}
+}
+
+func TestWriteInventory(t *testing.T) {
+	file := filepath.Join(t.TempDir(), "inventory.json")
+	ioutil.WriteFile(file, []byte(`{"old": {"Addr": "10.0.0.9:22", "Facts": {"OS": "Linux"}}}`), 0644)
+	scans := []Scan{{Host: "one", Addr: "10.0.0.1:22", Facts: Facts{"DISTRO": "debian"}}, {Host: "two"}}
+	if err := WriteInventory(file, scans); err != nil {
+		t.Fatal(err)
+	}
+	inventory := map[string]Inventoried{}
+	b, _ := ioutil.ReadFile(file)
+	if err := json.Unmarshal(b, &inventory); err != nil {
+		t.Fatal(err)
+	}
+	if len(inventory) != 2 || inventory["one"].Facts["DISTRO"] != "debian" || inventory["old"].Addr != "10.0.0.9:22" {
+		t.Errorf("unexpected inventory %v", inventory)
+	}
+}
@@ -0,0 +1,159 @@
+// Hap - the simple and effective provisioner
+// Copyright (c) 2015 Garrett Woodworth (https://github.com/gwoo)
+// The BSD License http://opensource.org/licenses/bsd-license.php.
+
+package hap
+
+import (
+	"crypto/rand"
+	"encoding/base64"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strings"
+
+	"golang.org/x/crypto/curve25519"
+	"golang.org/x/crypto/nacl/box"
+)
+
+// Prefix of encrypted values in the Hapfile
+const encrypted = "enc:"
+
+// DefaultSecretKey is used when a host does not set secret-key
+const DefaultSecretKey = "~/.hap/secret.key"
+
+// Matches valid environment variable names
+var validEnvName = regexp.MustCompile(`^[A-Za-z_][A-Za-z0-9_]*$`)
+
+// SecretKey holds the key pair used to encrypt and decrypt secrets
+type SecretKey struct {
+	Public  *[32]byte
+	Private *[32]byte
+}
+
+// GenerateSecretKey creates a new key and writes it to file
+// It refuses to overwrite an existing key.
+func GenerateSecretKey(file string) (*SecretKey, error) {
+	path, err := expandHome(file)
+	if err != nil {
+		return nil, err
+	}
+	if _, err := os.Stat(path); err == nil {
+		return nil, fmt.Errorf("[secret] %s already exists", file)
+	}
+	public, private, err := box.GenerateKey(rand.Reader)
+	if err != nil {
+		return nil, err
+	}
+	if err := os.MkdirAll(filepath.Dir(path), 0700); err != nil {
+		return nil, err
+	}
+	data := base64.StdEncoding.EncodeToString(private[:]) + "\n"
+	if err := ioutil.WriteFile(path, []byte(data), 0600); err != nil {
+		return nil, err
+	}
+	return &SecretKey{Public: public, Private: private}, nil
+}
+
+// NewSecretKey reads the key from file
+func NewSecretKey(file string) (*SecretKey, error) {
+	path, err := NewKeyFile(file)
+	if err != nil {
+		return nil, fmt.Errorf("[secret] %s", err)
+	}
+	b, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("[secret] %s", err)
+	}
+	raw, err := base64.StdEncoding.DecodeString(strings.TrimSpace(string(b)))
+	if err != nil || len(raw) != 32 {
+		return nil, fmt.Errorf("[secret] %s is not a valid key", file)
+	}
+	key := &SecretKey{Public: new([32]byte), Private: new([32]byte)}
+	copy(key.Private[:], raw)
+	public, err := curve25519.X25519(key.Private[:], curve25519.Basepoint)
+	if err != nil {
+		return nil, err
+	}
+	copy(key.Public[:], public)
+	return key, nil
+}
+
+// Encrypt returns the value sealed to the public key with the enc: prefix
+func (k *SecretKey) Encrypt(value string) (string, error) {
+	sealed, err := box.SealAnonymous(nil, []byte(value), k.Public, rand.Reader)
+	if err != nil {
+		return "", err
+	}
+	return encrypted + base64.StdEncoding.EncodeToString(sealed), nil
+}
+
+// Decrypt returns the plaintext of a value with the enc: prefix
+func (k *SecretKey) Decrypt(value string) (string, error) {
+	sealed, err := base64.StdEncoding.DecodeString(strings.TrimPrefix(value, encrypted))
+	if err != nil {
+		return "", fmt.Errorf("[secret] %s", err)
+	}
+	plain, ok := box.OpenAnonymous(nil, sealed, k.Public, k.Private)
+	if !ok {
+		return "", fmt.Errorf("[secret] unable to decrypt with this key")
+	}
+	return string(plain), nil
+}
+
+// IsEncrypted returns whether the value is encrypted
+func IsEncrypted(value string) bool {
+	return strings.HasPrefix(value, encrypted)
+}
+
+// Secret reads the secret-key of the host
+func (h *Host) Secret() (*SecretKey, error) {
+	file := h.SecretKey
+	if file == "" {
+		file = DefaultSecretKey
+	}
+	return NewSecretKey(file)
+}
+
+// Environment returns the env of the host as NAME=value pairs
+// Encrypted values are decrypted with the secret-key of the host.
+func (h *Host) Environment() ([]string, error) {
+	var key *SecretKey
+	env := []string{}
+	for _, pair := range h.Env {
+		parts := strings.SplitN(pair, "=", 2)
+		if len(parts) != 2 || !validEnvName.MatchString(parts[0]) {
+			return nil, fmt.Errorf("[%s] invalid env %q", h.Name, parts[0])
+		}
+		name, value := parts[0], parts[1]
+		if IsEncrypted(value) {
+			if key == nil {
+				var err error
+				if key, err = h.Secret(); err != nil {
+					return nil, err
+				}
+			}
+			plain, err := key.Decrypt(value)
+			if err != nil {
+				return nil, fmt.Errorf("[%s] %s %s", h.Name, name, err)
+			}
+			value = plain
+		}
+		env = append(env, name+"="+value)
+	}
+	return env, nil
+}
+
+// exportEnv returns the exports for NAME=value pairs
+// Values are base64 encoded so they survive any shell quoting.
+func exportEnv(env []string) string {
+	exports := ""
+	for _, pair := range env {
+		parts := strings.SplitN(pair, "=", 2)
+		value := base64.StdEncoding.EncodeToString([]byte(parts[1]))
+		exports += fmt.Sprintf("export %s=\"$(echo %s | base64 -d)\";", parts[0], value)
+	}
+	return exports
+}
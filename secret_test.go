@@ -0,0 +1,55 @@
+// Hap - the simple and effective provisioner
+// Copyright (c) 2015 Garrett Woodworth (https://github.com/gwoo)
+// The BSD License http://opensource.org/licenses/bsd-license.php.
+
+package hap
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestSecretKey(t *testing.T) {
+	dir, err := ioutil.TempDir("", "hap")
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	defer os.RemoveAll(dir)
+	file := filepath.Join(dir, "secret.key")
+	if _, err := GenerateSecretKey(file); err != nil {
+		t.Error(err)
+		return
+	}
+	if _, err := GenerateSecretKey(file); err == nil {
+		t.Error("expected error when the key exists")
+	}
+	key, err := NewSecretKey(file)
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	value, err := key.Encrypt("p@ss'word$")
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	if !IsEncrypted(value) {
+		t.Errorf("expected %s to be encrypted", value)
+	}
+	host := &Host{
+		Name:      "one",
+		SecretKey: file,
+		Env:       []string{"PLAIN=value", "SECRET=" + value},
+	}
+	env, err := host.Environment()
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	if len(env) != 2 || env[0] != "PLAIN=value" || env[1] != "SECRET=p@ss'word$" {
+		t.Errorf("unexpected env %v", env)
+	}
+}
@@ -0,0 +1,53 @@
+// Hap - the simple and effective provisioner
+// Copyright (c) 2015 Garrett Woodworth (https://github.com/gwoo)
+// The BSD License http://opensource.org/licenses/bsd-license.php.
+
+package hap
+
+import (
+	"fmt"
+	"io"
+	"sync"
+)
+
+// Turns holds the turns of the groups whose hosts run serial builds one
+// at a time. Each rollout has its own, the zero value is ready to use.
+type Turns struct {
+	turns map[string]chan struct{}
+	mu    sync.Mutex
+}
+
+// turn returns the turn of the group
+func (t *Turns) turn(group string) chan struct{} {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	if t.turns == nil {
+		t.turns = map[string]chan struct{}{}
+	}
+	turn, ok := t.turns[group]
+	if !ok {
+		turn = make(chan struct{}, 1)
+		t.turns[group] = turn
+	}
+	return turn
+}
+
+// serialize runs the step once no other host of its serial group runs
+// it, when the host is in the group. Hosts of a fleet run build at the
+// same time, so builds like cache warming or leader election wait for
+// their turn here instead of racing each other. Remotes without Turns
+// run it right away.
+func (r *Remote) serialize(step Step, stdout io.Writer, run func() (*Result, error)) (*Result, error) {
+	if step.Serial == "" || r.Turns == nil || !r.Host.InGroup(step.Serial) {
+		return run()
+	}
+	turn := r.Turns.turn(step.Serial)
+	select {
+	case turn <- struct{}{}:
+	default:
+		fmt.Fprintf(stdout, "waiting for another host of %s to finish %s\n", step.Serial, step.Name)
+		turn <- struct{}{}
+	}
+	defer func() { <-turn }()
+	return run()
+}
@@ -0,0 +1,106 @@
+// Hap - the simple and effective provisioner
+// Copyright (c) 2015 Garrett Woodworth (https://github.com/gwoo)
+// The BSD License http://opensource.org/licenses/bsd-license.php.
+
+package hap
+
+import (
+	"bytes"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+)
+
+func TestBuildSerial(t *testing.T) {
+	home := t.TempDir()
+	t.Setenv("HOME", home)
+	work := filepath.Join(home, "work", "app")
+	os.MkdirAll(work, 0755)
+	wd, _ := os.Getwd()
+	os.Chdir(work)
+	defer os.Chdir(wd)
+	for _, args := range [][]string{{"init", "-q"}, {"commit", "-q", "--allow-empty", "-m", "one"}} {
+		if out, err := exec.Command("git", args...).CombinedOutput(); err != nil {
+			t.Fatalf("%s %s", out, err)
+		}
+	}
+	builds := map[string]*Build{"warm": {Cmd: []string{"mkdir ~/warming || exit 9", "sleep 0.2", "rmdir ~/warming"}, Serial: "web"}}
+	groups := map[string]*Group{"web": {Host: []string{"one", "two"}}}
+	turns := &Turns{}
+	remotes := []*Remote{}
+	outs := []*bytes.Buffer{}
+	for _, name := range []string{"one", "two"} {
+		host := &Host{Name: name, Type: "local", Dir: name, Transfer: "bundle", Build: []string{"warm"}}
+		host.BuildCmds(builds)
+		host.GroupKeys(groups)
+		if !host.Serialized() {
+			t.Fatalf("expected %s to be serialized", name)
+		}
+		r, err := NewRemote(host, nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer r.Close()
+		r.Turns = turns
+		var out bytes.Buffer
+		r.Stdout, r.Stderr = &out, &out
+		if err := r.Initialize(); err != nil {
+			t.Fatal(err)
+		}
+		if err := r.PushBundle(); err != nil {
+			t.Fatal(err)
+		}
+		remotes, outs = append(remotes, r), append(outs, &out)
+	}
+	errs := make([]error, len(remotes))
+	var wg sync.WaitGroup
+	for i, r := range remotes {
+		wg.Add(1)
+		go func(i int, r *Remote) {
+			defer wg.Done()
+			errs[i] = r.Build()
+		}(i, r)
+	}
+	wg.Wait()
+	for i, err := range errs {
+		if err != nil {
+			t.Errorf("expected the builds to take turns, got %s %s", err, outs[i])
+		}
+	}
+	if !strings.Contains(outs[0].String()+outs[1].String(), "waiting for another host of web to finish warm") {
+		t.Errorf("expected a host to wait for its turn, got %q %q", outs[0], outs[1])
+	}
+	host := &Host{Name: "three", Build: []string{"warm"}}
+	host.BuildCmds(builds)
+	host.GroupKeys(groups)
+	if host.Serialized() {
+		t.Error("expected hosts outside the group not to be serialized")
+	}
+}
+
+func TestSerializeTurns(t *testing.T) {
+	host := &Host{Name: "one", groups: []string{"web"}}
+	step := Step{Name: "warm", Serial: "web"}
+	one, other := &Remote{Host: host, Turns: &Turns{}}, &Remote{Host: host, Turns: &Turns{}}
+	var out bytes.Buffer
+	_, err := one.serialize(step, &out, func() (*Result, error) {
+		// The turn of web is only taken in the rollout of one
+		select {
+		case one.Turns.turn("web") <- struct{}{}:
+			t.Error("expected the turn of web to be taken")
+		default:
+		}
+		for _, r := range []*Remote{other, {Host: host}} {
+			if _, err := r.serialize(step, &out, func() (*Result, error) { return &Result{}, nil }); err != nil {
+				return nil, err
+			}
+		}
+		return &Result{}, nil
+	})
+	if err != nil || out.Len() != 0 {
+		t.Errorf("expected other rollouts not to wait, got %v %q", err, out.String())
+	}
+}
@@ -0,0 +1,109 @@
+// Hap - the simple and effective provisioner
+// Copyright (c) 2015 Garrett Woodworth (https://github.com/gwoo)
+// The BSD License http://opensource.org/licenses/bsd-license.php.
+
+package hap
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// DefaultSkewWarn is the clock skew above which a build warns
+const DefaultSkewWarn = 5 * time.Second
+
+// Prints the time of the remote machine in seconds since the epoch,
+// with nanoseconds where date supports them.
+const remoteTime string = "date -u +%s.%N"
+
+// SkewLimits returns the clock skew at which builds of the host warn
+// and fail. It uses the DefaultSkewWarn when skew-warn is not set, and
+// only fails when skew-fail is set.
+func (h *Host) SkewLimits() (time.Duration, time.Duration, error) {
+	warn, fail := DefaultSkewWarn, time.Duration(0)
+	var err error
+	if h.SkewWarn != "" {
+		if warn, err = time.ParseDuration(h.SkewWarn); err != nil || warn <= 0 {
+			return 0, 0, fmt.Errorf("[%s] invalid skew-warn %q", h.Name, h.SkewWarn)
+		}
+	}
+	if h.SkewFail != "" {
+		if fail, err = time.ParseDuration(h.SkewFail); err != nil || fail <= 0 {
+			return 0, 0, fmt.Errorf("[%s] invalid skew-fail %q", h.Name, h.SkewFail)
+		}
+	}
+	return warn, fail, nil
+}
+
+// ParseRemoteTime takes the output of date +%s.%N and returns the time
+// and its resolution, which is a second when date has no nanoseconds.
+func ParseRemoteTime(output string) (time.Time, time.Duration, error) {
+	output = strings.TrimSpace(output)
+	secs, frac := output, ""
+	if i := strings.Index(output, "."); i >= 0 {
+		secs, frac = output[:i], output[i+1:]
+	}
+	s, err := strconv.ParseInt(secs, 10, 64)
+	if err != nil {
+		return time.Time{}, 0, fmt.Errorf("invalid remote time %q", output)
+	}
+	ns, err := strconv.ParseInt(frac, 10, 64)
+	if err != nil || len(frac) != 9 {
+		return time.Unix(s, 0), time.Second, nil
+	}
+	return time.Unix(s, ns), time.Nanosecond, nil
+}
+
+// ClockSkew returns how far the clock of the remote machine is ahead of
+// the local clock, along with the margin of error of the measurement,
+// half the round trip plus the resolution of the remote time.
+func (r *Remote) ClockSkew() (time.Duration, time.Duration, error) {
+	before := r.clock().Now()
+	result, err := r.read([]string{remoteTime})
+	if err != nil {
+		return 0, 0, err
+	}
+	after := r.clock().Now()
+	remote, resolution, err := ParseRemoteTime(string(result.Stdout))
+	if err != nil {
+		return 0, 0, err
+	}
+	rtt := after.Sub(before)
+	return remote.Sub(before.Add(rtt / 2)), rtt/2 + resolution, nil
+}
+
+// CheckClock compares the clock of the remote machine with the local
+// clock before a build, since skew silently breaks certificates and
+// time based steps. Skew beyond the margin of error and over skew-warn
+// is reported to the prefixed stderr, over skew-fail it fails.
+func (r *Remote) CheckClock() error {
+	warn, fail, err := r.Host.SkewLimits()
+	if err != nil {
+		return err
+	}
+	skew, margin, err := r.ClockSkew()
+	if err != nil {
+		return err
+	}
+	off := skew
+	if off < 0 {
+		off = -off
+	}
+	if off -= margin; off <= 0 {
+		return nil
+	}
+	desc := fmt.Sprintf("clock is %s ahead of the local clock", skew.Round(time.Millisecond))
+	if skew < 0 {
+		desc = fmt.Sprintf("clock is %s behind the local clock", (-skew).Round(time.Millisecond))
+	}
+	if fail > 0 && off > fail {
+		return fmt.Errorf("[%s] %s, more than skew-fail %s", r.Host.Name, desc, fail)
+	}
+	if off > warn {
+		_, stderr := r.writers()
+		fmt.Fprintf(stderr, "warning: %s, check ntp on the host\n", desc)
+	}
+	return nil
+}
@@ -0,0 +1,70 @@
+// Hap - the simple and effective provisioner
+// Copyright (c) 2015 Garrett Woodworth (https://github.com/gwoo)
+// The BSD License http://opensource.org/licenses/bsd-license.php.
+
+package hap
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestParseRemoteTime(t *testing.T) {
+	tests := []struct {
+		output     string
+		unix       int64
+		resolution time.Duration
+	}{
+		{"1700000000.250000000\n", 1700000000250000000, time.Nanosecond},
+		{"1700000000.N\n", 1700000000000000000, time.Second},
+		{"1700000000.%N", 1700000000000000000, time.Second},
+		{"1700000000", 1700000000000000000, time.Second},
+	}
+	for _, test := range tests {
+		remote, resolution, err := ParseRemoteTime(test.output)
+		if err != nil || remote.UnixNano() != test.unix || resolution != test.resolution {
+			t.Errorf("%q: unexpected %s %s %v", test.output, remote, resolution, err)
+		}
+	}
+	if _, _, err := ParseRemoteTime("Tue Oct 15"); err == nil {
+		t.Error("expected error for invalid time")
+	}
+}
+
+func TestSkewLimits(t *testing.T) {
+	warn, fail, err := (&Host{}).SkewLimits()
+	if err != nil || warn != DefaultSkewWarn || fail != 0 {
+		t.Errorf("unexpected limits %s %s %v", warn, fail, err)
+	}
+	warn, fail, err = (&Host{SkewWarn: "1s", SkewFail: "1m"}).SkewLimits()
+	if err != nil || warn != time.Second || fail != time.Minute {
+		t.Errorf("unexpected limits %s %s %v", warn, fail, err)
+	}
+	for _, h := range []*Host{{SkewWarn: "soon"}, {SkewFail: "-1s"}} {
+		if _, _, err := h.SkewLimits(); err == nil {
+			t.Errorf("expected error for %v", h)
+		}
+	}
+}
+
+func TestCheckClock(t *testing.T) {
+	var out bytes.Buffer
+	r := &Remote{Host: &Host{Name: "me", Type: "local"}, Stdout: &out, Stderr: &out}
+	if err := r.CheckClock(); err != nil || out.Len() != 0 {
+		t.Fatalf("expected no skew, got %v %q", err, out.String())
+	}
+	r.Clock = NewSimulatedClock(time.Now().Add(-time.Hour))
+	skew, margin, err := r.ClockSkew()
+	if err != nil || skew < 59*time.Minute || margin > time.Second {
+		t.Errorf("expected an hour of skew, got %s %s %v", skew, margin, err)
+	}
+	if err := r.CheckClock(); err != nil || !strings.Contains(out.String(), "ahead of the local clock") {
+		t.Errorf("expected a warning, got %v %q", err, out.String())
+	}
+	r.Host.SkewFail = "10m"
+	if err := r.CheckClock(); err == nil || !strings.Contains(err.Error(), "more than skew-fail 10m0s") {
+		t.Errorf("expected skew to fail, got %v", err)
+	}
+}
@@ -0,0 +1,181 @@
+// Hap - the simple and effective provisioner
+// Copyright (c) 2015 Garrett Woodworth (https://github.com/gwoo)
+// The BSD License http://opensource.org/licenses/bsd-license.php.
+
+package hap
+
+import (
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// DefaultSmokeTimeout is how long a smoke test waits for the response
+const DefaultSmokeTimeout = 10 * time.Second
+
+// Smoke tests read at most this much of the response body
+const smokeBodyLimit = 1 << 20
+
+// Matches smoke test urls that are safe to quote in commands
+var validSmokeURL = regexp.MustCompile("^https?://[^\\s\"'`$\\\\]+$")
+
+// Smoketest holds an http request that has to succeed after each build
+// The URL may contain {addr}, replaced with the host of the addr.
+// Status is the expected status (default 200) and Body a regexp the
+// response body has to match. From is local (the default) to send the
+// request from the machine running hap, host to send it with curl on
+// the host, or both.
+type Smoketest struct {
+	URL     string
+	Status  int
+	Body    string
+	Timeout string
+	From    string
+	name    string
+}
+
+// Smoketests sets the smoke tests of the host
+func (h *Host) Smoketests(tests map[string]*Smoketest) {
+	h.smoke = []*Smoketest{}
+	for _, name := range h.Smoketest {
+		test, ok := tests[name]
+		if !ok {
+			test = &Smoketest{}
+		}
+		test.name = name
+		h.smoke = append(h.smoke, test)
+	}
+}
+
+// Smoketest runs the smoke tests of the host
+// It returns a line for each request and fails when any of them did
+// not get the expected response. Mock hosts record the requests to
+// their transcript instead of sending them.
+func (r *Remote) Smoketest() ([]string, error) {
+	lines, errors := []string{}, []string{}
+	for _, test := range r.Host.smoke {
+		url := strings.Replace(test.URL, "{addr}", r.Host.hostname(), -1)
+		if !validSmokeURL.MatchString(url) {
+			errors = append(errors, fmt.Sprintf("[%s] smoketest not found or with invalid url %q", test.name, url))
+			continue
+		}
+		body, err := regexp.Compile(test.Body)
+		if err != nil {
+			errors = append(errors, fmt.Sprintf("[%s] invalid body %q", test.name, test.Body))
+			continue
+		}
+		timeout := DefaultSmokeTimeout
+		if test.Timeout != "" {
+			if timeout, err = time.ParseDuration(test.Timeout); err != nil {
+				errors = append(errors, fmt.Sprintf("[%s] invalid timeout %q", test.name, test.Timeout))
+				continue
+			}
+		}
+		status := test.Status
+		if status == 0 {
+			status = http.StatusOK
+		}
+		from := []string{test.From}
+		switch test.From {
+		case "":
+			from = []string{"local"}
+		case "both":
+			from = []string{"local", "host"}
+		case "local", "host":
+		default:
+			errors = append(errors, fmt.Sprintf("[%s] invalid from %q", test.name, test.From))
+			continue
+		}
+		for _, where := range from {
+			if mocked, err := r.mocked(fmt.Sprintf("smoketest %s %s from %s", test.name, url, where)); err != nil {
+				return lines, err
+			} else if mocked {
+				lines = append(lines, fmt.Sprintf("%s %s recorded from %s", test.name, url, where))
+				continue
+			}
+			start := r.clock().Now()
+			var code int
+			var content []byte
+			if where == "local" {
+				code, content, err = smokeLocal(url, timeout)
+			} else {
+				code, content, err = r.smokeHost(url, timeout)
+			}
+			if err == nil && code != status {
+				err = fmt.Errorf("expected status %d, got %d", status, code)
+			}
+			if err == nil && !body.Match(content) {
+				err = fmt.Errorf("body does not match %q", test.Body)
+			}
+			if err != nil {
+				errors = append(errors, fmt.Sprintf("[%s] %s from %s: %s", test.name, url, where, err))
+				continue
+			}
+			lines = append(lines, fmt.Sprintf("%s %s %d in %s from %s",
+				test.name, url, code, r.clock().Now().Sub(start).Round(time.Millisecond), where))
+		}
+	}
+	if len(errors) > 0 {
+		sort.Strings(errors)
+		return lines, fmt.Errorf("%s", strings.Join(errors, "\n"))
+	}
+	return lines, nil
+}
+
+// smokeLocal sends the request from the machine running hap
+func smokeLocal(url string, timeout time.Duration) (int, []byte, error) {
+	client := &http.Client{Timeout: timeout}
+	resp, err := client.Get(url)
+	if err != nil {
+		return 0, nil, err
+	}
+	defer resp.Body.Close()
+	content, err := ioutil.ReadAll(io.LimitReader(resp.Body, smokeBodyLimit))
+	return resp.StatusCode, content, err
+}
+
+// smokeHost sends the request with curl on the host
+// curl prints the status on a line of its own after the body.
+func (r *Remote) smokeHost(url string, timeout time.Duration) (int, []byte, error) {
+	seconds := int(timeout.Seconds())
+	if seconds < 1 {
+		seconds = 1
+	}
+	result, err := r.read([]string{
+		fmt.Sprintf("curl -sS -m %d -w \"\\n%%{http_code}\" \"%s\"", seconds, url),
+	})
+	if err != nil {
+		if result != nil && len(result.Stderr) > 0 {
+			return 0, nil, fmt.Errorf("%s", strings.TrimSpace(string(result.Stderr)))
+		}
+		return 0, nil, err
+	}
+	output := result.Stdout
+	i := strings.LastIndex(string(output), "\n")
+	if i < 0 {
+		return 0, nil, fmt.Errorf("unexpected curl output %q", output)
+	}
+	code, err := strconv.Atoi(strings.TrimSpace(string(output[i+1:])))
+	if err != nil {
+		return 0, nil, fmt.Errorf("unexpected curl output %q", output)
+	}
+	return code, output[:i], nil
+}
+
+// hostname returns the host of the addr, localhost for local hosts
+func (h *Host) hostname() string {
+	if h.Addr == "" {
+		return "localhost"
+	}
+	if host, _, err := net.SplitHostPort(h.Addr); err == nil {
+		return host
+	}
+	return h.Addr
+}
@@ -0,0 +1,80 @@
+// Hap - the simple and effective provisioner
+// Copyright (c) 2015 Garrett Woodworth (https://github.com/gwoo)
+// The BSD License http://opensource.org/licenses/bsd-license.php.
+
+package hap
+
+import (
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"os/exec"
+	"strings"
+	"testing"
+)
+
+func TestSmoketest(t *testing.T) {
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/ok" {
+			http.NotFound(w, r)
+			return
+		}
+		fmt.Fprint(w, "status: ok")
+	}))
+	defer ts.Close()
+	u, _ := url.Parse(ts.URL)
+	from := "local"
+	if _, err := exec.LookPath("curl"); err == nil {
+		from = "both"
+	}
+	host := &Host{Name: "one", Type: "local", Addr: "127.0.0.1", Smoketest: []string{"ok", "missing"}}
+	host.Smoketests(map[string]*Smoketest{
+		"ok":      {URL: "http://{addr}:" + u.Port() + "/ok", Body: "status: ok", From: from},
+		"missing": {URL: ts.URL + "/missing", Status: 404},
+	})
+	r := &Remote{Host: host}
+	lines, err := r.Smoketest()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if from == "both" && len(lines) != 3 || from == "local" && len(lines) != 2 {
+		t.Errorf("unexpected lines %v", lines)
+	}
+	if !strings.HasPrefix(lines[0], "ok http://127.0.0.1:"+u.Port()+"/ok 200 in ") {
+		t.Errorf("unexpected line %q", lines[0])
+	}
+
+	host.Smoketests(map[string]*Smoketest{
+		"ok":      {URL: ts.URL + "/ok", Body: "^ready$", From: from},
+		"missing": {URL: ts.URL + "/missing"},
+	})
+	if _, err = r.Smoketest(); err == nil {
+		t.Fatal("expected error")
+	}
+	if !strings.Contains(err.Error(), "body does not match") || !strings.Contains(err.Error(), "expected status 200, got 404") {
+		t.Errorf("unexpected error %s", err)
+	}
+}
+
+func TestSmoketestInvalid(t *testing.T) {
+	tests := map[string]*Smoketest{
+		"url":     {URL: "http://example.com/\"; rm -rf /"},
+		"body":    {URL: "http://example.com", Body: "("},
+		"timeout": {URL: "http://example.com", Timeout: "soon"},
+		"from":    {URL: "http://example.com", From: "elsewhere"},
+	}
+	for name := range tests {
+		host := &Host{Name: "one", Smoketest: []string{name}}
+		host.Smoketests(tests)
+		r := &Remote{Host: host}
+		if _, err := r.Smoketest(); err == nil || !strings.Contains(err.Error(), "invalid") {
+			t.Errorf("expected invalid error for %s, got %v", name, err)
+		}
+	}
+	host := &Host{Name: "one", Smoketest: []string{"none"}}
+	host.Smoketests(tests)
+	if _, err := (&Remote{Host: host}).Smoketest(); err == nil {
+		t.Error("expected error for missing smoketest")
+	}
+}
@@ -7,25 +7,40 @@ package hap
 import (
 	"fmt"
 	"io/ioutil"
+	"math"
 	"net"
 	"os"
-	"os/user"
 	"path/filepath"
 	"strings"
+	"time"
 
 	"golang.org/x/crypto/ssh"
 	"golang.org/x/crypto/ssh/agent"
 )
 
+// KeyLifetime is how long the ssh-agent keeps the deploy keys added for
+// a push, so decrypted keys do not linger in the agent of the operator
+const KeyLifetime = 10 * time.Minute
+
 // SSHConfig holds the config for ssh connections
+// Keys holds additional private keys as pem, like group deploy keys.
+// Identities holds candidate identity files, missing ones are skipped.
 type SSHConfig struct {
 	Addr         string
 	Username     string
 	Identity     string
 	Password     string
+	Identities   []string
+	Keys         [][]byte
 	ClientConfig *ssh.ClientConfig
 }
 
+// IsSSH returns whether hap connects to the host over ssh, as it does
+// with every host that is neither local nor a mock
+func (h *Host) IsSSH() bool {
+	return !h.IsLocal() && !h.IsMock()
+}
+
 // NewClientConfig constructs a new client config
 func NewClientConfig(config SSHConfig) (*ssh.ClientConfig, error) {
 	sock, err := net.Dial("unix", os.Getenv("SSH_AUTH_SOCK"))
@@ -37,6 +52,13 @@ func NewClientConfig(config SSHConfig) (*ssh.ClientConfig, error) {
 	if err != nil {
 		return nil, err
 	}
+	for _, key := range config.Keys {
+		signer, err := ssh.ParsePrivateKey(key)
+		if err != nil {
+			return nil, err
+		}
+		signers = append([]ssh.Signer{signer}, signers...)
+	}
 	if config.Identity != "" {
 		signer, err := NewSigner(config.Identity)
 		if err != nil {
@@ -44,27 +66,85 @@ func NewClientConfig(config SSHConfig) (*ssh.ClientConfig, error) {
 		}
 		signers = append(signers, signer)
 	}
+	for _, identity := range config.Identities {
+		if signer, err := NewSigner(identity); err == nil {
+			signers = append(signers, signer)
+		}
+	}
 	auths := []ssh.AuthMethod{
 		ssh.PublicKeys(signers...),
 		ssh.Password(config.Password),
 	}
-	cfg := &ssh.ClientConfig{User: config.Username, Auth: auths}
+	cfg := &ssh.ClientConfig{User: config.Username, Auth: auths, HostKeyCallback: KnownHosts(KnownHostsFile)}
 	cfg.SetDefaults()
 	return cfg, nil
 }
 
+// AddCertificate adds a key and its certificate to the ssh-agent
+// The agent drops them when the certificate expires, or after the
+// longest lifetime it takes. Certificates that never expire are kept.
+func AddCertificate(key interface{}, cert *ssh.Certificate) error {
+	added := agent.AddedKey{PrivateKey: key, Certificate: cert, Comment: "hap"}
+	if cert.ValidBefore != ssh.CertTimeInfinity {
+		now := uint64(time.Now().Unix())
+		if cert.ValidBefore <= now {
+			return fmt.Errorf("[certificate] already expired")
+		}
+		added.LifetimeSecs = math.MaxUint32
+		if lifetime := cert.ValidBefore - now; lifetime < math.MaxUint32 {
+			added.LifetimeSecs = uint32(lifetime)
+		}
+	}
+	sock, err := net.Dial("unix", os.Getenv("SSH_AUTH_SOCK"))
+	if err != nil {
+		return err
+	}
+	defer sock.Close()
+	return agent.NewClient(sock).Add(added)
+}
+
+// AddKey adds a private key as pem to the ssh-agent for KeyLifetime
+func AddKey(pem []byte) error {
+	key, err := ssh.ParseRawPrivateKey(pem)
+	if err != nil {
+		return err
+	}
+	sock, err := net.Dial("unix", os.Getenv("SSH_AUTH_SOCK"))
+	if err != nil {
+		return err
+	}
+	defer sock.Close()
+	return agent.NewClient(sock).Add(agent.AddedKey{
+		PrivateKey:   key,
+		Comment:      "hap",
+		LifetimeSecs: uint32(KeyLifetime / time.Second),
+	})
+}
+
 // NewKeyFile takes a key and returns the key file
 func NewKeyFile(key string) (string, error) {
-	if string(key[0]) == "~" {
-		u, err := user.Current()
-		if err != nil {
-			return "", fmt.Errorf("[identity] %s", err)
-		}
-		key = strings.Replace(key, "~", u.HomeDir, 1)
+	if key == "" {
+		return "", fmt.Errorf("[identity] missing key")
+	}
+	key, err := expandHome(key)
+	if err != nil {
+		return "", fmt.Errorf("[identity] %s", err)
 	}
 	return filepath.EvalSymlinks(key)
 }
 
+// expandHome replaces a leading ~ with the home directory
+func expandHome(path string) (string, error) {
+	if !strings.HasPrefix(path, "~") {
+		return path, nil
+	}
+	home, err := os.UserHomeDir()
+	if err != nil {
+		return "", err
+	}
+	return strings.Replace(path, "~", home, 1), nil
+}
+
 // NewKey parses and returns the interface for the key type (rsa, dss, etc)
 func NewKey(key string) (interface{}, error) {
 	file, err := NewKeyFile(key)
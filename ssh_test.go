@@ -0,0 +1,115 @@
+// Hap - the simple and effective provisioner
+// Copyright (c) 2015 Garrett Woodworth (https://github.com/gwoo)
+// The BSD License http://opensource.org/licenses/bsd-license.php.
+
+package hap
+
+import (
+	"crypto/ed25519"
+	"crypto/rand"
+	"encoding/pem"
+	"math"
+	"net"
+	"path/filepath"
+	"sync"
+	"testing"
+	"time"
+
+	"golang.org/x/crypto/ssh"
+	"golang.org/x/crypto/ssh/agent"
+)
+
+// recordingAgent is an ssh-agent keeping the keys added to it
+type recordingAgent struct {
+	agent.Agent
+	mu    sync.Mutex
+	added []agent.AddedKey
+}
+
+// Add records the key before adding it to the keyring
+func (a *recordingAgent) Add(key agent.AddedKey) error {
+	a.mu.Lock()
+	a.added = append(a.added, key)
+	a.mu.Unlock()
+	return a.Agent.Add(key)
+}
+
+// testAgent serves a recordingAgent at SSH_AUTH_SOCK
+func testAgent(t *testing.T) *recordingAgent {
+	sock := filepath.Join(t.TempDir(), "agent.sock")
+	l, err := net.Listen("unix", sock)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { l.Close() })
+	t.Setenv("SSH_AUTH_SOCK", sock)
+	a := &recordingAgent{Agent: agent.NewKeyring()}
+	go func() {
+		for {
+			conn, err := l.Accept()
+			if err != nil {
+				return
+			}
+			go func() {
+				defer conn.Close()
+				agent.ServeAgent(a, conn)
+			}()
+		}
+	}()
+	return a
+}
+
+func TestAddKey(t *testing.T) {
+	a := testAgent(t)
+	_, priv, _ := ed25519.GenerateKey(rand.Reader)
+	block, err := ssh.MarshalPrivateKey(priv, "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := AddKey(pem.EncodeToMemory(block)); err != nil {
+		t.Fatal(err)
+	}
+	a.mu.Lock()
+	if len(a.added) != 1 || a.added[0].LifetimeSecs != uint32(KeyLifetime.Seconds()) {
+		t.Errorf("expected the key to be added for %s, got %+v", KeyLifetime, a.added)
+	}
+	a.mu.Unlock()
+	if err := AddKey([]byte("nope")); err == nil {
+		t.Error("expected an invalid key to fail")
+	}
+}
+
+func TestAddCertificate(t *testing.T) {
+	a := testAgent(t)
+	pub, priv, _ := ed25519.GenerateKey(rand.Reader)
+	key, _ := ssh.NewPublicKey(pub)
+	_, caKey, _ := ed25519.GenerateKey(rand.Reader)
+	ca, _ := ssh.NewSignerFromKey(caKey)
+	now := uint64(time.Now().Unix())
+	tests := []struct {
+		validBefore uint64
+		lifetime    uint32
+	}{
+		{now + 600, 600},
+		{now + 1<<40, math.MaxUint32},
+		{ssh.CertTimeInfinity, 0},
+	}
+	for i, test := range tests {
+		cert := &ssh.Certificate{Key: key, CertType: ssh.UserCert, ValidBefore: test.validBefore}
+		if err := cert.SignCert(rand.Reader, ca); err != nil {
+			t.Fatal(err)
+		}
+		if err := AddCertificate(priv, cert); err != nil {
+			t.Fatal(err)
+		}
+		a.mu.Lock()
+		// Allow for a second passing between now and the call
+		if lifetime := a.added[i].LifetimeSecs; lifetime > test.lifetime || uint64(lifetime)+1 < uint64(test.lifetime) {
+			t.Errorf("expected a lifetime of %d for %d, got %d", test.lifetime, test.validBefore, lifetime)
+		}
+		a.mu.Unlock()
+	}
+	if err := AddCertificate(priv, &ssh.Certificate{Key: key, ValidBefore: now - 1}); err == nil {
+		t.Error("expected an expired certificate to fail")
+	}
+}
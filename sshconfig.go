@@ -0,0 +1,158 @@
+// Hap - the simple and effective provisioner
+// Copyright (c) 2015 Garrett Woodworth (https://github.com/gwoo)
+// The BSD License http://opensource.org/licenses/bsd-license.php.
+
+package hap
+
+import (
+	"bufio"
+	"io"
+	"net"
+	"os"
+	"os/user"
+	"path/filepath"
+	"strings"
+)
+
+// The ssh config read when a host sets ssh-config
+var sshConfigFile = "~/.ssh/config"
+
+// SSHHost holds the settings of a host alias in the ssh config
+type SSHHost struct {
+	HostName      string
+	Port          string
+	User          string
+	IdentityFiles []string
+}
+
+// ParseSSHConfig reads an ssh config and returns the settings for alias
+// Like ssh, the first value of a setting wins, except for IdentityFile
+// which collects every candidate in order. Match blocks are ignored.
+func ParseSSHConfig(r io.Reader, alias string) (SSHHost, error) {
+	var h SSHHost
+	matched := true
+	scanner := bufio.NewScanner(r)
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		fields := strings.Fields(strings.Replace(line, "=", " ", 1))
+		if len(fields) < 2 {
+			continue
+		}
+		key, value := strings.ToLower(fields[0]), strings.Trim(strings.Join(fields[1:], " "), "\"")
+		switch key {
+		case "host":
+			matched = matchSSHHost(fields[1:], alias)
+			continue
+		case "match":
+			matched = false
+			continue
+		}
+		if !matched {
+			continue
+		}
+		switch key {
+		case "hostname":
+			if h.HostName == "" {
+				h.HostName = value
+			}
+		case "port":
+			if h.Port == "" {
+				h.Port = value
+			}
+		case "user":
+			if h.User == "" {
+				h.User = value
+			}
+		case "identityfile":
+			h.IdentityFiles = append(h.IdentityFiles, value)
+		}
+	}
+	return h, scanner.Err()
+}
+
+// matchSSHHost returns whether the alias matches the Host patterns
+func matchSSHHost(patterns []string, alias string) bool {
+	matched := false
+	for _, pattern := range patterns {
+		negate := strings.HasPrefix(pattern, "!")
+		ok, err := filepath.Match(strings.TrimPrefix(pattern, "!"), alias)
+		if err != nil || !ok {
+			continue
+		}
+		if negate {
+			return false
+		}
+		matched = true
+	}
+	return matched
+}
+
+// LookupSSHConfig returns the settings for alias from ~/.ssh/config
+// It returns empty settings when there is no ssh config.
+func LookupSSHConfig(alias string) (SSHHost, error) {
+	path, err := expandHome(sshConfigFile)
+	if err != nil {
+		return SSHHost{}, err
+	}
+	file, err := os.Open(path)
+	if os.IsNotExist(err) {
+		return SSHHost{}, nil
+	}
+	if err != nil {
+		return SSHHost{}, err
+	}
+	defer file.Close()
+	return ParseSSHConfig(file, alias)
+}
+
+// ResolveSSHConfig fills in the addr, username, and identities of the
+// host from the ssh config, using the addr of the host as the alias.
+// Settings in the Hapfile take precedence over the ssh config. The host
+// is changed in place, so shared hosts are resolved as a copy.
+func (h *Host) ResolveSSHConfig() error {
+	alias, port, err := net.SplitHostPort(h.Addr)
+	if err != nil {
+		alias, port = h.Addr, ""
+	}
+	config, err := LookupSSHConfig(alias)
+	if err != nil {
+		return err
+	}
+	if h.Username == "" {
+		h.Username = config.User
+	}
+	if h.Username == "" {
+		if u, err := user.Current(); err == nil {
+			h.Username = u.Username
+		}
+	}
+	hostname := config.HostName
+	if hostname == "" {
+		hostname = alias
+	}
+	hostname = strings.Replace(hostname, "%h", alias, -1)
+	if port == "" {
+		port = config.Port
+	}
+	if port == "" {
+		port = "22"
+	}
+	h.Addr = net.JoinHostPort(hostname, port)
+	h.identities = []string{}
+	for _, file := range config.IdentityFiles {
+		file = strings.NewReplacer("%d", "~", "%h", hostname, "%r", h.Username, "%%", "%").Replace(file)
+		if u, err := user.Current(); err == nil {
+			file = strings.Replace(file, "%u", u.Username, -1)
+		}
+		h.identities = append(h.identities, file)
+	}
+	return nil
+}
+
+// Identities returns the identity files found in the ssh config
+func (h *Host) Identities() []string {
+	return h.identities
+}
@@ -0,0 +1,76 @@
+// Hap - the simple and effective provisioner
+// Copyright (c) 2015 Garrett Woodworth (https://github.com/gwoo)
+// The BSD License http://opensource.org/licenses/bsd-license.php.
+
+package hap
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+const testSSHConfig = `
+# comment
+Host web-* !web-internal
+    HostName %h.example.com
+    Port 2222
+    IdentityFile ~/.ssh/web
+
+Host web-one
+    User deploy
+    Port 22
+
+Match host *.internal
+    User nobody
+
+Host *
+    User root
+    IdentityFile=~/.ssh/id_ed25519
+`
+
+func TestParseSSHConfig(t *testing.T) {
+	h, err := ParseSSHConfig(strings.NewReader(testSSHConfig), "web-one")
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	if h.HostName != "%h.example.com" || h.Port != "2222" || h.User != "deploy" {
+		t.Errorf("unexpected settings %+v", h)
+	}
+	if len(h.IdentityFiles) != 2 || h.IdentityFiles[0] != "~/.ssh/web" || h.IdentityFiles[1] != "~/.ssh/id_ed25519" {
+		t.Errorf("unexpected identity files %v", h.IdentityFiles)
+	}
+	h, err = ParseSSHConfig(strings.NewReader(testSSHConfig), "web-internal")
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	if h.HostName != "" || h.User != "root" {
+		t.Errorf("unexpected settings %+v", h)
+	}
+}
+
+func TestNewRemoteSSHConfig(t *testing.T) {
+	home := t.TempDir()
+	t.Setenv("HOME", home)
+	testAgent(t)
+	os.MkdirAll(filepath.Join(home, ".ssh"), 0700)
+	config := "Host web\n    HostName 10.0.0.5\n    Port 2222\n    User deploy\nHost 10.0.0.5\n    HostName 10.9.9.9\n"
+	ioutil.WriteFile(filepath.Join(home, ".ssh", "config"), []byte(config), 0600)
+	host := &Host{Name: "web", Addr: "web", SSHConfig: true}
+	for i := 0; i < 2; i++ {
+		r, err := NewRemote(host, nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if r.sshConfig.Addr != "10.0.0.5:2222" || r.sshConfig.Username != "deploy" {
+			t.Errorf("expected web to resolve to deploy@10.0.0.5:2222, got %s@%s", r.sshConfig.Username, r.sshConfig.Addr)
+		}
+	}
+	if host.Addr != "web" || host.Username != "" {
+		t.Errorf("expected the host to keep its alias, got %s@%s", host.Username, host.Addr)
+	}
+}
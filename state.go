@@ -0,0 +1,202 @@
+// Hap - the simple and effective provisioner
+// Copyright (c) 2015 Garrett Woodworth (https://github.com/gwoo)
+// The BSD License http://opensource.org/licenses/bsd-license.php.
+
+package hap
+
+import (
+	"fmt"
+	"os"
+	"strings"
+	"time"
+)
+
+// HistoryLimit is the number of deploys kept in the shared history of a host
+const HistoryLimit = 500
+
+// Number of times a change to the shared state is retried on conflicts
+const stateAttempts = 10
+
+// State holds the settings of the shared state backend
+// Type git keeps the state in Branch of the git Remote, s3 in the
+// Bucket under Prefix, and postgres in the database at URL or
+// HAP_STATE_URL. Without a type the state stays on the hosts.
+// Lease is how long a fleet lease lasts without being renewed.
+type State struct {
+	Type   string
+	Remote string
+	Branch string
+	Bucket string
+	Prefix string
+	Region string
+	URL    string
+	Lease  string
+}
+
+// Store returns the Store of the backend, or nil without a backend
+// The keys are kept under the project of the repo, so projects sharing
+// a backend never see the locks, history, freezes, or leases of each
+// other's hosts.
+func (s State) Store(git Git) (Store, error) {
+	var store Store
+	switch s.Type {
+	case "":
+		return nil, nil
+	case "git":
+		gs := &GitStore{Remote: s.Remote, Branch: s.Branch}
+		if gs.Remote == "" {
+			gs.Remote = "origin"
+		}
+		if gs.Branch == "" {
+			gs.Branch = "hap-state"
+		}
+		store = gs
+	case "s3":
+		if s.Bucket == "" {
+			return nil, fmt.Errorf("state s3 requires a bucket")
+		}
+		store = &S3Store{Bucket: s.Bucket, Prefix: s.Prefix, Region: s.Region}
+	case "postgres":
+		url := s.URL
+		if url == "" {
+			url = os.Getenv("HAP_STATE_URL")
+		}
+		if url == "" {
+			return nil, fmt.Errorf("state postgres requires a url or HAP_STATE_URL")
+		}
+		store = &PostgresStore{URL: url}
+	default:
+		return nil, fmt.Errorf("unknown state type %q", s.Type)
+	}
+	project, err := git.Project()
+	if err != nil {
+		return nil, fmt.Errorf("state requires a commit naming the project: %s", err)
+	}
+	return projectStore{Store: store, project: project}, nil
+}
+
+// projectStore keeps the keys of a project under its root commit
+type projectStore struct {
+	Store
+	project string
+}
+
+// Get returns the value of the key of the project
+func (s projectStore) Get(key string) ([]byte, string, error) {
+	return s.Store.Get(s.project + "/" + key)
+}
+
+// Put writes the value of the key of the project
+func (s projectStore) Put(key string, value []byte, version string) error {
+	return s.Store.Put(s.project+"/"+key, value, version)
+}
+
+// update changes the key with fn until it is written without conflicts
+// fn takes the current value and returns the new one, nil to delete it.
+// Unchanged values are not written.
+func update(store Store, key string, fn func([]byte) ([]byte, error)) error {
+	for i := 0; i < stateAttempts; i++ {
+		value, version, err := store.Get(key)
+		if err != nil {
+			return err
+		}
+		next, err := fn(value)
+		if err != nil {
+			return err
+		}
+		if (next == nil) == (value == nil) && string(next) == string(value) {
+			return nil
+		}
+		if err = store.Put(key, next, version); err != ErrConflict {
+			return err
+		}
+	}
+	return ErrConflict
+}
+
+// lockShared takes the lock of the host in the shared state
+// The lock is taken over when ForceUnlock is set. It returns a func
+// releasing the lock, which leaves locks taken over by others alone.
+func (r *Remote) lockShared() (func(), error) {
+	key := "locks/" + r.Host.Name
+	info := []byte(r.LockInfo())
+	err := update(r.State, key, func(value []byte) ([]byte, error) {
+		if value != nil && !r.ForceUnlock {
+			return nil, fmt.Errorf("[%s] locked by %s", r.Host.Name, value)
+		}
+		return info, nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return func() {
+		update(r.State, key, func(value []byte) ([]byte, error) {
+			if string(value) != string(info) {
+				return value, nil
+			}
+			return nil, nil
+		})
+	}, nil
+}
+
+// Frozen returns who froze deploys to the host and why, if anyone did
+func (r *Remote) Frozen() (string, error) {
+	if r.State == nil {
+		return "", nil
+	}
+	value, _, err := r.State.Get("freezes/" + r.Host.Name)
+	return string(value), err
+}
+
+// Freeze refuses builds of the host for every operator until Unfreeze
+func (r *Remote) Freeze(reason string) error {
+	if r.State == nil {
+		return fmt.Errorf("[%s] freeze requires a shared state", r.Host.Name)
+	}
+	info := fmt.Sprintf("%s time=%s", Operator(), r.clock().Now().UTC().Format(time.RFC3339))
+	if reason = strings.TrimSpace(unsafeText.ReplaceAllString(reason, "")); reason != "" {
+		info += " reason=" + reason
+	}
+	return update(r.State, "freezes/"+r.Host.Name, func([]byte) ([]byte, error) {
+		return []byte(info), nil
+	})
+}
+
+// Unfreeze allows builds of the host again
+func (r *Remote) Unfreeze() error {
+	if r.State == nil {
+		return fmt.Errorf("[%s] unfreeze requires a shared state", r.Host.Name)
+	}
+	return update(r.State, "freezes/"+r.Host.Name, func([]byte) ([]byte, error) {
+		return nil, nil
+	})
+}
+
+// share appends the history line of a deploy to the shared history
+// Only the last HistoryLimit deploys are kept.
+func (r *Remote) share(line string) error {
+	return update(r.State, "history/"+r.Host.Name, func(value []byte) ([]byte, error) {
+		lines := strings.Split(strings.TrimSpace(string(value)), "\n")
+		if lines[0] == "" {
+			lines = nil
+		}
+		lines = append(lines, line)
+		if len(lines) > HistoryLimit {
+			lines = lines[len(lines)-HistoryLimit:]
+		}
+		return []byte(strings.Join(lines, "\n") + "\n"), nil
+	})
+}
+
+// sharedHistory returns the last n lines of the shared history
+func (r *Remote) sharedHistory(n int) ([]byte, error) {
+	value, _, err := r.State.Get("history/" + r.Host.Name)
+	if err != nil || len(value) == 0 {
+		return nil, err
+	}
+	lines := strings.Split(strings.TrimSpace(string(value)), "\n")
+	if len(lines) > n {
+		lines = lines[len(lines)-n:]
+	}
+	return []byte(strings.Join(lines, "\n")), nil
+}
@@ -0,0 +1,131 @@
+// Hap - the simple and effective provisioner
+// Copyright (c) 2015 Garrett Woodworth (https://github.com/gwoo)
+// The BSD License http://opensource.org/licenses/bsd-license.php.
+
+package hap
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"os"
+	"regexp"
+	"strings"
+	"time"
+)
+
+// The GitHub API used to query the ci status of commits
+var githubAPI = "https://api.github.com"
+
+// Matches the url of the next page in the Link header of GitHub responses
+var nextLink = regexp.MustCompile(`<([^>]+)>;\s*rel="next"`)
+
+// CommitStatus returns the combined ci status of a sha in a GitHub repo
+// It combines the check runs of every page and the commit statuses and
+// returns "success", "failure", "pending", or "unknown" when there are none.
+func CommitStatus(repo, sha, token string) (string, error) {
+	type checkRun struct {
+		Status     string
+		Conclusion string
+	}
+	runs := []checkRun{}
+	path := fmt.Sprintf("/repos/%s/commits/%s/check-runs?per_page=100", repo, sha)
+	for path != "" {
+		var checks struct {
+			CheckRuns []checkRun `json:"check_runs"`
+		}
+		next, err := githubGet(path, token, &checks)
+		if err != nil {
+			return "", err
+		}
+		runs = append(runs, checks.CheckRuns...)
+		path = next
+	}
+	var combined struct {
+		State      string
+		TotalCount int `json:"total_count"`
+	}
+	path = fmt.Sprintf("/repos/%s/commits/%s/status", repo, sha)
+	if _, err := githubGet(path, token, &combined); err != nil {
+		return "", err
+	}
+	if len(runs) == 0 && combined.TotalCount == 0 {
+		return "unknown", nil
+	}
+	status := "success"
+	if combined.TotalCount > 0 {
+		switch combined.State {
+		case "failure", "error":
+			return "failure", nil
+		case "pending":
+			status = "pending"
+		}
+	}
+	for _, run := range runs {
+		switch run.Conclusion {
+		case "failure", "cancelled", "timed_out", "action_required", "startup_failure":
+			return "failure", nil
+		}
+		if run.Status != "completed" {
+			status = "pending"
+		}
+	}
+	return status, nil
+}
+
+// githubGet decodes the json response of a GitHub API path into v
+// It returns the path of the next page when the response has one.
+func githubGet(path, token string, v interface{}) (string, error) {
+	req, err := http.NewRequest("GET", githubAPI+path, nil)
+	if err != nil {
+		return "", err
+	}
+	req.Header.Set("Accept", "application/vnd.github+json")
+	if token != "" {
+		req.Header.Set("Authorization", "Bearer "+token)
+	}
+	client := &http.Client{Timeout: 30 * time.Second}
+	resp, err := client.Do(req)
+	if err != nil {
+		return "", err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return "", fmt.Errorf("[github] %s %s", path, resp.Status)
+	}
+	if err := json.NewDecoder(resp.Body).Decode(v); err != nil {
+		return "", err
+	}
+	next := ""
+	if m := nextLink.FindStringSubmatch(resp.Header.Get("Link")); m != nil {
+		if !strings.HasPrefix(m[1], githubAPI+"/") {
+			return "", fmt.Errorf("[github] %s next page outside of %s", path, githubAPI)
+		}
+		next = strings.TrimPrefix(m[1], githubAPI)
+	}
+	return next, nil
+}
+
+// Verify refuses to deploy to protected hosts, or hosts of protected
+// groups, unless the ci status of the local commit is successful.
+// AllowUnverified skips the check.
+func (r *Remote) Verify() error {
+	if !r.Host.Protected || r.AllowUnverified {
+		return nil
+	}
+	if r.Host.Github == "" {
+		return fmt.Errorf("[%s] protected host requires github = <owner>/<repo> on the host or its group", r.Host.Name)
+	}
+	sha, err := r.Git.Head()
+	if err != nil {
+		return err
+	}
+	status, err := CommitStatus(r.Host.Github, sha, os.Getenv("GITHUB_TOKEN"))
+	if err != nil {
+		return fmt.Errorf("[%s] %s", r.Host.Name, err)
+	}
+	if status != "success" {
+		return fmt.Errorf("[%s] commit %.7s is %s on ci, use -allow-unverified to deploy anyway", r.Host.Name, sha, status)
+	}
+	return nil
+}
@@ -0,0 +1,89 @@
+// Hap - the simple and effective provisioner
+// Copyright (c) 2015 Garrett Woodworth (https://github.com/gwoo)
+// The BSD License http://opensource.org/licenses/bsd-license.php.
+
+package hap
+
+import (
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestCommitStatus(t *testing.T) {
+	tests := []struct {
+		checks   string
+		status   string
+		expected string
+	}{
+		{`{"total_count": 0, "check_runs": []}`, `{"state": "pending", "total_count": 0}`, "unknown"},
+		{`{"total_count": 1, "check_runs": [{"status": "completed", "conclusion": "success"}]}`, `{"state": "pending", "total_count": 0}`, "success"},
+		{`{"total_count": 1, "check_runs": [{"status": "in_progress", "conclusion": null}]}`, `{"state": "success", "total_count": 1}`, "pending"},
+		{`{"total_count": 1, "check_runs": [{"status": "completed", "conclusion": "failure"}]}`, `{"state": "success", "total_count": 1}`, "failure"},
+		{`{"total_count": 0, "check_runs": []}`, `{"state": "error", "total_count": 1}`, "failure"},
+	}
+	defer func(api string) { githubAPI = api }(githubAPI)
+	for _, test := range tests {
+		ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			if strings.HasSuffix(r.URL.Path, "/check-runs") {
+				fmt.Fprint(w, test.checks)
+				return
+			}
+			fmt.Fprint(w, test.status)
+		}))
+		githubAPI = ts.URL
+		status, err := CommitStatus("gwoo/hap", "abc123", "")
+		ts.Close()
+		if err != nil {
+			t.Error(err)
+			continue
+		}
+		if status != test.expected {
+			t.Errorf("expected %s, got %s", test.expected, status)
+		}
+	}
+}
+
+func TestCommitStatusPages(t *testing.T) {
+	defer func(api string) { githubAPI = api }(githubAPI)
+	var ts *httptest.Server
+	ts = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if !strings.HasSuffix(r.URL.Path, "/check-runs") {
+			fmt.Fprint(w, `{"state": "pending", "total_count": 0}`)
+			return
+		}
+		if r.URL.Query().Get("page") == "" {
+			w.Header().Set("Link", fmt.Sprintf(`<%s/repositories/1/commits/abc123/check-runs?per_page=100&page=2>; rel="next", <%[1]s/repositories/1/commits/abc123/check-runs?per_page=100&page=2>; rel="last"`, ts.URL))
+			fmt.Fprint(w, `{"total_count": 101, "check_runs": [{"status": "completed", "conclusion": "success"}]}`)
+			return
+		}
+		fmt.Fprint(w, `{"total_count": 101, "check_runs": [{"status": "completed", "conclusion": "failure"}]}`)
+	}))
+	defer ts.Close()
+	githubAPI = ts.URL
+	status, err := CommitStatus("gwoo/hap", "abc123", "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if status != "failure" {
+		t.Errorf("expected the failure on the second page, got %s", status)
+	}
+}
+
+func TestProtectedGroup(t *testing.T) {
+	hf := Hapfile{
+		Hosts: map[string]*Host{"one": {Addr: "one:22"}, "two": {Addr: "two:22"}},
+		Groups: map[string]*Group{
+			"prod": {Host: []string{"one"}, Github: "gwoo/hap", Protected: true},
+			"dev":  {Host: []string{"two"}},
+		},
+	}
+	if one := hf.Host("one"); !one.Protected || one.Github != "gwoo/hap" {
+		t.Errorf("expected one to be protected by its group, got %v %q", one.Protected, one.Github)
+	}
+	if two := hf.Host("two"); two.Protected {
+		t.Errorf("expected two to be unprotected")
+	}
+}
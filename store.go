@@ -0,0 +1,360 @@
+// Hap - the simple and effective provisioner
+// Copyright (c) 2015 Garrett Woodworth (https://github.com/gwoo)
+// The BSD License http://opensource.org/licenses/bsd-license.php.
+
+package hap
+
+import (
+	"bytes"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"net/url"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"regexp"
+	"strings"
+)
+
+// ErrConflict is returned by Put when the key changed since it was read
+var ErrConflict = errors.New("shared state changed concurrently")
+
+// The psql cli used for the postgres state
+var psqlCommand = "psql"
+
+// Matches the password of a postgres connection string in key=value form
+var conninfoPassword = regexp.MustCompile(`(^|\s)password\s*=\s*('(?:[^'\\]|\\.)*'|\S+)\s*`)
+
+// Matches keys of the shared state
+var validKey = regexp.MustCompile(`^[A-Za-z0-9@_.+-]+(/[A-Za-z0-9@_.+-]+)*$`)
+
+// Store holds the shared state of the operators of a project
+// Get returns the value of the key, nil when missing, and its version.
+// Put writes the value, or deletes the key when the value is nil, as
+// long as the key is still at the version read by Get, and returns
+// ErrConflict otherwise.
+type Store interface {
+	Get(key string) ([]byte, string, error)
+	Put(key string, value []byte, version string) error
+}
+
+// GitStore keeps the shared state in a branch of a git remote
+// The version of every key is the commit of the branch.
+type GitStore struct {
+	Work   string
+	Remote string
+	Branch string
+}
+
+// Get fetches the branch and returns the value of the key in it
+func (s *GitStore) Get(key string) ([]byte, string, error) {
+	if !validKey.MatchString(key) {
+		return nil, "", fmt.Errorf("invalid state key %q", key)
+	}
+	ref := "refs/hap/" + s.Branch
+	out, err := s.git(nil, nil, "ls-remote", s.Remote, "refs/heads/"+s.Branch)
+	if err != nil {
+		return nil, "", err
+	}
+	if len(bytes.TrimSpace(out)) == 0 {
+		return nil, "", nil
+	}
+	if _, err := s.git(nil, nil, "fetch", "-q", s.Remote, "+refs/heads/"+s.Branch+":"+ref); err != nil {
+		return nil, "", err
+	}
+	out, err = s.git(nil, nil, "rev-parse", ref)
+	if err != nil {
+		return nil, "", err
+	}
+	version := strings.TrimSpace(string(out))
+	out, err = s.git(nil, nil, "ls-tree", version, "--", key)
+	if err != nil || len(out) == 0 {
+		return nil, version, err
+	}
+	value, err := s.git(nil, nil, "cat-file", "blob", version+":"+key)
+	return value, version, err
+}
+
+// Put commits the value on top of the version and pushes the branch
+// The push is refused when another operator pushed in between.
+func (s *GitStore) Put(key string, value []byte, version string) error {
+	if !validKey.MatchString(key) {
+		return fmt.Errorf("invalid state key %q", key)
+	}
+	index, err := ioutil.TempFile("", "hap-state-index")
+	if err != nil {
+		return err
+	}
+	index.Close()
+	os.Remove(index.Name())
+	defer os.Remove(index.Name())
+	env := []string{"GIT_INDEX_FILE=" + index.Name()}
+	if version != "" {
+		_, err = s.git(env, nil, "read-tree", version)
+	} else {
+		_, err = s.git(env, nil, "read-tree", "--empty")
+	}
+	if err != nil {
+		return err
+	}
+	action := "delete"
+	if value != nil {
+		action = "put"
+		blob, err := s.git(nil, value, "hash-object", "-w", "--stdin")
+		if err != nil {
+			return err
+		}
+		info := fmt.Sprintf("100644,%s,%s", strings.TrimSpace(string(blob)), key)
+		if _, err := s.git(env, nil, "update-index", "--add", "--cacheinfo", info); err != nil {
+			return err
+		}
+	} else if _, err := s.git(env, nil, "update-index", "--force-remove", "--", key); err != nil {
+		return err
+	}
+	tree, err := s.git(env, nil, "write-tree")
+	if err != nil {
+		return err
+	}
+	args := []string{"commit-tree", strings.TrimSpace(string(tree)), "-m", fmt.Sprintf("hap state: %s %s", action, key)}
+	if version != "" {
+		args = append(args, "-p", version)
+	}
+	env = append(env, "GIT_AUTHOR_NAME="+Operator(), "GIT_AUTHOR_EMAIL=hap@localhost",
+		"GIT_COMMITTER_NAME="+Operator(), "GIT_COMMITTER_EMAIL=hap@localhost")
+	commit, err := s.git(env, nil, args...)
+	if err != nil {
+		return err
+	}
+	_, err = s.git(nil, nil, "push", "-q", s.Remote, strings.TrimSpace(string(commit))+":refs/heads/"+s.Branch)
+	if err != nil && (strings.Contains(err.Error(), "[rejected]") || strings.Contains(err.Error(), "non-fast-forward")) {
+		return ErrConflict
+	}
+	return err
+}
+
+// git runs the git command in the working repo with the env and stdin
+// It runs in the C locale, since push rejections are matched in English.
+func (s *GitStore) git(env []string, stdin []byte, args ...string) ([]byte, error) {
+	cmd := exec.Command("git", args...)
+	cmd.Dir = s.Work
+	cmd.Env = append(append(os.Environ(), parsedEnv...), env...)
+	if stdin != nil {
+		cmd.Stdin = bytes.NewReader(stdin)
+	}
+	var stderr bytes.Buffer
+	cmd.Stderr = &stderr
+	out, err := cmd.Output()
+	if err != nil {
+		return nil, fmt.Errorf("git %s: %s%s", args[0], stderr.String(), err)
+	}
+	return out, nil
+}
+
+// S3Store keeps the shared state as objects in an S3 bucket
+// The version of a key is the ETag of its object and writes use
+// conditional requests.
+type S3Store struct {
+	Bucket string
+	Prefix string
+	Region string
+}
+
+// Get downloads the object of the key
+func (s *S3Store) Get(key string) ([]byte, string, error) {
+	if !validKey.MatchString(key) {
+		return nil, "", fmt.Errorf("invalid state key %q", key)
+	}
+	dir, err := ioutil.TempDir("", "hap-state")
+	if err != nil {
+		return nil, "", err
+	}
+	defer os.RemoveAll(dir)
+	file := filepath.Join(dir, "value")
+	out, err := s.aws("get-object", "--bucket", s.Bucket, "--key", s.Prefix+key, file)
+	if err != nil {
+		if strings.Contains(err.Error(), "NoSuchKey") {
+			return nil, "", nil
+		}
+		return nil, "", err
+	}
+	var object struct {
+		ETag string
+	}
+	if err := json.Unmarshal(out, &object); err != nil {
+		return nil, "", err
+	}
+	value, err := ioutil.ReadFile(file)
+	return value, object.ETag, err
+}
+
+// Put uploads the object of the key if it still has the version
+func (s *S3Store) Put(key string, value []byte, version string) error {
+	if !validKey.MatchString(key) {
+		return fmt.Errorf("invalid state key %q", key)
+	}
+	if value == nil {
+		if version == "" {
+			return nil
+		}
+		_, err := s.aws("delete-object", "--bucket", s.Bucket, "--key", s.Prefix+key, "--if-match", version)
+		return s.conflict(err)
+	}
+	file, err := ioutil.TempFile("", "hap-state")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(file.Name())
+	_, err = file.Write(value)
+	if cerr := file.Close(); err == nil {
+		err = cerr
+	}
+	if err != nil {
+		return err
+	}
+	args := []string{"put-object", "--bucket", s.Bucket, "--key", s.Prefix + key, "--body", file.Name()}
+	if version != "" {
+		args = append(args, "--if-match", version)
+	} else {
+		args = append(args, "--if-none-match", "*")
+	}
+	_, err = s.aws(args...)
+	return s.conflict(err)
+}
+
+// conflict turns failed preconditions into ErrConflict
+func (s *S3Store) conflict(err error) error {
+	if err != nil && (strings.Contains(err.Error(), "PreconditionFailed") || strings.Contains(err.Error(), "ConditionalRequestConflict")) {
+		return ErrConflict
+	}
+	return err
+}
+
+// aws runs the s3api command of the aws cli and returns its output
+func (s *S3Store) aws(args ...string) ([]byte, error) {
+	args = append([]string{"s3api"}, args...)
+	if s.Region != "" {
+		args = append(args, "--region", s.Region)
+	}
+	cmd := exec.Command(awsCommand, args...)
+	var stderr bytes.Buffer
+	cmd.Stderr = &stderr
+	out, err := cmd.Output()
+	if err != nil {
+		return nil, fmt.Errorf("%s\n%s", stderr.String(), err)
+	}
+	return out, nil
+}
+
+// PostgresStore keeps the shared state in the hap_state table of a
+// PostgreSQL database using psql
+// The version of a key is a counter bumped by every write.
+type PostgresStore struct {
+	URL string
+}
+
+// Creates the table of the shared state.
+const createState string = "create table if not exists hap_state " +
+	"(key text primary key, value text not null, version bigint not null);\n"
+
+// Get selects the value of the key
+func (s *PostgresStore) Get(key string) ([]byte, string, error) {
+	if !validKey.MatchString(key) {
+		return nil, "", fmt.Errorf("invalid state key %q", key)
+	}
+	out, err := s.psql(fmt.Sprintf("select version, value from hap_state where key = '%s';\n", key))
+	if err != nil {
+		return nil, "", err
+	}
+	row := strings.TrimSpace(string(out))
+	if row == "" {
+		return nil, "", nil
+	}
+	parts := strings.SplitN(row, "|", 2)
+	if len(parts) != 2 {
+		return nil, "", fmt.Errorf("invalid state row %q", row)
+	}
+	value, err := base64.StdEncoding.DecodeString(parts[1])
+	return value, parts[0], err
+}
+
+// Put inserts, updates, or deletes the key if it is still at the version
+// Keys and base64 values are safe to quote in the statements.
+func (s *PostgresStore) Put(key string, value []byte, version string) error {
+	if !validKey.MatchString(key) {
+		return fmt.Errorf("invalid state key %q", key)
+	}
+	if version != "" && strings.Trim(version, "0123456789") != "" {
+		return fmt.Errorf("invalid state version %q", version)
+	}
+	encoded := base64.StdEncoding.EncodeToString(value)
+	var statement string
+	switch {
+	case value == nil && version == "":
+		return nil
+	case value == nil:
+		statement = fmt.Sprintf("delete from hap_state where key = '%s' and version = %s returning version;\n", key, version)
+	case version == "":
+		statement = fmt.Sprintf("insert into hap_state (key, value, version) values ('%s', '%s', 1) "+
+			"on conflict (key) do nothing returning version;\n", key, encoded)
+	default:
+		statement = fmt.Sprintf("update hap_state set value = '%s', version = version + 1 "+
+			"where key = '%s' and version = %s returning version;\n", encoded, key, version)
+	}
+	out, err := s.psql(statement)
+	if err != nil {
+		return err
+	}
+	if strings.TrimSpace(string(out)) == "" {
+		return ErrConflict
+	}
+	return nil
+}
+
+// psql runs the statement after creating the table and returns the rows
+// The password goes to psql in PGPASSWORD, so it does not show in ps.
+func (s *PostgresStore) psql(statement string) ([]byte, error) {
+	conn, password := pgPassword(s.URL)
+	cmd := exec.Command(psqlCommand, "-X", "-q", "-A", "-t", "-v", "ON_ERROR_STOP=1", "-d", conn)
+	if password != "" {
+		cmd.Env = append(os.Environ(), "PGPASSWORD="+password)
+	}
+	cmd.Stdin = strings.NewReader("set client_min_messages = warning;\n" + createState + statement)
+	var stderr bytes.Buffer
+	cmd.Stderr = &stderr
+	out, err := cmd.Output()
+	if err != nil {
+		return nil, fmt.Errorf("%s\n%s", stderr.String(), err)
+	}
+	return out, nil
+}
+
+// pgPassword splits the password off a postgres url or connection string
+// and returns the connection without it
+func pgPassword(conn string) (string, string) {
+	u, err := url.Parse(conn)
+	if err != nil || (u.Scheme != "postgres" && u.Scheme != "postgresql") {
+		match := conninfoPassword.FindStringSubmatch(conn)
+		if match == nil {
+			return conn, ""
+		}
+		password := match[2]
+		if strings.HasPrefix(password, "'") {
+			password = strings.NewReplacer("\\'", "'", "\\\\", "\\").Replace(password[1 : len(password)-1])
+		}
+		return strings.TrimSpace(conninfoPassword.ReplaceAllString(conn, "$1")), password
+	}
+	password := ""
+	if u.User != nil {
+		password, _ = u.User.Password()
+		u.User = url.User(u.User.Username())
+	}
+	if query := u.Query(); query.Get("password") != "" {
+		password = query.Get("password")
+		query.Del("password")
+		u.RawQuery = query.Encode()
+	}
+	return u.String(), password
+}
@@ -0,0 +1,118 @@
+// Hap - the simple and effective provisioner
+// Copyright (c) 2015 Garrett Woodworth (https://github.com/gwoo)
+// The BSD License http://opensource.org/licenses/bsd-license.php.
+
+package hap
+
+import (
+	"encoding/base64"
+	"fmt"
+	"io"
+	"strings"
+	"time"
+)
+
+// ReconnectTimeout is how long hap keeps reconnecting to a supervised build
+const ReconnectTimeout = 5 * time.Minute
+
+// The first and the longest wait between reconnects
+var (
+	reconnectDelay = time.Second
+	reconnectMax   = 30 * time.Second
+)
+
+// Writes the script of a supervised build to the temp dir and starts it in
+// the background, detached from the session, with the output going to its log.
+const startSupervised string = "echo %[1]s | base64 -d > %[2]s/supervise.sh && " +
+	"if command -v setsid >/dev/null 2>&1; then DETACH=setsid; else DETACH=nohup; fi && " +
+	"($DETACH sh %[2]s/supervise.sh > %[2]s/build.log 2>&1 < /dev/null &)"
+
+// Runs the cmds of a supervised build from the home dir and writes
+// their exit status to the temp dir when they are done.
+const superviseScript string = "cd && (%[1]s%[2]s); CODE=$? && " +
+	"echo $CODE > %[3]s/build.exit.tmp && mv -f %[3]s/build.exit.tmp %[3]s/build.exit"
+
+// Prints the log of a supervised build from the offset every second
+// and exits with the status of the build once it is done.
+const followSupervised string = "N=%[2]d && if [ ! -f %[1]s/build.log ]; then echo \"build log %[1]s/build.log not found\" >&2; exit 1; fi && " +
+	"while :; do DONE=\"\" && if [ -f %[1]s/build.exit ]; then DONE=1; fi && S=$(wc -c < %[1]s/build.log) && " +
+	"if [ \"$S\" -gt \"$N\" ]; then tail -c +$((N + 1)) %[1]s/build.log | head -c $((S - N)); N=$S; fi && " +
+	"if [ -n \"$DONE\" ]; then exit $(cat %[1]s/build.exit); fi; sleep 1; done"
+
+// countWriter counts the bytes written to w
+type countWriter struct {
+	w io.Writer
+	n int64
+}
+
+// Write implements the io.Writer interface
+func (cw *countWriter) Write(p []byte) (int, error) {
+	n, err := cw.w.Write(p)
+	cw.n += int64(n)
+	return n, err
+}
+
+// supervise runs the cmds detached from the ssh session and streams
+// their log. When the connection drops it reconnects with backoff for
+// up to ReconnectTimeout and resumes streaming from the log offset,
+// since the build keeps running on the remote machine. When it gives
+// up the temp dir is kept, so the log can still be read there.
+func (r *Remote) supervise(cmds []string, stdout, stderr io.Writer) (*Result, error) {
+	tmp, err := r.Temp()
+	if err != nil {
+		return nil, fmt.Errorf("[%s] %s", r.Host.Name, err)
+	}
+	script := fmt.Sprintf(superviseScript, r.Env(), strings.Join(cmds, " && "), tmp)
+	start := r.clock().Now()
+	_, err = r.runOnce([]string{
+		fmt.Sprintf(startSupervised, base64.StdEncoding.EncodeToString([]byte(script)), tmp),
+	}, stdout, stderr)
+	if err != nil {
+		return nil, fmt.Errorf("[%s] %s", r.Host.Name, err)
+	}
+	log := &countWriter{w: stdout}
+	result, err := r.follow(tmp, log, stderr)
+	if result != nil {
+		result.Duration = r.clock().Now().Sub(start)
+	} else if err != nil {
+		r.tmpMu.Lock()
+		r.keepTmp = true
+		r.tmpMu.Unlock()
+		err = fmt.Errorf("%s, the log is kept in %s/build.log", err, tmp)
+	}
+	if err != nil {
+		return result, fmt.Errorf("[%s] %s", r.Host.Name, err)
+	}
+	return result, nil
+}
+
+// follow streams the log of the supervised build in the dir until it is done
+// The log is resumed from the bytes already written after reconnecting.
+// It returns no Result when it gave up, as the build may still run.
+func (r *Remote) follow(dir string, log *countWriter, stderr io.Writer) (*Result, error) {
+	delay := reconnectDelay
+	var lost time.Time
+	for {
+		offset := log.n
+		result, err := r.runOnce([]string{
+			fmt.Sprintf(followSupervised, dir, log.n),
+		}, log, stderr)
+		if err == nil || !IsRetryable(err) {
+			return result, err
+		}
+		if log.n > offset {
+			lost, delay = time.Time{}, reconnectDelay
+		}
+		if lost.IsZero() {
+			lost = r.clock().Now()
+		}
+		if r.clock().Now().Sub(lost) > ReconnectTimeout {
+			return nil, fmt.Errorf("%s, gave up reconnecting after %s", err, ReconnectTimeout)
+		}
+		fmt.Fprintf(stderr, "connection lost: %s, reconnecting in %s\n", err, delay)
+		r.clock().Sleep(delay)
+		if delay *= 2; delay > reconnectMax {
+			delay = reconnectMax
+		}
+	}
+}
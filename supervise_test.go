@@ -0,0 +1,52 @@
+// Hap - the simple and effective provisioner
+// Copyright (c) 2015 Garrett Woodworth (https://github.com/gwoo)
+// The BSD License http://opensource.org/licenses/bsd-license.php.
+
+package hap
+
+import (
+	"bytes"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestSupervise(t *testing.T) {
+	home := t.TempDir()
+	t.Setenv("HOME", home)
+	os.MkdirAll(filepath.Join(home, "app"), 0755)
+	var stdout, stderr bytes.Buffer
+	r := &Remote{Host: &Host{Name: "me", Type: "local"}, Dir: "app"}
+	result, err := r.supervise([]string{"cd app", "echo $HAP_HOSTNAME", "echo two >&2", "exit 4"}, &stdout, &stderr)
+	if err == nil || result == nil || result.ExitCode != 4 {
+		t.Fatalf("expected exit code 4, got %v %v", result, err)
+	}
+	if stdout.String() != "me\ntwo\n" {
+		t.Errorf("unexpected output %q %q", stdout.String(), stderr.String())
+	}
+	r.Close()
+	if files, _ := filepath.Glob(filepath.Join(home, TmpDir, "*")); len(files) != 0 {
+		t.Errorf("expected supervise files to be removed, got %v", files)
+	}
+}
+
+func TestFollowFromOffset(t *testing.T) {
+	home := t.TempDir()
+	t.Setenv("HOME", home)
+	os.MkdirAll(filepath.Join(home, TmpDir, "1"), 0755)
+	ioutil.WriteFile(filepath.Join(home, TmpDir, "1", "build.log"), []byte("hello world\n"), 0644)
+	ioutil.WriteFile(filepath.Join(home, TmpDir, "1", "build.exit"), []byte("0\n"), 0644)
+	var stdout bytes.Buffer
+	r := &Remote{Host: &Host{Name: "me", Type: "local"}, Dir: "app"}
+	log := &countWriter{w: &stdout, n: 6}
+	if _, err := r.follow("$HOME/"+TmpDir+"/1", log, ioutil.Discard); err != nil {
+		t.Fatal(err)
+	}
+	if stdout.String() != "world\n" || log.n != 12 {
+		t.Errorf("unexpected output %q at %d", stdout.String(), log.n)
+	}
+	if _, err := r.follow("$HOME/"+TmpDir+"/2", log, ioutil.Discard); err == nil {
+		t.Error("expected error for missing log")
+	}
+}
@@ -0,0 +1,186 @@
+// Hap - the simple and effective provisioner
+// Copyright (c) 2015 Garrett Woodworth (https://github.com/gwoo)
+// The BSD License http://opensource.org/licenses/bsd-license.php.
+
+package hap
+
+import (
+	"archive/tar"
+	"compress/gzip"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"regexp"
+	"runtime"
+	"sort"
+	"strings"
+	"time"
+)
+
+// LastRunFile keeps the results of the last run of hap on the hosts
+const LastRunFile = ".hap/last-run.json"
+
+// Matches Hapfile lines whose values are replaced entirely
+var secretSetting = regexp.MustCompile(`(?i)^(\s*(password|url|token)\s*=\s*).+$`)
+
+// Matches Hapfile env lines, whose names are kept
+var envSetting = regexp.MustCompile(`(?i)^(\s*env\s*=\s*"?[A-Za-z_][A-Za-z0-9_]*=).*?("?)$`)
+
+// Matches key=value pairs and flags named like secrets anywhere, as in
+// build output, scripts, and the cert-command
+var secretPair = regexp.MustCompile(`(?i)([A-Za-z0-9_]*(?:pass(?:word|wd)?|secret|token|api_?key|private_?key|credentials?)[A-Za-z0-9_]*\s*(?:=|:\s)\s*|--?[A-Za-z-]*(?:password|secret|token)[A-Za-z-]*\s+)("[^"]*"|'[^']*'|[^\s"',;&]+)`)
+
+// Matches encrypted secrets and credentials in urls
+var (
+	encryptedValue = regexp.MustCompile(`enc:[A-Za-z0-9+/=]+`)
+	urlCredentials = regexp.MustCompile(`://[^/\s:@]+:[^/\s@]+@`)
+)
+
+// Matches the characters replaced in the names of files in the bundle
+var unsafeName = regexp.MustCompile(`[^A-Za-z0-9_.-]`)
+
+// LastRun holds the results of a run of hap
+type LastRun struct {
+	Command  string
+	Operator string
+	Version  string
+	Time     time.Time
+	Duration time.Duration
+	Hosts    []RunResult
+}
+
+// RunResult is the result of a run on a host, with its last lines of
+// output and the bytes pushed to it, or whether it timed out
+type RunResult struct {
+	Host     string
+	Result   string
+	Error    string `json:",omitempty"`
+	TimedOut bool   `json:",omitempty"`
+	Duration time.Duration
+	Pushed   int64    `json:",omitempty"`
+	Tail     []string `json:",omitempty"`
+}
+
+// WriteLastRun replaces the file with the results of the run
+func WriteLastRun(file string, run LastRun) error {
+	sort.Slice(run.Hosts, func(i, j int) bool { return run.Hosts[i].Host < run.Hosts[j].Host })
+	b, err := json.MarshalIndent(run, "", "  ")
+	if err != nil {
+		return err
+	}
+	if err := os.MkdirAll(filepath.Dir(file), 0700); err != nil {
+		return err
+	}
+	return ioutil.WriteFile(file, append(b, '\n'), 0600)
+}
+
+// Redact masks the secrets in Hapfile config and output
+// Passwords, urls, and tokens are masked entirely, env keeps the
+// names, and the values of pairs and flags named like secrets,
+// encrypted values, and credentials in urls are masked anywhere.
+func Redact(text string) string {
+	lines := strings.Split(text, "\n")
+	for i, line := range lines {
+		line = secretSetting.ReplaceAllString(line, "${1}***")
+		line = envSetting.ReplaceAllString(line, "${1}***${2}")
+		line = secretPair.ReplaceAllString(line, "${1}***")
+		line = encryptedValue.ReplaceAllString(line, "enc:***")
+		lines[i] = urlCredentials.ReplaceAllString(line, "://***@")
+	}
+	return strings.Join(lines, "\n")
+}
+
+// redactLastRun masks the secrets in the results and output of the
+// last run, line by line, so quotes in the output cannot hide them in
+// the json
+func redactLastRun(b []byte) string {
+	var run LastRun
+	if err := json.Unmarshal(b, &run); err != nil {
+		return string(b)
+	}
+	for i, host := range run.Hosts {
+		host.Result, host.Error = Redact(host.Result), Redact(host.Error)
+		for j, line := range host.Tail {
+			host.Tail[j] = Redact(line)
+		}
+		run.Hosts[i] = host
+	}
+	redacted, err := json.MarshalIndent(run, "", "  ")
+	if err != nil {
+		return string(b)
+	}
+	return string(redacted) + "\n"
+}
+
+// versions returns the versions of hap and the local tools it uses
+func versions() string {
+	lines := []string{
+		fmt.Sprintf("hap %s", Version),
+		fmt.Sprintf("go %s %s/%s", runtime.Version(), runtime.GOOS, runtime.GOARCH),
+	}
+	for _, tool := range [][]string{{"git", "--version"}, {"ssh", "-V"}, {"rsync", "--version"}, {"aws", "--version"}} {
+		out, err := exec.Command(tool[0], tool[1:]...).CombinedOutput()
+		version := strings.SplitN(strings.TrimSpace(string(out)), "\n", 2)[0]
+		if err != nil {
+			version = "not found"
+		}
+		lines = append(lines, fmt.Sprintf("%s: %s", tool[0], version))
+	}
+	return strings.Join(lines, "\n") + "\n"
+}
+
+// SupportBundle writes a gzipped tar archive for bug reports to w
+// It holds the Hapfile and the resolved config of every host, the
+// versions, the inventory, and the last run, all redacted. A Hapfile
+// that does not parse is kept along with the error. The cwd names the
+// deploy dirs like it does for Explain.
+func SupportBundle(w io.Writer, hapfile []byte, cwd string) error {
+	files := [][2]string{
+		{"Hapfile", string(hapfile)},
+		{"versions", versions()},
+	}
+	hf, err := ParseHapfile(hapfile)
+	if err != nil {
+		files = append(files, [2]string{"errors", fmt.Sprintf("Hapfile: %s\n", err)})
+	}
+	names := []string{}
+	for name := range hf.Hosts {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		explained, err := hf.Explain(name, cwd)
+		if err != nil {
+			explained = fmt.Sprintf("# explain failed: %s\n", err)
+		}
+		file := strings.TrimLeft(unsafeName.ReplaceAllString(name, "_"), ".")
+		files = append(files, [2]string{"hosts/" + file, explained})
+	}
+	if b, err := ioutil.ReadFile(InventoryFile); err == nil {
+		files = append(files, [2]string{filepath.Base(InventoryFile), string(b)})
+	}
+	if b, err := ioutil.ReadFile(LastRunFile); err == nil {
+		files = append(files, [2]string{filepath.Base(LastRunFile), redactLastRun(b)})
+	}
+	gz := gzip.NewWriter(w)
+	tw := tar.NewWriter(gz)
+	now := time.Now()
+	for _, file := range files {
+		body := Redact(file[1])
+		header := &tar.Header{Name: "hap-support/" + file[0], Mode: 0600, Size: int64(len(body)), ModTime: now}
+		if err := tw.WriteHeader(header); err != nil {
+			return err
+		}
+		if _, err := io.WriteString(tw, body); err != nil {
+			return err
+		}
+	}
+	if err := tw.Close(); err != nil {
+		return err
+	}
+	return gz.Close()
+}
@@ -0,0 +1,123 @@
+// Hap - the simple and effective provisioner
+// Copyright (c) 2015 Garrett Woodworth (https://github.com/gwoo)
+// The BSD License http://opensource.org/licenses/bsd-license.php.
+
+package hap
+
+import (
+	"bytes"
+	"fmt"
+	"net"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"regexp"
+	"strconv"
+	"strings"
+)
+
+// Sync copies the sync paths of the host from the local repo to the
+// same place in the deploy dir, for artifacts that are not committed.
+// It uses rsync when both ends have it and streams a tar archive over
+// the ssh session otherwise. Paths missing locally are removed.
+func (r *Remote) Sync() error {
+	if len(r.Host.Sync) < 1 {
+		return nil
+	}
+	paths := []string{}
+	for _, path := range r.Host.Sync {
+		clean := filepath.Clean(path)
+		if !validDir.MatchString(clean) || filepath.IsAbs(clean) || clean == "." || strings.HasPrefix(clean, "..") {
+			return fmt.Errorf("[%s] invalid sync path %q", r.Host.Name, path)
+		}
+		paths = append(paths, clean)
+	}
+	existing, missing := []string{}, []string{}
+	for _, path := range paths {
+		if _, err := os.Stat(filepath.Join(r.Git.Work, path)); err != nil {
+			missing = append(missing, path)
+			continue
+		}
+		existing = append(existing, path)
+	}
+	if len(missing) > 0 {
+		if err := r.Execute([]string{"cd " + r.Dir, "rm -rf " + strings.Join(missing, " ")}); err != nil {
+			return err
+		}
+	}
+	if len(existing) < 1 {
+		return nil
+	}
+	if r.rsync() {
+		return r.syncRsync(existing)
+	}
+	return r.syncTar(existing)
+}
+
+// rsync returns whether rsync can be used to sync the host
+// Only the git transfer and local hosts use it, since rsync connects
+// with ssh like git does.
+func (r *Remote) rsync() bool {
+	if _, err := exec.LookPath("rsync"); err != nil {
+		return false
+	}
+	if r.Host.IsLocal() {
+		return true
+	}
+	if r.Host.Transfer != "" && r.Host.Transfer != "git" {
+		return false
+	}
+	result, err := r.read([]string{"command -v rsync >/dev/null && echo rsync || true"})
+	return err == nil && strings.TrimSpace(string(result.Stdout)) == "rsync"
+}
+
+// Matches the bytes rsync reports sending with --stats
+var rsyncSent = regexp.MustCompile(`Total bytes sent: ([0-9,.]+)`)
+
+// syncRsync transfers the changes of the paths with rsync
+// The bytes it sent are counted as Pushed.
+func (r *Remote) syncRsync(paths []string) error {
+	args := []string{"-az", "--partial", "--delete", "--relative", "--stats"}
+	dest := r.Git.Repo + "/"
+	if !r.Host.IsLocal() {
+		host, port, err := net.SplitHostPort(r.sshConfig.Addr)
+		if err != nil {
+			return err
+		}
+		args = append(args, "-e", fmt.Sprintf("ssh -p %s", port))
+		dest = fmt.Sprintf("%s@%s:%s/", r.sshConfig.Username, host, r.Dir)
+	}
+	args = append(args, paths...)
+	args = append(args, dest)
+	return r.retry(func() error {
+		cmd := exec.Command("rsync", args...)
+		cmd.Dir = r.Git.Work
+		cmd.Env = append(os.Environ(), parsedEnv...)
+		output, err := cmd.CombinedOutput()
+		if err != nil {
+			return fmt.Errorf("%s\n%s", output, err)
+		}
+		if m := rsyncSent.FindSubmatch(output); m != nil {
+			size, _ := strconv.ParseInt(strings.NewReplacer(",", "", ".", "").Replace(string(m[1])), 10, 64)
+			r.pushed(size)
+		}
+		return nil
+	})
+}
+
+// syncTar replaces the paths with a tar archive of them
+func (r *Remote) syncTar(paths []string) error {
+	cmd := exec.Command("tar", append([]string{"-cf", "-"}, paths...)...)
+	cmd.Dir = r.Git.Work
+	var stderr bytes.Buffer
+	cmd.Stderr = &stderr
+	archive, err := cmd.Output()
+	if err != nil {
+		return fmt.Errorf("%s\n%s", stderr.Bytes(), err)
+	}
+	return r.pipe(archive, []string{
+		"cd " + r.Dir,
+		"rm -rf " + strings.Join(paths, " "),
+		"tar -xf -",
+	})
+}
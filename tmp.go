@@ -0,0 +1,104 @@
+// Hap - the simple and effective provisioner
+// Copyright (c) 2015 Garrett Woodworth (https://github.com/gwoo)
+// The BSD License http://opensource.org/licenses/bsd-license.php.
+
+package hap
+
+import (
+	"bufio"
+	"bytes"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"sync/atomic"
+	"time"
+)
+
+// TmpDir holds the temp dirs of the runs, relative to the remote home dir
+const TmpDir = ".hap/tmp"
+
+// CleanAge is how long leftovers have to be untouched before hap clean removes them
+const CleanAge = time.Hour
+
+// Counts the temp dirs of the process, keeping their names apart even
+// when the clock of the remotes stands still.
+var tmpSeq int64
+
+// Creates the temp dir of a run, readable only by the user.
+const makeTmp string = "(umask 077 && mkdir -p %s)"
+
+// Removes the temp dirs of runs without a file changed in the last minutes
+// and without a live process group in their .happid. They are all kept
+// while the deploy dir holds a .haplock.
+const cleanTmp string = "if [ -f %[3]s/.haplock ]; then echo \"kept %[1]s, %[3]s is locked by $(cat %[3]s/.haplock)\"; " +
+	"elif [ -d %[1]s ]; then for d in %[1]s/*/; do " +
+	"if [ -d \"$d\" ] && [ -z \"$(find \"$d\" -mmin -%[2]d | head -n 1)\" ] && " +
+	"[ -z \"$(for P in $(cat \"$d.happid\" 2>/dev/null); do kill -0 -$P 2>/dev/null && echo $P; done)\" ]; " +
+	"then rm -rf \"$d\" && echo \"removed ${d%%/}\"; fi; done; fi"
+
+// Adds the process group of a build session to the .happid of the temp
+// dir, so hap clean keeps the temp dir while the session is alive.
+const tmpPgid string = "echo $PGID >> %s/.happid"
+
+// Removes the temp files older versions of hap left in the deploy dir.
+const cleanLeftovers string = "if [ -d %[1]s ]; then cd %[1]s && " +
+	"for f in .hapsupervise-* .haplog-* .hapexit-* .hapoutput .happended.tmp .hapbuild.tmp .hapschema.tmp .git/hap.bundle .git/hooks/post-receive.hap; do " +
+	"if [ -f \"$f\" ] && [ -z \"$(find \"$f\" -mmin -%[2]d)\" ]; then rm -f \"$f\" && echo \"removed $PWD/$f\"; fi; done; fi"
+
+// Temp returns the temp dir of the run on the remote machine
+// It is created on first use and removed by Close, so scripts, uploads,
+// and markers of the run do not pile up in the deploy dir.
+func (r *Remote) Temp() (string, error) {
+	r.tmpMu.Lock()
+	defer r.tmpMu.Unlock()
+	if r.tmp != "" {
+		return r.tmp, nil
+	}
+	seq := atomic.AddInt64(&tmpSeq, 1)
+	dir := fmt.Sprintf("$HOME/%s/%d-%d-%d", TmpDir, r.clock().Now().UnixNano(), os.Getpid(), seq)
+	if err := r.Execute([]string{fmt.Sprintf(makeTmp, dir)}); err != nil {
+		return "", err
+	}
+	r.tmp = dir
+	return dir, nil
+}
+
+// removeTemp removes the temp dir of the run unless it is kept
+// It is tried once, hap clean removes what is left.
+func (r *Remote) removeTemp() {
+	r.tmpMu.Lock()
+	tmp, keep := r.tmp, r.keepTmp
+	if !keep {
+		r.tmp = ""
+	}
+	r.tmpMu.Unlock()
+	if tmp == "" || keep {
+		return
+	}
+	r.runOnce([]string{"rm -rf " + tmp}, ioutil.Discard, ioutil.Discard)
+}
+
+// Clean removes the temp dirs of crashed runs and the temp files of
+// older versions untouched for CleanAge. Runs still writing to their
+// temp dir or with a live process group are left alone, and so are all
+// temp dirs while the deploy dir is locked. It returns a report of what
+// was removed.
+func (r *Remote) Clean() ([]string, error) {
+	minutes := int(CleanAge / time.Minute)
+	result, err := r.Capture([]string{
+		fmt.Sprintf(cleanTmp, "$HOME/"+TmpDir, minutes, r.Dir),
+		fmt.Sprintf(cleanLeftovers, r.Dir, minutes),
+	})
+	if err != nil {
+		if result != nil {
+			err = fmt.Errorf("%s%s", result.Stderr, err)
+		}
+		return nil, err
+	}
+	report := []string{}
+	scanner := bufio.NewScanner(bytes.NewReader(result.Stdout))
+	for scanner.Scan() {
+		report = append(report, scanner.Text())
+	}
+	return report, nil
+}
@@ -0,0 +1,101 @@
+// Hap - the simple and effective provisioner
+// Copyright (c) 2015 Garrett Woodworth (https://github.com/gwoo)
+// The BSD License http://opensource.org/licenses/bsd-license.php.
+
+package hap
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"syscall"
+	"testing"
+	"time"
+)
+
+func TestTemp(t *testing.T) {
+	home := t.TempDir()
+	t.Setenv("HOME", home)
+	r := &Remote{Host: &Host{Name: "me", Type: "local"}, Dir: "app", Stdout: ioutil.Discard, Stderr: ioutil.Discard}
+	tmp, err := r.Temp()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if again, _ := r.Temp(); again != tmp {
+		t.Errorf("expected the same temp dir, got %s and %s", tmp, again)
+	}
+	dirs, _ := filepath.Glob(filepath.Join(home, TmpDir, "*"))
+	if len(dirs) != 1 {
+		t.Fatalf("expected one temp dir, got %v", dirs)
+	}
+	if info, err := os.Stat(dirs[0]); err != nil || info.Mode().Perm() != 0700 {
+		t.Errorf("expected a private temp dir, got %v %v", info, err)
+	}
+	r.Close()
+	if _, err := os.Stat(dirs[0]); !os.IsNotExist(err) {
+		t.Errorf("expected %s to be removed, got %v", dirs[0], err)
+	}
+}
+
+func TestClean(t *testing.T) {
+	home := t.TempDir()
+	t.Setenv("HOME", home)
+	old := time.Now().Add(-2 * CleanAge)
+	for _, dir := range []string{"crashed", "running", "quiet"} {
+		os.MkdirAll(filepath.Join(home, TmpDir, dir), 0700)
+		ioutil.WriteFile(filepath.Join(home, TmpDir, dir, "build.log"), []byte("log"), 0600)
+	}
+	ioutil.WriteFile(filepath.Join(home, TmpDir, "crashed", ".happid"), []byte("999999999\n"), 0600)
+	ioutil.WriteFile(filepath.Join(home, TmpDir, "quiet", ".happid"), []byte(fmt.Sprintf("%d\n", syscall.Getpgrp())), 0600)
+	for _, dir := range []string{"crashed", "quiet"} {
+		for _, file := range []string{"build.log", ".happid", ""} {
+			os.Chtimes(filepath.Join(home, TmpDir, dir, file), old, old)
+		}
+	}
+	os.MkdirAll(filepath.Join(home, "app", ".git"), 0755)
+	for _, file := range []string{".haplog-1", ".git/hap.bundle", ".happended"} {
+		ioutil.WriteFile(filepath.Join(home, "app", file), []byte("x"), 0644)
+		os.Chtimes(filepath.Join(home, "app", file), old, old)
+	}
+	r := &Remote{Host: &Host{Name: "me", Type: "local"}, Dir: "app"}
+	report, err := r.Clean()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(report) != 3 || !strings.HasSuffix(report[0], "/crashed") {
+		t.Errorf("unexpected report %v", report)
+	}
+	for _, file := range []string{TmpDir + "/crashed", "app/.haplog-1", "app/.git/hap.bundle"} {
+		if _, err := os.Stat(filepath.Join(home, file)); !os.IsNotExist(err) {
+			t.Errorf("expected %s to be removed, got %v", file, err)
+		}
+	}
+	for _, file := range []string{TmpDir + "/running", TmpDir + "/quiet", "app/.happended"} {
+		if _, err := os.Stat(filepath.Join(home, file)); err != nil {
+			t.Errorf("expected %s to be kept, got %v", file, err)
+		}
+	}
+}
+
+func TestCleanLocked(t *testing.T) {
+	home := t.TempDir()
+	t.Setenv("HOME", home)
+	old := time.Now().Add(-2 * CleanAge)
+	os.MkdirAll(filepath.Join(home, TmpDir, "crashed"), 0700)
+	os.Chtimes(filepath.Join(home, TmpDir, "crashed"), old, old)
+	os.MkdirAll(filepath.Join(home, "app"), 0755)
+	ioutil.WriteFile(filepath.Join(home, "app", ".haplock"), []byte("me@box pid=1"), 0644)
+	r := &Remote{Host: &Host{Name: "me", Type: "local"}, Dir: "app"}
+	report, err := r.Clean()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(report) != 1 || !strings.Contains(report[0], "locked by me@box pid=1") {
+		t.Errorf("unexpected report %v", report)
+	}
+	if _, err := os.Stat(filepath.Join(home, TmpDir, "crashed")); err != nil {
+		t.Errorf("expected the temp dir to be kept, got %v", err)
+	}
+}
@@ -0,0 +1,150 @@
+// Hap - the simple and effective provisioner
+// Copyright (c) 2015 Garrett Woodworth (https://github.com/gwoo)
+// The BSD License http://opensource.org/licenses/bsd-license.php.
+
+package hap
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+// SpoolDir keeps the full output of builds with spool-output
+const SpoolDir = ".hap/spool"
+
+// Multipliers of the size suffixes of max-output
+var sizeUnits = map[string]int64{"": 1, "k": 1 << 10, "m": 1 << 20, "g": 1 << 30}
+
+// ParseSize takes a size in bytes like 65536, 64k, 10M, or 1GB
+func ParseSize(size string) (int64, error) {
+	s := strings.TrimSuffix(strings.ToLower(strings.TrimSpace(size)), "b")
+	i := strings.IndexFunc(s, func(r rune) bool { return r < '0' || r > '9' })
+	if i < 0 {
+		i = len(s)
+	}
+	unit, ok := sizeUnits[s[i:]]
+	n, err := strconv.ParseInt(s[:i], 10, 64)
+	if !ok || err != nil || n <= 0 {
+		return 0, fmt.Errorf("invalid size %q", size)
+	}
+	return n * unit, nil
+}
+
+// capWriter passes the first three quarters of max bytes through to w
+// and keeps the last quarter of what follows. Once the output is done,
+// Flush marks what was dropped and writes the kept tail.
+type capWriter struct {
+	w       io.Writer
+	max     int64
+	n       int64
+	tail    []byte
+	newline bool
+}
+
+// head returns the bytes passed through before the tail is kept
+func (cw *capWriter) head() int64 {
+	return cw.max - cw.max/4
+}
+
+// Write implements the io.Writer interface
+func (cw *capWriter) Write(p []byte) (int, error) {
+	l := len(p)
+	if rest := cw.head() - cw.n; rest > 0 {
+		if int64(l) <= rest {
+			cw.n += int64(l)
+			cw.newline = l > 0 && p[l-1] == '\n'
+			return cw.w.Write(p)
+		}
+		if _, err := cw.w.Write(p[:rest]); err != nil {
+			return 0, err
+		}
+		cw.n += rest
+		cw.newline = p[rest-1] == '\n'
+		p = p[rest:]
+	}
+	cw.n += int64(len(p))
+	cw.tail = append(cw.tail, p...)
+	if keep := cw.max / 4; int64(len(cw.tail)) > 2*keep {
+		cw.tail = append([]byte{}, cw.tail[int64(len(cw.tail))-keep:]...)
+	}
+	return l, nil
+}
+
+// Truncated returns the bytes of output that were dropped
+func (cw *capWriter) Truncated() int64 {
+	if cw.n <= cw.max {
+		return 0
+	}
+	return cw.n - cw.max
+}
+
+// Flush writes the marker of the truncated output, when there is any,
+// and the tail starting at its first whole line
+func (cw *capWriter) Flush(marker string) error {
+	tail := cw.tail
+	if cw.Truncated() > 0 {
+		tail = tail[int64(len(tail))-cw.max/4:]
+		if i := bytes.IndexByte(tail, '\n'); i >= 0 {
+			tail = tail[i+1:]
+		}
+		if !cw.newline {
+			marker = "\n" + marker
+		}
+		if _, err := fmt.Fprintf(cw.w, "%s\n", marker); err != nil {
+			return err
+		}
+	}
+	cw.tail = nil
+	_, err := cw.w.Write(tail)
+	return err
+}
+
+// capped runs the session of the step with the output shown capped to
+// the max-output of the step, and the full output spooled to a file in
+// the SpoolDir with spool-output
+func (r *Remote) capped(step Step, label string, stdout, stderr io.Writer, run func(stdout, stderr io.Writer) (*Result, error)) (*Result, error) {
+	var cout, cerr *capWriter
+	if step.MaxOutput != "" {
+		max, err := ParseSize(step.MaxOutput)
+		if err != nil {
+			return nil, fmt.Errorf("[%s] invalid max-output %q of %s", r.Host.Name, step.MaxOutput, step.Name)
+		}
+		cout, cerr = &capWriter{w: stdout, max: max}, &capWriter{w: stderr, max: max}
+		stdout, stderr = cout, cerr
+	}
+	spool := ""
+	if step.Spool {
+		name := fmt.Sprintf("%s-%s-%s.log", r.Host.Name, label, r.clock().Now().UTC().Format("20060102-150405"))
+		spool = filepath.Join(SpoolDir, strings.TrimLeft(unsafeName.ReplaceAllString(name, "_"), "."))
+		if err := os.MkdirAll(SpoolDir, 0700); err != nil {
+			return nil, err
+		}
+		f, err := os.OpenFile(spool, os.O_WRONLY|os.O_CREATE|os.O_TRUNC, 0600)
+		if err != nil {
+			return nil, err
+		}
+		defer f.Close()
+		full := &lockedWriter{mu: &sync.Mutex{}, w: f}
+		stdout, stderr = io.MultiWriter(stdout, full), io.MultiWriter(stderr, full)
+	}
+	result, err := run(stdout, stderr)
+	if cout == nil {
+		return result, err
+	}
+	for _, cw := range []*capWriter{cout, cerr} {
+		marker := fmt.Sprintf("... truncated %d bytes of output over max-output %s ...", cw.Truncated(), step.MaxOutput)
+		if spool != "" {
+			marker = fmt.Sprintf("... truncated %d bytes of output over max-output %s, full output in %s ...", cw.Truncated(), step.MaxOutput, spool)
+		}
+		if ferr := cw.Flush(marker); ferr != nil && err == nil {
+			err = ferr
+		}
+	}
+	return result, err
+}
@@ -0,0 +1,137 @@
+// Hap - the simple and effective provisioner
+// Copyright (c) 2015 Garrett Woodworth (https://github.com/gwoo)
+// The BSD License http://opensource.org/licenses/bsd-license.php.
+
+package hap
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestParseSize(t *testing.T) {
+	tests := map[string]int64{"65536": 65536, "64k": 64 << 10, "10M": 10 << 20, "1GB": 1 << 30, "2kb": 2048}
+	for size, expected := range tests {
+		if n, err := ParseSize(size); err != nil || n != expected {
+			t.Errorf("expected %d for %s, got %d %v", expected, size, n, err)
+		}
+	}
+	for _, invalid := range []string{"", "0", "-1k", "10T", "k", "1.5M"} {
+		if _, err := ParseSize(invalid); err == nil {
+			t.Errorf("expected error for %q", invalid)
+		}
+	}
+}
+
+func TestCapWriter(t *testing.T) {
+	var out bytes.Buffer
+	cw := &capWriter{w: &out, max: 40}
+	for i := 1; i <= 20; i++ {
+		fmt.Fprintf(cw, "line %02d\n", i)
+	}
+	if cw.Truncated() != 160-40 {
+		t.Errorf("expected 120 bytes truncated, got %d", cw.Truncated())
+	}
+	cw.Flush("...")
+	expected := "line 01\nline 02\nline 03\nline 0\n...\nline 20\n"
+	if out.String() != expected {
+		t.Errorf("expected %q, got %q", expected, out.String())
+	}
+	out.Reset()
+	cw = &capWriter{w: &out, max: 40}
+	fmt.Fprint(cw, "line 01\nline 02\nline 03\nline 04\n")
+	cw.Flush("...")
+	if out.String() != "line 01\nline 02\nline 03\nline 04\n" {
+		t.Errorf("expected the output to be whole, got %q", out.String())
+	}
+}
+
+func TestBuildMaxOutput(t *testing.T) {
+	home := t.TempDir()
+	t.Setenv("HOME", home)
+	work := filepath.Join(home, "work", "app")
+	os.MkdirAll(work, 0755)
+	wd, _ := os.Getwd()
+	os.Chdir(work)
+	defer os.Chdir(wd)
+	for _, args := range [][]string{{"init", "-q"}, {"commit", "-q", "--allow-empty", "-m", "one"}} {
+		if out, err := exec.Command("git", args...).CombinedOutput(); err != nil {
+			t.Fatalf("%s %s", out, err)
+		}
+	}
+	host := &Host{Name: "me", Type: "local", Transfer: "bundle", Build: []string{"noisy"}, Cmd: []string{"echo done"}}
+	host.BuildCmds(map[string]*Build{"noisy": {Cmd: []string{"seq 1 10000"}, MaxOutput: "1k", Spool: true}})
+	r, err := NewRemote(host, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer r.Close()
+	var out bytes.Buffer
+	r.Stdout, r.Stderr = &out, &out
+	if err := r.Initialize(); err != nil {
+		t.Fatal(err)
+	}
+	if err := r.PushBundle(); err != nil {
+		t.Fatal(err)
+	}
+	if err := r.Build(); err != nil {
+		t.Fatal(err)
+	}
+	output := out.String()
+	if !strings.HasPrefix(output, "1\n2\n") || !strings.Contains(output, "\n10000\ndone\n") || strings.Contains(output, "\n5000\n") {
+		t.Errorf("expected the head and tail of the output, got %q", output)
+	}
+	if !strings.Contains(output, "truncated 47870 bytes of output over max-output 1k, full output in .hap/spool/me-noisy-") {
+		t.Errorf("expected the truncation marker, got %q", output)
+	}
+	spools, _ := filepath.Glob(filepath.Join(SpoolDir, "me-noisy-*.log"))
+	if len(spools) != 1 {
+		t.Fatalf("expected a spool file, got %v", spools)
+	}
+	b, _ := ioutil.ReadFile(spools[0])
+	if !bytes.Contains(b, []byte("\n5000\n")) || len(b) != 48894 {
+		t.Errorf("expected the full output in the spool file, got %d bytes", len(b))
+	}
+}
+
+func TestParseHapfileInvalidSizes(t *testing.T) {
+	tests := map[string]string{
+		"[build \"deps\"]\n\tmax-output = 10X\n":               `[deps] invalid max-output "10X"`,
+		"[host \"web\"]\n\taddr = web:22\n\tretry-delay = 2\n": `[web] invalid retry-delay "2"`,
+		"[default]\n\ttime-budget = soon\n":                    `[default] invalid time-budget "soon"`,
+		"[build \"deps\"]\n\tmax-output = 10M\n":               "",
+	}
+	for hapfile, expected := range tests {
+		_, err := ParseHapfile([]byte(hapfile))
+		if fmt.Sprint(err) != expected && !(expected == "" && err == nil) {
+			t.Errorf("expected %q for %q, got %v", expected, hapfile, err)
+		}
+	}
+}
+
+// failingWriter fails every write
+type failingWriter struct{}
+
+// Write implements the io.Writer interface
+func (failingWriter) Write(p []byte) (int, error) {
+	return 0, fmt.Errorf("disk full")
+}
+
+func TestCappedFlushError(t *testing.T) {
+	r := &Remote{Host: &Host{Name: "me"}}
+	step := Step{Name: "deps", MaxOutput: "4"}
+	_, err := r.capped(step, "deps", failingWriter{}, ioutil.Discard, func(stdout, stderr io.Writer) (*Result, error) {
+		stdout.Write([]byte("way over the cap\n"))
+		return &Result{}, nil
+	})
+	if err == nil || err.Error() != "disk full" {
+		t.Errorf("expected the flush to fail, got %v", err)
+	}
+}
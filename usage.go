@@ -0,0 +1,145 @@
+// Hap - the simple and effective provisioner
+// Copyright (c) 2015 Garrett Woodworth (https://github.com/gwoo)
+// The BSD License http://opensource.org/licenses/bsd-license.php.
+
+package hap
+
+import (
+	"bufio"
+	"bytes"
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+// DefaultDiskWarn is the percentage of disk or inodes used that warns
+const DefaultDiskWarn = 90
+
+// Prints the sizes in kilobytes of the deploy dir, the git object store,
+// and the filesystem, and the inode counts of the filesystem.
+const diskUsage string = "echo \"dir $(du -sk . | cut -f 1)\" && " +
+	"echo \"git $(du -sk .git | cut -f 1)\" && " +
+	"echo \"objects $(du -sk .git/objects | cut -f 1)\" && " +
+	"echo \"disk $(df -Pk . | tail -n 1 | tr -s \" \" | cut -d \" \" -f 2-4)\" && " +
+	"echo \"inodes $(df -Pi . 2>/dev/null | tail -n 1 | tr -s \" \" | cut -d \" \" -f 2-4)\""
+
+// Usage holds the disk usage of a deployment in kilobytes
+type Usage struct {
+	Dir        int64
+	Git        int64
+	Objects    int64
+	Disk       int64
+	DiskUsed   int64
+	DiskFree   int64
+	Inodes     int64
+	InodesUsed int64
+	InodesFree int64
+}
+
+// ParseUsage takes the output of the usage commands and returns the Usage
+func ParseUsage(b []byte) (Usage, error) {
+	var u Usage
+	targets := map[string][]*int64{
+		"dir":     {&u.Dir},
+		"git":     {&u.Git},
+		"objects": {&u.Objects},
+		"disk":    {&u.Disk, &u.DiskUsed, &u.DiskFree},
+		"inodes":  {&u.Inodes, &u.InodesUsed, &u.InodesFree},
+	}
+	scanner := bufio.NewScanner(bytes.NewReader(b))
+	for scanner.Scan() {
+		fields := strings.Fields(scanner.Text())
+		if len(fields) < 1 {
+			continue
+		}
+		values, ok := targets[fields[0]]
+		if !ok {
+			continue
+		}
+		// Filesystems without inodes report dashes, which leave them at 0
+		for i, target := range values {
+			if i+1 >= len(fields) {
+				break
+			}
+			if n, err := strconv.ParseInt(fields[i+1], 10, 64); err == nil {
+				*target = n
+			}
+		}
+	}
+	if u.Disk == 0 {
+		return u, fmt.Errorf("unable to read disk usage from %q", b)
+	}
+	return u, scanner.Err()
+}
+
+// Checkout returns the size of the checked out files
+func (u Usage) Checkout() int64 {
+	return u.Dir - u.Git
+}
+
+// DiskPercent returns the percentage of the filesystem used
+func (u Usage) DiskPercent() int {
+	return percent(u.DiskUsed, u.DiskUsed+u.DiskFree)
+}
+
+// InodePercent returns the percentage of the inodes used
+func (u Usage) InodePercent() int {
+	return percent(u.InodesUsed, u.InodesUsed+u.InodesFree)
+}
+
+// Warnings returns the filesystem usages at or over the warn percentage
+// It uses the DefaultDiskWarn when warn is not set.
+func (u Usage) Warnings(warn int) []string {
+	if warn <= 0 {
+		warn = DefaultDiskWarn
+	}
+	warnings := []string{}
+	if p := u.DiskPercent(); p >= warn {
+		warnings = append(warnings, fmt.Sprintf("disk %d%% full, %s free", p, kilobytes(u.DiskFree)))
+	}
+	if p := u.InodePercent(); p >= warn {
+		warnings = append(warnings, fmt.Sprintf("inodes %d%% used, %d free", p, u.InodesFree))
+	}
+	return warnings
+}
+
+// String returns the usage as a line for hap du
+func (u Usage) String() string {
+	return fmt.Sprintf("dir %s (git %s, objects %s, checkout %s), disk %d%% of %s, inodes %d%%",
+		kilobytes(u.Dir), kilobytes(u.Git), kilobytes(u.Objects), kilobytes(u.Checkout()),
+		u.DiskPercent(), kilobytes(u.Disk), u.InodePercent())
+}
+
+// Usage returns the disk usage of the deployment on the remote machine
+func (r *Remote) Usage() (Usage, error) {
+	result, err := r.read([]string{"cd " + r.Dir, diskUsage})
+	if err != nil {
+		if result != nil {
+			err = fmt.Errorf("%s%s", result.Stderr, err)
+		}
+		return Usage{}, err
+	}
+	return ParseUsage(result.Stdout)
+}
+
+// percent returns the part of the total in percent, rounded up
+func percent(part, total int64) int {
+	if total <= 0 {
+		return 0
+	}
+	return int((part*100 + total - 1) / total)
+}
+
+// kilobytes formats the kilobytes in the largest unit up to terabytes
+func kilobytes(kb int64) string {
+	units := []string{"K", "M", "G", "T"}
+	size, unit := float64(kb), 0
+	for size >= 1024 && unit < len(units)-1 {
+		size /= 1024
+		unit++
+	}
+	if unit == 0 {
+		return fmt.Sprintf("%d%s", kb, units[0])
+	}
+	return fmt.Sprintf("%.1f%s", size, units[unit])
+}
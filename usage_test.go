@@ -0,0 +1,46 @@
+// Hap - the simple and effective provisioner
+// Copyright (c) 2015 Garrett Woodworth (https://github.com/gwoo)
+// The BSD License http://opensource.org/licenses/bsd-license.php.
+
+package hap
+
+import (
+	"testing"
+)
+
+func TestParseUsage(t *testing.T) {
+	output := "dir 52340\ngit 40120\nobjects 39800\ndisk 10000000 9300000 700000\ninodes 640000 12000 628000\n"
+	u, err := ParseUsage([]byte(output))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if u.Dir != 52340 || u.Git != 40120 || u.Objects != 39800 || u.Checkout() != 12220 {
+		t.Errorf("unexpected usage %+v", u)
+	}
+	if u.DiskPercent() != 93 || u.InodePercent() != 2 {
+		t.Errorf("unexpected percentages %d and %d", u.DiskPercent(), u.InodePercent())
+	}
+	expected := "dir 51.1M (git 39.2M, objects 38.9M, checkout 11.9M), disk 93% of 9.5G, inodes 2%"
+	if u.String() != expected {
+		t.Errorf("expected %q, got %q", expected, u.String())
+	}
+	if warnings := u.Warnings(0); len(warnings) != 1 || warnings[0] != "disk 93% full, 683.6M free" {
+		t.Errorf("unexpected warnings %v", warnings)
+	}
+	if warnings := u.Warnings(95); len(warnings) != 0 {
+		t.Errorf("unexpected warnings %v", warnings)
+	}
+}
+
+func TestParseUsageWithoutInodes(t *testing.T) {
+	u, err := ParseUsage([]byte("dir 1\ngit 1\nobjects 1\ndisk 100 10 90\ninodes - - -\n"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if u.InodePercent() != 0 {
+		t.Errorf("expected 0, got %d", u.InodePercent())
+	}
+	if _, err := ParseUsage([]byte("dir 1\n")); err == nil {
+		t.Error("expected error without disk usage")
+	}
+}
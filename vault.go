@@ -0,0 +1,113 @@
+// Hap - the simple and effective provisioner
+// Copyright (c) 2015 Garrett Woodworth (https://github.com/gwoo)
+// The BSD License http://opensource.org/licenses/bsd-license.php.
+
+package hap
+
+import (
+	"bytes"
+	"encoding/base64"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// HapfileName is the file the Hapfile is read from
+const HapfileName = "Hapfile"
+
+// Marks the first line of an encrypted Hapfile, a comment for gcfg.
+const encryptedHapfile = "; hap encrypted hapfile"
+
+// Length of the base64 lines of an encrypted Hapfile
+const encryptedWidth = 76
+
+// HapfileKey returns the secret key file that encrypts the Hapfile
+// It is HAP_SECRET_KEY or DefaultSecretKey, since the secret-key of
+// an encrypted Hapfile cannot be read before it is decrypted.
+func HapfileKey() string {
+	if file := os.Getenv("HAP_SECRET_KEY"); file != "" {
+		return file
+	}
+	return DefaultSecretKey
+}
+
+// IsEncryptedHapfile returns whether the contents are an encrypted Hapfile
+func IsEncryptedHapfile(b []byte) bool {
+	return bytes.HasPrefix(b, []byte(encryptedHapfile+"\n"))
+}
+
+// EncryptHapfile seals the contents of a Hapfile to the key
+func EncryptHapfile(key *SecretKey, plain []byte) ([]byte, error) {
+	if IsEncryptedHapfile(plain) {
+		return nil, fmt.Errorf("[secret] Hapfile is already encrypted")
+	}
+	sealed, err := key.Encrypt(string(plain))
+	if err != nil {
+		return nil, err
+	}
+	sealed = strings.TrimPrefix(sealed, encrypted)
+	lines := []string{encryptedHapfile}
+	for len(sealed) > encryptedWidth {
+		lines = append(lines, sealed[:encryptedWidth])
+		sealed = sealed[encryptedWidth:]
+	}
+	lines = append(lines, sealed)
+	return []byte(strings.Join(lines, "\n") + "\n"), nil
+}
+
+// DecryptHapfile opens the contents of an encrypted Hapfile with the key
+func DecryptHapfile(key *SecretKey, b []byte) ([]byte, error) {
+	if !IsEncryptedHapfile(b) {
+		return nil, fmt.Errorf("[secret] Hapfile is not encrypted")
+	}
+	sealed := strings.Join(strings.Fields(string(b[len(encryptedHapfile):])), "")
+	if _, err := base64.StdEncoding.DecodeString(sealed); err != nil {
+		return nil, fmt.Errorf("[secret] invalid encrypted Hapfile: %s", err)
+	}
+	plain, err := key.Decrypt(encrypted + sealed)
+	if err != nil {
+		return nil, err
+	}
+	return []byte(plain), nil
+}
+
+// ReadHapfile returns the contents of the Hapfile in the file
+// Encrypted Hapfiles are decrypted in memory with the HapfileKey.
+func ReadHapfile(file string) ([]byte, error) {
+	b, err := ioutil.ReadFile(file)
+	if err != nil || !IsEncryptedHapfile(b) {
+		return b, err
+	}
+	key, err := NewSecretKey(HapfileKey())
+	if err != nil {
+		return nil, err
+	}
+	return DecryptHapfile(key, b)
+}
+
+// WriteHapfile replaces the file with the contents without leaving a
+// partially written Hapfile behind
+func WriteHapfile(file string, b []byte) error {
+	mode := os.FileMode(0644)
+	if info, err := os.Stat(file); err == nil {
+		mode = info.Mode().Perm()
+	}
+	tmp, err := ioutil.TempFile(filepath.Dir(file), ".Hapfile")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name())
+	_, err = tmp.Write(b)
+	if cerr := tmp.Close(); err == nil {
+		err = cerr
+	}
+	if err != nil {
+		return err
+	}
+	if err := os.Chmod(tmp.Name(), mode); err != nil {
+		return err
+	}
+	return os.Rename(tmp.Name(), file)
+}
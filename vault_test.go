@@ -0,0 +1,77 @@
+// Hap - the simple and effective provisioner
+// Copyright (c) 2015 Garrett Woodworth (https://github.com/gwoo)
+// The BSD License http://opensource.org/licenses/bsd-license.php.
+
+package hap
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestEncryptHapfile(t *testing.T) {
+	dir, err := ioutil.TempDir("", "hap")
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	defer os.RemoveAll(dir)
+	keyFile := filepath.Join(dir, "secret.key")
+	key, err := GenerateSecretKey(keyFile)
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	plain := []byte("[host \"db\"]\naddr = \"10.0.0.5:22\"\nusername = \"deploy\"\n")
+	sealed, err := EncryptHapfile(key, plain)
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	if !IsEncryptedHapfile(sealed) {
+		t.Errorf("expected %q to be encrypted", sealed)
+	}
+	if strings.Contains(string(sealed), "10.0.0.5") {
+		t.Errorf("expected no plaintext in %q", sealed)
+	}
+	if _, err := EncryptHapfile(key, sealed); err == nil {
+		t.Error("expected error encrypting twice")
+	}
+	file := filepath.Join(dir, "Hapfile")
+	if err := WriteHapfile(file, sealed); err != nil {
+		t.Error(err)
+		return
+	}
+	os.Setenv("HAP_SECRET_KEY", keyFile)
+	defer os.Unsetenv("HAP_SECRET_KEY")
+	b, err := ReadHapfile(file)
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	if string(b) != string(plain) {
+		t.Errorf("expected %q, got %q", plain, b)
+	}
+	hf, err := ParseHapfile(b)
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	if addr := hf.Hosts["db"].Addr; addr != "10.0.0.5:22" {
+		t.Errorf("expected addr 10.0.0.5:22, got %s", addr)
+	}
+	other, err := GenerateSecretKey(filepath.Join(dir, "other.key"))
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	if _, err := DecryptHapfile(other, sealed); err == nil {
+		t.Error("expected error decrypting with another key")
+	}
+	if _, err := DecryptHapfile(key, plain); err == nil {
+		t.Error("expected error decrypting a plain Hapfile")
+	}
+}